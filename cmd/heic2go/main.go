@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 
+	"github.com/spenceriam/HEIC-2-Go/internal/app"
+	"github.com/spenceriam/HEIC-2-Go/internal/cli"
+	"github.com/spenceriam/HEIC-2-Go/internal/errors"
 	"github.com/spenceriam/HEIC-2-Go/internal/ui"
-	"github.com/spenceriam/HEIC-2-Go/pkg/version"
 )
 
 const (
@@ -13,12 +16,22 @@ const (
 )
 
 func main() {
+	// Run a command non-interactively if one was given
+	if len(os.Args) > 1 {
+		// Ctrl+C and SIGTERM cancel the running conversions, which remove
+		// their partial output before the command exits
+		ctx, stop := app.WithInterrupt(context.Background())
+		code := cli.Run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+		stop()
+		os.Exit(code)
+	}
+
 	// Initialize the terminal UI
 	screen := ui.NewScreen()
 
 	// Start the main menu
 	if err := screen.ShowMenu(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		os.Exit(errors.ExitCode(err))
 	}
 }
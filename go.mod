@@ -8,11 +8,11 @@ require (
 	github.com/fatih/color v1.15.0
 	github.com/rwcarlsen/goexif v0.0.0-20190401172101-9e8deecbddbd
 	github.com/strukturag/libheif v1.16.0
+	golang.org/x/image v0.0.0-20220902085622-e7cb96979f69
 )
 
 require (
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.17 // indirect
-	golang.org/x/image v0.0.0-20220902085622-e7cb96979f69 // indirect
 	golang.org/x/sys v0.6.0 // indirect
 )
@@ -0,0 +1,21 @@
+package app
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// WithInterrupt returns a context that is cancelled when the process receives
+// SIGINT (Ctrl+C) or SIGTERM. After the first signal the default handling is
+// restored, so a second one terminates the process immediately. Calling stop
+// releases the signal handler.
+func WithInterrupt(parent context.Context) (ctx context.Context, stop context.CancelFunc) {
+	ctx, stop = signal.NotifyContext(parent, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
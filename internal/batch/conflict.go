@@ -0,0 +1,131 @@
+package batch
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spenceriam/HEIC-2-Go/internal/errors"
+)
+
+// ConflictPolicy decides what happens to a job whose output file already exists
+type ConflictPolicy string
+
+const (
+	// ConflictOverwrite replaces the existing file
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictSkip leaves the existing file alone and skips the job
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictRename writes to the first free numbered name, e.g. "IMG_0001_2.jpg"
+	ConflictRename ConflictPolicy = "rename"
+	// ConflictKeepNewer skips the job if an existing output file is not older
+	// than the input file and overwrites the existing files otherwise
+	ConflictKeepNewer ConflictPolicy = "keep-newer"
+	// ConflictFail fails the job with ErrFileExists
+	ConflictFail ConflictPolicy = "fail"
+)
+
+// ConflictPolicies returns the supported conflict policies
+func ConflictPolicies() []ConflictPolicy {
+	return []ConflictPolicy{ConflictOverwrite, ConflictSkip, ConflictRename, ConflictKeepNewer, ConflictFail}
+}
+
+// ParseConflictPolicy parses a conflict policy name such as "skip" or "keep-newer"
+func ParseConflictPolicy(name string) (ConflictPolicy, error) {
+	name = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), "_", "-")
+	for _, policy := range ConflictPolicies() {
+		if name == string(policy) {
+			return policy, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported conflict policy: %s", name)
+}
+
+// ConflictResolver chooses how to handle a job whose outputs include an
+// existing file, for example by asking the user. existing is the first such
+// file, which is the job's output path or another file the converter writes
+// for it. The resolver may return the job with another output path; the
+// policy is only applied if that path's outputs exist as well. The engine
+// never calls a resolver from more than one goroutine at a time.
+type ConflictResolver func(job Job, existing string) (Job, ConflictPolicy, error)
+
+// outputClaims records which job writes each output file of a batch, keyed
+// by the lowercased path so that names differing in case never collide on
+// case-insensitive file systems
+type outputClaims map[string]string
+
+// claim records the paths as written by a job
+func (c outputClaims) claim(job Job, paths []string) {
+	for _, path := range paths {
+		c[strings.ToLower(path)] = job.Input
+	}
+}
+
+// taken reports whether another job of the batch writes one of the paths
+func (c outputClaims) taken(job Job, paths []string) bool {
+	for _, path := range paths {
+		if owner, ok := c[strings.ToLower(path)]; ok && owner != job.Input {
+			return true
+		}
+	}
+	return false
+}
+
+// existingOutput returns the first of the paths that exists, or "" if none does
+func existingOutput(paths []string) string {
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// resolveConflict applies a conflict policy to a job whose outputs, as
+// listed by plan, include an existing file. It returns the job to run, or
+// skip set if the job should not run. The outputs of a job that runs are
+// claimed so that concurrent jobs never pick the same names.
+func resolveConflict(job Job, policy ConflictPolicy, plan func(Job) []string, claims outputClaims) (resolved Job, skip bool, err error) {
+	outputs := plan(job)
+	switch policy {
+	case ConflictOverwrite:
+	case ConflictSkip:
+		return job, true, nil
+	case ConflictRename:
+		// All outputs move to the first number under which none of them
+		// exists or is written by another job
+		for n := 2; ; n++ {
+			candidate := job
+			candidate.Output = numberedPath(job.Output, n)
+			outputs := plan(candidate)
+			if claims.taken(candidate, outputs) || existingOutput(outputs) != "" {
+				continue
+			}
+			claims.claim(candidate, outputs)
+			return candidate, false, nil
+		}
+	case ConflictKeepNewer:
+		input, err := os.Stat(job.Input)
+		if err != nil {
+			return job, false, errors.HandleFileError(err, job.Input)
+		}
+		for _, path := range outputs {
+			output, err := os.Stat(path)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return job, false, errors.HandleFileError(err, path)
+			}
+			if !output.ModTime().Before(input.ModTime()) {
+				return job, true, nil
+			}
+		}
+	case ConflictFail:
+		return job, false, errors.FileExists(existingOutput(outputs))
+	default:
+		return job, false, errors.InvalidInput("conflict policy", policy)
+	}
+	claims.claim(job, outputs)
+	return job, false, nil
+}
@@ -0,0 +1,169 @@
+package batch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spenceriam/HEIC-2-Go/internal/errors"
+)
+
+// singleOutput plans only the job's output path
+func singleOutput(job Job) []string {
+	return []string{job.Output}
+}
+
+// conflictJob creates an input and an existing output in a new directory
+func conflictJob(t *testing.T) Job {
+	t.Helper()
+	dir := t.TempDir()
+	job := Job{Input: filepath.Join(dir, "IMG_0001.heic"), Output: filepath.Join(dir, "IMG_0001.jpg")}
+	writeFile(t, job.Input, "heic")
+	writeFile(t, job.Output, "old")
+	return job
+}
+
+// setModTime sets the modification time of a file
+func setModTime(t *testing.T, path string, modTime time.Time) {
+	t.Helper()
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseConflictPolicy(t *testing.T) {
+	tests := map[string]ConflictPolicy{
+		"overwrite":  ConflictOverwrite,
+		"SKIP":       ConflictSkip,
+		" rename ":   ConflictRename,
+		"keep-newer": ConflictKeepNewer,
+		"keep_newer": ConflictKeepNewer,
+		"Fail":       ConflictFail,
+	}
+	for name, want := range tests {
+		if got, err := ParseConflictPolicy(name); err != nil || got != want {
+			t.Errorf("ParseConflictPolicy(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	for _, name := range []string{"", "ask", "merge"} {
+		if _, err := ParseConflictPolicy(name); err == nil {
+			t.Errorf("ParseConflictPolicy(%q) succeeded, want an error", name)
+		}
+	}
+}
+
+func TestResolveConflict(t *testing.T) {
+	tests := []struct {
+		policy ConflictPolicy
+		skip   bool
+		err    errors.ErrorCode
+	}{
+		{ConflictOverwrite, false, 0},
+		{ConflictSkip, true, 0},
+		{ConflictFail, false, errors.ErrFileExists},
+		{ConflictPolicy("merge"), false, errors.ErrInvalidInput},
+	}
+	for _, tt := range tests {
+		job := conflictJob(t)
+		claims := outputClaims{}
+		resolved, skip, err := resolveConflict(job, tt.policy, singleOutput, claims)
+		if skip != tt.skip || resolved != job {
+			t.Errorf("%s: job %v, skip %v; want %v, skip %v", tt.policy, resolved, skip, job, tt.skip)
+		}
+		if tt.err != 0 {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("%s: error %v, want %s", tt.policy, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.policy, err)
+		}
+		// A job that runs claims its outputs
+		if !skip && !claims.taken(Job{Input: "other.heic"}, []string{job.Output}) {
+			t.Errorf("%s: output not claimed", tt.policy)
+		}
+	}
+}
+
+func TestResolveConflictRename(t *testing.T) {
+	job := conflictJob(t)
+	dir := filepath.Dir(job.Output)
+	// _2 exists on disk, and _3 is claimed by another job in another case
+	writeFile(t, filepath.Join(dir, "IMG_0001_2.jpg"), "old")
+	claims := outputClaims{}
+	claims.claim(Job{Input: "other.heic"}, []string{filepath.Join(dir, "img_0001_3.JPG")})
+
+	resolved, skip, err := resolveConflict(job, ConflictRename, singleOutput, claims)
+	if err != nil || skip {
+		t.Fatalf("rename: skip %v, %v", skip, err)
+	}
+	if got := filepath.Base(resolved.Output); got != "IMG_0001_4.jpg" {
+		t.Errorf("renamed to %s, want IMG_0001_4.jpg", got)
+	}
+
+	// The next job to rename does not pick the claimed name
+	again, _, _ := resolveConflict(Job{Input: filepath.Join(dir, "other2.heic"), Output: job.Output}, ConflictRename, singleOutput, claims)
+	if got := filepath.Base(again.Output); got != "IMG_0001_5.jpg" {
+		t.Errorf("second rename to %s, want IMG_0001_5.jpg", got)
+	}
+}
+
+func TestResolveConflictKeepNewer(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	tests := []struct {
+		name   string
+		output time.Time
+		skip   bool
+	}{
+		{"older output", now.Add(-time.Hour), false},
+		{"same time", now, true},
+		{"newer output", now.Add(time.Hour), true},
+	}
+	for _, tt := range tests {
+		job := conflictJob(t)
+		setModTime(t, job.Input, now)
+		setModTime(t, job.Output, tt.output)
+
+		_, skip, err := resolveConflict(job, ConflictKeepNewer, singleOutput, outputClaims{})
+		if err != nil || skip != tt.skip {
+			t.Errorf("%s: skip %v, %v; want skip %v", tt.name, skip, err, tt.skip)
+		}
+	}
+
+	// Any existing output that is not older keeps the job from running
+	job := conflictJob(t)
+	web := filepath.Join(filepath.Dir(job.Output), "IMG_0001_web.jpg")
+	writeFile(t, web, "old")
+	setModTime(t, job.Input, now)
+	setModTime(t, job.Output, now.Add(-time.Hour))
+	setModTime(t, web, now.Add(time.Hour))
+	plan := func(job Job) []string { return []string{job.Output, web} }
+	if _, skip, _ := resolveConflict(job, ConflictKeepNewer, plan, outputClaims{}); !skip {
+		t.Error("job runs although one of its outputs is newer than the input")
+	}
+
+	// A missing input cannot be compared
+	os.Remove(job.Input)
+	if _, _, err := resolveConflict(job, ConflictKeepNewer, singleOutput, outputClaims{}); !errors.Is(err, errors.ErrFileNotFound) {
+		t.Errorf("missing input: %v, want file_not_found", err)
+	}
+}
+
+func TestOutputClaims(t *testing.T) {
+	a := Job{Input: "a.heic", Output: "out/A.jpg"}
+	b := Job{Input: "b.heic", Output: "out/b.jpg"}
+	claims := outputClaims{}
+	claims.claim(a, []string{a.Output, "out/A_web.jpg"})
+
+	if claims.taken(a, []string{"out/a.JPG", "out/a_WEB.jpg"}) {
+		t.Error("a job's own outputs are taken")
+	}
+	if !claims.taken(b, []string{b.Output, "out/a_web.JPG"}) {
+		t.Error("another job's output in another case is not taken")
+	}
+	if claims.taken(b, []string{b.Output}) {
+		t.Error("unclaimed output is taken")
+	}
+}
@@ -0,0 +1,415 @@
+// Package batch converts sets of files concurrently, independently of any
+// user interface
+package batch
+
+import (
+	"context"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spenceriam/HEIC-2-Go/internal/errors"
+)
+
+// DefaultWorkers returns the number of concurrent conversions used by
+// default: one for every CPU that Go may use (GOMAXPROCS)
+func DefaultWorkers() int {
+	return runtime.GOMAXPROCS(0)
+}
+
+// Converter converts a single file. It should stop with ErrCancelled and
+// remove any partial output when the context is cancelled.
+type Converter interface {
+	ConvertContext(ctx context.Context, inputPath, outputPath string) error
+}
+
+// OutputPlanner is implemented by converters that write more files than the
+// job's output path, such as one file for each image of the input. The
+// engine applies the conflict policy to every planned file and keeps jobs
+// from writing each other's files. The engine plans each job once, so the
+// planned names must start with the output path without its extension,
+// which a renamed job replaces.
+type OutputPlanner interface {
+	PlannedOutputs(inputPath, outputPath string) ([]string, error)
+}
+
+// Job is a single file conversion
+type Job struct {
+	Input  string
+	Output string
+}
+
+// Status is the outcome of a job
+type Status int
+
+const (
+	// StatusSucceeded means the file was converted
+	StatusSucceeded Status = iota
+	// StatusWarning means the file was converted but a non-fatal error
+	// occurred, such as metadata that could not be written
+	StatusWarning
+	// StatusFailed means the file could not be converted
+	StatusFailed
+	// StatusCancelled means the job did not run because the batch was cancelled
+	StatusCancelled
+	// StatusSkipped means the job did not run because of the conflict policy
+	StatusSkipped
+)
+
+// String returns the name of the status
+func (s Status) String() string {
+	switch s {
+	case StatusSucceeded:
+		return "succeeded"
+	case StatusWarning:
+		return "warning"
+	case StatusFailed:
+		return "failed"
+	case StatusCancelled:
+		return "cancelled"
+	case StatusSkipped:
+		return "skipped"
+	default:
+		return "unknown"
+	}
+}
+
+// Result is the outcome of a job
+type Result struct {
+	Job      Job
+	Status   Status
+	Err      error
+	Duration time.Duration
+}
+
+// EventType identifies a batch event
+type EventType int
+
+const (
+	// EventJobStarted is sent when a worker starts a job
+	EventJobStarted EventType = iota
+	// EventJobFinished is sent when a job has a result
+	EventJobFinished
+	// EventBatchFinished is sent once after all jobs have a result
+	EventBatchFinished
+)
+
+// Event reports the progress of a batch run
+type Event struct {
+	Type EventType
+	// Job the event is about, unset for EventBatchFinished
+	Job Job
+	// Result of the job, set for EventJobFinished
+	Result *Result
+	// Number of jobs with a result so far
+	Completed int
+	// Total number of jobs
+	Total int
+}
+
+// Summary is the outcome of a batch run
+type Summary struct {
+	Total     int
+	Succeeded int
+	Warnings  int
+	Failed    int
+	Cancelled int
+	Skipped   int
+	// Results in the order of the jobs
+	Results  []Result
+	Duration time.Duration
+}
+
+// Engine runs conversion jobs on a pool of workers
+type Engine struct {
+	converter        Converter
+	workers          int
+	memoryBudget     int64
+	events           chan<- Event
+	conflictPolicy   ConflictPolicy
+	conflictResolver ConflictResolver
+}
+
+// NewEngine creates a new batch engine using the given converter
+func NewEngine(conv Converter) *Engine {
+	return &Engine{
+		converter:      conv,
+		workers:        DefaultWorkers(),
+		conflictPolicy: ConflictOverwrite,
+	}
+}
+
+// WithWorkers sets the number of concurrent conversions
+func (e *Engine) WithWorkers(workers int) *Engine {
+	if workers < 1 {
+		workers = 1
+	}
+	e.workers = workers
+	return e
+}
+
+// WithMemoryBudget limits the estimated memory of the conversions running
+// at once, in bytes. A job is started only when its estimate fits into what
+// the running jobs leave, and a job larger than the whole budget runs alone.
+// Estimates come from the converter if it implements MemoryEstimator;
+// otherwise the budget has no effect. Zero, the default, means no limit.
+func (e *Engine) WithMemoryBudget(bytes int64) *Engine {
+	if bytes < 0 {
+		bytes = 0
+	}
+	e.memoryBudget = bytes
+	return e
+}
+
+// WithEvents sets the channel that receives progress events. The channel is
+// closed when Run returns, so it must be drained by the caller.
+func (e *Engine) WithEvents(events chan<- Event) *Engine {
+	e.events = events
+	return e
+}
+
+// WithConflictPolicy sets how jobs whose output file already exists are
+// handled. The default is ConflictOverwrite.
+func (e *Engine) WithConflictPolicy(policy ConflictPolicy) *Engine {
+	e.conflictPolicy = policy
+	return e
+}
+
+// WithConflictResolver sets a resolver that chooses the conflict policy for
+// each job whose output file already exists, instead of the fixed policy
+func (e *Engine) WithConflictResolver(resolver ConflictResolver) *Engine {
+	e.conflictResolver = resolver
+	return e
+}
+
+// Run converts the jobs and returns a summary once every job has a result.
+// Cancelling the context stops the running conversions and keeps new jobs
+// from starting; all of them are reported as cancelled.
+func (e *Engine) Run(ctx context.Context, jobs []Job) *Summary {
+	start := time.Now()
+	results := make([]Result, len(jobs))
+
+	// Results and events are recorded under a lock so that Completed
+	// increases monotonically in the event stream
+	var mu sync.Mutex
+	completed := 0
+	finish := func(i int, result Result) {
+		mu.Lock()
+		defer mu.Unlock()
+		results[i] = result
+		completed++
+		e.emit(Event{Type: EventJobFinished, Job: result.Job, Result: &result, Completed: completed, Total: len(jobs)})
+	}
+
+	// Conflicts are resolved one at a time, and every output file in use is
+	// claimed so that no two jobs write the same file
+	var conflictMu sync.Mutex
+	claims := make(outputClaims, len(jobs))
+	for _, job := range jobs {
+		claims.claim(job, []string{job.Output})
+	}
+	prepare := func(job Job) (Job, bool, error) {
+		// Planning reads the input, so only the claims wait for each other
+		plan := e.plan(job)
+		conflictMu.Lock()
+		defer conflictMu.Unlock()
+		return e.prepare(job, plan, claims)
+	}
+
+	var budget *memoryBudget
+	estimator, ok := e.converter.(MemoryEstimator)
+	if ok && e.memoryBudget > 0 {
+		budget = newMemoryBudget(e.memoryBudget)
+	}
+
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < e.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				if ctx.Err() != nil {
+					finish(i, cancelledResult(jobs[i], ctx.Err()))
+					continue
+				}
+				e.emit(Event{Type: EventJobStarted, Job: jobs[i], Total: len(jobs)})
+				job, skip, err := prepare(jobs[i])
+				switch {
+				case ctx.Err() != nil:
+					finish(i, cancelledResult(job, ctx.Err()))
+				case err != nil:
+					finish(i, Result{Job: job, Status: StatusFailed, Err: err})
+				case skip:
+					finish(i, Result{Job: job, Status: StatusSkipped})
+				default:
+					finish(i, e.run(ctx, job, budget, estimator))
+				}
+			}
+		}()
+	}
+
+feed:
+	for i := range jobs {
+		select {
+		case queue <- i:
+		case <-ctx.Done():
+			for ; i < len(jobs); i++ {
+				finish(i, cancelledResult(jobs[i], ctx.Err()))
+			}
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	summary := summarize(results)
+	summary.Duration = time.Since(start)
+
+	e.emit(Event{Type: EventBatchFinished, Completed: len(jobs), Total: len(jobs)})
+	if e.events != nil {
+		close(e.events)
+	}
+	return summary
+}
+
+// prepare applies the conflict policy to a job whose outputs include an
+// existing file and returns the job to run, or skip set if it should not
+// run. A job whose outputs another job of the batch writes is renamed
+// whatever the policy, since both files are new.
+func (e *Engine) prepare(job Job, plan func(Job) []string, claims outputClaims) (Job, bool, error) {
+	outputs := plan(job)
+	if claims.taken(job, outputs) {
+		return resolveConflict(job, ConflictRename, plan, claims)
+	}
+	existing := existingOutput(outputs)
+	if existing == "" {
+		claims.claim(job, outputs)
+		return job, false, nil
+	}
+
+	policy := e.conflictPolicy
+	if e.conflictResolver != nil {
+		var err error
+		job, policy, err = e.conflictResolver(job, existing)
+		if err != nil {
+			return job, false, err
+		}
+		outputs = plan(job)
+		if claims.taken(job, outputs) {
+			return resolveConflict(job, ConflictRename, plan, claims)
+		}
+		if existingOutput(outputs) == "" {
+			claims.claim(job, outputs)
+			return job, false, nil
+		}
+	}
+	return resolveConflict(job, policy, plan, claims)
+}
+
+// plan asks the converter once for the files a job writes, or takes just its
+// output path, and returns a function that lists them for the job under any
+// output path. Files that cannot be planned fail in the conversion.
+func (e *Engine) plan(job Job) func(Job) []string {
+	planned := []string{job.Output}
+	if planner, ok := e.converter.(OutputPlanner); ok {
+		if outputs, err := planner.PlannedOutputs(job.Input, job.Output); err == nil && len(outputs) > 0 {
+			planned = outputs
+		}
+	}
+	return func(moved Job) []string {
+		return moveOutputs(planned, job.Output, moved.Output)
+	}
+}
+
+// moveOutputs returns the files planned for the output path from as they
+// are for the output path to, replacing the start of the names that the
+// planner derives from the output path, e.g. "a_web.jpg" from "a.jpg"
+func moveOutputs(planned []string, from, to string) []string {
+	fromStem := strings.TrimSuffix(from, filepath.Ext(from))
+	toStem := strings.TrimSuffix(to, filepath.Ext(to))
+	moved := make([]string, len(planned))
+	for i, path := range planned {
+		switch {
+		case path == from:
+			moved[i] = to
+		case strings.HasPrefix(path, fromStem):
+			moved[i] = toStem + strings.TrimPrefix(path, fromStem)
+		default:
+			moved[i] = path
+		}
+	}
+	return moved
+}
+
+// run waits until the job's estimated memory fits into the budget, if
+// there is one, and then converts it
+func (e *Engine) run(ctx context.Context, job Job, budget *memoryBudget, estimator MemoryEstimator) Result {
+	if budget == nil {
+		return e.convert(ctx, job)
+	}
+
+	// A file whose size cannot be estimated is likely invalid and fails
+	// early in the conversion, so it is admitted without waiting
+	size, err := estimator.EstimateMemory(job.Input)
+	if err != nil {
+		size = 0
+	}
+	if err := budget.acquire(ctx, size); err != nil {
+		return cancelledResult(job, err)
+	}
+	defer budget.release(size)
+	return e.convert(ctx, job)
+}
+
+// convert runs a single job
+func (e *Engine) convert(ctx context.Context, job Job) Result {
+	start := time.Now()
+	err := e.converter.ConvertContext(ctx, job.Input, job.Output)
+
+	result := Result{Job: job, Status: StatusSucceeded, Err: err, Duration: time.Since(start)}
+	switch {
+	case err == nil:
+	case errors.Is(err, errors.ErrCancelled):
+		result.Status = StatusCancelled
+	case errors.Is(err, errors.ErrMetadataPreservation):
+		result.Status = StatusWarning
+	default:
+		result.Status = StatusFailed
+	}
+	return result
+}
+
+// emit sends an event if an event channel is set
+func (e *Engine) emit(event Event) {
+	if e.events != nil {
+		e.events <- event
+	}
+}
+
+// cancelledResult returns the result of a job that did not run
+func cancelledResult(job Job, err error) Result {
+	return Result{Job: job, Status: StatusCancelled, Err: err}
+}
+
+// summarize counts the results by status
+func summarize(results []Result) *Summary {
+	summary := &Summary{Total: len(results), Results: results}
+	for _, result := range results {
+		switch result.Status {
+		case StatusSucceeded:
+			summary.Succeeded++
+		case StatusWarning:
+			summary.Warnings++
+		case StatusFailed:
+			summary.Failed++
+		case StatusCancelled:
+			summary.Cancelled++
+		case StatusSkipped:
+			summary.Skipped++
+		}
+	}
+	return summary
+}
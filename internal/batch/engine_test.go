@@ -0,0 +1,372 @@
+package batch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spenceriam/HEIC-2-Go/internal/errors"
+)
+
+// fakeConverter writes "converted" to each output file and records it.
+// Inputs whose name contains "bad" fail, and "warn" converts with a metadata
+// warning.
+type fakeConverter struct {
+	mu      sync.Mutex
+	written []string
+}
+
+func (f *fakeConverter) ConvertContext(ctx context.Context, inputPath, outputPath string) error {
+	return f.write(inputPath, []string{outputPath})
+}
+
+// write fails or writes the outputs of a conversion
+func (f *fakeConverter) write(inputPath string, outputs []string) error {
+	name := filepath.Base(inputPath)
+	if strings.Contains(name, "bad") {
+		return errors.New(errors.ErrDecodeFailed, "failed to decode HEIC file")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, output := range outputs {
+		if err := os.WriteFile(output, []byte("converted"), 0644); err != nil {
+			return err
+		}
+		f.written = append(f.written, output)
+	}
+	if strings.Contains(name, "warn") {
+		return errors.New(errors.ErrMetadataPreservation, "warning: failed to write metadata")
+	}
+	return nil
+}
+
+// plannedConverter also writes one file for each suffix next to the output,
+// e.g. "a_web.jpg" for the suffix "_web", and plans them. It counts the
+// plans made for each input.
+type plannedConverter struct {
+	fakeConverter
+	suffixes []string
+	plans    map[string]int
+}
+
+func (p *plannedConverter) PlannedOutputs(inputPath, outputPath string) ([]string, error) {
+	p.mu.Lock()
+	if p.plans == nil {
+		p.plans = map[string]int{}
+	}
+	p.plans[inputPath]++
+	p.mu.Unlock()
+
+	outputs := []string{outputPath}
+	ext := filepath.Ext(outputPath)
+	for _, suffix := range p.suffixes {
+		outputs = append(outputs, strings.TrimSuffix(outputPath, ext)+suffix+ext)
+	}
+	return outputs, nil
+}
+
+func (p *plannedConverter) ConvertContext(ctx context.Context, inputPath, outputPath string) error {
+	outputs := []string{outputPath}
+	ext := filepath.Ext(outputPath)
+	for _, suffix := range p.suffixes {
+		outputs = append(outputs, strings.TrimSuffix(outputPath, ext)+suffix+ext)
+	}
+	return p.write(inputPath, outputs)
+}
+
+// writeFile creates a file with the given content
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readFile returns the content of a file, or "" if it cannot be read
+func readFile(path string) string {
+	data, _ := os.ReadFile(path)
+	return string(data)
+}
+
+func TestEngineAppliesPolicyToPlannedOutputs(t *testing.T) {
+	tests := []struct {
+		policy   ConflictPolicy
+		status   Status
+		output   string
+		existing string
+	}{
+		{ConflictOverwrite, StatusSucceeded, "a.jpg", "converted"},
+		{ConflictSkip, StatusSkipped, "a.jpg", "old"},
+		{ConflictRename, StatusSucceeded, "a_2.jpg", "old"},
+		{ConflictKeepNewer, StatusSkipped, "a.jpg", "old"},
+		{ConflictFail, StatusFailed, "a.jpg", "old"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			dir := t.TempDir()
+			writeFile(t, filepath.Join(dir, "a.heic"), "heic")
+			// Only a file the job writes besides its output exists
+			writeFile(t, filepath.Join(dir, "a_web.jpg"), "old")
+
+			conv := &plannedConverter{suffixes: []string{"_web"}}
+			summary := NewEngine(conv).WithConflictPolicy(tt.policy).
+				Run(context.Background(), []Job{{Input: filepath.Join(dir, "a.heic"), Output: filepath.Join(dir, "a.jpg")}})
+
+			result := summary.Results[0]
+			if result.Status != tt.status {
+				t.Fatalf("status %v (%v), want %v", result.Status, result.Err, tt.status)
+			}
+			if got := filepath.Base(result.Job.Output); got != tt.output {
+				t.Errorf("output %s, want %s", got, tt.output)
+			}
+			if got := readFile(filepath.Join(dir, "a_web.jpg")); got != tt.existing {
+				t.Errorf("existing file holds %q, want %q", got, tt.existing)
+			}
+			if tt.policy == ConflictFail && !errors.Is(result.Err, errors.ErrFileExists) {
+				t.Errorf("error %v, want file_exists", result.Err)
+			}
+			if tt.policy == ConflictRename && readFile(filepath.Join(dir, "a_2_web.jpg")) != "converted" {
+				t.Error("renamed job did not write a_2_web.jpg")
+			}
+		})
+	}
+}
+
+func TestEngineResolverGetsPlannedConflict(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a_web.jpg"), "old")
+
+	var existing []string
+	resolver := func(job Job, path string) (Job, ConflictPolicy, error) {
+		existing = append(existing, filepath.Base(path))
+		return job, ConflictSkip, nil
+	}
+	conv := &plannedConverter{suffixes: []string{"_web"}}
+	summary := NewEngine(conv).WithConflictResolver(resolver).
+		Run(context.Background(), []Job{{Input: filepath.Join(dir, "a.heic"), Output: filepath.Join(dir, "a.jpg")}})
+
+	if len(existing) != 1 || existing[0] != "a_web.jpg" {
+		t.Errorf("resolver called for %v, want [a_web.jpg]", existing)
+	}
+	if summary.Skipped != 1 || len(conv.written) != 0 {
+		t.Errorf("%d skipped, wrote %v; want the job skipped", summary.Skipped, conv.written)
+	}
+}
+
+func TestEngineKeepsJobsFromWritingTheSameFile(t *testing.T) {
+	dir := t.TempDir()
+	// The "_web" file of a.heic is the output of a_web.heic, differing only
+	// in case
+	jobs := []Job{
+		{Input: filepath.Join(dir, "a.heic"), Output: filepath.Join(dir, "a.jpg")},
+		{Input: filepath.Join(dir, "a_web.heic"), Output: filepath.Join(dir, "A_WEB.jpg")},
+	}
+	conv := &plannedConverter{suffixes: []string{"_web"}}
+	summary := NewEngine(conv).WithWorkers(1).Run(context.Background(), jobs)
+	if summary.Succeeded != 2 {
+		t.Fatalf("%d of 2 jobs succeeded", summary.Succeeded)
+	}
+
+	seen := map[string]bool{}
+	for _, path := range conv.written {
+		key := strings.ToLower(path)
+		if seen[key] {
+			t.Errorf("%s written twice", filepath.Base(path))
+		}
+		seen[key] = true
+	}
+	if got := filepath.Base(summary.Results[0].Job.Output); got != "a_2.jpg" {
+		t.Errorf("first job writes %s, want a_2.jpg to keep clear of the second job's output", got)
+	}
+
+	var names []string
+	for _, path := range conv.written {
+		names = append(names, filepath.Base(path))
+	}
+	sort.Strings(names)
+	want := []string{"A_WEB.jpg", "A_WEB_web.jpg", "a_2.jpg", "a_2_web.jpg"}
+	if strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Errorf("wrote %v, want %v", names, want)
+	}
+}
+
+func TestEnginePlansEachJobOnce(t *testing.T) {
+	dir := t.TempDir()
+	// Renaming passes a.jpg and a_2_web.jpg before it finds a free number
+	writeFile(t, filepath.Join(dir, "a.jpg"), "old")
+	writeFile(t, filepath.Join(dir, "a_2_web.jpg"), "old")
+
+	conv := &plannedConverter{suffixes: []string{"_web"}}
+	summary := NewEngine(conv).WithConflictPolicy(ConflictRename).
+		Run(context.Background(), []Job{{Input: filepath.Join(dir, "a.heic"), Output: filepath.Join(dir, "a.jpg")}})
+
+	if got := filepath.Base(summary.Results[0].Job.Output); got != "a_3.jpg" {
+		t.Errorf("renamed to %s, want a_3.jpg", got)
+	}
+	if plans := conv.plans[filepath.Join(dir, "a.heic")]; plans != 1 {
+		t.Errorf("planned %d times, want once", plans)
+	}
+}
+
+func TestMoveOutputs(t *testing.T) {
+	planned := []string{"out/a.png", "out/a_web.jpg", "out/a_depth.png", "other/b.jpg"}
+	got := moveOutputs(planned, "out/a.jpg", "out/a_2.jpg")
+	want := []string{"out/a_2.png", "out/a_2_web.jpg", "out/a_2_depth.png", "other/b.jpg"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("moved to %v, want %v", got, want)
+	}
+	if got := moveOutputs([]string{"a.jpg"}, "a.jpg", "b.jpeg"); got[0] != "b.jpeg" {
+		t.Errorf("output moved to %s, want b.jpeg", got[0])
+	}
+}
+
+// blockingConverter waits in every conversion until the context is cancelled
+type blockingConverter struct {
+	started chan struct{}
+	once    sync.Once
+}
+
+func (b *blockingConverter) ConvertContext(ctx context.Context, inputPath, outputPath string) error {
+	b.once.Do(func() { close(b.started) })
+	<-ctx.Done()
+	return errors.Wrap(ctx.Err(), errors.ErrCancelled, "conversion cancelled")
+}
+
+// collectEvents drains an event channel until the engine closes it
+func collectEvents(events <-chan Event) <-chan []Event {
+	done := make(chan []Event, 1)
+	go func() {
+		var got []Event
+		for event := range events {
+			got = append(got, event)
+		}
+		done <- got
+	}()
+	return done
+}
+
+func TestEngineRun(t *testing.T) {
+	dir := t.TempDir()
+	var jobs []Job
+	for _, name := range []string{"a", "bad", "b", "warn", "c", "d"} {
+		jobs = append(jobs, Job{Input: filepath.Join(dir, name+".heic"), Output: filepath.Join(dir, name+".jpg")})
+	}
+
+	events := make(chan Event)
+	collected := collectEvents(events)
+	summary := NewEngine(&fakeConverter{}).WithWorkers(3).WithEvents(events).Run(context.Background(), jobs)
+	got := <-collected
+
+	if summary.Total != 6 || summary.Succeeded != 4 || summary.Warnings != 1 || summary.Failed != 1 {
+		t.Errorf("summary %d total, %d succeeded, %d warnings, %d failed; want 6, 4, 1, 1",
+			summary.Total, summary.Succeeded, summary.Warnings, summary.Failed)
+	}
+	for i, result := range summary.Results {
+		if result.Job != jobs[i] {
+			t.Errorf("result %d is for %s, want the results in job order", i, result.Job.Input)
+		}
+	}
+	if !errors.Is(summary.Results[1].Err, errors.ErrDecodeFailed) || summary.Results[3].Status != StatusWarning {
+		t.Errorf("results %v and %v, want a failure and a warning", summary.Results[1], summary.Results[3])
+	}
+
+	// Every job starts and finishes once, Completed counts up to the total,
+	// and the batch event comes last, after which the channel is closed
+	started, finished := map[string]int{}, map[string]int{}
+	completed := 0
+	for i, event := range got {
+		if event.Total != len(jobs) {
+			t.Errorf("event %d has total %d", i, event.Total)
+		}
+		switch event.Type {
+		case EventJobStarted:
+			started[event.Job.Input]++
+		case EventJobFinished:
+			finished[event.Job.Input]++
+			completed++
+			if event.Completed != completed {
+				t.Errorf("event %d: completed %d after %d results", i, event.Completed, completed)
+			}
+			if event.Result == nil || event.Result.Job != event.Job {
+				t.Errorf("event %d: result %v for job %v", i, event.Result, event.Job)
+			}
+		case EventBatchFinished:
+			if i != len(got)-1 || event.Completed != len(jobs) {
+				t.Errorf("batch finished as event %d of %d with %d completed", i, len(got), event.Completed)
+			}
+		}
+	}
+	for _, job := range jobs {
+		if started[job.Input] != 1 || finished[job.Input] != 1 {
+			t.Errorf("%s started %d and finished %d times, want once", job.Input, started[job.Input], finished[job.Input])
+		}
+	}
+}
+
+func TestEngineCancelledBeforeRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	jobs := []Job{{Input: "a.heic", Output: "a.jpg"}, {Input: "b.heic", Output: "b.jpg"}}
+	conv := &fakeConverter{}
+	events := make(chan Event)
+	collected := collectEvents(events)
+	summary := NewEngine(conv).WithEvents(events).Run(ctx, jobs)
+	got := <-collected
+
+	if summary.Cancelled != 2 || len(conv.written) != 0 {
+		t.Errorf("%d cancelled, wrote %v; want every job cancelled without converting", summary.Cancelled, conv.written)
+	}
+	if last := got[len(got)-1]; last.Type != EventBatchFinished || last.Completed != 2 {
+		t.Errorf("last event %+v, want the batch finished with 2 completed", last)
+	}
+}
+
+func TestEngineCancelStopsRunningJobs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var jobs []Job
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		jobs = append(jobs, Job{Input: name + ".heic", Output: name + ".jpg"})
+	}
+	conv := &blockingConverter{started: make(chan struct{})}
+	go func() {
+		<-conv.started
+		cancel()
+	}()
+	summary := NewEngine(conv).WithWorkers(2).Run(ctx, jobs)
+
+	if summary.Cancelled != len(jobs) {
+		t.Errorf("%d of %d jobs cancelled", summary.Cancelled, len(jobs))
+	}
+	for _, result := range summary.Results {
+		if result.Status != StatusCancelled || result.Job.Input == "" {
+			t.Errorf("result %+v, want a cancelled result for its job", result)
+		}
+	}
+}
+
+func TestStatusString(t *testing.T) {
+	names := map[Status]string{
+		StatusSucceeded: "succeeded",
+		StatusWarning:   "warning",
+		StatusFailed:    "failed",
+		StatusCancelled: "cancelled",
+		StatusSkipped:   "skipped",
+		Status(99):      "unknown",
+	}
+	for status, name := range names {
+		if got := status.String(); got != name {
+			t.Errorf("Status(%d).String() = %q, want %q", status, got, name)
+		}
+	}
+}
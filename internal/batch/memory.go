@@ -0,0 +1,147 @@
+package batch
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MemoryEstimator is implemented by converters that can estimate how much
+// memory converting a file needs before running the conversion. The engine
+// uses the estimates to keep the jobs running at once within its memory
+// budget.
+type MemoryEstimator interface {
+	EstimateMemory(inputPath string) (int64, error)
+}
+
+// memoryBudget admits jobs while the sum of their estimated memory stays
+// within a limit. Jobs are admitted in the order they ask, so a large job is
+// not overtaken by a stream of small ones, and a job larger than the whole
+// budget runs once no other job is running.
+type memoryBudget struct {
+	limit int64
+
+	mu      sync.Mutex
+	used    int64
+	running int
+	waiters []*budgetWaiter
+}
+
+// budgetWaiter is a job waiting for memory
+type budgetWaiter struct {
+	size  int64
+	ready chan struct{}
+}
+
+// newMemoryBudget creates a budget of limit bytes
+func newMemoryBudget(limit int64) *memoryBudget {
+	return &memoryBudget{limit: limit}
+}
+
+// acquire waits until size bytes fit into the budget. It fails with the
+// context's error if the context is cancelled first.
+func (b *memoryBudget) acquire(ctx context.Context, size int64) error {
+	b.mu.Lock()
+	if len(b.waiters) == 0 && b.fits(size) {
+		b.admit(size)
+		b.mu.Unlock()
+		return nil
+	}
+	w := &budgetWaiter{size: size, ready: make(chan struct{})}
+	b.waiters = append(b.waiters, w)
+	b.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		select {
+		case <-w.ready:
+			// Admitted while the context was cancelled; give the memory back
+			b.releaseLocked(size)
+		default:
+			for i, other := range b.waiters {
+				if other == w {
+					b.waiters = append(b.waiters[:i], b.waiters[i+1:]...)
+					break
+				}
+			}
+			// The next waiter may fit now that this one is gone
+			b.admitWaiters()
+		}
+		return ctx.Err()
+	}
+}
+
+// release returns size bytes acquired before to the budget
+func (b *memoryBudget) release(size int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.releaseLocked(size)
+}
+
+func (b *memoryBudget) releaseLocked(size int64) {
+	b.used -= size
+	b.running--
+	b.admitWaiters()
+}
+
+// admitWaiters admits waiting jobs in order for as long as they fit
+func (b *memoryBudget) admitWaiters() {
+	for len(b.waiters) > 0 && b.fits(b.waiters[0].size) {
+		w := b.waiters[0]
+		b.waiters = b.waiters[1:]
+		b.admit(w.size)
+		close(w.ready)
+	}
+}
+
+// fits reports whether size bytes can be admitted now
+func (b *memoryBudget) fits(size int64) bool {
+	return b.running == 0 || b.used+size <= b.limit
+}
+
+func (b *memoryBudget) admit(size int64) {
+	b.used += size
+	b.running++
+}
+
+// Binary units accepted by ParseMemorySize
+var memoryUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}, {"TB", 1 << 40},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40},
+	{"B", 1},
+}
+
+// ParseMemorySize parses a memory size such as "512MB", "2G" or "1.5GB" into
+// bytes. Units are binary (1 KB = 1024 bytes); a number without a unit is a
+// number of bytes.
+func ParseMemorySize(value string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	s = strings.Replace(s, "IB", "B", 1)
+
+	unit := int64(1)
+	for _, u := range memoryUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s, unit = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.size
+			break
+		}
+	}
+
+	// Sizes that do not fit into an int64 would wrap around. The units are
+	// powers of two, so the product is exact, and float64(math.MaxInt64)
+	// rounds up to 2^63, the first size that does not fit.
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 || math.IsNaN(n) || n*float64(unit) >= float64(math.MaxInt64) {
+		return 0, fmt.Errorf("invalid memory size: %s", value)
+	}
+	return int64(n * float64(unit)), nil
+}
@@ -0,0 +1,189 @@
+package batch
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseMemorySize(t *testing.T) {
+	tests := map[string]int64{
+		"0":        0,
+		"1024":     1024,
+		"512B":     512,
+		"4k":       4 << 10,
+		"4KB":      4 << 10,
+		"512MB":    512 << 20,
+		"512 MiB":  512 << 20,
+		"2G":       2 << 30,
+		"1.5GB":    3 << 29,
+		" 1 TiB ":  1 << 40,
+		"8191T":    8191 << 40,
+		"8388607T": 8388607 << 40,
+		"0.5K":     512,
+	}
+	for value, want := range tests {
+		if got, err := ParseMemorySize(value); err != nil || got != want {
+			t.Errorf("ParseMemorySize(%q) = %d, %v, want %d", value, got, err, want)
+		}
+	}
+
+	for _, value := range []string{"", "G", "-1G", "lots", "1.5.2M", "NaN", "Inf", "99999999999G", "9000000T", "1e300", "9223372036854775807", "8388608T"} {
+		if got, err := ParseMemorySize(value); err == nil {
+			t.Errorf("ParseMemorySize(%q) = %d, want an error", value, got)
+		}
+	}
+}
+
+// admitted reports whether an acquire finishes within a short time
+func admitted(done <-chan error) bool {
+	select {
+	case <-done:
+		return true
+	case <-time.After(50 * time.Millisecond):
+		return false
+	}
+}
+
+// acquireAsync acquires memory in a new goroutine
+func acquireAsync(ctx context.Context, b *memoryBudget, size int64) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- b.acquire(ctx, size) }()
+	return done
+}
+
+// waiting returns the number of waiters once it reaches n, or after a timeout
+func waiting(b *memoryBudget, n int) int {
+	deadline := time.Now().Add(time.Second)
+	for {
+		b.mu.Lock()
+		count := len(b.waiters)
+		b.mu.Unlock()
+		if count >= n || time.Now().After(deadline) {
+			return count
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMemoryBudgetAdmitsInOrder(t *testing.T) {
+	ctx := context.Background()
+	b := newMemoryBudget(100)
+	if err := b.acquire(ctx, 60); err != nil {
+		t.Fatal(err)
+	}
+
+	// A large job waits, and a small one that would fit waits behind it
+	large := acquireAsync(ctx, b, 80)
+	waiting(b, 1)
+	small := acquireAsync(ctx, b, 10)
+	if waiting(b, 2) != 2 || admitted(large) || admitted(small) {
+		t.Fatal("jobs admitted beyond the budget or out of order")
+	}
+
+	b.release(60)
+	if !admitted(large) || !admitted(small) {
+		t.Fatal("waiting jobs not admitted after the memory was released")
+	}
+	if b.used != 90 || b.running != 2 {
+		t.Errorf("%d bytes used by %d jobs, want 90 by 2", b.used, b.running)
+	}
+}
+
+func TestMemoryBudgetRunsOversizedJobAlone(t *testing.T) {
+	ctx := context.Background()
+	b := newMemoryBudget(100)
+	if err := b.acquire(ctx, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	huge := acquireAsync(ctx, b, 500)
+	if admitted(huge) {
+		t.Fatal("job larger than the budget admitted while another job runs")
+	}
+	b.release(10)
+	if !admitted(huge) {
+		t.Fatal("job larger than the budget not admitted once alone")
+	}
+
+	next := acquireAsync(ctx, b, 1)
+	if admitted(next) {
+		t.Fatal("job admitted next to one larger than the budget")
+	}
+	b.release(500)
+	if !admitted(next) {
+		t.Fatal("job not admitted after the large job finished")
+	}
+}
+
+func TestMemoryBudgetCancel(t *testing.T) {
+	b := newMemoryBudget(100)
+	if err := b.acquire(context.Background(), 90); err != nil {
+		t.Fatal(err)
+	}
+
+	// A cancelled waiter leaves the queue and lets the ones behind it in
+	ctx, cancel := context.WithCancel(context.Background())
+	blocked := acquireAsync(ctx, b, 50)
+	waiting(b, 1)
+	small := acquireAsync(context.Background(), b, 5)
+	waiting(b, 2)
+
+	cancel()
+	if err := <-blocked; err != context.Canceled {
+		t.Errorf("cancelled acquire = %v, want context.Canceled", err)
+	}
+	if !admitted(small) {
+		t.Fatal("job behind a cancelled one not admitted")
+	}
+	if b.used != 95 || b.running != 2 || len(b.waiters) != 0 {
+		t.Errorf("%d bytes used by %d jobs with %d waiting, want 95 by 2 with none", b.used, b.running, len(b.waiters))
+	}
+}
+
+// estimatingConverter needs 40 bytes for every file and records the most
+// memory its conversions held at once
+type estimatingConverter struct {
+	mu      sync.Mutex
+	used    int64
+	maxUsed int64
+}
+
+func (e *estimatingConverter) EstimateMemory(inputPath string) (int64, error) {
+	return 40, nil
+}
+
+func (e *estimatingConverter) ConvertContext(ctx context.Context, inputPath, outputPath string) error {
+	e.mu.Lock()
+	e.used += 40
+	if e.used > e.maxUsed {
+		e.maxUsed = e.used
+	}
+	e.mu.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	e.mu.Lock()
+	e.used -= 40
+	e.mu.Unlock()
+	return nil
+}
+
+func TestEngineMemoryBudget(t *testing.T) {
+	var jobs []Job
+	for i := 0; i < 12; i++ {
+		jobs = append(jobs, Job{Input: fmt.Sprintf("%d.heic", i), Output: fmt.Sprintf("%d.jpg", i)})
+	}
+
+	conv := &estimatingConverter{}
+	summary := NewEngine(conv).WithWorkers(6).WithMemoryBudget(100).Run(context.Background(), jobs)
+	if summary.Succeeded != len(jobs) {
+		t.Fatalf("%d of %d jobs succeeded", summary.Succeeded, len(jobs))
+	}
+	if conv.maxUsed > 100 {
+		t.Errorf("conversions held %d bytes at once, want at most the budget of 100", conv.maxUsed)
+	}
+
+}
@@ -0,0 +1,89 @@
+package batch
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/spenceriam/HEIC-2-Go/internal/converter"
+	"github.com/spenceriam/HEIC-2-Go/internal/errors"
+)
+
+// Layout selects how outputs are arranged in the output directory
+type Layout int
+
+const (
+	// LayoutMirror recreates the input directory structure under the output directory
+	LayoutMirror Layout = iota
+	// LayoutFlatten writes every output directly into the output directory.
+	// Files whose names collide are prefixed with their relative directory,
+	// e.g. "2023/Trip/IMG_0001.HEIC" becomes "2023_Trip_IMG_0001.jpg".
+	LayoutFlatten
+)
+
+// OutputNamer returns the output path of a converted file next to its input
+type OutputNamer func(inputPath string) string
+
+// PlanDirectory creates a job for every HEIC file in a directory and its
+// subdirectories. Outputs are arranged in outputDir according to the layout,
+// or written next to each input file if outputDir is empty. Outputs that
+// would still collide get a numeric suffix, e.g. "IMG_0001_2.jpg".
+func PlanDirectory(inputDir, outputDir string, layout Layout, outputName OutputNamer) ([]Job, error) {
+	files, err := converter.FindHEICFiles(inputDir)
+	if err != nil {
+		return nil, errors.Wrap(err, errors.ErrDirRead, "failed to read input directory").WithDetails(inputDir)
+	}
+
+	// Count output names to find the ones a flat layout would collide on
+	nameCount := make(map[string]int)
+	for _, file := range files {
+		nameCount[strings.ToLower(filepath.Base(outputName(file)))]++
+	}
+
+	used := make(map[string]bool)
+	jobs := make([]Job, 0, len(files))
+	for _, file := range files {
+		output := outputName(file)
+		name := filepath.Base(output)
+		dir := filepath.Dir(output)
+
+		if outputDir != "" {
+			relDir, err := filepath.Rel(inputDir, filepath.Dir(file))
+			if err != nil {
+				relDir = "."
+			}
+
+			switch layout {
+			case LayoutFlatten:
+				dir = outputDir
+				if relDir != "." && nameCount[strings.ToLower(name)] > 1 {
+					name = strings.ReplaceAll(relDir, string(filepath.Separator), "_") + "_" + name
+				}
+			default:
+				dir = filepath.Join(outputDir, relDir)
+			}
+		}
+
+		output = uniquePath(filepath.Join(dir, name), used)
+		jobs = append(jobs, Job{Input: file, Output: output})
+	}
+	return jobs, nil
+}
+
+// uniquePath returns the path, or the first numbered variant of it, that is
+// not in used, and marks it as used. Paths are compared case-insensitively
+// because many file systems are.
+func uniquePath(path string, used map[string]bool) string {
+	candidate := path
+	for n := 2; used[strings.ToLower(candidate)]; n++ {
+		candidate = numberedPath(path, n)
+	}
+	used[strings.ToLower(candidate)] = true
+	return candidate
+}
+
+// numberedPath inserts a numeric suffix before the extension of a path
+func numberedPath(path string, n int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s_%d%s", strings.TrimSuffix(path, ext), n, ext)
+}
@@ -0,0 +1,127 @@
+package batch
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// jpegName names outputs like the converter does for JPEG output
+func jpegName(inputPath string) string {
+	return strings.TrimSuffix(inputPath, filepath.Ext(inputPath)) + ".jpg"
+}
+
+// planOutputs plans a directory and returns the outputs relative to base,
+// keyed by the input relative to the input directory
+func planOutputs(t *testing.T, inputDir, outputDir string, layout Layout, base string) map[string]string {
+	t.Helper()
+	jobs, err := PlanDirectory(inputDir, outputDir, layout, jpegName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs := make(map[string]string, len(jobs))
+	for _, job := range jobs {
+		input, _ := filepath.Rel(inputDir, job.Input)
+		output, _ := filepath.Rel(base, job.Output)
+		outputs[filepath.ToSlash(input)] = filepath.ToSlash(output)
+	}
+	return outputs
+}
+
+// createInputs creates empty files under dir
+func createInputs(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		writeFile(t, filepath.Join(dir, filepath.FromSlash(name)), "")
+	}
+}
+
+func TestPlanDirectoryLayouts(t *testing.T) {
+	in := t.TempDir()
+	createInputs(t, in, "IMG_0001.HEIC", "2023/Trip/IMG_0001.HEIC", "2023/IMG_0002.heif", "notes.txt")
+	out := t.TempDir()
+
+	tests := []struct {
+		name   string
+		layout Layout
+		outDir string
+		base   string
+		want   map[string]string
+	}{
+		{"mirror", LayoutMirror, out, out, map[string]string{
+			"IMG_0001.HEIC":           "IMG_0001.jpg",
+			"2023/Trip/IMG_0001.HEIC": "2023/Trip/IMG_0001.jpg",
+			"2023/IMG_0002.heif":      "2023/IMG_0002.jpg",
+		}},
+		{"flatten", LayoutFlatten, out, out, map[string]string{
+			"IMG_0001.HEIC":           "IMG_0001.jpg",
+			"2023/Trip/IMG_0001.HEIC": "2023_Trip_IMG_0001.jpg",
+			"2023/IMG_0002.heif":      "IMG_0002.jpg",
+		}},
+		{"next to the inputs", LayoutFlatten, "", in, map[string]string{
+			"IMG_0001.HEIC":           "IMG_0001.jpg",
+			"2023/Trip/IMG_0001.HEIC": "2023/Trip/IMG_0001.jpg",
+			"2023/IMG_0002.heif":      "2023/IMG_0002.jpg",
+		}},
+	}
+	for _, tt := range tests {
+		got := planOutputs(t, in, tt.outDir, tt.layout, tt.base)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: planned %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for input, output := range tt.want {
+			if got[input] != output {
+				t.Errorf("%s: %s -> %s, want %s", tt.name, input, got[input], output)
+			}
+		}
+	}
+}
+
+func TestPlanDirectoryNumbersCollisions(t *testing.T) {
+	in := t.TempDir()
+	// The flattened prefix of a/b/x.heic and a_b/x.heic is the same, and
+	// the two files in c differ only in the case of their extension
+	createInputs(t, in, "a/b/x.heic", "a_b/x.heic", "c/y.heic", "c/y.HEIF")
+	out := t.TempDir()
+
+	got := planOutputs(t, in, out, LayoutFlatten, out)
+	seen := map[string]bool{}
+	for input, output := range got {
+		key := strings.ToLower(output)
+		if seen[key] {
+			t.Errorf("%s -> %s collides with another output", input, output)
+		}
+		seen[key] = true
+	}
+	for _, want := range []string{"a_b_x.jpg", "a_b_x_2.jpg", "c_y.jpg", "c_y_2.jpg"} {
+		if !seen[strings.ToLower(want)] {
+			t.Errorf("planned %v, want %s among the outputs", got, want)
+		}
+	}
+
+	// A mirrored layout keeps the case-insensitive collision in its directory
+	got = planOutputs(t, in, out, LayoutMirror, out)
+	if got["c/y.HEIF"] != "c/y_2.jpg" && got["c/y.heic"] != "c/y_2.jpg" {
+		t.Errorf("planned %v, want c/y_2.jpg for one of the files in c", got)
+	}
+}
+
+func TestPlanDirectoryMissing(t *testing.T) {
+	if _, err := PlanDirectory(filepath.Join(t.TempDir(), "missing"), "", LayoutMirror, jpegName); err == nil {
+		t.Error("PlanDirectory succeeded for a missing directory")
+	}
+}
+
+func TestNumberedPath(t *testing.T) {
+	tests := map[string]string{
+		"IMG_0001.jpg":                "IMG_0001_3.jpg",
+		filepath.Join("a", "b.c.png"): filepath.Join("a", "b.c_3.png"),
+		"noext":                       "noext_3",
+	}
+	for path, want := range tests {
+		if got := numberedPath(path, 3); got != want {
+			t.Errorf("numberedPath(%q, 3) = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/spenceriam/HEIC-2-Go/internal/batch"
+	"github.com/spenceriam/HEIC-2-Go/internal/config"
+	"github.com/spenceriam/HEIC-2-Go/internal/errors"
+)
+
+// runBatch implements the batch command
+func runBatch(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	flags := newCommonFlags()
+	settings := flags.settings
+	var outputDir string
+	var workers int
+	var memory string
+	var flatten bool
+	var conflict string
+
+	fs := newFlagSet("batch", "batch <directory> [-o output-directory] [flags]", stderr)
+	flags.register(fs)
+	fs.StringVar(&outputDir, "o", "", "output directory (default: next to each input file)")
+	fs.StringVar(&outputDir, "output", "", "same as -o")
+	fs.IntVar(&workers, "workers", settings.BatchWorkers(), "number of concurrent conversions (default: one per CPU)")
+	fs.StringVar(&memory, "memory", memoryDefault(settings), "limit the estimated memory of concurrent conversions, e.g. 2GB (default: no limit)")
+	fs.BoolVar(&flatten, "flatten", settings.FlattenOutput, "write all files into the output directory instead of mirroring subdirectories")
+	fs.StringVar(&conflict, "conflict", string(conflictDefault(settings)), "what to do with existing output files: "+conflictPolicyNames())
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return parseError(err)
+	}
+	if len(positional) != 1 {
+		return usageError(fs, stderr, fmt.Errorf("batch takes exactly one input directory"))
+	}
+	if workers < 1 {
+		return usageError(fs, stderr, fmt.Errorf("-workers must be at least 1"))
+	}
+	var memoryBudget int64
+	if memory != "" {
+		if memoryBudget, err = batch.ParseMemorySize(memory); err != nil {
+			return usageError(fs, stderr, err)
+		}
+	}
+	out, err := flags.output(stdout, stderr)
+	if err != nil {
+		return usageError(fs, stderr, err)
+	}
+	policy, err := batch.ParseConflictPolicy(conflict)
+	if err != nil {
+		return usageError(fs, stderr, err)
+	}
+
+	inputDir := positional[0]
+	layout := batch.LayoutMirror
+	if flatten {
+		layout = batch.LayoutFlatten
+	}
+	conv, err := flags.newConverter("")
+	if err != nil {
+		return usageError(fs, stderr, err)
+	}
+
+	jobs, err := batch.PlanDirectory(inputDir, outputDir, layout, conv.GetOutputPath)
+	if err != nil {
+		out.Error(inputDir, err)
+		return errors.ExitCode(err)
+	}
+	if len(jobs) == 0 {
+		out.Info("No HEIC files found in %s", inputDir)
+		return errors.ExitOK
+	}
+	out.Debug("Found %d HEIC files in %s", len(jobs), inputDir)
+
+	events := make(chan batch.Event)
+	engine := batch.NewEngine(conv).
+		WithWorkers(workers).
+		WithMemoryBudget(memoryBudget).
+		WithConflictPolicy(policy).
+		WithEvents(events)
+	done := make(chan *batch.Summary)
+	go func() {
+		done <- engine.Run(ctx, jobs)
+	}()
+
+	exitCode := errors.ExitOK
+	for event := range events {
+		if event.Type != batch.EventJobFinished {
+			continue
+		}
+		result := event.Result
+		switch result.Status {
+		case batch.StatusSucceeded:
+			out.Debug("[%d/%d] Converted %s -> %s", event.Completed, event.Total, result.Job.Input, result.Job.Output)
+		case batch.StatusSkipped:
+			out.Debug("[%d/%d] Skipped %s, %s already exists", event.Completed, event.Total, result.Job.Input, result.Job.Output)
+		case batch.StatusCancelled:
+			out.Debug("[%d/%d] Cancelled %s", event.Completed, event.Total, result.Job.Input)
+		case batch.StatusWarning:
+			out.Warn(result.Job.Input, result.Err)
+		default:
+			out.Error(result.Job.Input, result.Err)
+			exitCode = batchExitCode(exitCode, result.Err)
+		}
+	}
+	summary := <-done
+
+	if summary.Cancelled > 0 {
+		out.Info("Interrupted: converted %d of %d files, %d failed, %d cancelled", summary.Succeeded+summary.Warnings, summary.Total, summary.Failed, summary.Cancelled)
+		return errors.ErrCancelled.ExitCode()
+	}
+	if summary.Skipped > 0 {
+		out.Info("Converted %d of %d files in %s, skipped %d existing", summary.Succeeded+summary.Warnings, summary.Total, summary.Duration.Round(time.Millisecond), summary.Skipped)
+	} else {
+		out.Info("Converted %d of %d files in %s", summary.Succeeded+summary.Warnings, summary.Total, summary.Duration.Round(time.Millisecond))
+	}
+	return exitCode
+}
+
+// conflictDefault returns the saved conflict policy, or overwrite if the
+// interactive interface asks about every file, which a command cannot do
+func conflictDefault(settings *config.Settings) batch.ConflictPolicy {
+	if policy, ok := settings.BatchConflictPolicy(); ok {
+		return policy
+	}
+	return batch.ConflictOverwrite
+}
+
+// memoryDefault returns the saved memory budget as a -memory value
+func memoryDefault(settings *config.Settings) string {
+	if settings.MemoryBudgetMB > 0 {
+		return fmt.Sprintf("%dMB", settings.MemoryBudgetMB)
+	}
+	return ""
+}
+
+// conflictPolicyNames lists the conflict policies for the usage text
+func conflictPolicyNames() string {
+	var names []string
+	for _, policy := range batch.ConflictPolicies() {
+		names = append(names, string(policy))
+	}
+	return strings.Join(names, ", ")
+}
+
+// batchExitCode combines the exit code of a failed file with the exit code
+// of the previous failures: a run whose failures all share one error code
+// exits with that code, any other failed run exits with ExitFailure
+func batchExitCode(current int, err error) int {
+	code := errors.ExitCode(err)
+	if current == errors.ExitOK || code == current {
+		return code
+	}
+	return errors.ExitFailure
+}
@@ -0,0 +1,384 @@
+// Package cli implements the non-interactive command-line interface
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/spenceriam/HEIC-2-Go/internal/config"
+	"github.com/spenceriam/HEIC-2-Go/internal/converter"
+	"github.com/spenceriam/HEIC-2-Go/internal/errors"
+	"github.com/spenceriam/HEIC-2-Go/pkg/version"
+)
+
+const usageText = `Usage:
+  heic2go                              Start the interactive interface
+  heic2go convert <file> [flags]       Convert a single HEIC file
+  heic2go - [flags] < a.heic > a.jpg   Convert HEIC data from stdin to stdout
+  heic2go batch <directory> [flags]    Convert all HEIC files in a directory
+  heic2go inspect <file> [-json]       Describe the structure and metadata of a HEIC file
+  heic2go version                      Print version information
+  heic2go help                         Show this help
+
+Run 'heic2go <command> -h' for the flags of a command.
+`
+
+// Run executes a command line and returns the process exit code. Cancelling
+// the context interrupts the running conversions. The input file "-" is read
+// from stdin.
+func Run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usageText)
+		return errors.ExitUsage
+	}
+
+	switch args[0] {
+	case "convert":
+		return runConvert(ctx, args[1:], stdin, stdout, stderr)
+	case "-":
+		// Shorthand for converting stdin to stdout
+		return runConvert(ctx, args, stdin, stdout, stderr)
+	case "batch":
+		return runBatch(ctx, args[1:], stdout, stderr)
+	case "inspect":
+		return runInspect(ctx, args[1:], stdout, stderr)
+	case "version", "-version", "--version":
+		fmt.Fprintf(stdout, "heic2go %s\n", version.String())
+		return errors.ExitOK
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usageText)
+		return errors.ExitOK
+	default:
+		fmt.Fprintf(stderr, "Error: unknown command %q\n\n", args[0])
+		fmt.Fprint(stderr, usageText)
+		return errors.ExitUsage
+	}
+}
+
+// output writes messages according to the quiet, verbose and JSON flags
+type output struct {
+	stdout  io.Writer
+	stderr  io.Writer
+	quiet   bool
+	verbose bool
+	json    bool
+}
+
+// jsonReport is a machine-readable error or warning about a file
+type jsonReport struct {
+	File    string          `json:"file,omitempty"`
+	Error   json.RawMessage `json:"error,omitempty"`
+	Warning json.RawMessage `json:"warning,omitempty"`
+}
+
+// Info prints a message unless quiet or JSON output was requested
+func (o *output) Info(format string, args ...interface{}) {
+	if !o.quiet && !o.json {
+		fmt.Fprintf(o.stdout, format+"\n", args...)
+	}
+}
+
+// Debug prints a message only if verbose output was requested
+func (o *output) Debug(format string, args ...interface{}) {
+	if o.verbose && !o.json {
+		fmt.Fprintf(o.stdout, format+"\n", args...)
+	}
+}
+
+// Warn reports a non-fatal error about a file unless quiet output was requested
+func (o *output) Warn(file string, err error) {
+	switch {
+	case o.json:
+		o.writeJSON(jsonReport{File: file, Warning: errors.ToJSON(err)})
+	case !o.quiet:
+		fmt.Fprintf(o.stderr, "Warning: %s: %v\n", file, err)
+	}
+}
+
+// Error reports an error, optionally about a file
+func (o *output) Error(file string, err error) {
+	switch {
+	case o.json:
+		o.writeJSON(jsonReport{File: file, Error: errors.ToJSON(err)})
+	case file != "":
+		fmt.Fprintf(o.stderr, "Error: %s: %v\n", file, err)
+	default:
+		fmt.Fprintf(o.stderr, "Error: %v\n", err)
+	}
+}
+
+// writeJSON writes a report as a single line of JSON to stderr
+func (o *output) writeJSON(report jsonReport) {
+	data, _ := json.Marshal(report)
+	fmt.Fprintf(o.stderr, "%s\n", data)
+}
+
+// commonFlags holds the flags shared by the conversion commands
+type commonFlags struct {
+	// Saved settings providing the defaults of the flags, and the problem
+	// found while loading them
+	settings    *config.Settings
+	settingsErr error
+
+	format          string
+	quality         int
+	subsampling     string
+	progressive     bool
+	optimizeHuffman bool
+	webpLossless    bool
+	colorMode       string
+	noMetadata      bool
+	noGainMap       bool
+	exifOrientation bool
+	allImages       bool
+	imageID         int
+	auxFormat       string
+	alphaFormat     string
+	background      string
+	maxWidth        int
+	maxHeight       int
+	scale           int
+	resizeMode      string
+	filter          string
+	renditions      renditionFlag
+	quiet           bool
+	verbose         bool
+	json            bool
+}
+
+// newCommonFlags creates the shared flags. Their defaults are the settings
+// saved by the interactive interface, so flags given on the command line
+// take precedence over those.
+func newCommonFlags() *commonFlags {
+	settings, err := config.Load()
+	return &commonFlags{settings: settings, settingsErr: err}
+}
+
+// register adds the shared flags to a flag set
+func (c *commonFlags) register(fs *flag.FlagSet) {
+	defaults := c.settings.EncoderOptions()
+	resize := c.settings.ResizeOptions()
+	formats := make([]string, 0)
+	for _, format := range converter.SupportedFormats() {
+		formats = append(formats, string(format))
+	}
+
+	fs.StringVar(&c.format, "format", "", "output format: "+strings.Join(formats, ", ")+" (default: from the output extension, else "+string(c.settings.Format())+")")
+	fs.IntVar(&c.quality, "quality", defaults.Quality, "quality of lossy formats (1-100)")
+	fs.StringVar(&c.subsampling, "subsampling", string(defaults.ChromaSubsampling), "JPEG chroma subsampling: 4:4:4, 4:2:2 or 4:2:0")
+	fs.BoolVar(&c.progressive, "progressive", defaults.Progressive, "write progressive JPEG files")
+	fs.BoolVar(&c.optimizeHuffman, "optimize-huffman", defaults.OptimizeHuffman, "compute JPEG Huffman tables for each image")
+	fs.BoolVar(&c.webpLossless, "webp-lossless", defaults.WebPLossless, "write lossless WebP files, ignoring -quality")
+	fs.StringVar(&c.colorMode, "color", converter.ColorModeEmbed.String(), "color handling: embed (keep the source profile) or srgb (convert to sRGB)")
+	fs.BoolVar(&c.noMetadata, "no-metadata", !c.settings.PreserveMetadata, "do not copy EXIF metadata")
+	fs.BoolVar(&c.noGainMap, "no-gain-map", false, "do not keep the HDR gain map of iPhone photos in JPEG output (Ultra HDR)")
+	fs.BoolVar(&c.exifOrientation, "exif-orientation", false, "rotate files without a HEIF rotation by their EXIF Orientation tag (only for non-conforming files)")
+	fs.BoolVar(&c.allImages, "all-images", false, "write every image of multi-image files, e.g. IMG_0001-1.jpg, IMG_0001-2.jpg")
+	fs.IntVar(&c.imageID, "image", 0, "item ID of the image to convert (default: the primary image)")
+	fs.StringVar(&c.alphaFormat, "alpha-format", c.settings.AlphaFormat, "write images with an alpha channel as png, webp, tiff or avif when the output format cannot store transparency")
+	fs.StringVar(&c.background, "background", c.settings.Background, "color that transparency is flattened onto for JPEG output, e.g. white, black or #336699")
+	fs.IntVar(&c.maxWidth, "max-width", resize.MaxWidth, "scale images down to at most this width in pixels")
+	fs.IntVar(&c.maxHeight, "max-height", resize.MaxHeight, "scale images down to at most this height in pixels")
+	fs.IntVar(&c.scale, "scale", resize.Scale, "scale images to this percentage of their size (1-100)")
+	fs.StringVar(&c.resizeMode, "fit", string(resize.Mode), "how images fit -max-width and -max-height: fit (keep all of the image), fill (scale and crop to the exact size) or crop (cut without scaling)")
+	fs.StringVar(&c.filter, "filter", string(resize.Filter), "resampling filter: "+filterNames())
+	fs.Var(&c.renditions, "rendition", "also write a rendition, e.g. _web:max=1600 or _thumb:max=320x320,fit=fill,quality=75,format=webp (repeatable)")
+	fs.StringVar(&c.auxFormat, "aux", "", "also write depth maps and alpha/matte images as grayscale png or tiff, e.g. IMG_0001_depth.png")
+	fs.BoolVar(&c.quiet, "quiet", false, "only print errors")
+	fs.BoolVar(&c.quiet, "q", false, "shorthand for -quiet")
+	fs.BoolVar(&c.verbose, "verbose", false, "print details for every file")
+	fs.BoolVar(&c.verbose, "v", false, "shorthand for -verbose")
+	fs.BoolVar(&c.json, "json", false, "report errors as JSON lines on stderr")
+}
+
+// output creates the message writer for the flags and warns about saved
+// settings that could not be used
+func (c *commonFlags) output(stdout, stderr io.Writer) (*output, error) {
+	if c.quiet && c.verbose {
+		return nil, fmt.Errorf("-quiet and -verbose cannot be used together")
+	}
+	out := &output{stdout: stdout, stderr: stderr, quiet: c.quiet, verbose: c.verbose, json: c.json}
+	if c.settingsErr != nil {
+		out.Warn("settings", c.settingsErr)
+	}
+	return out, nil
+}
+
+// newConverter creates a converter from the flags. The output path, if any,
+// selects the format when -format is not given.
+func (c *commonFlags) newConverter(outputPath string) (*converter.HEICConverter, error) {
+	format := c.settings.Format()
+	switch {
+	case c.format != "":
+		parsed, err := converter.ParseOutputFormat(c.format)
+		if err != nil {
+			return nil, err
+		}
+		format = parsed
+	case outputPath != "":
+		if parsed, err := converter.ParseOutputFormat(filepath.Ext(outputPath)); err == nil {
+			format = parsed
+		}
+	}
+
+	subsampling, err := converter.ParseChromaSubsampling(c.subsampling)
+	if err != nil {
+		return nil, err
+	}
+	colorMode, err := converter.ParseColorMode(c.colorMode)
+	if err != nil {
+		return nil, err
+	}
+	if c.allImages && c.imageID != 0 {
+		return nil, fmt.Errorf("-all-images and -image cannot be used together")
+	}
+	if c.imageID < 0 {
+		return nil, fmt.Errorf("-image must be a positive item ID")
+	}
+	background, err := converter.ParseBackground(c.background)
+	if err != nil {
+		return nil, err
+	}
+	var alphaFormat converter.OutputFormat
+	if c.alphaFormat != "" {
+		alphaFormat, err = converter.ParseOutputFormat(c.alphaFormat)
+		if err != nil || !alphaFormat.SupportsAlpha() {
+			return nil, fmt.Errorf("-alpha-format must be a format that can store transparency")
+		}
+	}
+	var auxFormat converter.OutputFormat
+	if c.auxFormat != "" {
+		auxFormat, err = converter.ParseOutputFormat(c.auxFormat)
+		if err != nil || (auxFormat != converter.FormatPNG && auxFormat != converter.FormatTIFF) {
+			return nil, fmt.Errorf("-aux must be png or tiff")
+		}
+	}
+
+	mode, err := converter.ParseResizeMode(c.resizeMode)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := converter.ParseResampleFilter(c.filter)
+	if err != nil {
+		return nil, err
+	}
+	resize := converter.ResizeOptions{MaxWidth: c.maxWidth, MaxHeight: c.maxHeight, Scale: c.scale, Mode: mode, Filter: filter}
+	if err := resize.Validate(); err != nil {
+		return nil, err
+	}
+
+	opts := converter.DefaultOptions()
+	opts.Quality = c.quality
+	opts.ChromaSubsampling = subsampling
+	opts.Progressive = c.progressive
+	opts.OptimizeHuffman = c.optimizeHuffman
+	opts.WebPLossless = c.webpLossless
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	return converter.NewHEICConverter(!c.noMetadata).
+		WithOutputFormat(format).
+		WithOptions(opts).
+		WithResize(resize).
+		WithRenditions(c.renditions).
+		WithColorMode(colorMode).
+		WithAllImages(c.allImages).
+		WithImageID(c.imageID).
+		WithAuxiliaryImages(auxFormat).
+		WithAlphaFormat(alphaFormat).
+		WithBackground(background).
+		WithGainMap(!c.noGainMap).
+		WithExifOrientation(c.exifOrientation), nil
+}
+
+// renditionFlag collects the renditions of repeated -rendition flags
+type renditionFlag []converter.Rendition
+
+// String implements flag.Value
+func (r *renditionFlag) String() string {
+	suffixes := make([]string, 0, len(*r))
+	for _, rendition := range *r {
+		suffixes = append(suffixes, rendition.Suffix)
+	}
+	return strings.Join(suffixes, ", ")
+}
+
+// Set implements flag.Value
+func (r *renditionFlag) Set(value string) error {
+	rendition, err := converter.ParseRendition(value)
+	if err != nil {
+		return err
+	}
+	*r = append(*r, rendition)
+	return nil
+}
+
+// filterNames lists the resampling filters for the usage text
+func filterNames() string {
+	var names []string
+	for _, filter := range converter.ResampleFilters() {
+		names = append(names, string(filter))
+	}
+	return strings.Join(names, ", ")
+}
+
+// newFlagSet creates a flag set for a command
+func newFlagSet(name, usage string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: heic2go %s\n\nFlags:\n", usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseArgs parses flags that may appear before, between or after the
+// positional arguments and returns the positional arguments
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// isSet reports whether a flag was given on the command line
+func isSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// parseError returns the exit code for a flag parsing error, which the flag
+// package has already reported
+func parseError(err error) int {
+	if err == flag.ErrHelp {
+		return errors.ExitOK
+	}
+	return errors.ExitUsage
+}
+
+// usageError reports a command line error and returns the usage exit code
+func usageError(fs *flag.FlagSet, stderr io.Writer, err error) int {
+	fmt.Fprintf(stderr, "Error: %v\n\n", err)
+	fs.Usage()
+	return errors.ExitUsage
+}
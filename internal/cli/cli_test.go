@@ -0,0 +1,163 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spenceriam/HEIC-2-Go/internal/config"
+	"github.com/spenceriam/HEIC-2-Go/internal/converter"
+	"github.com/spenceriam/HEIC-2-Go/internal/errors"
+)
+
+// useConfigDir points the user config directory to a new temporary directory
+func useConfigDir(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+	t.Setenv("AppData", dir)
+}
+
+// parseCommonFlags registers the shared flags and parses args
+func parseCommonFlags(t *testing.T, args ...string) *commonFlags {
+	t.Helper()
+	flags := newCommonFlags()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	flags.register(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return flags
+}
+
+func TestFlagsDefaultToSavedSettings(t *testing.T) {
+	useConfigDir(t)
+	settings := config.DefaultSettings()
+	settings.OutputFormat = "png"
+	settings.Quality = 70
+	settings.ChromaSubsampling = "4:4:4"
+	settings.MaxWidth = 1600
+	if err := config.Save(settings); err != nil {
+		t.Fatal(err)
+	}
+
+	flags := parseCommonFlags(t, "-quality", "50")
+	conv, err := flags.newConverter("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conv.Format() != converter.FormatPNG {
+		t.Errorf("format %s, want png from the settings", conv.Format())
+	}
+	if opts := conv.Options(); opts.Quality != 50 || opts.ChromaSubsampling != converter.Subsampling444 {
+		t.Errorf("quality %d and subsampling %s, want 50 from the flag and 4:4:4 from the settings", opts.Quality, opts.ChromaSubsampling)
+	}
+	if flags.maxWidth != 1600 {
+		t.Errorf("max width %d, want 1600 from the settings", flags.maxWidth)
+	}
+
+	// The output extension still selects the format
+	conv, err = flags.newConverter("out.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conv.Format() != converter.FormatWebP {
+		t.Errorf("format %s, want webp from the output path", conv.Format())
+	}
+}
+
+func TestFlagsWithoutSavedSettings(t *testing.T) {
+	useConfigDir(t)
+	flags := parseCommonFlags(t)
+	if flags.settingsErr != nil {
+		t.Fatalf("settings error %v, want none without a settings file", flags.settingsErr)
+	}
+	conv, err := flags.newConverter("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conv.Format() != converter.FormatJPEG || conv.Options() != converter.DefaultOptions() {
+		t.Errorf("format %s and options %+v, want the defaults", conv.Format(), conv.Options())
+	}
+
+	var stderr bytes.Buffer
+	if _, err := flags.output(io.Discard, &stderr); err != nil || stderr.Len() != 0 {
+		t.Errorf("output() = %v with %q on stderr, want no warning", err, stderr.String())
+	}
+}
+
+func TestFlagsWarnAboutCorruptSettings(t *testing.T) {
+	useConfigDir(t)
+	path, err := config.Path()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.SaveFile(config.DefaultSettings(), path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("{corrupt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	flags := parseCommonFlags(t)
+	var stderr bytes.Buffer
+	if _, err := flags.output(io.Discard, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stderr.String(), "Warning: settings:") {
+		t.Errorf("stderr %q, want a warning about the settings", stderr.String())
+	}
+}
+
+func TestStreamKeepsOutputFileOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	outputPath := filepath.Join(dir, "photo.jpg")
+	if err := os.WriteFile(outputPath, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := &output{stdout: &bytes.Buffer{}, stderr: &bytes.Buffer{}}
+	code := convertStream(context.Background(), converter.NewHEICConverter(true), "-", outputPath,
+		strings.NewReader("not a HEIC file"), &bytes.Buffer{}, out)
+	if code == errors.ExitOK {
+		t.Fatal("converting invalid data succeeded")
+	}
+
+	// The existing file is neither truncated nor removed, and no temporary
+	// file is left next to it
+	if data, err := os.ReadFile(outputPath); err != nil || string(data) != "keep" {
+		t.Errorf("output file holds %q (%v), want it untouched", data, err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d files, want only the output", len(entries))
+	}
+}
+
+func TestStreamIgnoresSavedAlphaFormat(t *testing.T) {
+	useConfigDir(t)
+	settings := config.DefaultSettings()
+	settings.AlphaFormat = "png"
+	if err := config.Save(settings); err != nil {
+		t.Fatal(err)
+	}
+
+	// The saved alpha format does not keep a stream from converting, so the
+	// invalid data is what fails
+	run := func(args ...string) int {
+		return runConvert(context.Background(), append(args, "-"), strings.NewReader("not a HEIC file"), &bytes.Buffer{}, &bytes.Buffer{})
+	}
+	if code := run(); code != errors.ErrInvalidImage.ExitCode() {
+		t.Errorf("exit code %d, want %d for invalid data", code, errors.ErrInvalidImage.ExitCode())
+	}
+	if code := run("-alpha-format", "png"); code != errors.ErrNotSupported.ExitCode() {
+		t.Errorf("exit code %d with -alpha-format, want %d", code, errors.ErrNotSupported.ExitCode())
+	}
+}
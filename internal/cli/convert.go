@@ -0,0 +1,151 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spenceriam/HEIC-2-Go/internal/converter"
+	"github.com/spenceriam/HEIC-2-Go/internal/errors"
+)
+
+// runConvert implements the convert command. The input "-" is read from
+// stdin and the output "-" is written to stdout.
+func runConvert(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := newCommonFlags()
+	var outputPath string
+
+	fs := newFlagSet("convert", "convert <file> [-o output] [flags]", stderr)
+	flags.register(fs)
+	fs.StringVar(&outputPath, "o", "", `output file or directory, "-" for stdout (default: next to the input file, stdout for stdin)`)
+	fs.StringVar(&outputPath, "output", "", "same as -o")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return parseError(err)
+	}
+	if len(positional) != 1 {
+		return usageError(fs, stderr, fmt.Errorf("convert takes exactly one input file"))
+	}
+
+	// Converted data on stdout moves the messages to stderr
+	inputPath := positional[0]
+	if inputPath == "-" && outputPath == "" {
+		outputPath = "-"
+	}
+	messages := stdout
+	if outputPath == "-" {
+		messages = stderr
+	}
+	out, err := flags.output(messages, stderr)
+	if err != nil {
+		return usageError(fs, stderr, err)
+	}
+
+	conv, err := flags.newConverter(outputPath)
+	if err != nil {
+		return usageError(fs, stderr, err)
+	}
+	if inputPath == "-" || outputPath == "-" {
+		if inputPath == "-" && isDirectory(outputPath) {
+			return usageError(fs, stderr, fmt.Errorf("-o must name a file when reading from stdin"))
+		}
+		// A stream has one output, so transparency is flattened unless
+		// -alpha-format is given, which the converter then rejects
+		if !isSet(fs, "alpha-format") {
+			conv.WithAlphaFormat("")
+		}
+		return convertStream(ctx, conv, inputPath, outputPath, stdin, stdout, out)
+	}
+
+	// Write into the directory if the output names one
+	switch {
+	case outputPath == "":
+		outputPath = conv.GetOutputPath(inputPath)
+	case isDirectory(outputPath):
+		outputPath = filepath.Join(outputPath, filepath.Base(conv.GetOutputPath(inputPath)))
+	}
+
+	// Collect the files actually written, which differ from the output path
+	// for multi-image files and for transparency kept in another format
+	var written []string
+	conv.WithProgress(func(p converter.Progress) {
+		if p.Stage == converter.StageDone {
+			written = append(written, p.Output)
+		}
+	})
+
+	start := time.Now()
+	if err := conv.ConvertContext(ctx, inputPath, outputPath); err != nil {
+		if !errors.Is(err, errors.ErrMetadataPreservation) {
+			out.Error(inputPath, err)
+			return errors.ExitCode(err)
+		}
+		out.Warn(inputPath, err)
+	}
+
+	if len(written) == 0 {
+		written = append(written, outputPath)
+	}
+	out.Info("Converted %s -> %s", inputPath, strings.Join(written, ", "))
+	out.Debug("  format: %s, quality: %d, time: %s", conv.Format(), conv.Options().Quality, time.Since(start).Round(time.Millisecond))
+	return errors.ExitOK
+}
+
+// convertStream converts between files and stdin or stdout, where "-"
+// stands for the standard stream
+func convertStream(ctx context.Context, conv *converter.HEICConverter, inputPath, outputPath string, stdin io.Reader, stdout io.Writer, out *output) int {
+	input, output := inputPath, outputPath
+	if input == "-" {
+		input = "<stdin>"
+	}
+	if output == "-" {
+		output = "<stdout>"
+	}
+
+	r := stdin
+	if inputPath != "-" {
+		file, err := os.Open(inputPath)
+		if err != nil {
+			err = errors.HandleFileError(err, inputPath)
+			out.Error(input, err)
+			return errors.ExitCode(err)
+		}
+		defer file.Close()
+		r = file
+	}
+
+	// Files are replaced only once the converted image is complete
+	start := time.Now()
+	var err error
+	if outputPath == "-" {
+		err = conv.ConvertStream(ctx, r, stdout)
+	} else {
+		err = conv.ConvertStreamToFile(ctx, r, outputPath)
+	}
+	if err != nil {
+		if !errors.Is(err, errors.ErrMetadataPreservation) {
+			out.Error(input, err)
+			return errors.ExitCode(err)
+		}
+		out.Warn(input, err)
+	}
+
+	out.Info("Converted %s -> %s", input, output)
+	out.Debug("  format: %s, quality: %d, time: %s", conv.Format(), conv.Options().Quality, time.Since(start).Round(time.Millisecond))
+	return errors.ExitOK
+}
+
+// isDirectory reports whether a path is an existing directory or ends with a
+// path separator
+func isDirectory(path string) bool {
+	if strings.HasSuffix(path, "/") || strings.HasSuffix(path, string(os.PathSeparator)) {
+		return true
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
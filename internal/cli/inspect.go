@@ -0,0 +1,119 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/spenceriam/HEIC-2-Go/internal/converter"
+	"github.com/spenceriam/HEIC-2-Go/internal/errors"
+)
+
+// runInspect implements the inspect command
+func runInspect(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	var jsonOutput bool
+
+	fs := newFlagSet("inspect", "inspect <file> [-json]", stderr)
+	fs.BoolVar(&jsonOutput, "json", false, "print the description as JSON")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return parseError(err)
+	}
+	if len(positional) != 1 {
+		return usageError(fs, stderr, fmt.Errorf("inspect takes exactly one input file"))
+	}
+	out := &output{stdout: stdout, stderr: stderr, json: jsonOutput}
+
+	inputPath := positional[0]
+	info, err := converter.Inspect(inputPath)
+	if err != nil {
+		out.Error(inputPath, err)
+		return errors.ExitCode(err)
+	}
+
+	if jsonOutput {
+		data, _ := json.MarshalIndent(info, "", "  ")
+		fmt.Fprintf(stdout, "%s\n", data)
+		return errors.ExitOK
+	}
+	printFileInfo(stdout, info)
+	return errors.ExitOK
+}
+
+// printFileInfo writes a human-readable description of a HEIF file
+func printFileInfo(w io.Writer, info *converter.FileInfo) {
+	field := func(indent, name, format string, args ...interface{}) {
+		fmt.Fprintf(w, "%s%-13s %s\n", indent, name+":", fmt.Sprintf(format, args...))
+	}
+
+	field("", "File", "%s (%d bytes)", info.Path, info.Size)
+	field("", "Brands", "%s (compatible: %s)", info.MajorBrand, strings.Join(info.CompatibleBrands, ", "))
+	field("", "Images", "%d (primary: %d)", info.ImageCount, info.PrimaryID)
+
+	for _, img := range info.Images {
+		title := fmt.Sprintf("Image %d", img.ID)
+		if img.Primary {
+			title += " (primary)"
+		}
+		fmt.Fprintf(w, "\n%s\n", title)
+
+		if img.Grid != nil {
+			field("  ", "Type", "%s, %dx%d tiles of %dx%d", img.Type, img.Grid.Columns, img.Grid.Rows, img.Grid.TileWidth, img.Grid.TileHeight)
+		} else {
+			field("  ", "Type", "%s", img.Type)
+		}
+		field("  ", "Size", "%dx%d", img.Width, img.Height)
+		field("  ", "Bit depth", "%d", img.BitDepth)
+		field("  ", "Alpha", "%s", yesNo(img.Alpha))
+		field("  ", "Depth", "%s", yesNo(img.Depth))
+
+		switch {
+		case img.Color == nil:
+			field("  ", "Color", "not declared (sRGB)")
+		case img.Color.HDR:
+			field("  ", "Color", "%s (HDR)", img.Color.Description)
+		default:
+			field("  ", "Color", "%s", img.Color.Description)
+		}
+
+		if len(img.Thumbnails) > 0 {
+			var thumbs []string
+			for _, t := range img.Thumbnails {
+				thumbs = append(thumbs, fmt.Sprintf("%dx%d (id %d)", t.Width, t.Height, t.ID))
+			}
+			field("  ", "Thumbnails", "%s", strings.Join(thumbs, ", "))
+		}
+		if len(img.Auxiliary) > 0 {
+			var aux []string
+			for _, a := range img.Auxiliary {
+				aux = append(aux, fmt.Sprintf("%s (id %d)", a.Name, a.ID))
+			}
+			field("  ", "Auxiliary", "%s", strings.Join(aux, ", "))
+		}
+	}
+
+	if len(info.Exif) > 0 {
+		names := make([]string, 0, len(info.Exif))
+		for name := range info.Exif {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		fmt.Fprintf(w, "\nEXIF\n")
+		for _, name := range names {
+			fmt.Fprintf(w, "  %-26s %s\n", name+":", info.Exif[name])
+		}
+	}
+}
+
+// yesNo formats a flag for human-readable output
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,243 @@
+// Package config manages the user settings and their persistence
+package config
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/spenceriam/HEIC-2-Go/internal/batch"
+	"github.com/spenceriam/HEIC-2-Go/internal/converter"
+)
+
+// Settings holds the application settings
+type Settings struct {
+	// Schema version of the settings file
+	Version int `json:"version"`
+	// Image quality (1-100)
+	Quality int `json:"quality"`
+	// Default output directory
+	OutputDir string `json:"output_dir"`
+	// Color theme (light/dark)
+	Theme string `json:"theme"`
+	// Whether to preserve EXIF metadata
+	PreserveMetadata bool `json:"preserve_metadata"`
+	// Output image format (jpeg, png, webp, tiff, avif)
+	OutputFormat string `json:"output_format"`
+	// JPEG chroma subsampling (4:4:4, 4:2:2, 4:2:0)
+	ChromaSubsampling string `json:"chroma_subsampling"`
+	// Whether to write progressive JPEG files
+	Progressive bool `json:"progressive"`
+	// Whether to optimize JPEG Huffman tables
+	OptimizeHuffman bool `json:"optimize_huffman"`
+	// Whether batch output is written into a single directory instead of
+	// mirroring the input directory structure
+	FlattenOutput bool `json:"flatten_output"`
+	// How existing output files are handled in batch conversions
+	// (ask, overwrite, skip, rename, keep-newer, fail)
+	ConflictPolicy string `json:"conflict_policy"`
+	// Number of concurrent batch conversions, 0 for one per CPU
+	Workers int `json:"workers"`
+	// Limit for the estimated memory of concurrent batch conversions in MB,
+	// 0 for no limit
+	MemoryBudgetMB int `json:"memory_budget_mb"`
+	// Format for images with transparency when the output format cannot
+	// store it (e.g. png), or empty to flatten them onto the background
+	AlphaFormat string `json:"alpha_format"`
+	// Color that transparency is flattened onto, e.g. "#ffffff"
+	Background string `json:"background"`
+	// Maximum output width and height in pixels, 0 for no limit
+	MaxWidth  int `json:"max_width"`
+	MaxHeight int `json:"max_height"`
+	// Output size in percent of the original (1-100), 0 to keep the size
+	ScalePercent int `json:"scale_percent"`
+	// How images fit the maximum dimensions (fit, fill, crop)
+	ResizeMode string `json:"resize_mode"`
+	// Resampling filter (lanczos, catmull-rom, linear, box, nearest)
+	ResizeFilter string `json:"resize_filter"`
+
+	// Corrupted settings file that these settings must not be saved over
+	keepPath string
+}
+
+// ConflictAsk is the conflict policy setting that asks the user about every
+// existing output file
+const ConflictAsk = "ask"
+
+// DefaultSettings returns the default application settings
+func DefaultSettings() *Settings {
+	// Get user's home directory
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		homeDir = "."
+	}
+
+	return &Settings{
+		Version:           CurrentVersion,
+		Quality:           90,
+		OutputDir:         filepath.Join(homeDir, "Pictures", "HEIC-2-JPG"),
+		Theme:             "dark",
+		PreserveMetadata:  true,
+		OutputFormat:      string(converter.FormatJPEG),
+		ChromaSubsampling: string(converter.Subsampling420),
+		Progressive:       false,
+		OptimizeHuffman:   true,
+		FlattenOutput:     false,
+		ConflictPolicy:    ConflictAsk,
+		Workers:           0,
+		MemoryBudgetMB:    0,
+		AlphaFormat:       "",
+		Background:        "#ffffff",
+		MaxWidth:          0,
+		MaxHeight:         0,
+		ScalePercent:      0,
+		ResizeMode:        string(converter.ResizeFit),
+		ResizeFilter:      string(converter.FilterLanczos),
+	}
+}
+
+// Reset restores the default settings in place. Settings that must not be
+// saved over a corrupted settings file keep that restriction.
+func (s *Settings) Reset() {
+	keepPath := s.keepPath
+	*s = *DefaultSettings()
+	s.keepPath = keepPath
+}
+
+// Format returns the configured output format, falling back to JPEG
+func (s *Settings) Format() converter.OutputFormat {
+	format, err := converter.ParseOutputFormat(s.OutputFormat)
+	if err != nil {
+		return converter.FormatJPEG
+	}
+	return format
+}
+
+// EncoderOptions returns the converter options for the current settings
+func (s *Settings) EncoderOptions() converter.Options {
+	opts := converter.DefaultOptions()
+	opts.Quality = s.Quality
+	if subsampling, err := converter.ParseChromaSubsampling(s.ChromaSubsampling); err == nil {
+		opts.ChromaSubsampling = subsampling
+	}
+	opts.Progressive = s.Progressive
+	opts.OptimizeHuffman = s.OptimizeHuffman
+	return opts
+}
+
+// ResizeOptions returns the converter resize options for the current settings
+func (s *Settings) ResizeOptions() converter.ResizeOptions {
+	resize := converter.DefaultResizeOptions()
+	resize.MaxWidth = s.MaxWidth
+	resize.MaxHeight = s.MaxHeight
+	resize.Scale = s.ScalePercent
+	if mode, err := converter.ParseResizeMode(s.ResizeMode); err == nil {
+		resize.Mode = mode
+	}
+	if filter, err := converter.ParseResampleFilter(s.ResizeFilter); err == nil {
+		resize.Filter = filter
+	}
+	return resize
+}
+
+// BatchLayout returns the layout of batch output
+func (s *Settings) BatchLayout() batch.Layout {
+	if s.FlattenOutput {
+		return batch.LayoutFlatten
+	}
+	return batch.LayoutMirror
+}
+
+// BatchConflictPolicy returns the conflict policy for batch conversions, or
+// false if the user should be asked about every existing output file
+func (s *Settings) BatchConflictPolicy() (batch.ConflictPolicy, bool) {
+	policy, err := batch.ParseConflictPolicy(s.ConflictPolicy)
+	if err != nil {
+		return "", false
+	}
+	return policy, true
+}
+
+// BatchWorkers returns the number of concurrent batch conversions
+func (s *Settings) BatchWorkers() int {
+	if s.Workers > 0 {
+		return s.Workers
+	}
+	return batch.DefaultWorkers()
+}
+
+// BatchMemoryBudget returns the memory budget for batch conversions in
+// bytes, or 0 for no limit
+func (s *Settings) BatchMemoryBudget() int64 {
+	return int64(s.MemoryBudgetMB) << 20
+}
+
+// validate replaces invalid values with their defaults and returns the names
+// of the settings that were replaced
+func (s *Settings) validate() []string {
+	defaults := DefaultSettings()
+	var invalid []string
+
+	if s.Quality < 1 || s.Quality > 100 {
+		s.Quality = defaults.Quality
+		invalid = append(invalid, "quality")
+	}
+	if s.Theme != "light" && s.Theme != "dark" {
+		s.Theme = defaults.Theme
+		invalid = append(invalid, "theme")
+	}
+	if _, err := converter.ParseOutputFormat(s.OutputFormat); err != nil {
+		s.OutputFormat = defaults.OutputFormat
+		invalid = append(invalid, "output_format")
+	}
+	if _, err := converter.ParseChromaSubsampling(s.ChromaSubsampling); err != nil {
+		s.ChromaSubsampling = defaults.ChromaSubsampling
+		invalid = append(invalid, "chroma_subsampling")
+	}
+	if _, err := batch.ParseConflictPolicy(s.ConflictPolicy); err != nil && s.ConflictPolicy != ConflictAsk {
+		s.ConflictPolicy = defaults.ConflictPolicy
+		invalid = append(invalid, "conflict_policy")
+	}
+	if s.Workers < 0 {
+		s.Workers = defaults.Workers
+		invalid = append(invalid, "workers")
+	}
+	if s.MemoryBudgetMB < 0 {
+		s.MemoryBudgetMB = defaults.MemoryBudgetMB
+		invalid = append(invalid, "memory_budget_mb")
+	}
+	if format, err := converter.ParseOutputFormat(s.AlphaFormat); s.AlphaFormat != "" && (err != nil || !format.SupportsAlpha()) {
+		s.AlphaFormat = defaults.AlphaFormat
+		invalid = append(invalid, "alpha_format")
+	}
+	if _, err := converter.ParseBackground(s.Background); err != nil {
+		s.Background = defaults.Background
+		invalid = append(invalid, "background")
+	}
+	if s.MaxWidth < 0 {
+		s.MaxWidth = defaults.MaxWidth
+		invalid = append(invalid, "max_width")
+	}
+	if s.MaxHeight < 0 {
+		s.MaxHeight = defaults.MaxHeight
+		invalid = append(invalid, "max_height")
+	}
+	if s.ScalePercent < 0 || s.ScalePercent > 100 {
+		s.ScalePercent = defaults.ScalePercent
+		invalid = append(invalid, "scale_percent")
+	}
+	if mode, err := converter.ParseResizeMode(s.ResizeMode); err != nil ||
+		(mode != converter.ResizeFit && (s.MaxWidth == 0 || s.MaxHeight == 0)) {
+		s.ResizeMode = defaults.ResizeMode
+		invalid = append(invalid, "resize_mode")
+	}
+	if _, err := converter.ParseResampleFilter(s.ResizeFilter); err != nil {
+		s.ResizeFilter = defaults.ResizeFilter
+		invalid = append(invalid, "resize_filter")
+	}
+	if s.OutputDir == "" {
+		s.OutputDir = defaults.OutputDir
+		invalid = append(invalid, "output_dir")
+	}
+
+	return invalid
+}
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spenceriam/HEIC-2-Go/internal/errors"
+)
+
+const (
+	// CurrentVersion is the schema version written by Save
+	CurrentVersion = 1
+
+	appDirName       = "heic2go"
+	settingsFileName = "settings.json"
+)
+
+// migration upgrades the raw settings of one schema version to the next
+type migration func(raw map[string]interface{}) error
+
+// migrations maps a schema version to the migration that upgrades it.
+// Version 0 files were written before the version field existed.
+var migrations = map[int]migration{
+	0: func(raw map[string]interface{}) error { return nil },
+}
+
+// Path returns the location of the settings file in the user's config directory
+func Path() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", errors.Wrap(err, errors.ErrSystem, "failed to locate the user config directory")
+	}
+	return filepath.Join(dir, appDirName, settingsFileName), nil
+}
+
+// Load reads the settings file. A missing file yields the default settings.
+// If the file cannot be used, the default settings (or the valid part of the
+// file) are returned together with an error describing the problem, so the
+// caller can warn the user and carry on.
+func Load() (*Settings, error) {
+	path, err := Path()
+	if err != nil {
+		return DefaultSettings(), err
+	}
+	return LoadFile(path)
+}
+
+// LoadFile reads settings from the given file, see Load
+func LoadFile(path string) (*Settings, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return DefaultSettings(), nil
+	}
+	if err != nil {
+		return DefaultSettings(), errors.Wrap(err, errors.ErrFileRead, "failed to read settings").WithDetails(path)
+	}
+
+	settings, version, err := decode(data)
+	if err != nil {
+		// Keep the unreadable file so the next save does not destroy it
+		backup := path + ".corrupt"
+		corrupt := errors.New(errors.ErrInvalidFormat, "settings file is corrupted, using defaults").WithError(err)
+		settings := DefaultSettings()
+		if renameErr := os.Rename(path, backup); renameErr != nil {
+			// The file stays in place, so these settings must never replace it
+			settings.keepPath = path
+			return settings, corrupt.WithDetails(fmt.Sprintf("%s (could not be moved aside: %v; settings will not be saved until it is fixed or removed)",
+				path, renameErr))
+		}
+		return settings, corrupt.WithDetails(fmt.Sprintf("%s (moved to %s)", path, backup))
+	}
+
+	if version > CurrentVersion {
+		// Keep what this version understands, newer fields are ignored
+		settings.validate()
+		return settings, errors.New(errors.ErrNotSupported, "settings file was written by a newer version").
+			WithDetails(fmt.Sprintf("%s (schema version %d, supported %d)", path, version, CurrentVersion))
+	}
+
+	if invalid := settings.validate(); len(invalid) > 0 {
+		return settings, errors.New(errors.ErrInvalidInput, "invalid settings replaced with defaults").
+			WithDetails(strings.Join(invalid, ", "))
+	}
+	return settings, nil
+}
+
+// decode parses a settings file, migrating older schema versions, and
+// returns the settings with the schema version found in the file
+func decode(data []byte) (*Settings, int, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, 0, err
+	}
+
+	version := 0
+	if value, ok := raw["version"]; ok {
+		number, ok := value.(float64)
+		if !ok || number < 0 || number != float64(int(number)) {
+			return nil, 0, fmt.Errorf("invalid schema version: %v", value)
+		}
+		version = int(number)
+	}
+
+	for v := version; v < CurrentVersion; v++ {
+		if err := migrations[v](raw); err != nil {
+			return nil, version, fmt.Errorf("failed to migrate settings from version %d: %w", v, err)
+		}
+	}
+	raw["version"] = CurrentVersion
+
+	// Decode on top of the defaults so fields missing from the file keep them
+	migrated, err := json.Marshal(raw)
+	if err != nil {
+		return nil, version, err
+	}
+	settings := DefaultSettings()
+	if err := json.Unmarshal(migrated, settings); err != nil {
+		return nil, version, err
+	}
+	return settings, version, nil
+}
+
+// Save writes the settings to the settings file
+func Save(settings *Settings) error {
+	path, err := Path()
+	if err != nil {
+		return err
+	}
+	return SaveFile(settings, path)
+}
+
+// SaveFile writes the settings to the given file, replacing it atomically.
+// Settings loaded in place of a corrupted file that could not be moved aside
+// are not written over that file.
+func SaveFile(settings *Settings, path string) error {
+	if settings.keepPath != "" && settings.keepPath == path {
+		return errors.New(errors.ErrFileExists, "settings not saved to keep the corrupted settings file").
+			WithDetails(fmt.Sprintf("%s (fix or remove it to save settings)", path))
+	}
+
+	settings.Version = CurrentVersion
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, errors.ErrSystem, "failed to encode settings")
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrap(err, errors.ErrDirCreate, "failed to create config directory").WithDetails(dir)
+	}
+
+	tmp, err := os.CreateTemp(dir, settingsFileName+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, errors.ErrFileCreate, "failed to save settings").WithDetails(path)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, errors.ErrFileWrite, "failed to save settings").WithDetails(path)
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, errors.ErrFileWrite, "failed to save settings").WithDetails(path)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return errors.Wrap(err, errors.ErrFileWrite, "failed to save settings").WithDetails(path)
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spenceriam/HEIC-2-Go/internal/batch"
+	"github.com/spenceriam/HEIC-2-Go/internal/errors"
+)
+
+// writeSettingsFile writes a settings file into a new temporary directory
+// and returns its path
+func writeSettingsFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), settingsFileName)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadFileMissing(t *testing.T) {
+	settings, err := LoadFile(filepath.Join(t.TempDir(), "missing", settingsFileName))
+	if err != nil {
+		t.Fatalf("LoadFile = %v, want no error for a missing file", err)
+	}
+	if settings.Quality != DefaultSettings().Quality || settings.Version != CurrentVersion {
+		t.Errorf("LoadFile = %+v, want the defaults", settings)
+	}
+}
+
+func TestSaveFileRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", settingsFileName)
+	settings := DefaultSettings()
+	settings.Quality = 70
+	settings.OutputFormat = "png"
+	settings.ConflictPolicy = "skip"
+	settings.Workers = 3
+	if err := SaveFile(settings, path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *loaded != *settings {
+		t.Errorf("LoadFile = %+v, want %+v", loaded, settings)
+	}
+
+	// Only the settings file is left behind, no temporary files
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("%d files in the settings directory, want 1", len(entries))
+	}
+}
+
+func TestLoadFileMigratesVersion0(t *testing.T) {
+	// Version 0 files were written before the version field existed
+	path := writeSettingsFile(t, `{"quality": 55, "output_format": "webp", "preserve_metadata": false}`)
+
+	settings, err := LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Version != CurrentVersion {
+		t.Errorf("Version = %d, want %d", settings.Version, CurrentVersion)
+	}
+	if settings.Quality != 55 || settings.OutputFormat != "webp" || settings.PreserveMetadata {
+		t.Errorf("fields from the file lost: %+v", settings)
+	}
+	// Fields missing from the file keep their defaults
+	if defaults := DefaultSettings(); settings.ChromaSubsampling != defaults.ChromaSubsampling || settings.ResizeFilter != defaults.ResizeFilter {
+		t.Errorf("missing fields not defaulted: %+v", settings)
+	}
+
+	if err := SaveFile(settings, path); err != nil {
+		t.Fatal(err)
+	}
+	var raw map[string]interface{}
+	data, _ := os.ReadFile(path)
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if raw["version"] != float64(CurrentVersion) {
+		t.Errorf("saved version %v, want %d", raw["version"], CurrentVersion)
+	}
+}
+
+func TestLoadFileCorrupt(t *testing.T) {
+	for name, content := range map[string]string{
+		"invalid json":   `{"quality": 55,`,
+		"invalid type":   `{"quality": "high"}`,
+		"invalid schema": `{"version": "one"}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := writeSettingsFile(t, content)
+
+			settings, err := LoadFile(path)
+			if !errors.Is(err, errors.ErrInvalidFormat) {
+				t.Fatalf("LoadFile error = %v, want invalid_format", err)
+			}
+			if settings.Quality != DefaultSettings().Quality {
+				t.Errorf("LoadFile = %+v, want the defaults", settings)
+			}
+
+			// The file is moved aside with its content
+			backup, readErr := os.ReadFile(path + ".corrupt")
+			if readErr != nil || string(backup) != content {
+				t.Fatalf("backup = %q, %v, want the corrupted file", backup, readErr)
+			}
+			if _, statErr := os.Stat(path); !os.IsNotExist(statErr) {
+				t.Errorf("corrupted file still at %s", path)
+			}
+			if err := SaveFile(settings, path); err != nil {
+				t.Errorf("SaveFile after moving the corrupted file: %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadFileCorruptNotMoved(t *testing.T) {
+	content := `{"quality": 55,`
+	path := writeSettingsFile(t, content)
+	// A directory in the way makes moving the file aside fail
+	if err := os.Mkdir(path+".corrupt", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(path+".corrupt", "keep"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	settings, err := LoadFile(path)
+	if !errors.Is(err, errors.ErrInvalidFormat) {
+		t.Fatalf("LoadFile error = %v, want invalid_format", err)
+	}
+	if strings.Contains(err.Error(), "(moved to") || !strings.Contains(err.Error(), "could not be moved") {
+		t.Errorf("LoadFile error = %v, want it to report the failed move", err)
+	}
+
+	// Saving must not replace the file that could not be moved
+	if err := SaveFile(settings, path); !errors.Is(err, errors.ErrFileExists) {
+		t.Errorf("SaveFile error = %v, want file_exists", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != content {
+		t.Errorf("corrupted file changed to %q", data)
+	}
+
+	// Other files can still be written
+	if err := SaveFile(settings, filepath.Join(t.TempDir(), settingsFileName)); err != nil {
+		t.Errorf("SaveFile to another path: %v", err)
+	}
+
+	// Resetting the settings to their defaults keeps the file safe
+	settings.Reset()
+	if err := SaveFile(settings, path); !errors.Is(err, errors.ErrFileExists) {
+		t.Errorf("SaveFile after Reset error = %v, want file_exists", err)
+	}
+}
+
+func TestLoadFileNewerVersion(t *testing.T) {
+	path := writeSettingsFile(t, `{"version": 9, "quality": 44, "future_setting": true}`)
+
+	settings, err := LoadFile(path)
+	if !errors.Is(err, errors.ErrNotSupported) {
+		t.Fatalf("LoadFile error = %v, want not_supported", err)
+	}
+	if settings.Quality != 44 {
+		t.Errorf("Quality = %d, want the value from the file", settings.Quality)
+	}
+	if _, statErr := os.Stat(path); statErr != nil {
+		t.Errorf("newer settings file was moved: %v", statErr)
+	}
+}
+
+func TestLoadFileInvalidValues(t *testing.T) {
+	path := writeSettingsFile(t, `{"version": 1, "quality": 500, "theme": "pink", "conflict_policy": "merge",
+		"workers": -2, "memory_budget_mb": -1, "max_width": -5, "output_format": "png"}`)
+
+	settings, err := LoadFile(path)
+	if !errors.Is(err, errors.ErrInvalidInput) {
+		t.Fatalf("LoadFile error = %v, want invalid_input", err)
+	}
+	appErr, _ := errors.AsAppError(err)
+	want := "quality, theme, conflict_policy, workers, memory_budget_mb, max_width"
+	if appErr.Details != want {
+		t.Errorf("Details = %q, want %q", appErr.Details, want)
+	}
+
+	defaults := DefaultSettings()
+	if settings.Quality != defaults.Quality || settings.Theme != defaults.Theme || settings.ConflictPolicy != defaults.ConflictPolicy ||
+		settings.Workers != defaults.Workers || settings.MemoryBudgetMB != defaults.MemoryBudgetMB || settings.MaxWidth != defaults.MaxWidth {
+		t.Errorf("invalid values not replaced: %+v", settings)
+	}
+	if settings.OutputFormat != "png" {
+		t.Errorf("OutputFormat = %q, want the valid value from the file", settings.OutputFormat)
+	}
+}
+
+func TestBatchSettings(t *testing.T) {
+	settings := DefaultSettings()
+	if settings.BatchWorkers() != batch.DefaultWorkers() {
+		t.Errorf("BatchWorkers() = %d, want %d", settings.BatchWorkers(), batch.DefaultWorkers())
+	}
+	if settings.BatchMemoryBudget() != 0 {
+		t.Errorf("BatchMemoryBudget() = %d, want no limit", settings.BatchMemoryBudget())
+	}
+	if _, ok := settings.BatchConflictPolicy(); ok {
+		t.Error("BatchConflictPolicy() is set for the ask setting")
+	}
+
+	settings.Workers = 3
+	settings.MemoryBudgetMB = 512
+	settings.ConflictPolicy = "keep_newer"
+	settings.FlattenOutput = true
+	if settings.BatchWorkers() != 3 || settings.BatchMemoryBudget() != 512<<20 || settings.BatchLayout() != batch.LayoutFlatten {
+		t.Errorf("batch settings = %d workers, %d bytes, %v", settings.BatchWorkers(), settings.BatchMemoryBudget(), settings.BatchLayout())
+	}
+	if policy, ok := settings.BatchConflictPolicy(); !ok || policy != batch.ConflictKeepNewer {
+		t.Errorf("BatchConflictPolicy() = %q, %v, want keep-newer", policy, ok)
+	}
+}
@@ -0,0 +1,76 @@
+package converter
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+	"strings"
+)
+
+// DefaultBackground is the color that transparent areas are composited onto
+// when the output format cannot store transparency
+var DefaultBackground = color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+
+// ParseBackground parses a background color given as "white", "black" or a
+// hex value such as "#ffffff" or "fff"
+func ParseBackground(value string) (color.NRGBA, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	switch value {
+	case "white":
+		return DefaultBackground, nil
+	case "black":
+		return color.NRGBA{A: 0xff}, nil
+	}
+
+	hex := strings.TrimPrefix(value, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return color.NRGBA{}, fmt.Errorf("invalid background color: %s", value)
+	}
+	return color.NRGBA{R: uint8(rgb >> 16), G: uint8(rgb >> 8), B: uint8(rgb), A: 0xff}, nil
+}
+
+// SupportsAlpha reports whether the format can store transparency
+func (f OutputFormat) SupportsAlpha() bool {
+	return f != FormatJPEG
+}
+
+// straightAlpha returns an image decoded by libheif with an alpha channel as
+// a non-premultiplied image. libheif delivers straight alpha, but the binding
+// stores it in the premultiplied RGBA types.
+func straightAlpha(img image.Image) image.Image {
+	switch src := img.(type) {
+	case *image.RGBA:
+		return &image.NRGBA{Pix: src.Pix, Stride: src.Stride, Rect: src.Rect}
+	case *image.RGBA64:
+		return &image.NRGBA64{Pix: src.Pix, Stride: src.Stride, Rect: src.Rect}
+	}
+	return img
+}
+
+// hasTransparency reports whether any pixel of an image is not fully opaque
+func hasTransparency(img image.Image) bool {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok {
+		return !opaque.Opaque()
+	}
+	return false
+}
+
+// flattenAlpha composites an image onto an opaque background color
+func flattenAlpha(img image.Image, background color.Color) image.Image {
+	bounds := img.Bounds()
+	var dst draw.Image
+	if isHighBitDepth(img) {
+		dst = image.NewRGBA64(bounds)
+	} else {
+		dst = image.NewRGBA(bounds)
+	}
+	draw.Draw(dst, bounds, image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(dst, bounds, img, bounds.Min, draw.Over)
+	return dst
+}
@@ -0,0 +1,240 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"path/filepath"
+	"strings"
+
+	"github.com/spenceriam/HEIC-2-Go/internal/errors"
+	heif "github.com/strukturag/libheif/go/heif"
+)
+
+// auxiliaryImage is an auxiliary image item, such as a depth map or a
+// portrait matte, attached to an image of a HEIF file
+type auxiliaryImage struct {
+	ID uint32
+	// Auxiliary type URN from the 'auxC' property
+	URN string
+}
+
+// Name returns a short name for the kind of auxiliary image, used in its
+// output file name: "depth", "alpha", or the last part of the URN such as
+// "portraiteffectsmatte" for Apple's portrait mattes
+func (a auxiliaryImage) Name() string {
+	urn := strings.ToLower(a.URN)
+	switch {
+	case urn == "urn:mpeg:hevc:2015:auxid:1" || strings.HasSuffix(urn, ":auxiliary:alpha"):
+		return "alpha"
+	case urn == "urn:mpeg:hevc:2015:auxid:2" || strings.HasSuffix(urn, ":depth"):
+		return "depth"
+	}
+
+	name := urn[strings.LastIndex(urn, ":")+1:]
+	name = strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return -1
+	}, name)
+	if name == "" {
+		return "aux"
+	}
+	return name
+}
+
+// isExported reports whether the auxiliary image is a depth map, an alpha
+// plane or a matte, as opposed to e.g. an HDR gain map
+func (a auxiliaryImage) isExported() bool {
+	name := a.Name()
+	return name == "depth" || name == "alpha" || strings.Contains(name, "matte")
+}
+
+// findAuxiliaryImages returns the auxiliary images of an image in a HEIF file
+func findAuxiliaryImages(data []byte, itemID uint32) ([]auxiliaryImage, error) {
+	meta, err := parseHEIFMeta(data)
+	if err != nil {
+		return nil, err
+	}
+	return meta.auxiliaryImages(itemID), nil
+}
+
+// auxiliaryImages returns the auxiliary images of an image
+func (m *heifMeta) auxiliaryImages(itemID uint32) []auxiliaryImage {
+	var images []auxiliaryImage
+	for _, id := range m.ItemIDs {
+		if !m.referencesTo("auxl", id, itemID) {
+			continue
+		}
+
+		aux := auxiliaryImage{ID: id}
+		for _, prop := range m.itemProperties(id) {
+			if prop.Type == "auxC" {
+				r := newBoxReader(prop.Payload)
+				r.fullBoxHeader()
+				aux.URN = r.cString()
+			}
+		}
+		images = append(images, aux)
+	}
+	return images
+}
+
+// revealAuxiliaryImage returns a copy of a HEIF file in which an auxiliary
+// image is an ordinary top-level image. libheif only hands out top-level
+// images, so the item's 'auxl' references are renamed to a reference type
+// it ignores. Box sizes do not change, so all offsets stay valid.
+func revealAuxiliaryImage(data []byte, itemID uint32) ([]byte, error) {
+	revealed := append([]byte(nil), data...)
+	meta, err := parseHEIFMeta(revealed)
+	if err != nil {
+		return nil, err
+	}
+
+	found := false
+	for _, ref := range meta.References {
+		if ref.Type == "auxl" && ref.From == itemID {
+			copy(ref.header[4:8], "auxX")
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("item %d is not an auxiliary image", itemID)
+	}
+	return revealed, nil
+}
+
+// decodeAuxiliaryImage decodes an auxiliary image of a HEIF file as a
+// grayscale image
+func decodeAuxiliaryImage(data []byte, itemID uint32) (image.Image, error) {
+	revealed, err := revealAuxiliaryImage(data, itemID)
+	if err != nil {
+		return nil, err
+	}
+
+	heifCtx, err := heif.NewContext()
+	if err != nil {
+		return nil, err
+	}
+	if err := heifCtx.ReadFromMemory(revealed); err != nil {
+		return nil, err
+	}
+	handle, err := heifCtx.GetImageHandle(int(itemID))
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := handle.DecodeImage(heif.ColorspaceUndefined, heif.ChromaUndefined, nil)
+	if err != nil {
+		return nil, err
+	}
+	img, err := decoded.GetImage()
+	if err != nil {
+		return nil, err
+	}
+	return toGray(img), nil
+}
+
+// toGray returns the luma of an image as a grayscale image, keeping 16 bits
+// per sample for high bit depth sources
+func toGray(img image.Image) image.Image {
+	switch src := img.(type) {
+	case *image.Gray, *image.Gray16:
+		return img
+	case *image.YCbCr:
+		// Monochrome images decode with the samples in the Y plane
+		gray := image.NewGray(image.Rect(0, 0, src.Rect.Dx(), src.Rect.Dy()))
+		for y := 0; y < gray.Rect.Dy(); y++ {
+			start := src.YOffset(src.Rect.Min.X, src.Rect.Min.Y+y)
+			copy(gray.Pix[y*gray.Stride:], src.Y[start:start+gray.Rect.Dx()])
+		}
+		return gray
+	}
+
+	bounds := img.Bounds()
+	if isHighBitDepth(img) {
+		gray := image.NewGray16(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				gray.Set(x-bounds.Min.X, y-bounds.Min.Y, color.Gray16Model.Convert(img.At(x, y)))
+			}
+		}
+		return gray
+	}
+	gray := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			gray.Set(x-bounds.Min.X, y-bounds.Min.Y, color.GrayModel.Convert(img.At(x, y)))
+		}
+	}
+	return gray
+}
+
+// AuxiliaryOutputPath returns the output path of an auxiliary image of the
+// image written to outputPath, e.g. "IMG_0001_depth.png"
+func AuxiliaryOutputPath(outputPath, name string, format OutputFormat) string {
+	ext := filepath.Ext(outputPath)
+	return strings.TrimSuffix(outputPath, ext) + "_" + name + format.Extension()
+}
+
+// auxiliaryOutput is an exported auxiliary image and its output path
+type auxiliaryOutput struct {
+	image auxiliaryImage
+	path  string
+}
+
+// auxiliaryOutputs returns the depth maps, alpha planes and mattes among the
+// auxiliary images of the image written to outputPath, with their output
+// paths. Additional images of the same kind are numbered, e.g. "depth2".
+func (c *HEICConverter) auxiliaryOutputs(aux []auxiliaryImage, outputPath string) []auxiliaryOutput {
+	var outputs []auxiliaryOutput
+	used := make(map[string]int)
+	for _, a := range aux {
+		if !a.isExported() {
+			continue
+		}
+		name := a.Name()
+		used[name]++
+		if n := used[name]; n > 1 {
+			name = fmt.Sprintf("%s%d", name, n)
+		}
+		outputs = append(outputs, auxiliaryOutput{image: a, path: AuxiliaryOutputPath(outputPath, name, c.auxFormat)})
+	}
+	return outputs
+}
+
+// exportAuxiliaryImages writes the depth maps, alpha planes and mattes of an
+// image next to its output file
+func (c *HEICConverter) exportAuxiliaryImages(ctx context.Context, data []byte, itemID uint32, outputPath string) error {
+	aux, err := findAuxiliaryImages(data, itemID)
+	if err != nil {
+		return errors.Wrap(err, errors.ErrDecodeFailed, "failed to read auxiliary images")
+	}
+
+	encoder, err := NewEncoder(c.auxFormat, c.options)
+	if err != nil {
+		return errors.Wrap(err, errors.ErrNotSupported, "unsupported auxiliary image format")
+	}
+
+	for _, output := range c.auxiliaryOutputs(aux, outputPath) {
+		if err := checkCancelled(ctx); err != nil {
+			return err
+		}
+		path := output.path
+
+		img, err := decodeAuxiliaryImage(data, output.image.ID)
+		if err != nil {
+			return errors.Wrap(err, errors.ErrDecodeFailed, "failed to decode auxiliary image").WithDetails(path)
+		}
+		var buf bytes.Buffer
+		if err := encoder.Encode(&buf, img); err != nil {
+			return errors.Wrap(err, errors.ErrEncodeFailed, "failed to encode auxiliary image").WithDetails(path)
+		}
+		if err := writeOutput(ctx, path, buf.Bytes()); err != nil {
+			return errors.Wrap(err, errors.ErrFileWrite, "failed to save auxiliary image").WithDetails(path)
+		}
+	}
+	return nil
+}
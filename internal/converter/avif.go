@@ -0,0 +1,74 @@
+package converter
+
+import (
+	"image"
+	"io"
+	"os"
+
+	"github.com/disintegration/imaging"
+	heif "github.com/strukturag/libheif/go/heif"
+)
+
+// avifEncoder encodes AVIF files through libheif's AV1 encoder
+type avifEncoder struct {
+	quality  int
+	lossless bool
+}
+
+// Encode implements ImageEncoder
+func (e *avifEncoder) Encode(w io.Writer, img image.Image) error {
+	lossless := heif.LosslessModeDisabled
+	if e.lossless {
+		lossless = heif.LosslessModeEnabled
+	}
+
+	ctx, err := heif.EncodeFromImage(toHEIFImage(img), heif.CompressionAV1, e.quality, lossless, heif.LoggingLevelNone)
+	if err != nil {
+		return err
+	}
+
+	// libheif can only write to a file, so go through a temporary one
+	tmp, err := os.CreateTemp("", "heic2go-*.avif")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	defer os.Remove(tmpPath)
+
+	if err := ctx.WriteToFile(tmpPath); err != nil {
+		return err
+	}
+
+	file, err := os.Open(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// toHEIFImage converts an image to one of the types libheif can encode.
+// libheif expects straight alpha, so non-premultiplied pixel data is passed
+// through in the RGBA containers it accepts.
+func toHEIFImage(img image.Image) image.Image {
+	switch src := img.(type) {
+	case *image.YCbCr, *image.Gray:
+		return src
+	case *image.NRGBA64:
+		return &image.RGBA64{Pix: src.Pix, Stride: src.Stride, Rect: src.Rect}
+	case *image.RGBA64:
+		nrgba := image.NewNRGBA64(src.Bounds())
+		for y := src.Rect.Min.Y; y < src.Rect.Max.Y; y++ {
+			for x := src.Rect.Min.X; x < src.Rect.Max.X; x++ {
+				nrgba.Set(x, y, src.At(x, y))
+			}
+		}
+		return &image.RGBA64{Pix: nrgba.Pix, Stride: nrgba.Stride, Rect: nrgba.Rect}
+	}
+
+	nrgba := imaging.Clone(img)
+	return &image.RGBA{Pix: nrgba.Pix, Stride: nrgba.Stride, Rect: nrgba.Rect}
+}
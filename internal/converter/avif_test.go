@@ -0,0 +1,57 @@
+package converter
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestToHEIFImage(t *testing.T) {
+	nrgba := testImage(5, 4, true)
+	if rgba, ok := toHEIFImage(nrgba).(*image.RGBA); !ok {
+		t.Fatalf("8-bit image converted to %T, want *image.RGBA", toHEIFImage(nrgba))
+	} else if !bytes.Equal(rgba.Pix, nrgba.Pix) {
+		// libheif expects straight alpha, so the samples must not be premultiplied
+		t.Error("8-bit samples changed")
+	}
+
+	deep := image.NewNRGBA64(image.Rect(0, 0, 2, 2))
+	deep.SetNRGBA64(1, 1, color.NRGBA64{R: 0xffff, G: 0x8000, B: 0x1234, A: 0x8000})
+	if rgba, ok := toHEIFImage(deep).(*image.RGBA64); !ok || !bytes.Equal(rgba.Pix, deep.Pix) {
+		t.Error("16-bit straight-alpha samples were not passed through")
+	}
+
+	premultiplied := image.NewRGBA64(image.Rect(0, 0, 1, 1))
+	premultiplied.SetRGBA64(0, 0, color.RGBA64{R: 0x4000, G: 0x2000, B: 0, A: 0x8000})
+	got := toHEIFImage(premultiplied).(*image.RGBA64)
+	if r, g := got.Pix[0:2], got.Pix[2:4]; r[0] != 0x7f || g[0] != 0x3f {
+		t.Errorf("premultiplied samples not converted to straight alpha: %v", got.Pix)
+	}
+
+	for _, img := range []image.Image{image.NewGray(image.Rect(0, 0, 2, 2)), image.NewYCbCr(image.Rect(0, 0, 2, 2), image.YCbCrSubsampleRatio420)} {
+		if converted := toHEIFImage(img); converted != img {
+			t.Errorf("%T converted, want it passed through", img)
+		}
+	}
+}
+
+func TestAVIFEncode(t *testing.T) {
+	src := testImage(64, 48, false)
+	var buf bytes.Buffer
+	if err := (&avifEncoder{quality: 80}).Encode(&buf, src); err != nil {
+		t.Skipf("libheif cannot encode AV1: %v", err)
+	}
+
+	file, err := validateHEIF(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !file.FileType.hasBrand("avif") {
+		t.Errorf("brands %s %v, want avif", file.FileType.MajorBrand, file.FileType.CompatibleBrands)
+	}
+	width, height, ok := itemSize(file.Meta, file.Meta.PrimaryID)
+	if !ok || width != 64 || height != 48 {
+		t.Errorf("primary image %dx%d, want 64x48", width, height)
+	}
+}
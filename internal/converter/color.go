@@ -0,0 +1,710 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/binary"
+	stderrors "errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+)
+
+// ColorMode controls how the color space of the source image is carried into the output
+type ColorMode int
+
+const (
+	// ColorModeEmbed keeps the source pixels and embeds the source ICC profile
+	ColorModeEmbed ColorMode = iota
+	// ColorModeConvertSRGB converts the pixels to sRGB before encoding
+	ColorModeConvertSRGB
+)
+
+// String returns the settings name of the color mode
+func (m ColorMode) String() string {
+	switch m {
+	case ColorModeConvertSRGB:
+		return "srgb"
+	default:
+		return "embed"
+	}
+}
+
+// ParseColorMode parses a color mode name as used in settings
+func ParseColorMode(name string) (ColorMode, error) {
+	switch name {
+	case "", "embed":
+		return ColorModeEmbed, nil
+	case "srgb":
+		return ColorModeConvertSRGB, nil
+	default:
+		return ColorModeEmbed, fmt.Errorf("unknown color mode: %s", name)
+	}
+}
+
+// NCLX code points from ITU-T H.273 that we know how to handle
+const (
+	nclxPrimariesBT709       = 1
+	nclxPrimariesUnspecified = 2
+	nclxPrimariesBT2020      = 9
+	nclxPrimariesDCIP3       = 11
+	nclxPrimariesDisplayP3   = 12
+
+	nclxTransferBT709       = 1
+	nclxTransferUnspecified = 2
+	nclxTransferGamma22     = 4
+	nclxTransferGamma28     = 5
+	nclxTransferBT601       = 6
+	nclxTransferLinear      = 8
+	nclxTransferSRGB        = 13
+	nclxTransferBT2020_10   = 14
+	nclxTransferBT2020_12   = 15
+	nclxTransferPQ          = 16
+	nclxTransferHLG         = 18
+)
+
+// nclxColor holds the parameters of an 'nclx' color property
+type nclxColor struct {
+	ColorPrimaries          uint16
+	TransferCharacteristics uint16
+	MatrixCoefficients      uint16
+	FullRange               bool
+}
+
+// colorInfo describes the color space declared by the source image
+type colorInfo struct {
+	// Raw ICC profile from a 'prof' or 'rICC' color property
+	ICCProfile []byte
+	// Parameters from an 'nclx' color property
+	NCLX *nclxColor
+}
+
+// extractColorInfo returns the color space declared for an image of a HEIF
+// file, or nil if the file does not declare one
+func extractColorInfo(data []byte, itemID uint32) (*colorInfo, error) {
+	meta, err := parseHEIFMeta(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var info *colorInfo
+	for _, prop := range meta.itemProperties(itemID) {
+		if prop.Type != "colr" {
+			continue
+		}
+
+		r := newBoxReader(prop.Payload)
+		switch colorType := r.fourCC(); colorType {
+		case "nclx":
+			nclx := &nclxColor{
+				ColorPrimaries:          r.uint16(),
+				TransferCharacteristics: r.uint16(),
+				MatrixCoefficients:      r.uint16(),
+				FullRange:               r.uint8()&0x80 != 0,
+			}
+			if r.err != nil {
+				return nil, fmt.Errorf("invalid 'nclx' color property: %w", r.err)
+			}
+			if info == nil {
+				info = &colorInfo{}
+			}
+			info.NCLX = nclx
+		case "prof", "rICC":
+			if info == nil {
+				info = &colorInfo{}
+			}
+			info.ICCProfile = r.rest()
+		}
+	}
+
+	return info, nil
+}
+
+// isSRGB reports whether the declared color space is sRGB, or is not declared
+// at all, in which case viewers assume sRGB anyway
+func (ci *colorInfo) isSRGB() bool {
+	if ci == nil {
+		return true
+	}
+	if ci.ICCProfile != nil {
+		return false
+	}
+	if ci.NCLX == nil {
+		return true
+	}
+
+	primaries := ci.NCLX.ColorPrimaries
+	transfer := ci.NCLX.TransferCharacteristics
+	return (primaries == nclxPrimariesBT709 || primaries == nclxPrimariesUnspecified) &&
+		(transfer == nclxTransferSRGB || transfer == nclxTransferUnspecified)
+}
+
+// outputProfile returns the ICC profile to embed in the output, synthesizing
+// one from the NCLX parameters when the source has no ICC profile
+func (ci *colorInfo) outputProfile() ([]byte, error) {
+	if ci.isSRGB() {
+		return nil, nil
+	}
+	if ci.ICCProfile != nil {
+		return ci.ICCProfile, nil
+	}
+
+	space, err := ci.rgbSpace()
+	if err != nil {
+		return nil, err
+	}
+	return buildICCProfile(space), nil
+}
+
+// rgbSpace returns the source color space as a matrix/curve RGB space
+func (ci *colorInfo) rgbSpace() (*rgbSpace, error) {
+	if ci.ICCProfile != nil {
+		return parseICCProfile(ci.ICCProfile)
+	}
+	if ci.NCLX != nil {
+		return nclxRGBSpace(ci.NCLX)
+	}
+	return srgbSpace(), nil
+}
+
+// mat3 is a 3x3 matrix in row-major order
+type mat3 [3][3]float64
+
+// mul returns the product m * n
+func (m mat3) mul(n mat3) mat3 {
+	var r mat3
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				r[i][j] += m[i][k] * n[k][j]
+			}
+		}
+	}
+	return r
+}
+
+// apply returns the product m * v
+func (m mat3) apply(v [3]float64) [3]float64 {
+	return [3]float64{
+		m[0][0]*v[0] + m[0][1]*v[1] + m[0][2]*v[2],
+		m[1][0]*v[0] + m[1][1]*v[1] + m[1][2]*v[2],
+		m[2][0]*v[0] + m[2][1]*v[1] + m[2][2]*v[2],
+	}
+}
+
+// inverse returns the inverse of m
+func (m mat3) inverse() (mat3, error) {
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	if math.Abs(det) < 1e-12 {
+		return mat3{}, stderrors.New("singular color matrix")
+	}
+
+	return mat3{
+		{
+			(m[1][1]*m[2][2] - m[1][2]*m[2][1]) / det,
+			(m[0][2]*m[2][1] - m[0][1]*m[2][2]) / det,
+			(m[0][1]*m[1][2] - m[0][2]*m[1][1]) / det,
+		},
+		{
+			(m[1][2]*m[2][0] - m[1][0]*m[2][2]) / det,
+			(m[0][0]*m[2][2] - m[0][2]*m[2][0]) / det,
+			(m[0][2]*m[1][0] - m[0][0]*m[1][2]) / det,
+		},
+		{
+			(m[1][0]*m[2][1] - m[1][1]*m[2][0]) / det,
+			(m[0][1]*m[2][0] - m[0][0]*m[2][1]) / det,
+			(m[0][0]*m[1][1] - m[0][1]*m[1][0]) / det,
+		},
+	}, nil
+}
+
+// Chromaticities of the white points used by the supported color spaces
+var (
+	whiteD65 = [2]float64{0.3127, 0.3290}
+	whiteDCI = [2]float64{0.314, 0.351}
+	// ICC profile connection space white point
+	whiteD50XYZ = [3]float64{0.9642, 1.0, 0.8249}
+)
+
+// xyToXYZ converts a chromaticity to XYZ with Y = 1
+func xyToXYZ(xy [2]float64) [3]float64 {
+	return [3]float64{xy[0] / xy[1], 1, (1 - xy[0] - xy[1]) / xy[1]}
+}
+
+// primariesToXYZD50 builds the RGB to XYZ matrix for the given primaries and
+// white point, chromatically adapted to the D50 profile connection space
+func primariesToXYZD50(primaries [3][2]float64, white [2]float64) (mat3, error) {
+	var m mat3
+	for c := 0; c < 3; c++ {
+		xyz := xyToXYZ(primaries[c])
+		for i := 0; i < 3; i++ {
+			m[i][c] = xyz[i]
+		}
+	}
+
+	inv, err := m.inverse()
+	if err != nil {
+		return mat3{}, err
+	}
+
+	// Scale the primaries so that RGB 1,1,1 maps to the white point
+	whiteXYZ := xyToXYZ(white)
+	scale := inv.apply(whiteXYZ)
+	for i := 0; i < 3; i++ {
+		for c := 0; c < 3; c++ {
+			m[i][c] *= scale[c]
+		}
+	}
+
+	adapt, err := bradfordAdaptation(whiteXYZ, whiteD50XYZ)
+	if err != nil {
+		return mat3{}, err
+	}
+	return adapt.mul(m), nil
+}
+
+// bradfordAdaptation returns the Bradford chromatic adaptation matrix
+// between two white points
+func bradfordAdaptation(src, dst [3]float64) (mat3, error) {
+	bradford := mat3{
+		{0.8951, 0.2664, -0.1614},
+		{-0.7502, 1.7135, 0.0367},
+		{0.0389, -0.0685, 1.0296},
+	}
+	inv, err := bradford.inverse()
+	if err != nil {
+		return mat3{}, err
+	}
+
+	srcLMS := bradford.apply(src)
+	dstLMS := bradford.apply(dst)
+	var scale mat3
+	for i := 0; i < 3; i++ {
+		scale[i][i] = dstLMS[i] / srcLMS[i]
+	}
+
+	return inv.mul(scale).mul(bradford), nil
+}
+
+// rgbSpace is a matrix/curve RGB color space
+type rgbSpace struct {
+	// Human-readable name used in synthesized profiles
+	Name string
+	// Matrix from linear RGB to D50 XYZ
+	ToXYZ mat3
+	// Per-channel transfer functions from encoded to linear values
+	Decode [3]func(float64) float64
+}
+
+// srgbSpace returns the sRGB color space
+func srgbSpace() *rgbSpace {
+	m, _ := primariesToXYZD50(primariesBT709, whiteD65)
+	return &rgbSpace{
+		Name:   "sRGB",
+		ToXYZ:  m,
+		Decode: uniformCurve(srgbDecode),
+	}
+}
+
+// Primaries of the supported NCLX color spaces
+var (
+	primariesBT709  = [3][2]float64{{0.640, 0.330}, {0.300, 0.600}, {0.150, 0.060}}
+	primariesBT2020 = [3][2]float64{{0.708, 0.292}, {0.170, 0.797}, {0.131, 0.046}}
+	primariesP3     = [3][2]float64{{0.680, 0.320}, {0.265, 0.690}, {0.150, 0.060}}
+)
+
+// nclxRGBSpace builds an RGB space from NCLX parameters
+func nclxRGBSpace(nclx *nclxColor) (*rgbSpace, error) {
+	var (
+		name      string
+		primaries [3][2]float64
+		white     = whiteD65
+	)
+	switch nclx.ColorPrimaries {
+	case nclxPrimariesBT709, nclxPrimariesUnspecified:
+		name, primaries = "BT.709", primariesBT709
+	case nclxPrimariesBT2020:
+		name, primaries = "BT.2020", primariesBT2020
+	case nclxPrimariesDCIP3:
+		name, primaries, white = "DCI-P3", primariesP3, whiteDCI
+	case nclxPrimariesDisplayP3:
+		name, primaries = "Display P3", primariesP3
+	default:
+		return nil, fmt.Errorf("unsupported color primaries: %d", nclx.ColorPrimaries)
+	}
+
+	var decode func(float64) float64
+	switch nclx.TransferCharacteristics {
+	case nclxTransferSRGB, nclxTransferUnspecified:
+		decode = srgbDecode
+	case nclxTransferBT709, nclxTransferBT601, nclxTransferBT2020_10, nclxTransferBT2020_12:
+		decode = bt709Decode
+	case nclxTransferGamma22:
+		decode = gammaDecode(2.2)
+	case nclxTransferGamma28:
+		decode = gammaDecode(2.8)
+	case nclxTransferLinear:
+		decode = func(v float64) float64 { return v }
+	default:
+		return nil, fmt.Errorf("unsupported transfer characteristics: %d", nclx.TransferCharacteristics)
+	}
+
+	m, err := primariesToXYZD50(primaries, white)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rgbSpace{Name: name, ToXYZ: m, Decode: uniformCurve(decode)}, nil
+}
+
+// uniformCurve uses the same transfer function for all three channels
+func uniformCurve(f func(float64) float64) [3]func(float64) float64 {
+	return [3]func(float64) float64{f, f, f}
+}
+
+// srgbDecode is the sRGB electro-optical transfer function
+func srgbDecode(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// srgbEncode is the inverse of srgbDecode
+func srgbEncode(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// bt709Decode inverts the BT.709 opto-electronic transfer function
+func bt709Decode(v float64) float64 {
+	if v < 0.081 {
+		return v / 4.5
+	}
+	return math.Pow((v+0.099)/1.099, 1/0.45)
+}
+
+// gammaDecode returns a pure power-law transfer function
+func gammaDecode(gamma float64) func(float64) float64 {
+	return func(v float64) float64 {
+		return math.Pow(v, gamma)
+	}
+}
+
+// parseICCProfile extracts the matrix and tone curves of an RGB display profile
+func parseICCProfile(profile []byte) (*rgbSpace, error) {
+	tags, err := iccTags(profile)
+	if err != nil {
+		return nil, err
+	}
+	if string(profile[16:20]) != "RGB " || string(profile[20:24]) != "XYZ " {
+		return nil, stderrors.New("ICC profile is not an RGB profile with an XYZ connection space")
+	}
+
+	space := &rgbSpace{Name: "ICC"}
+	for c, sig := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		xyz, err := parseICCXYZ(tags[sig])
+		if err != nil {
+			return nil, fmt.Errorf("ICC tag '%s': %w", sig, err)
+		}
+		for i := 0; i < 3; i++ {
+			space.ToXYZ[i][c] = xyz[i]
+		}
+	}
+	for c, sig := range []string{"rTRC", "gTRC", "bTRC"} {
+		curve, err := parseICCCurve(tags[sig])
+		if err != nil {
+			return nil, fmt.Errorf("ICC tag '%s': %w", sig, err)
+		}
+		space.Decode[c] = curve
+	}
+
+	return space, nil
+}
+
+// iccTags returns the tags of an ICC profile keyed by their signature
+func iccTags(profile []byte) (map[string][]byte, error) {
+	if len(profile) < 132 {
+		return nil, stderrors.New("ICC profile too small")
+	}
+
+	tags := make(map[string][]byte)
+	count := int(binary.BigEndian.Uint32(profile[128:]))
+	for i := 0; i < count; i++ {
+		entry := 132 + i*12
+		if entry+12 > len(profile) {
+			return nil, stderrors.New("truncated ICC tag table")
+		}
+		sig := string(profile[entry : entry+4])
+		offset := binary.BigEndian.Uint32(profile[entry+4:])
+		size := binary.BigEndian.Uint32(profile[entry+8:])
+		if uint64(offset)+uint64(size) > uint64(len(profile)) {
+			return nil, fmt.Errorf("ICC tag '%s' is out of range", sig)
+		}
+		tags[sig] = profile[offset : offset+size]
+	}
+	return tags, nil
+}
+
+// s15Fixed16 decodes an ICC signed 15.16 fixed-point number
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// parseICCXYZ parses an XYZType tag
+func parseICCXYZ(tag []byte) ([3]float64, error) {
+	if len(tag) < 20 || string(tag[:4]) != "XYZ " {
+		return [3]float64{}, stderrors.New("missing or invalid XYZ tag")
+	}
+	return [3]float64{s15Fixed16(tag[8:]), s15Fixed16(tag[12:]), s15Fixed16(tag[16:])}, nil
+}
+
+// parseICCCurve parses a curveType or parametricCurveType tag
+func parseICCCurve(tag []byte) (func(float64) float64, error) {
+	if len(tag) < 12 {
+		return nil, stderrors.New("missing or invalid curve tag")
+	}
+
+	switch string(tag[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+		if len(tag) < 12+2*n {
+			return nil, stderrors.New("truncated curve")
+		}
+		switch n {
+		case 0:
+			return func(v float64) float64 { return v }, nil
+		case 1:
+			return gammaDecode(float64(binary.BigEndian.Uint16(tag[12:])) / 256), nil
+		}
+
+		table := make([]float64, n)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(tag[12+2*i:])) / 65535
+		}
+		return func(v float64) float64 {
+			pos := v * float64(n-1)
+			i := int(pos)
+			if i >= n-1 {
+				return table[n-1]
+			}
+			if i < 0 {
+				return table[0]
+			}
+			frac := pos - float64(i)
+			return table[i]*(1-frac) + table[i+1]*frac
+		}, nil
+
+	case "para":
+		function := binary.BigEndian.Uint16(tag[8:])
+		paramCounts := map[uint16]int{0: 1, 1: 3, 2: 4, 3: 5, 4: 7}
+		count, ok := paramCounts[function]
+		if !ok {
+			return nil, fmt.Errorf("unsupported parametric curve type %d", function)
+		}
+		if len(tag) < 12+4*count {
+			return nil, stderrors.New("truncated parametric curve")
+		}
+
+		// Unused parameters default to the identity of the general form
+		p := [7]float64{1, 1, 0, 0, 0, 0, 0}
+		for i := 0; i < count; i++ {
+			p[i] = s15Fixed16(tag[12+4*i:])
+		}
+		g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+
+		return func(v float64) float64 {
+			switch function {
+			case 0:
+				return math.Pow(v, g)
+			case 1:
+				if v >= -b/a {
+					return math.Pow(a*v+b, g)
+				}
+				return 0
+			case 2:
+				if v >= -b/a {
+					return math.Pow(a*v+b, g) + c
+				}
+				return c
+			case 3:
+				if v >= d {
+					return math.Pow(a*v+b, g)
+				}
+				return c * v
+			default:
+				if v >= d {
+					return math.Pow(a*v+b, g) + e
+				}
+				return c*v + f
+			}
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported curve type '%s'", string(tag[:4]))
+}
+
+// buildICCProfile synthesizes a version 2 matrix/TRC display profile
+func buildICCProfile(space *rgbSpace) []byte {
+	xyzTag := func(v [3]float64) []byte {
+		tag := []byte("XYZ \x00\x00\x00\x00")
+		for _, f := range v {
+			tag = binary.BigEndian.AppendUint32(tag, uint32(int32(math.Round(f*65536))))
+		}
+		return tag
+	}
+	column := func(c int) [3]float64 {
+		return [3]float64{space.ToXYZ[0][c], space.ToXYZ[1][c], space.ToXYZ[2][c]}
+	}
+	curveTag := func(decode func(float64) float64) []byte {
+		const points = 1024
+		tag := []byte("curv\x00\x00\x00\x00")
+		tag = binary.BigEndian.AppendUint32(tag, points)
+		for i := 0; i < points; i++ {
+			v := math.Max(0, math.Min(1, decode(float64(i)/(points-1))))
+			tag = binary.BigEndian.AppendUint16(tag, uint16(math.Round(v*65535)))
+		}
+		return tag
+	}
+
+	desc := []byte("desc\x00\x00\x00\x00")
+	desc = binary.BigEndian.AppendUint32(desc, uint32(len(space.Name)+1))
+	desc = append(desc, space.Name...)
+	desc = append(desc, 0)
+	// Empty Unicode and ScriptCode descriptions
+	desc = append(desc, make([]byte, 4+4+2+1+67)...)
+
+	tags := []struct {
+		sig  string
+		data []byte
+	}{
+		{"desc", desc},
+		{"cprt", []byte("text\x00\x00\x00\x00No copyright, use freely\x00")},
+		{"wtpt", xyzTag(whiteD50XYZ)},
+		{"rXYZ", xyzTag(column(0))},
+		{"gXYZ", xyzTag(column(1))},
+		{"bXYZ", xyzTag(column(2))},
+		{"rTRC", curveTag(space.Decode[0])},
+		{"gTRC", curveTag(space.Decode[1])},
+		{"bTRC", curveTag(space.Decode[2])},
+	}
+
+	// Lay out tag data after the header and tag table, 4-byte aligned
+	var body bytes.Buffer
+	table := binary.BigEndian.AppendUint32(nil, uint32(len(tags)))
+	dataStart := 128 + 4 + 12*len(tags)
+	for _, tag := range tags {
+		offset := dataStart + body.Len()
+		table = append(table, tag.sig...)
+		table = binary.BigEndian.AppendUint32(table, uint32(offset))
+		table = binary.BigEndian.AppendUint32(table, uint32(len(tag.data)))
+		body.Write(tag.data)
+		for body.Len()%4 != 0 {
+			body.WriteByte(0)
+		}
+	}
+
+	size := dataStart + body.Len()
+	header := make([]byte, 128)
+	binary.BigEndian.PutUint32(header[0:], uint32(size))
+	binary.BigEndian.PutUint32(header[8:], 0x02100000) // version 2.1
+	copy(header[12:], "mntr")
+	copy(header[16:], "RGB ")
+	copy(header[20:], "XYZ ")
+	copy(header[36:], "acsp")
+	for i, f := range whiteD50XYZ {
+		binary.BigEndian.PutUint32(header[68+4*i:], uint32(int32(math.Round(f*65536))))
+	}
+
+	profile := make([]byte, 0, size)
+	profile = append(profile, header...)
+	profile = append(profile, table...)
+	profile = append(profile, body.Bytes()...)
+	return profile
+}
+
+// convertToSRGB converts an image from the given color space to sRGB
+func convertToSRGB(img image.Image, space *rgbSpace) (image.Image, error) {
+	srgb := srgbSpace()
+	toRGB, err := srgb.ToXYZ.inverse()
+	if err != nil {
+		return nil, err
+	}
+	m := toRGB.mul(space.ToXYZ)
+
+	bounds := img.Bounds()
+	if isHighBitDepth(img) {
+		dst := image.NewNRGBA64(bounds)
+		draw.Draw(dst, bounds, img, bounds.Min, draw.Src)
+		transformPixels(dst.Pix, 2, m, space.Decode)
+		return dst, nil
+	}
+
+	dst := image.NewNRGBA(bounds)
+	draw.Draw(dst, bounds, img, bounds.Min, draw.Src)
+	transformPixels(dst.Pix, 1, m, space.Decode)
+	return dst, nil
+}
+
+// isHighBitDepth reports whether an image stores more than 8 bits per channel
+func isHighBitDepth(img image.Image) bool {
+	switch img.(type) {
+	case *image.RGBA64, *image.NRGBA64, *image.Gray16:
+		return true
+	}
+	return false
+}
+
+// transformPixels applies a linear-light matrix to non-premultiplied RGBA
+// pixel data with the given number of bytes per channel, writing sRGB values
+func transformPixels(pix []byte, bytesPerChannel int, m mat3, decode [3]func(float64) float64) {
+	levels := 1 << (8 * bytesPerChannel)
+	maxValue := float64(levels - 1)
+
+	// Tabulate the transfer functions since they are expensive to evaluate
+	var decodeLUT [3][]float64
+	for c := 0; c < 3; c++ {
+		decodeLUT[c] = make([]float64, levels)
+		for i := range decodeLUT[c] {
+			decodeLUT[c][i] = decode[c](float64(i) / maxValue)
+		}
+	}
+
+	const encodeSteps = 1 << 16
+	encodeLUT := make([]float64, encodeSteps+1)
+	for i := range encodeLUT {
+		encodeLUT[i] = math.Round(srgbEncode(float64(i)/encodeSteps) * maxValue)
+	}
+
+	read := func(p []byte) int {
+		if bytesPerChannel == 2 {
+			return int(p[0])<<8 | int(p[1])
+		}
+		return int(p[0])
+	}
+	write := func(p []byte, v float64) {
+		v = math.Max(0, math.Min(1, v))
+		out := int(encodeLUT[int(v*encodeSteps)])
+		if bytesPerChannel == 2 {
+			p[0], p[1] = byte(out>>8), byte(out)
+		} else {
+			p[0] = byte(out)
+		}
+	}
+
+	stride := 4 * bytesPerChannel
+	for i := 0; i+stride <= len(pix); i += stride {
+		var linear [3]float64
+		for c := 0; c < 3; c++ {
+			linear[c] = decodeLUT[c][read(pix[i+c*bytesPerChannel:])]
+		}
+		out := m.apply(linear)
+		for c := 0; c < 3; c++ {
+			write(pix[i+c*bytesPerChannel:], out[c])
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package converter
+
+import (
+	"image"
+	"io"
+
+	"github.com/spenceriam/HEIC-2-Go/internal/errors"
+)
+
+// Decode reads a HEIC file and decodes its primary image. The image is
+// upright and in sRGB, with HDR images tone mapped, so that it displays
+// correctly without a color profile.
+func Decode(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, errors.ErrFileRead, "failed to read HEIC data")
+	}
+
+	heifCtx, err := openHEIF(data)
+	if err != nil {
+		return nil, err
+	}
+	id, err := heifCtx.GetPrimaryImageID()
+	if err != nil {
+		return nil, errors.Wrap(err, errors.ErrInvalidImage, "invalid or corrupted HEIC file")
+	}
+
+	img, metadata, err := NewHEICConverter(false).decodeImage(heifCtx, data, id)
+	if err != nil {
+		return nil, err
+	}
+	return displayImage(img, metadata.Color), nil
+}
+
+// displayImage converts a decoded image to sRGB, tone mapping HDR images.
+// Images whose color space cannot be converted are returned unchanged.
+func displayImage(img image.Image, info *colorInfo) image.Image {
+	if info.isHDR() {
+		if mapped, err := toneMapToSDR(img, info); err == nil {
+			return mapped
+		}
+	}
+	if info.isSRGB() {
+		return img
+	}
+	if space, err := info.rgbSpace(); err == nil {
+		if converted, err := convertToSRGB(img, space); err == nil {
+			return converted
+		}
+	}
+	return img
+}
+
+// Encode writes an image in the converter's output format with its encoder
+// settings. The image is resized according to the resize options, and
+// transparency is flattened onto the background color if the format
+// cannot store it.
+func (c *HEICConverter) Encode(w io.Writer, img image.Image) error {
+	if err := c.options.Validate(); err != nil {
+		return err
+	}
+	if err := c.resize.Validate(); err != nil {
+		return err
+	}
+
+	img = resizeImage(img, c.resize)
+	if hasTransparency(img) && !c.format.SupportsAlpha() {
+		img = flattenAlpha(img, c.background)
+	}
+
+	encoder, err := NewEncoder(c.format, c.options)
+	if err != nil {
+		return errors.Wrap(err, errors.ErrNotSupported, "unsupported output format")
+	}
+	if err := encoder.Encode(w, img); err != nil {
+		return errors.Wrap(err, errors.ErrEncodeFailed, "failed to encode output image")
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package converter
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"sort"
+	"strings"
+
+	"golang.org/x/image/tiff"
+)
+
+// OutputFormat identifies the image format written by the converter
+type OutputFormat string
+
+const (
+	// FormatJPEG writes baseline JPEG files
+	FormatJPEG OutputFormat = "jpeg"
+	// FormatPNG writes lossless PNG files
+	FormatPNG OutputFormat = "png"
+	// FormatWebP writes lossy or lossless WebP files
+	FormatWebP OutputFormat = "webp"
+	// FormatTIFF writes lossless TIFF files
+	FormatTIFF OutputFormat = "tiff"
+	// FormatAVIF writes AV1-compressed AVIF files
+	FormatAVIF OutputFormat = "avif"
+)
+
+// ImageEncoder encodes images in a single output format
+type ImageEncoder interface {
+	// Encode writes the image to w
+	Encode(w io.Writer, img image.Image) error
+}
+
+// MetadataEmbedder is implemented by encoders whose format can carry EXIF
+// and ICC metadata
+type MetadataEmbedder interface {
+	// EmbedMetadata returns a copy of an encoded image with the EXIF block and
+	// ICC profile added. Either block may be nil.
+	EmbedMetadata(encoded, exifData, iccProfile []byte) ([]byte, error)
+}
+
+// EncoderFactory creates an encoder from the converter options
+type EncoderFactory func(opts Options) ImageEncoder
+
+// formatEntry is a registered output format
+type formatEntry struct {
+	extension string
+	aliases   []string
+	factory   EncoderFactory
+}
+
+// formats holds the registered output formats
+var formats = map[OutputFormat]formatEntry{}
+
+// RegisterFormat registers an output format with its file extension, any
+// alternative names accepted by ParseOutputFormat, and its encoder factory
+func RegisterFormat(format OutputFormat, extension string, aliases []string, factory EncoderFactory) {
+	formats[format] = formatEntry{
+		extension: extension,
+		aliases:   aliases,
+		factory:   factory,
+	}
+}
+
+func init() {
+	RegisterFormat(FormatJPEG, ".jpg", []string{"jpg"}, func(opts Options) ImageEncoder {
+		return &jpegEncoder{
+			quality:         opts.Quality,
+			subsampling:     opts.ChromaSubsampling,
+			progressive:     opts.Progressive,
+			optimizeHuffman: opts.OptimizeHuffman,
+		}
+	})
+	RegisterFormat(FormatPNG, ".png", nil, func(opts Options) ImageEncoder {
+		return &pngEncoder{compression: opts.PNGCompression}
+	})
+	RegisterFormat(FormatWebP, ".webp", nil, func(opts Options) ImageEncoder {
+		return &webpEncoder{quality: opts.Quality, lossless: opts.WebPLossless}
+	})
+	RegisterFormat(FormatTIFF, ".tiff", []string{"tif"}, func(opts Options) ImageEncoder {
+		return &tiffEncoder{deflate: opts.TIFFDeflate}
+	})
+	RegisterFormat(FormatAVIF, ".avif", nil, func(opts Options) ImageEncoder {
+		return &avifEncoder{quality: opts.Quality, lossless: opts.AVIFLossless}
+	})
+}
+
+// SupportedFormats returns the registered output formats in name order
+func SupportedFormats() []OutputFormat {
+	list := make([]OutputFormat, 0, len(formats))
+	for format := range formats {
+		list = append(list, format)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	return list
+}
+
+// ParseOutputFormat parses a format name such as "jpg", "png" or "webp"
+func ParseOutputFormat(name string) (OutputFormat, error) {
+	name = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "."))
+	for format, entry := range formats {
+		if name == string(format) {
+			return format, nil
+		}
+		for _, alias := range entry.aliases {
+			if name == alias {
+				return format, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("unsupported output format: %s", name)
+}
+
+// Extension returns the file extension for the format, including the dot
+func (f OutputFormat) Extension() string {
+	if entry, ok := formats[f]; ok {
+		return entry.extension
+	}
+	return "." + string(f)
+}
+
+// NewEncoder creates an encoder for the given format
+func NewEncoder(format OutputFormat, opts Options) (ImageEncoder, error) {
+	entry, ok := formats[format]
+	if !ok {
+		return nil, fmt.Errorf("unsupported output format: %s", format)
+	}
+	return entry.factory(opts), nil
+}
+
+// pngEncoder encodes PNG files
+type pngEncoder struct {
+	compression png.CompressionLevel
+}
+
+// Encode implements ImageEncoder
+func (e *pngEncoder) Encode(w io.Writer, img image.Image) error {
+	encoder := png.Encoder{CompressionLevel: e.compression}
+	return encoder.Encode(w, img)
+}
+
+// EmbedMetadata implements MetadataEmbedder
+func (e *pngEncoder) EmbedMetadata(encoded, exifData, iccProfile []byte) ([]byte, error) {
+	return insertPNGMetadata(encoded, exifData, iccProfile)
+}
+
+// tiffEncoder encodes TIFF files
+type tiffEncoder struct {
+	deflate bool
+}
+
+// Encode implements ImageEncoder
+func (e *tiffEncoder) Encode(w io.Writer, img image.Image) error {
+	opts := &tiff.Options{Compression: tiff.Uncompressed}
+	if e.deflate {
+		opts.Compression = tiff.Deflate
+		opts.Predictor = true
+	}
+	return tiff.Encode(w, img, opts)
+}
+
+// EmbedMetadata implements MetadataEmbedder
+func (e *tiffEncoder) EmbedMetadata(encoded, exifData, iccProfile []byte) ([]byte, error) {
+	return insertTIFFMetadata(encoded, exifData, iccProfile)
+}
@@ -0,0 +1,282 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"golang.org/x/image/tiff"
+)
+
+// testImage returns an image with gradients, noise and a flat area, so that
+// encoders exercise their predictors, literals and back references. With
+// alpha set the alpha channel is random.
+func testImage(width, height int, alpha bool) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	r := rand.New(rand.NewSource(1))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			a := uint8(255)
+			if alpha {
+				a = uint8(r.Intn(256))
+			}
+			c := color.NRGBA{uint8(x * 3), uint8(y * 5), uint8((x + y) * 2), a}
+			if (x/7+y/5)%3 == 0 {
+				c = color.NRGBA{uint8(r.Intn(256)), uint8(r.Intn(256)), uint8(r.Intn(256)), a}
+			}
+			if y > height/2 && x < width/3 {
+				c = color.NRGBA{10, 20, 30, a}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// testExif is a minimal big-endian TIFF-structured EXIF block whose IFD0
+// holds an Orientation of 6
+var testExif = []byte("MM\x00\x2a\x00\x00\x00\x08" +
+	"\x00\x01" + "\x01\x12\x00\x03\x00\x00\x00\x01\x00\x06\x00\x00" +
+	"\x00\x00\x00\x00")
+
+func TestParseOutputFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		want OutputFormat
+	}{
+		{"jpeg", FormatJPEG},
+		{"jpg", FormatJPEG},
+		{".JPG", FormatJPEG},
+		{" png ", FormatPNG},
+		{"webp", FormatWebP},
+		{"tiff", FormatTIFF},
+		{".tif", FormatTIFF},
+		{"AVIF", FormatAVIF},
+	}
+	for _, tt := range tests {
+		got, err := ParseOutputFormat(tt.name)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseOutputFormat(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+
+	for _, name := range []string{"", "gif", "heic", "jpeg2000"} {
+		if _, err := ParseOutputFormat(name); err == nil {
+			t.Errorf("ParseOutputFormat(%q) succeeded, want an error", name)
+		}
+	}
+}
+
+func TestFormatRegistry(t *testing.T) {
+	extensions := map[OutputFormat]string{
+		FormatJPEG: ".jpg",
+		FormatPNG:  ".png",
+		FormatWebP: ".webp",
+		FormatTIFF: ".tiff",
+		FormatAVIF: ".avif",
+	}
+
+	supported := SupportedFormats()
+	if len(supported) != len(extensions) {
+		t.Fatalf("SupportedFormats() = %v, want %d formats", supported, len(extensions))
+	}
+	if !sort.SliceIsSorted(supported, func(i, j int) bool { return supported[i] < supported[j] }) {
+		t.Errorf("SupportedFormats() = %v, want name order", supported)
+	}
+
+	for _, format := range supported {
+		ext, ok := extensions[format]
+		if !ok {
+			t.Errorf("unexpected format %q", format)
+			continue
+		}
+		if got := format.Extension(); got != ext {
+			t.Errorf("%s.Extension() = %q, want %q", format, got, ext)
+		}
+		// The extension of every format maps back to it
+		if parsed, err := ParseOutputFormat(format.Extension()); err != nil || parsed != format {
+			t.Errorf("ParseOutputFormat(%q) = %q, %v, want %q", format.Extension(), parsed, err, format)
+		}
+
+		encoder, err := NewEncoder(format, DefaultOptions())
+		if err != nil || encoder == nil {
+			t.Errorf("NewEncoder(%s) = %v, %v", format, encoder, err)
+		}
+		_, embeds := encoder.(MetadataEmbedder)
+		if wantEmbeds := format != FormatAVIF; embeds != wantEmbeds {
+			t.Errorf("%s encoder implements MetadataEmbedder = %v, want %v", format, embeds, wantEmbeds)
+		}
+	}
+
+	if _, err := NewEncoder("gif", DefaultOptions()); err == nil {
+		t.Error("NewEncoder(gif) succeeded, want an error")
+	}
+	if got := OutputFormat("gif").Extension(); got != ".gif" {
+		t.Errorf("unregistered Extension() = %q, want %q", got, ".gif")
+	}
+}
+
+func TestPNGRoundTrip(t *testing.T) {
+	src := testImage(40, 30, true)
+	encoder := &pngEncoder{compression: png.DefaultCompression}
+
+	var buf bytes.Buffer
+	if err := encoder.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	data, err := encoder.EmbedMetadata(buf.Bytes(), testExif, buildICCProfile(srgbSpace()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSamePixels(t, src, decoded)
+}
+
+func TestTIFFRoundTrip(t *testing.T) {
+	src := testImage(33, 17, false)
+	for _, deflate := range []bool{false, true} {
+		var buf bytes.Buffer
+		if err := (&tiffEncoder{deflate: deflate}).Encode(&buf, src); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := tiff.Decode(&buf)
+		if err != nil {
+			t.Fatalf("deflate %v: %v", deflate, err)
+		}
+		assertSamePixels(t, src, decoded)
+	}
+}
+
+// photoExif is a big-endian EXIF block whose IFD0 holds a Make and an
+// Orientation of 1 and points to an EXIF IFD with an exposure time of 1/120
+// and an ISO of 100
+var photoExif = []byte("MM\x00\x2a\x00\x00\x00\x08" +
+	// IFD0 at 8, its Make value at 50 and the EXIF IFD at 56
+	"\x00\x03" +
+	"\x01\x0f\x00\x02\x00\x00\x00\x06\x00\x00\x00\x32" +
+	"\x01\x12\x00\x03\x00\x00\x00\x01\x00\x01\x00\x00" +
+	"\x87\x69\x00\x04\x00\x00\x00\x01\x00\x00\x00\x38" +
+	"\x00\x00\x00\x00" +
+	"Apple\x00" +
+	// EXIF IFD at 56 with the exposure time at 86
+	"\x00\x02" +
+	"\x82\x9a\x00\x05\x00\x00\x00\x01\x00\x00\x00\x56" +
+	"\x88\x27\x00\x03\x00\x00\x00\x01\x00\x64\x00\x00" +
+	"\x00\x00\x00\x00" +
+	"\x00\x00\x00\x01\x00\x00\x00\x78")
+
+func TestTIFFMetadata(t *testing.T) {
+	src := testImage(33, 17, true)
+	encoder := &tiffEncoder{deflate: true}
+	var buf bytes.Buffer
+	if err := encoder.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	icc := buildICCProfile(srgbSpace())
+	data, err := encoder.EmbedMetadata(buf.Bytes(), photoExif, icc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Embedding again replaces the profile instead of adding another
+	data, err = encoder.EmbedMetadata(data, nil, icc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := tiff.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSamePixels(t, src, decoded)
+
+	x, err := exif.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("parsing the EXIF tags: %v", err)
+	}
+	if tag, err := x.Get(exif.Make); err != nil {
+		t.Error(err)
+	} else if maker, _ := tag.StringVal(); maker != "Apple" {
+		t.Errorf("Make %q, want Apple", maker)
+	}
+	if tag, err := x.Get(exif.ExposureTime); err != nil {
+		t.Error(err)
+	} else if num, den, _ := tag.Rat2(0); num != 1 || den != 120 {
+		t.Errorf("ExposureTime %d/%d, want 1/120", num, den)
+	}
+	if tag, err := x.Get(exif.ISOSpeedRatings); err != nil {
+		t.Error(err)
+	} else if iso, _ := tag.Int(0); iso != 100 {
+		t.Errorf("ISO %d, want 100", iso)
+	}
+
+	entries, err := readTIFFIFD(data, binary.LittleEndian, binary.LittleEndian, binary.LittleEndian.Uint32(data[4:]), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profiles := 0
+	for _, entry := range entries {
+		if entry.tag == tiffTagICCProfile {
+			profiles++
+			if !bytes.Equal(entry.value, icc) {
+				t.Error("ICC profile tag differs from the profile")
+			}
+		}
+	}
+	if profiles != 1 {
+		t.Errorf("%d ICC profile tags, want 1", profiles)
+	}
+}
+
+func TestInsertTIFFMetadataRejectsInvalidInput(t *testing.T) {
+	var buf bytes.Buffer
+	if err := (&tiffEncoder{}).Encode(&buf, testImage(4, 4, false)); err != nil {
+		t.Fatal(err)
+	}
+	valid := buf.Bytes()
+
+	tests := []struct {
+		name       string
+		data, exif []byte
+	}{
+		{"empty", nil, nil},
+		{"not tiff", []byte("\x89PNG\r\n\x1a\n"), nil},
+		{"ifd out of range", []byte("II\x2a\x00\xff\x00\x00\x00"), nil},
+		{"exif without header", valid, []byte("Exif\x00\x00MM")},
+		{"exif value out of range", valid, []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01" +
+			"\x01\x0f\x00\x02\x00\x00\x00\x20\x00\x00\x01\x00\x00\x00\x00\x00")},
+	}
+	for _, tt := range tests {
+		if _, err := insertTIFFMetadata(tt.data, tt.exif, nil); err == nil {
+			t.Errorf("%s: insertTIFFMetadata succeeded, want an error", tt.name)
+		}
+	}
+}
+
+// assertSamePixels fails unless two images have the same size and pixels.
+// The color of fully transparent pixels is not compared.
+func assertSamePixels(t *testing.T, want *image.NRGBA, got image.Image) {
+	t.Helper()
+	if got.Bounds().Size() != want.Bounds().Size() {
+		t.Fatalf("size %v, want %v", got.Bounds().Size(), want.Bounds().Size())
+	}
+	offset := got.Bounds().Min.Sub(want.Bounds().Min)
+	for y := want.Rect.Min.Y; y < want.Rect.Max.Y; y++ {
+		for x := want.Rect.Min.X; x < want.Rect.Max.X; x++ {
+			w := want.NRGBAAt(x, y)
+			g := color.NRGBAModel.Convert(got.At(x+offset.X, y+offset.Y)).(color.NRGBA)
+			if g != w && !(g.A == 0 && w.A == 0) {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, g, w)
+			}
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"math"
+	"strings"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// appleGainMapURN is the auxiliary type of the HDR gain map of iPhone photos
+const appleGainMapURN = "urn:com:apple:photo:2020:aux:hdrgainmap"
+
+var (
+	// xmpHeader prefixes the XMP packet in a JPEG APP1 segment
+	xmpHeader = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	// mpfHeader prefixes the Multi-Picture Format index in a JPEG APP2 segment
+	mpfHeader = []byte("MPF\x00")
+	// appleMakerNoteHeader starts the maker note of photos taken with iOS
+	appleMakerNoteHeader = []byte("Apple iOS\x00")
+)
+
+// findGainMap returns the Apple HDR gain map of an image in a HEIF file
+func findGainMap(data []byte, itemID uint32) (auxiliaryImage, bool) {
+	aux, err := findAuxiliaryImages(data, itemID)
+	if err != nil {
+		return auxiliaryImage{}, false
+	}
+	for _, a := range aux {
+		if strings.EqualFold(a.URN, appleGainMapURN) {
+			return a, true
+		}
+	}
+	return auxiliaryImage{}, false
+}
+
+// appleHDRHeadroom returns the HDR headroom of an iPhone photo, the ratio of
+// the brightest HDR level to SDR white, from tags 33 and 48 of the Apple
+// maker note, following Apple's documentation of its gain maps
+func appleHDRHeadroom(exifData []byte) (float64, bool) {
+	if exifData == nil {
+		return 0, false
+	}
+	x, err := exif.Decode(bytes.NewReader(exifData))
+	if err != nil {
+		return 0, false
+	}
+	tag, err := x.Get(exif.MakerNote)
+	if err != nil {
+		return 0, false
+	}
+
+	values := parseAppleMakerNote(tag.Val)
+	maker33, ok33 := values[33]
+	maker48, ok48 := values[48]
+	if !ok33 || !ok48 {
+		return 0, false
+	}
+
+	var stops float64
+	switch {
+	case maker33 < 1 && maker48 <= 0.01:
+		stops = -20*maker48 + 1.8
+	case maker33 < 1:
+		stops = -0.101*maker48 + 1.601
+	case maker48 <= 0.01:
+		stops = -70*maker48 + 3.0
+	default:
+		stops = -0.303*maker48 + 2.303
+	}
+	if stops <= 0 {
+		return 0, false
+	}
+	return math.Pow(2, stops), true
+}
+
+// parseAppleMakerNote returns the numeric entries of an Apple maker note,
+// which holds a big-endian IFD with offsets relative to the maker note
+func parseAppleMakerNote(note []byte) map[uint16]float64 {
+	const ifdOffset = 14
+	if !bytes.HasPrefix(note, appleMakerNoteHeader) || len(note) < ifdOffset+2 {
+		return nil
+	}
+
+	order := binary.BigEndian
+	count := int(order.Uint16(note[ifdOffset:]))
+	values := make(map[uint16]float64)
+	for i := 0; i < count; i++ {
+		entry := ifdOffset + 2 + i*12
+		if entry+12 > len(note) {
+			break
+		}
+		tag := order.Uint16(note[entry:])
+		value := note[entry+8 : entry+12]
+
+		switch order.Uint16(note[entry+2:]) {
+		case 4: // LONG
+			values[tag] = float64(order.Uint32(value))
+		case 9: // SLONG
+			values[tag] = float64(int32(order.Uint32(value)))
+		case 5, 10: // RATIONAL, SRATIONAL
+			offset := int(order.Uint32(value))
+			if offset+8 > len(note) {
+				continue
+			}
+			num, den := order.Uint32(note[offset:]), order.Uint32(note[offset+4:])
+			if den == 0 {
+				continue
+			}
+			if order.Uint16(note[entry+2:]) == 10 {
+				values[tag] = float64(int32(num)) / float64(int32(den))
+			} else {
+				values[tag] = float64(num) / float64(den)
+			}
+		}
+	}
+	return values
+}
+
+// gainMapRecovery converts an Apple gain map to the log-encoded recovery map
+// of the Adobe gain map specification. Apple maps store the extra gain on a
+// linear scale from 1 to the headroom, encoded with the sRGB curve.
+func gainMapRecovery(img image.Image, headroom float64) *image.Gray {
+	var lut [256]uint8
+	for i := range lut {
+		gain := 1 + (headroom-1)*srgbDecode(float64(i)/255)
+		lut[i] = uint8(math.Round(math.Log2(gain) / math.Log2(headroom) * 255))
+	}
+
+	bounds := img.Bounds()
+	recovery := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			v, _, _, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			recovery.Pix[y*recovery.Stride+x] = lut[v>>8]
+		}
+	}
+	return recovery
+}
+
+// attachGainMap turns an encoded JPEG into an Ultra HDR JPEG by appending the
+// HDR gain map of the source image, if it has one, turned like the image,
+// scaled down to the JPEG's size if larger and encoded at the JPEG's quality.
+// The primary image gets an XMP directory and a Multi-Picture Format index
+// that locate the gain map.
+func (c *HEICConverter) attachGainMap(primary, data []byte, itemID uint32, bounds image.Rectangle, exifData []byte, quality int, filter ResampleFilter) ([]byte, error) {
+	gainMap, ok := findGainMap(data, itemID)
+	if !ok {
+		return primary, nil
+	}
+	headroom, ok := appleHDRHeadroom(exifData)
+	if !ok {
+		return primary, nil
+	}
+
+	img, err := decodeAuxiliaryImage(data, gainMap.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode HDR gain map: %w", err)
+	}
+	img = c.orientGainMap(img, data, itemID, gainMap.ID, exifData)
+	img, err = fitGainMap(img, bounds, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	encoder := &jpegEncoder{quality: quality, subsampling: Subsampling444, optimizeHuffman: true}
+	if err := encoder.Encode(&buf, gainMapRecovery(img, headroom)); err != nil {
+		return nil, fmt.Errorf("failed to encode HDR gain map: %w", err)
+	}
+	gainMapJPEG, _, err := insertJPEGSegments(buf.Bytes(), []jpegSegment{
+		{marker: jpegMarkerAPP1, payload: gainMapXMP(math.Log2(headroom))},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The MPF index has a fixed size, so the primary's length is known before
+	// the index is filled in
+	xmp := jpegSegment{marker: jpegMarkerAPP1, payload: primaryXMP(len(gainMapJPEG))}
+	mpf := jpegSegment{marker: jpegMarkerAPP2, payload: mpfIndex(0, 0, 0)}
+	withIndex, at, err := insertJPEGSegments(primary, []jpegSegment{xmp, mpf})
+	if err != nil {
+		return nil, err
+	}
+
+	// Offsets in the index are relative to its TIFF header
+	tiffStart := at + 4 + len(xmp.payload) + 4 + len(mpfHeader)
+	copy(withIndex[tiffStart-len(mpfHeader):], mpfIndex(len(withIndex), len(gainMapJPEG), len(withIndex)-tiffStart))
+	return append(withIndex, gainMapJPEG...), nil
+}
+
+// orientGainMap turns a decoded gain map the way the decoded image was
+// turned. libheif applies an item's own 'irot' and 'imir' properties, but gain
+// maps usually carry none and follow the transformations of their image.
+func (c *HEICConverter) orientGainMap(img image.Image, data []byte, itemID, gainMapID uint32, exifData []byte) image.Image {
+	if hasTransformProperties(data, gainMapID) {
+		return img
+	}
+	if hasTransformProperties(data, itemID) {
+		meta, err := parseHEIFMeta(data)
+		if err != nil {
+			return img
+		}
+		return applyTransformProperties(img, meta.itemProperties(itemID))
+	}
+	return normalizeOrientation(img, data, itemID, exifData, c.exifOrientation)
+}
+
+// fitGainMap checks that a gain map covers an image of the given bounds and
+// scales it down to the image's size if it is larger. Gain maps are often
+// smaller than their image, which viewers scale up.
+func fitGainMap(img image.Image, bounds image.Rectangle, filter ResampleFilter) (image.Image, error) {
+	gw, gh := img.Bounds().Dx(), img.Bounds().Dy()
+	if gw == 0 || gh == 0 || math.Abs(float64(gw)/float64(gh)-float64(bounds.Dx())/float64(bounds.Dy())) > 0.02 {
+		return nil, fmt.Errorf("HDR gain map of %dx%d does not match the %dx%d image", gw, gh, bounds.Dx(), bounds.Dy())
+	}
+	if gw > bounds.Dx() || gh > bounds.Dy() {
+		return scaleImage(img, bounds.Dx(), bounds.Dy(), filter), nil
+	}
+	return img, nil
+}
+
+// insertJPEGSegments returns a copy of a JPEG stream with marker segments
+// added after its existing APPn segments, and the offset of the first added
+// segment
+func insertJPEGSegments(jpeg []byte, inserts []jpegSegment) ([]byte, int, error) {
+	segments, rest, err := splitJPEGSegments(jpeg)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var out bytes.Buffer
+	out.Grow(len(jpeg) + 1024)
+	out.Write([]byte{jpegMarkerPrefix, jpegMarkerSOI})
+	at := -1
+	for _, seg := range segments {
+		if at < 0 && (seg.marker < jpegMarkerAPP0 || seg.marker > jpegMarkerAPP0+15) {
+			at = out.Len()
+			writeJPEGSegments(&out, inserts)
+		}
+		writeJPEGSegment(&out, seg.marker, seg.payload)
+	}
+	if at < 0 {
+		at = out.Len()
+		writeJPEGSegments(&out, inserts)
+	}
+	out.Write(rest)
+	return out.Bytes(), at, nil
+}
+
+// mpfIndex builds the APP2 payload of a Multi-Picture Format index listing a
+// primary image and a gain map. The gain map offset is relative to the TIFF
+// header of the index.
+func mpfIndex(primarySize, gainMapSize, gainMapOffset int) []byte {
+	const (
+		entryCount  = 3
+		ifdSize     = 2 + entryCount*12 + 4
+		entriesSize = 2 * 16
+	)
+	order := binary.BigEndian
+	var b bytes.Buffer
+	b.Write(mpfHeader)
+	b.Write(tiffHeaderBE)
+	binary.Write(&b, order, uint32(8))
+
+	binary.Write(&b, order, uint16(entryCount))
+	writeIFDEntry := func(tag, typ uint16, count uint32, value []byte) {
+		binary.Write(&b, order, tag)
+		binary.Write(&b, order, typ)
+		binary.Write(&b, order, count)
+		b.Write(value)
+	}
+	writeIFDEntry(0xB000, 7, 4, []byte("0100"))                               // MPFVersion
+	writeIFDEntry(0xB001, 4, 1, order.AppendUint32(nil, 2))                   // NumberOfImages
+	writeIFDEntry(0xB002, 7, entriesSize, order.AppendUint32(nil, 8+ifdSize)) // MPEntry
+	binary.Write(&b, order, uint32(0))
+
+	// Primary image: baseline MP primary image flagged as representative
+	binary.Write(&b, order, uint32(0x20030000))
+	binary.Write(&b, order, uint32(primarySize))
+	binary.Write(&b, order, uint32(0))
+	binary.Write(&b, order, uint32(0))
+	// Gain map: no special attributes
+	binary.Write(&b, order, uint32(0))
+	binary.Write(&b, order, uint32(gainMapSize))
+	binary.Write(&b, order, uint32(gainMapOffset))
+	binary.Write(&b, order, uint32(0))
+	return b.Bytes()
+}
+
+// primaryXMP builds the XMP packet of an Ultra HDR primary image, which
+// declares the gain map that follows it
+func primaryXMP(gainMapSize int) []byte {
+	return append(append([]byte{}, xmpHeader...), fmt.Sprintf(`<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:Container="http://ns.google.com/photos/1.0/container/"
+    xmlns:Item="http://ns.google.com/photos/1.0/container/item/"
+    xmlns:hdrgm="http://ns.adobe.com/hdr-gain-map/1.0/"
+   hdrgm:Version="1.0">
+   <Container:Directory>
+    <rdf:Seq>
+     <rdf:li rdf:parseType="Resource">
+      <Container:Item Item:Semantic="Primary" Item:Mime="image/jpeg"/>
+     </rdf:li>
+     <rdf:li rdf:parseType="Resource">
+      <Container:Item Item:Semantic="GainMap" Item:Mime="image/jpeg" Item:Length="%d"/>
+     </rdf:li>
+    </rdf:Seq>
+   </Container:Directory>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>`, gainMapSize)...)
+}
+
+// gainMapXMP builds the XMP packet of an Ultra HDR gain map image with the
+// given maximum gain in stops
+func gainMapXMP(maxStops float64) []byte {
+	return append(append([]byte{}, xmpHeader...), fmt.Sprintf(`<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:hdrgm="http://ns.adobe.com/hdr-gain-map/1.0/"
+   hdrgm:Version="1.0"
+   hdrgm:GainMapMin="0"
+   hdrgm:GainMapMax="%.4f"
+   hdrgm:Gamma="1"
+   hdrgm:OffsetSDR="0"
+   hdrgm:OffsetHDR="0"
+   hdrgm:HDRCapacityMin="0"
+   hdrgm:HDRCapacityMax="%.4f"
+   hdrgm:BaseRenditionIsHDR="False"/>
+ </rdf:RDF>
+</x:xmpmeta>`, maxStops, maxStops)...)
+}
@@ -0,0 +1,71 @@
+package converter
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestFitGainMap(t *testing.T) {
+	gainMap := image.NewGray(image.Rect(0, 0, 2016, 1512))
+	tests := []struct {
+		name          string
+		width, height int
+		want          image.Point
+	}{
+		// Viewers scale smaller gain maps up, so they are kept as they are
+		{"full size", 4032, 3024, image.Pt(2016, 1512)},
+		{"same size", 2016, 1512, image.Pt(2016, 1512)},
+		{"thumbnail", 320, 240, image.Pt(320, 240)},
+	}
+	for _, tt := range tests {
+		fitted, err := fitGainMap(gainMap, image.Rect(0, 0, tt.width, tt.height), FilterLanczos)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := fitted.Bounds().Size(); got != tt.want {
+			t.Errorf("%s: gain map of %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// A gain map that does not cover the image is reported
+	if _, err := fitGainMap(gainMap, image.Rect(0, 0, 3024, 4032), FilterLanczos); err == nil {
+		t.Error("gain map in another orientation accepted")
+	}
+}
+
+func TestApplyTransformProperties(t *testing.T) {
+	// A 3x2 image with a marked top-left corner
+	src := image.NewGray(image.Rect(0, 0, 3, 2))
+	src.SetGray(0, 0, color.Gray{Y: 255})
+
+	tests := []struct {
+		name   string
+		props  []box
+		size   image.Point
+		marked image.Point
+	}{
+		{"none", nil, image.Pt(3, 2), image.Pt(0, 0)},
+		{"rotate 90 counter-clockwise", []box{{Type: "irot", Payload: []byte{1}}}, image.Pt(2, 3), image.Pt(0, 2)},
+		{"rotate 180", []box{{Type: "irot", Payload: []byte{2}}}, image.Pt(3, 2), image.Pt(2, 1)},
+		{"rotate 270 counter-clockwise", []box{{Type: "irot", Payload: []byte{3}}}, image.Pt(2, 3), image.Pt(1, 0)},
+		{"flip top to bottom", []box{{Type: "imir", Payload: []byte{0}}}, image.Pt(3, 2), image.Pt(0, 1)},
+		{"flip left to right", []box{{Type: "imir", Payload: []byte{1}}}, image.Pt(3, 2), image.Pt(2, 0)},
+		// Properties apply in order: rotating first moves the corner to the
+		// bottom left, which the flip then moves to the bottom right
+		{"rotate then flip", []box{{Type: "irot", Payload: []byte{1}}, {Type: "imir", Payload: []byte{1}}}, image.Pt(2, 3), image.Pt(1, 2)},
+		{"other properties", []box{{Type: "ispe", Payload: []byte{1}}}, image.Pt(3, 2), image.Pt(0, 0)},
+	}
+	for _, tt := range tests {
+		got := applyTransformProperties(src, tt.props)
+		if size := got.Bounds().Size(); size != tt.size {
+			t.Errorf("%s: size %v, want %v", tt.name, size, tt.size)
+			continue
+		}
+		b := got.Bounds()
+		if r, _, _, _ := got.At(b.Min.X+tt.marked.X, b.Min.Y+tt.marked.Y).RGBA(); r != 0xffff {
+			t.Errorf("%s: marked pixel not at %v", tt.name, tt.marked)
+		}
+	}
+}
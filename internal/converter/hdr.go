@@ -0,0 +1,266 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+
+	heif "github.com/strukturag/libheif/go/heif"
+)
+
+// Luminance levels used when tone mapping HDR images, in cd/m²
+const (
+	// sdrReferenceWhite is the HDR level that SDR white is mapped to, as
+	// recommended by ITU-R BT.2408
+	sdrReferenceWhite = 203.0
+	// hdrNominalPeak is the brightest HDR level kept apart from white, which
+	// is also the nominal display peak for HLG
+	hdrNominalPeak = 1000.0
+	// toneMapKnee is the relative level up to which tone mapping leaves the
+	// image unchanged
+	toneMapKnee = 0.75
+)
+
+// imageBitDepth returns the bits per sample of an image in a HEIF file,
+// taken from its 'pixi' or 'hvcC' property. Grid images without either
+// property use the bit depth of their first tile.
+func imageBitDepth(data []byte, itemID uint32) int {
+	meta, err := parseHEIFMeta(data)
+	if err != nil {
+		return 8
+	}
+	return itemBitDepth(meta, itemID)
+}
+
+// itemBitDepth returns the bits per sample of an item in a parsed 'meta' box
+func itemBitDepth(meta *heifMeta, itemID uint32) int {
+	for attempt := 0; attempt < 2; attempt++ {
+		for _, prop := range meta.itemProperties(itemID) {
+			r := newBoxReader(prop.Payload)
+			switch prop.Type {
+			case "pixi":
+				r.fullBoxHeader()
+				if channels := r.uint8(); channels > 0 {
+					if bits := r.uint8(); r.err == nil {
+						return int(bits)
+					}
+				}
+			case "hvcC":
+				r.next(17)
+				if bits := r.uint8(); r.err == nil {
+					return int(bits&0x07) + 8
+				}
+			}
+		}
+
+		// Fall back to the first tile of a grid
+		tile, found := uint32(0), false
+		for _, ref := range meta.References {
+			if ref.Type == "dimg" && ref.From == itemID && len(ref.To) > 0 {
+				tile, found = ref.To[0], true
+				break
+			}
+		}
+		if !found {
+			break
+		}
+		itemID = tile
+	}
+	return 8
+}
+
+// decodeHighBitDepth decodes an image with more than 8 bits per sample into
+// a 16-bit non-premultiplied image. The binding's own conversion of 10 and
+// 12-bit images mixes up strides, so the samples are scaled here.
+func decodeHighBitDepth(handle *heif.ImageHandle) (image.Image, error) {
+	decoded, err := handle.DecodeImage(heif.ColorspaceRGB, heif.ChromaInterleavedRRGGBBAA_BE, nil)
+	if err != nil {
+		return nil, err
+	}
+	plane, err := decoded.GetPlane(heif.ChannelInterleaved)
+	if err != nil {
+		return nil, err
+	}
+
+	bits := decoded.GetBitsPerPixelRange(heif.ChannelInterleaved)
+	if bits <= 8 || bits > 16 {
+		return nil, fmt.Errorf("unsupported bit depth: %d", bits)
+	}
+	width := decoded.GetWidth(heif.ChannelInterleaved)
+	height := decoded.GetHeight(heif.ChannelInterleaved)
+	if len(plane.Plane) < (height-1)*plane.Stride+width*8 {
+		return nil, fmt.Errorf("decoded image plane too small")
+	}
+
+	img := image.NewNRGBA64(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		src := plane.Plane[y*plane.Stride : y*plane.Stride+width*8]
+		dst := img.Pix[y*img.Stride : y*img.Stride+width*8]
+		for i := 0; i < len(src); i += 2 {
+			// Replicate the high bits into the low ones to scale to 16 bits
+			v := uint32(src[i])<<8 | uint32(src[i+1])
+			v = v<<(16-bits) | v>>(2*bits-16)
+			dst[i], dst[i+1] = byte(v>>8), byte(v)
+		}
+	}
+	return img, nil
+}
+
+// isHDR reports whether the declared color space uses an HDR transfer
+// function (PQ or HLG)
+func (ci *colorInfo) isHDR() bool {
+	if ci == nil || ci.NCLX == nil {
+		return false
+	}
+	transfer := ci.NCLX.TransferCharacteristics
+	return transfer == nclxTransferPQ || transfer == nclxTransferHLG
+}
+
+// keepsHDR reports whether a format stores HDR images without tone mapping.
+// Only PNG can both hold 16 bits per sample and declare the PQ or HLG
+// transfer function, through its cICP chunk.
+func (f OutputFormat) keepsHDR() bool {
+	return f == FormatPNG
+}
+
+// pqDecode is the SMPTE ST 2084 (PQ) electro-optical transfer function. It
+// returns the absolute luminance in cd/m².
+func pqDecode(v float64) float64 {
+	const (
+		m1 = 2610.0 / 16384
+		m2 = 2523.0 / 4096 * 128
+		c1 = 3424.0 / 4096
+		c2 = 2413.0 / 4096 * 32
+		c3 = 2392.0 / 4096 * 32
+	)
+	p := math.Pow(v, 1/m2)
+	return 10000 * math.Pow(math.Max(p-c1, 0)/(c2-c3*p), 1/m1)
+}
+
+// hlgDecode inverts the ITU-R BT.2100 HLG opto-electronic transfer function
+// and returns the relative scene light (0-1)
+func hlgDecode(v float64) float64 {
+	const (
+		a = 0.17883277
+		b = 1 - 4*a
+	)
+	c := 0.5 - a*math.Log(4*a)
+	if v <= 0.5 {
+		return v * v / 3
+	}
+	return (math.Exp((v-c)/a) + b) / 12
+}
+
+// toneMapToSDR converts a PQ or HLG image to a 16-bit sRGB image. HDR levels
+// are scaled so that the reference white becomes SDR white, then levels above
+// the knee up to the nominal peak are compressed into the remaining range.
+// The curve is applied to the luminance, which keeps the hue of bright colors.
+func toneMapToSDR(img image.Image, info *colorInfo) (image.Image, error) {
+	space, err := nclxRGBSpace(&nclxColor{
+		ColorPrimaries:          info.NCLX.ColorPrimaries,
+		TransferCharacteristics: nclxTransferLinear,
+	})
+	if err != nil {
+		return nil, err
+	}
+	toRGB, err := srgbSpace().ToXYZ.inverse()
+	if err != nil {
+		return nil, err
+	}
+	m := toRGB.mul(space.ToXYZ)
+
+	// Decode to display light relative to the reference white
+	hlg := info.NCLX.TransferCharacteristics == nclxTransferHLG
+	decodeLUT := make([]float64, 1<<16)
+	for i := range decodeLUT {
+		v := float64(i) / 0xffff
+		if hlg {
+			decodeLUT[i] = hlgDecode(v)
+		} else {
+			decodeLUT[i] = pqDecode(v) / sdrReferenceWhite
+		}
+	}
+
+	const encodeSteps = 1 << 16
+	encodeLUT := make([]uint16, encodeSteps+1)
+	for i := range encodeLUT {
+		encodeLUT[i] = uint16(math.Round(srgbEncode(float64(i)/encodeSteps) * 0xffff))
+	}
+
+	bounds := img.Bounds()
+	dst, ok := img.(*image.NRGBA64)
+	if ok {
+		dst = &image.NRGBA64{Pix: append([]byte(nil), dst.Pix...), Stride: dst.Stride, Rect: dst.Rect}
+	} else {
+		dst = image.NewNRGBA64(bounds)
+		draw.Draw(dst, bounds, img, bounds.Min, draw.Src)
+	}
+
+	for y := 0; y < bounds.Dy(); y++ {
+		row := dst.Pix[y*dst.Stride : y*dst.Stride+bounds.Dx()*8]
+		for i := 0; i < len(row); i += 8 {
+			var rgb [3]float64
+			for c := 0; c < 3; c++ {
+				rgb[c] = decodeLUT[int(row[i+2*c])<<8|int(row[i+2*c+1])]
+			}
+			if hlg {
+				// HLG OOTF for a display with the nominal peak (system gamma 1.2)
+				ys := 0.2627*rgb[0] + 0.6780*rgb[1] + 0.0593*rgb[2]
+				scale := hdrNominalPeak / sdrReferenceWhite * math.Pow(ys, 0.2)
+				for c := range rgb {
+					rgb[c] *= scale
+				}
+			}
+
+			rgb = m.apply(rgb)
+			if l := 0.2126*rgb[0] + 0.7152*rgb[1] + 0.0722*rgb[2]; l > toneMapKnee {
+				mapped := toneMapCurve(l)
+				for c := range rgb {
+					rgb[c] *= mapped / l
+				}
+			}
+
+			for c := 0; c < 3; c++ {
+				v := math.Max(0, math.Min(1, rgb[c]))
+				out := encodeLUT[int(v*encodeSteps)]
+				row[i+2*c], row[i+2*c+1] = byte(out>>8), byte(out)
+			}
+		}
+	}
+	return dst, nil
+}
+
+// toneMapCurve maps a relative luminance above the knee into the range from
+// the knee to 1. The Reinhard-style curve reaches 1 at the nominal peak and
+// continues the identity below the knee with the same slope.
+func toneMapCurve(l float64) float64 {
+	const peak = hdrNominalPeak / sdrReferenceWhite
+	slope := (peak - toneMapKnee) / (1 - toneMapKnee)
+	x := math.Min((l-toneMapKnee)/(peak-toneMapKnee), 1)
+	return toneMapKnee + (1-toneMapKnee)*slope*x/(1+(slope-1)*x)
+}
+
+// insertPNGColorCode returns a copy of a PNG stream with a cICP chunk that
+// declares the primaries and transfer function of an HDR image
+func insertPNGColorCode(png []byte, nclx *nclxColor) ([]byte, error) {
+	if !bytes.HasPrefix(png, pngSignature) || len(png) < len(pngSignature)+8 {
+		return nil, fmt.Errorf("not a PNG stream")
+	}
+
+	// IHDR is always the first chunk, and cICP must precede the image data
+	headerEnd := len(pngSignature) + 12 + int(binary.BigEndian.Uint32(png[len(pngSignature):]))
+	if headerEnd > len(png) {
+		return nil, fmt.Errorf("truncated PNG header")
+	}
+
+	var out bytes.Buffer
+	out.Grow(len(png) + 16)
+	out.Write(png[:headerEnd])
+	// Matrix coefficients 0 (RGB) with full-range samples
+	writePNGChunk(&out, "cICP", []byte{byte(nclx.ColorPrimaries), byte(nclx.TransferCharacteristics), 0, 1})
+	out.Write(png[headerEnd:])
+	return out.Bytes(), nil
+}
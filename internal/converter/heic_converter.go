@@ -1,32 +1,173 @@
 package converter
 
 import (
+	"bytes"
+	"context"
+	stderrors "errors"
 	"fmt"
 	"image"
+	"image/color"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
-	"github.com/disintegration/imaging"
 	"github.com/rwcarlsen/goexif/exif"
 	"github.com/spenceriam/HEIC-2-Go/internal/errors"
 	heif "github.com/strukturag/libheif/go/heif"
 )
 
-// HEICConverter handles the conversion from HEIC to JPG
+// HEICConverter handles the conversion from HEIC to JPG and other output formats
 type HEICConverter struct {
 	preserveMetadata bool
+	colorMode        ColorMode
+	format           OutputFormat
+	options          Options
+	resize           ResizeOptions
+	renditions       []Rendition
+	progress         ProgressFunc
+	allImages        bool
+	imageID          int
+	auxFormat        OutputFormat
+	alphaFormat      OutputFormat
+	background       color.Color
+	gainMap          bool
+	exifOrientation  bool
 }
 
 // NewHEICConverter creates a new HEICConverter instance
 func NewHEICConverter(preserveMetadata bool) *HEICConverter {
 	return &HEICConverter{
 		preserveMetadata: preserveMetadata,
+		colorMode:        ColorModeEmbed,
+		format:           FormatJPEG,
+		options:          DefaultOptions(),
+		resize:           DefaultResizeOptions(),
+		background:       DefaultBackground,
+		gainMap:          true,
 	}
 }
 
-// Convert converts a HEIC file to JPG format
+// WithOptions sets the encoder settings
+func (c *HEICConverter) WithOptions(opts Options) *HEICConverter {
+	c.options = opts
+	return c
+}
+
+// Options returns the configured encoder settings
+func (c *HEICConverter) Options() Options {
+	return c.options
+}
+
+// WithResize sets how images are scaled and cropped before encoding
+func (c *HEICConverter) WithResize(resize ResizeOptions) *HEICConverter {
+	c.resize = resize
+	return c
+}
+
+// WithRenditions makes Convert write additional outputs of each image, such
+// as a web version and a thumbnail, named by RenditionOutputPath. The image
+// is decoded once for all of them.
+func (c *HEICConverter) WithRenditions(renditions []Rendition) *HEICConverter {
+	c.renditions = renditions
+	return c
+}
+
+// WithOutputFormat sets the output format
+func (c *HEICConverter) WithOutputFormat(format OutputFormat) *HEICConverter {
+	c.format = format
+	return c
+}
+
+// Format returns the configured output format
+func (c *HEICConverter) Format() OutputFormat {
+	return c.format
+}
+
+// WithProgress sets a function that receives progress updates during Convert
+func (c *HEICConverter) WithProgress(fn ProgressFunc) *HEICConverter {
+	c.progress = fn
+	return c
+}
+
+// WithAllImages makes Convert write every top-level image of a file instead of
+// only the primary image. Files with several images get one output per image,
+// named by IndexedOutputPath.
+func (c *HEICConverter) WithAllImages(all bool) *HEICConverter {
+	c.allImages = all
+	return c
+}
+
+// WithImageID makes Convert write the top-level image with the given item ID
+// instead of the primary image. Zero selects the primary image.
+func (c *HEICConverter) WithImageID(id int) *HEICConverter {
+	c.imageID = id
+	return c
+}
+
+// WithAuxiliaryImages makes Convert also write the depth maps, alpha planes
+// and portrait mattes of each image as grayscale files in the given format,
+// named by AuxiliaryOutputPath. Only PNG and TIFF are supported; an empty
+// format disables the export.
+func (c *HEICConverter) WithAuxiliaryImages(format OutputFormat) *HEICConverter {
+	c.auxFormat = format
+	return c
+}
+
+// WithAlphaFormat sets the format written instead of the configured one for
+// images with an alpha plane when the configured format cannot store
+// transparency, e.g. PNG for JPEG output. The output path then gets the
+// extension of that format. The choice is made from the file before
+// decoding, so the output path is known in advance. An empty format keeps
+// the configured format and composites the image onto the background color.
+func (c *HEICConverter) WithAlphaFormat(format OutputFormat) *HEICConverter {
+	c.alphaFormat = format
+	return c
+}
+
+// WithBackground sets the color that transparent areas are composited onto
+// when the output format cannot store transparency
+func (c *HEICConverter) WithBackground(background color.Color) *HEICConverter {
+	c.background = background
+	return c
+}
+
+// WithGainMap sets whether JPEG outputs of iPhone HDR photos keep the HDR
+// gain map as an Ultra HDR JPEG. It is enabled by default.
+func (c *HEICConverter) WithGainMap(enabled bool) *HEICConverter {
+	c.gainMap = enabled
+	return c
+}
+
+// WithExifOrientation makes Convert rotate images by their EXIF Orientation
+// tag when the file has no HEIF rotation or mirroring property. HEIF treats
+// the tag as informative, so this is only needed for non-conforming files;
+// it rotates correctly stored files a second time.
+func (c *HEICConverter) WithExifOrientation(enabled bool) *HEICConverter {
+	c.exifOrientation = enabled
+	return c
+}
+
+// WithColorMode sets how the source color profile is carried into the output
+func (c *HEICConverter) WithColorMode(mode ColorMode) *HEICConverter {
+	c.colorMode = mode
+	return c
+}
+
+// Convert converts a HEIC file to the configured output format
 func (c *HEICConverter) Convert(inputPath, outputPath string) error {
+	return c.ConvertContext(context.Background(), inputPath, outputPath)
+}
+
+// ConvertContext converts a HEIC file to the configured output format and
+// stops with ErrCancelled when the context is cancelled. A cancelled
+// conversion leaves no partial output file behind.
+func (c *HEICConverter) ConvertContext(ctx context.Context, inputPath, outputPath string) error {
+	// Validate encoder settings
+	if err := c.validate(); err != nil {
+		return err
+	}
+
 	// Validate input file
 	if _, err := os.Stat(inputPath); os.IsNotExist(err) {
 		return errors.FileNotFound(inputPath)
@@ -39,122 +180,412 @@ func (c *HEICConverter) Convert(inputPath, outputPath string) error {
 	}
 
 	// Read HEIC file
-	img, metadata, err := c.decodeHEIC(inputPath)
+	data, err := c.readHEIC(ctx, inputPath)
 	if err != nil {
-		return errors.Wrap(err, errors.ErrDecodeFailed, "failed to decode HEIC file")
+		return err
+	}
+	c.report(StageDecode)
+	heifCtx, err := openHEIF(data)
+	if err != nil {
+		return err
 	}
 
-	// Save as JPG
-	if err := imaging.Save(img, outputPath, imaging.JPEGQuality(90)); err != nil {
-		return errors.Wrap(err, errors.ErrEncodeFailed, "failed to save JPG file")
+	// Convert the selected images, each into its own output file
+	ids, err := c.selectImages(heifCtx)
+	if err != nil {
+		return err
 	}
+	var warning error
+	for i, id := range ids {
+		output := imageOutputPath(outputPath, i, len(ids))
 
-	// Preserve metadata if requested
-	if c.preserveMetadata && metadata != nil {
-		if err := c.writeMetadata(outputPath, metadata); err != nil {
-			// Don't fail the entire conversion if metadata can't be written
-			// Just log the error and continue
-			return errors.Wrap(err, errors.ErrMetadataPreservation, "warning: failed to write metadata")
+		err := c.convertImage(ctx, heifCtx, data, id, output)
+		if c.auxFormat != "" && (err == nil || errors.Is(err, errors.ErrMetadataPreservation)) {
+			if auxErr := c.exportAuxiliaryImages(ctx, data, uint32(id), output); auxErr != nil {
+				return auxErr
+			}
+		}
+		switch {
+		case err == nil:
+		case errors.Is(err, errors.ErrMetadataPreservation):
+			if warning == nil {
+				warning = err
+			}
+		default:
+			return err
 		}
 	}
+	return warning
+}
 
+// validate checks the converter settings before a conversion
+func (c *HEICConverter) validate() error {
+	if err := c.options.Validate(); err != nil {
+		return err
+	}
+	if err := c.resize.Validate(); err != nil {
+		return err
+	}
+	if err := c.validateRenditions(); err != nil {
+		return err
+	}
+	if c.auxFormat != "" && c.auxFormat != FormatPNG && c.auxFormat != FormatTIFF {
+		return errors.InvalidInput("auxiliary image format", c.auxFormat)
+	}
+	if c.alphaFormat != "" && !c.alphaFormat.SupportsAlpha() {
+		return errors.InvalidInput("alpha format", c.alphaFormat)
+	}
 	return nil
 }
 
-// decodeHEIC decodes a HEIC file and returns the image and metadata
-func (c *HEICConverter) decodeHEIC(path string) (image.Image, *exif.Exif, error) {
-	// Open the HEIC file
-	file, err := os.Open(path)
+// openHEIF parses HEIF file data with libheif. Files libheif rejects are
+// described by the validator if their structure is invalid.
+func openHEIF(data []byte) (*heif.Context, error) {
+	heifCtx, err := heif.NewContext()
 	if err != nil {
-		return nil, nil, errors.HandleFileError(err, path)
+		return nil, errors.Wrap(err, errors.ErrSystem, "failed to create HEIF context")
 	}
-	defer file.Close()
+	if err := heifCtx.ReadFromMemory(data); err != nil {
+		if _, invalid := validateHEIF(bytes.NewReader(data), int64(len(data))); invalid != nil {
+			return nil, invalid
+		}
+		return nil, errors.Wrap(err, errors.ErrDecodeFailed, "failed to read HEIC data")
+	}
+	return heifCtx, nil
+}
 
-	// Get file info for size
-	fileInfo, err := file.Stat()
-	if err != nil {
-		return nil, nil, errors.Wrap(err, errors.ErrFileRead, "failed to get file info")
+// selectImages returns the IDs of the images to convert: the configured
+// image, every top-level image, or the primary image
+func (c *HEICConverter) selectImages(heifCtx *heif.Context) ([]int, error) {
+	if c.imageID != 0 {
+		if !heifCtx.IsTopLevelImageID(c.imageID) {
+			return nil, errors.InvalidInput("image id", c.imageID)
+		}
+		return []int{c.imageID}, nil
 	}
 
-	// Read the entire file into memory
-	data := make([]byte, fileInfo.Size())
-	if _, err := file.Read(data); err != nil {
-		return nil, nil, errors.Wrap(err, errors.ErrFileRead, "failed to read file data")
+	if c.allImages {
+		if ids := heifCtx.GetListOfTopLevelImageIDs(); len(ids) > 0 {
+			return ids, nil
+		}
 	}
 
-	// Create HEIF context
-	ctx := heif.NewContext()
-	if err := ctx.ReadFromMemory(data); err != nil {
-		return nil, nil, errors.Wrap(err, errors.ErrDecodeFailed, "failed to read HEIC data")
+	id, err := heifCtx.GetPrimaryImageID()
+	if err != nil {
+		return nil, errors.Wrap(err, errors.ErrInvalidImage, "invalid or corrupted HEIC file")
 	}
+	return []int{id}, nil
+}
 
-	// Get the primary image
-	handle, err := ctx.GetPrimaryImageHandle()
+// convertImage decodes a single image of a HEIC file and writes it to the
+// output path in the configured format, followed by its renditions
+func (c *HEICConverter) convertImage(ctx context.Context, heifCtx *heif.Context, data []byte, id int, outputPath string) error {
+	img, metadata, err := c.decodeImage(heifCtx, data, id)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, errors.ErrInvalidImage, "invalid or corrupted HEIC file")
+		return errors.Wrap(err, errors.ErrDecodeFailed, "failed to decode HEIC file")
+	}
+	if err := checkCancelled(ctx); err != nil {
+		return err
 	}
 
-	// Decode the image
-	img, err := handle.DecodeImage(heif.ColorspaceUndefined, heif.ChromaUndefined, nil)
+	// Every output is encoded from the one decoded image
+	var warning error
+	for _, output := range c.outputs(outputPath, metadata.HasAlpha) {
+		err := c.writeImage(ctx, img, metadata, data, id, output)
+		switch {
+		case err == nil:
+		case errors.Is(err, errors.ErrMetadataPreservation):
+			if warning == nil {
+				warning = err
+			}
+		default:
+			return err
+		}
+	}
+	return warning
+}
+
+// encodedImage is a decoded image encoded for one output
+type encodedImage struct {
+	data []byte
+	// Errors that kept metadata out of the encoded image, if any
+	metadataErr error
+}
+
+// addMetadataErr records an error that kept metadata out of the encoded
+// image, in addition to earlier ones
+func (e *encodedImage) addMetadataErr(err error) {
+	e.metadataErr = stderrors.Join(e.metadataErr, err)
+}
+
+// writeImage encodes a decoded image for one output and saves it
+func (c *HEICConverter) writeImage(ctx context.Context, img image.Image, metadata *imageMetadata, data []byte, id int, output imageOutput) error {
+	encoded, err := c.encodeImage(ctx, img, metadata, data, id, output)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, errors.ErrDecodeFailed, "failed to decode HEIC image")
+		return err
 	}
 
-	// Extract metadata if needed
-	var exifData *exif.Exif
-	if c.preserveMetadata {
-		exifData, _ = c.extractExifMetadata(handle)
+	// Save the output file
+	outputPath := output.path
+	c.reportOutput(StageWrite, outputPath)
+	if err := writeOutput(ctx, outputPath, encoded.data); err != nil {
+		return errors.Wrap(err, errors.ErrFileWrite, "failed to save output file")
 	}
+	c.reportOutput(StageDone, outputPath)
 
-	return img, exifData, nil
+	return encoded.warning()
+}
+
+// warning returns the error for metadata that could not be written, which
+// doesn't fail the entire conversion
+func (e *encodedImage) warning() error {
+	if e.metadataErr == nil {
+		return nil
+	}
+	return errors.Wrap(e.metadataErr, errors.ErrMetadataPreservation, "warning: failed to write metadata")
 }
 
-// extractExifMetadata extracts EXIF metadata from HEIC image
-func (c *HEICConverter) extractExifMetadata(handle *heif.ImageHandle) (*exif.Exif, error) {
-	// Get EXIF data
-	exifData, err := handle.GetExif()
+// encodeImage resizes, color-converts and encodes a decoded image for one
+// output, with its metadata
+func (c *HEICConverter) encodeImage(ctx context.Context, img image.Image, metadata *imageMetadata, data []byte, id int, output imageOutput) (*encodedImage, error) {
+	// Scale and crop before the per-pixel color work
+	img = resizeImage(img, output.resize)
+
+	// Tone map HDR images unless the output format can declare them as HDR
+	c.report(StageColor)
+	colorInfo, keepHDR := metadata.Color, metadata.Color.isHDR()
+	if keepHDR && !output.format.keepsHDR() {
+		if mapped, err := toneMapToSDR(img, colorInfo); err == nil {
+			img, colorInfo, keepHDR = mapped, nil, false
+		}
+	}
+
+	// Convert to sRGB or pick the profile to embed
+	img, iccProfile := c.applyColorMode(img, colorInfo)
+
+	// Flatten transparency the output format cannot store
+	format := output.format
+	if hasTransparency(img) && !format.SupportsAlpha() {
+		img = flattenAlpha(img, c.background)
+	}
+
+	// Encode in the selected output format
+	encoder, err := NewEncoder(format, output.options)
 	if err != nil {
+		return nil, errors.Wrap(err, errors.ErrNotSupported, "unsupported output format")
+	}
+
+	c.report(StageEncode)
+	var buf bytes.Buffer
+	if err := encoder.Encode(&buf, img); err != nil {
+		return nil, errors.Wrap(err, errors.ErrEncodeFailed, "failed to encode output image")
+	}
+	result := &encodedImage{data: buf.Bytes()}
+	if err := checkCancelled(ctx); err != nil {
 		return nil, err
 	}
 
-	// Parse EXIF data
-	return exif.Decode(exifData.Reader())
+	// Preserve metadata if requested and the format can carry it
+	var exifData []byte
+	if c.preserveMetadata {
+		exifData = metadata.Exif
+		if metadata.ExifErr != nil {
+			result.addMetadataErr(metadata.ExifErr)
+		}
+	}
+	if embedder, ok := encoder.(MetadataEmbedder); ok && (exifData != nil || iccProfile != nil) {
+		c.report(StageMetadata)
+		if updated, err := embedder.EmbedMetadata(result.data, exifData, iccProfile); err != nil {
+			result.addMetadataErr(err)
+		} else {
+			result.data = updated
+		}
+	}
+	// Keep the HDR gain map of iPhone photos unless cropping made it misfit,
+	// and declare HDR output as such
+	if c.gainMap && format == FormatJPEG && !output.resize.crops() {
+		if updated, err := c.attachGainMap(result.data, data, uint32(id), img.Bounds(), metadata.SourceExif, output.options.Quality, output.resize.Filter); err != nil {
+			result.addMetadataErr(err)
+		} else {
+			result.data = updated
+		}
+	}
+	if keepHDR && format == FormatPNG {
+		if updated, err := insertPNGColorCode(result.data, colorInfo.NCLX); err != nil {
+			result.addMetadataErr(err)
+		} else {
+			result.data = updated
+		}
+	}
+
+	return result, nil
+}
+
+// applyColorMode applies the configured color mode to a decoded image and
+// returns the image to encode together with the ICC profile to embed, if any
+func (c *HEICConverter) applyColorMode(img image.Image, info *colorInfo) (image.Image, []byte) {
+	if info.isSRGB() {
+		return img, nil
+	}
+
+	if c.colorMode == ColorModeConvertSRGB {
+		if space, err := info.rgbSpace(); err == nil {
+			if converted, err := convertToSRGB(img, space); err == nil {
+				return converted, nil
+			}
+		}
+		// Fall back to embedding the profile if the conversion is not possible
+	}
+
+	iccProfile, err := info.outputProfile()
+	if err != nil {
+		return img, nil
+	}
+	return img, iccProfile
 }
 
-// writeMetadata writes metadata to the output file
-func (c *HEICConverter) writeMetadata(path string, exifData *exif.Exif) error {
-	// Open the output file for reading and writing
-	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+// readHEIC reads a HEIC file into memory
+func (c *HEICConverter) readHEIC(ctx context.Context, path string) ([]byte, error) {
+	// Open the HEIC file
+	file, err := os.Open(path)
 	if err != nil {
-		return err
+		return nil, errors.HandleFileError(err, path)
 	}
 	defer file.Close()
 
-	// Get the current file content
-	imgData, err := os.ReadFile(path)
+	// Get file info for size
+	fileInfo, err := file.Stat()
 	if err != nil {
-		return err
+		return nil, errors.Wrap(err, errors.ErrFileRead, "failed to get file info")
 	}
 
-	// Create a new buffer with the EXIF data
-	var buf []byte
-	if exifData != nil {
-		buf, err = exifData.MarshalJSON()
+	// Read the entire file into memory
+	data, err := c.readWithProgress(ctx, file, fileInfo.Size())
+	if err != nil {
+		return nil, errors.Wrap(err, errors.ErrFileRead, "failed to read file data")
+	}
+	return data, nil
+}
+
+// decodeImage decodes an image of a HEIC file and returns it with its metadata
+func (c *HEICConverter) decodeImage(heifCtx *heif.Context, data []byte, id int) (image.Image, *imageMetadata, error) {
+	handle, err := heifCtx.GetImageHandle(id)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, errors.ErrInvalidImage, "invalid or corrupted HEIC file")
+	}
+
+	itemID := uint32(id)
+	hasAlpha := handle.HasAlphaChannel()
+	var img image.Image
+	if imageBitDepth(data, itemID) > 8 {
+		// Keep 10 and 12-bit images at 16 bits per sample
+		img, err = decodeHighBitDepth(handle)
 		if err != nil {
-			return err
+			return nil, nil, errors.Wrap(err, errors.ErrDecodeFailed, "failed to decode HEIC image")
+		}
+	} else {
+		// Decode the image, as RGBA if it has an alpha plane since the YCbCr
+		// image type cannot carry one
+		colorspace, chroma := heif.Colorspace(heif.ColorspaceUndefined), heif.Chroma(heif.ChromaUndefined)
+		if hasAlpha {
+			colorspace, chroma = heif.ColorspaceRGB, heif.ChromaInterleavedRGBA
+		}
+		decoded, err := handle.DecodeImage(colorspace, chroma, nil)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, errors.ErrDecodeFailed, "failed to decode HEIC image")
+		}
+
+		img, err = decoded.GetImage()
+		if err != nil {
+			return nil, nil, errors.Wrap(err, errors.ErrDecodeFailed, "failed to convert decoded HEIC image")
+		}
+		if hasAlpha {
+			img = straightAlpha(img)
 		}
 	}
 
-	// For now, just print the metadata since we need additional libraries
-	// to properly write EXIF to JPEG files
-	fmt.Printf("Metadata to be written (not implemented yet): %s\n", string(buf))
+	// EXIF is needed to settle the orientation even if it is not preserved
+	exifData, exifErr := c.extractExifMetadata(data, itemID)
+	img = normalizeOrientation(img, data, itemID, exifData, c.exifOrientation)
 
-	return nil
+	// The pixels are upright now, so the tag must not rotate them again
+	metadata := &imageMetadata{SourceExif: exifData, HasAlpha: hasAlpha}
+	if exifErr != nil {
+		metadata.ExifErr = fmt.Errorf("failed to read EXIF metadata: %w", exifErr)
+	}
+	if exifData != nil {
+		metadata.Exif = resetExifOrientation(exifData)
+	}
+
+	// The color profile is always needed to display the pixels correctly
+	metadata.Color, _ = extractColorInfo(data, itemID)
+
+	return img, metadata, nil
+}
+
+// extractExifMetadata extracts the raw EXIF block of an image from HEIC file data
+func (c *HEICConverter) extractExifMetadata(data []byte, itemID uint32) ([]byte, error) {
+	// Locate the EXIF item in the HEIF container
+	exifData, err := extractExif(data, itemID)
+	if err != nil || exifData == nil {
+		return nil, err
+	}
+
+	// Make sure the block parses before we embed it in the output
+	if _, err := exif.Decode(bytes.NewReader(exifData)); err != nil {
+		return nil, err
+	}
+
+	return exifData, nil
 }
 
 // GetOutputPath generates an output path for the converted file
 func (c *HEICConverter) GetOutputPath(inputPath string) string {
 	ext := filepath.Ext(inputPath)
 	base := strings.TrimSuffix(inputPath, ext)
-	return base + ".jpg"
+	return base + c.format.Extension()
+}
+
+// IndexedOutputPath returns the output path of the n-th of count images
+// converted from one file, e.g. "IMG_0001-2.jpg". The index is zero-padded
+// to the width of count so that the outputs sort in order.
+func IndexedOutputPath(outputPath string, n, count int) string {
+	ext := filepath.Ext(outputPath)
+	width := len(strconv.Itoa(count))
+	return fmt.Sprintf("%s-%0*d%s", strings.TrimSuffix(outputPath, ext), width, n, ext)
+}
+
+// imageOutputPath returns the output path of the i-th of count images
+// selected for conversion, which is outputPath itself for a single image
+func imageOutputPath(outputPath string, i, count int) string {
+	if count == 1 {
+		return outputPath
+	}
+	return IndexedOutputPath(outputPath, i+1, count)
+}
+
+// FindHEICFiles finds all HEIC/HEIF files in a directory and its subdirectories
+func FindHEICFiles(dir string) ([]string, error) {
+	var files []string
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Skip directories
+		if info.IsDir() {
+			return nil
+		}
+
+		// Check if file is HEIC/HEIF
+		ext := strings.ToLower(filepath.Ext(path))
+		if ext == ".heic" || ext == ".heif" {
+			files = append(files, path)
+		}
+
+		return nil
+	})
+
+	return files, err
 }
@@ -0,0 +1,97 @@
+package converter
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/spenceriam/HEIC-2-Go/internal/errors"
+)
+
+func TestEncodedImageWarning(t *testing.T) {
+	var encoded encodedImage
+	if encoded.warning() != nil {
+		t.Fatal("warning without metadata errors")
+	}
+
+	// A later error, e.g. from the gain map, does not hide an earlier one
+	exifErr := fmt.Errorf("invalid EXIF block")
+	gainMapErr := errors.New(errors.ErrDecodeFailed, "failed to decode HDR gain map")
+	encoded.addMetadataErr(exifErr)
+	encoded.addMetadataErr(gainMapErr)
+
+	warning := encoded.warning()
+	if !errors.Is(warning, errors.ErrMetadataPreservation) {
+		t.Errorf("warning %v, want metadata_preservation", warning)
+	}
+	if !stderrors.Is(warning, exifErr) || !stderrors.Is(warning, gainMapErr) {
+		t.Errorf("warning %v does not wrap both errors", warning)
+	}
+	if msg := warning.Error(); !strings.Contains(msg, "EXIF") || !strings.Contains(msg, "gain map") {
+		t.Errorf("warning %q, want both errors in the message", msg)
+	}
+}
+
+func TestOutputsUseRenditionQuality(t *testing.T) {
+	options := DefaultOptions()
+	options.Quality = 92
+	c := NewHEICConverter(true).WithOptions(options).WithRenditions([]Rendition{
+		{Suffix: "_web", Quality: 70},
+		{Suffix: "_full"},
+	})
+
+	// The quality of each output is used for its image and its gain map
+	want := []int{92, 70, 92}
+	for i, output := range c.outputs("IMG.jpg", false) {
+		if output.options.Quality != want[i] {
+			t.Errorf("%s: quality %d, want %d", output.path, output.options.Quality, want[i])
+		}
+	}
+}
+
+func TestEncoderSize(t *testing.T) {
+	const pixels = 12_000_000
+	tests := []struct {
+		name     string
+		format   OutputFormat
+		opts     Options
+		hasAlpha bool
+		want     int64
+	}{
+		{"jpeg 4:2:0", FormatJPEG, Options{ChromaSubsampling: Subsampling420}, false, pixels * 21 / 2},
+		{"jpeg 4:4:4", FormatJPEG, Options{ChromaSubsampling: Subsampling444}, false, pixels * 15},
+		{"lossy webp", FormatWebP, Options{}, false, pixels * 7},
+		{"lossy webp with alpha", FormatWebP, Options{}, true, pixels * 36},
+		{"lossless webp", FormatWebP, Options{WebPLossless: true}, false, pixels * 36},
+		{"png", FormatPNG, Options{}, false, 0},
+	}
+	for _, tt := range tests {
+		if got := encoderSize(tt.format, tt.opts, tt.hasAlpha, pixels); got != tt.want {
+			t.Errorf("%s: %d bytes, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeImageReportsUnreadableExif(t *testing.T) {
+	exifErr := fmt.Errorf("failed to read EXIF metadata: %w", stderrors.New("bad IFD"))
+	metadata := &imageMetadata{ExifErr: exifErr}
+
+	for _, preserve := range []bool{false, true} {
+		c := NewHEICConverter(preserve).WithOutputFormat(FormatPNG)
+		encoded, err := c.encodeImage(context.Background(), testImage(4, 4, false), metadata, nil, 1, c.outputs("IMG.png", false)[0])
+		if err != nil {
+			t.Fatalf("preserve %v: %v", preserve, err)
+		}
+
+		// The lost EXIF block only matters when metadata is preserved
+		warning := encoded.warning()
+		if preserve != errors.Is(warning, errors.ErrMetadataPreservation) {
+			t.Errorf("preserve %v: warning %v", preserve, warning)
+		}
+		if preserve && !stderrors.Is(warning, exifErr) {
+			t.Errorf("warning %v does not wrap the EXIF error", warning)
+		}
+	}
+}
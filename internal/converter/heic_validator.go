@@ -1,54 +1,326 @@
 package converter
 
 import (
-	"bytes"
 	"encoding/binary"
-	"errors"
+	"fmt"
+	"io"
 	"os"
-)
+	"strings"
 
-// HEIC signature (file signature for HEIF/HEIC files)
-var (
-	heicSignature = []byte{'f', 't', 'y', 'p'}
-	heicBrands    = [][]byte{
-		{'h', 'e', 'i', 'c'}, // HEIC
-		{'h', 'e', 'i', 'x'}, // HEIF still image
-		{'m', 'i', 'f', '1'}, // HEIF image sequence
-		{'m', 's', 'f', '1'}, // HEIF image sequence
-	}
+	"github.com/spenceriam/HEIC-2-Go/internal/errors"
 )
 
-// IsValidHEIC checks if the given file is a valid HEIC/HEIF file
+// HEIF brands accepted in the 'ftyp' box, either as the major brand or as
+// one of the compatible brands
+var heicBrands = []string{
+	"heic", // HEVC still image
+	"heix", // HEVC still image, extended profiles
+	"heim", // HEVC multi-layer still image
+	"heis", // HEVC scalable still image
+	"hevc", // HEVC image sequence
+	"hevx", // HEVC image sequence, extended profiles
+	"mif1", // HEIF still image
+	"msf1", // HEIF image sequence
+	"avif", // AV1 still image
+	"avis", // AV1 image sequence
+}
+
+// supportedItemTypes are the item types the primary image may have
+var supportedItemTypes = map[string]bool{
+	"hvc1": true, // HEVC coded image
+	"av01": true, // AV1 coded image
+	"jpeg": true, // JPEG coded image
+	"grid": true, // Image assembled from tiles
+	"iovl": true, // Image overlaying other images
+	"iden": true, // Identity-derived image, e.g. a rotated view of another
+}
+
+// maxMetaBoxSize limits the size of the 'meta' box that is read into memory
+const maxMetaBoxSize = 64 << 20
+
+// heifFile is the validated structure of a HEIF file
+type heifFile struct {
+	// Brands from the 'ftyp' box
+	FileType fileType
+	// Top-level boxes. Only the 'ftyp' and 'meta' boxes have their payload.
+	Boxes []box
+	// Parsed 'meta' box
+	Meta *heifMeta
+}
+
+// IsValidHEIC checks if the given file is a valid HEIC/HEIF file. If it is
+// not, the returned ErrInvalidImage error explains why it was rejected.
 func IsValidHEIC(filePath string) (bool, error) {
-	// Open the file
 	file, err := os.Open(filePath)
 	if err != nil {
-		return false, err
+		return false, errors.HandleFileError(err, filePath)
 	}
 	defer file.Close()
 
-	// Read the first 16 bytes (should be enough to identify the file type)
-	buffer := make([]byte, 16)
-	n, err := file.Read(buffer)
-	if err != nil || n < 16 {
-		return false, errors.New("file too small or unreadable")
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return false, errors.Wrap(err, errors.ErrFileRead, "failed to get file info")
+	}
+
+	if _, err := validateHEIF(file, fileInfo.Size()); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// invalidHEIC creates the error returned for a file that is not a valid
+// HEIC/HEIF file, with the reason as details
+func invalidHEIC(format string, args ...interface{}) *errors.AppError {
+	return errors.New(errors.ErrInvalidImage, "not a valid HEIC/HEIF file").
+		WithDetails(fmt.Sprintf(format, args...))
+}
+
+// validateHEIF parses the box structure of a HEIF file and checks that it
+// holds a primary image that can be decoded: a supported brand in 'ftyp',
+// and 'meta' with the primary item declared in 'iinf', stored within the
+// file according to 'iloc' and described by properties in 'ipma'.
+func validateHEIF(r io.ReaderAt, size int64) (*heifFile, error) {
+	boxes, err := readTopLevelBoxes(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	ft, err := parseFileType(boxes[0].Payload)
+	if err != nil {
+		return nil, invalidHEIC("%v", err)
+	}
+	if !hasHEIFBrand(ft) {
+		brands := "none"
+		if len(ft.CompatibleBrands) > 0 {
+			brands = "'" + strings.Join(ft.CompatibleBrands, "', '") + "'"
+		}
+		return nil, invalidHEIC("no HEIF brand in 'ftyp' (major brand '%s', compatible brands %s)", ft.MajorBrand, brands)
+	}
+
+	metaBox := findBox(boxes, "meta")
+	if metaBox == nil {
+		if findBox(boxes, "moov") != nil {
+			return nil, invalidHEIC("image sequence without a still image; only files with a 'meta' box can be converted")
+		}
+		return nil, invalidHEIC("missing 'meta' box")
+	}
+
+	meta, err := validateMeta(metaBox.Payload, size)
+	if err != nil {
+		return nil, err
+	}
+	return &heifFile{FileType: ft, Boxes: boxes, Meta: meta}, nil
+}
+
+// hasHEIFBrand reports whether a file type lists one of the HEIF brands
+func hasHEIFBrand(ft fileType) bool {
+	for _, brand := range heicBrands {
+		if ft.hasBrand(brand) {
+			return true
+		}
+	}
+	return false
+}
+
+// readTopLevelBoxes walks the top-level boxes of a file. The payload of the
+// 'ftyp' and 'meta' boxes is read; other boxes, such as the image data in
+// 'mdat', are skipped.
+func readTopLevelBoxes(r io.ReaderAt, size int64) ([]box, error) {
+	var boxes []box
+	var offset int64
+	for offset < size {
+		if size-offset < 8 {
+			return nil, invalidHEIC("truncated box header at offset %d", offset)
+		}
+		header := make([]byte, 16)
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return nil, errors.Wrap(err, errors.ErrFileRead, "failed to read file data")
+		}
+
+		boxSize := uint64(binary.BigEndian.Uint32(header))
+		boxType := string(header[4:8])
+		headerSize := uint64(8)
+		if offset == 0 && boxType != "ftyp" {
+			return nil, invalidHEIC("missing 'ftyp' box at the start of the file")
+		}
+
+		switch boxSize {
+		case 0:
+			// Box extends to the end of the file
+			boxSize = uint64(size - offset)
+		case 1:
+			// 64-bit largesize follows the type
+			if size-offset < 16 {
+				return nil, invalidHEIC("truncated '%s' box header at offset %d", boxType, offset)
+			}
+			if _, err := r.ReadAt(header[8:], offset+8); err != nil {
+				return nil, errors.Wrap(err, errors.ErrFileRead, "failed to read file data")
+			}
+			boxSize = binary.BigEndian.Uint64(header[8:])
+			headerSize = 16
+		}
+
+		if boxSize < headerSize {
+			return nil, invalidHEIC("invalid size %d for '%s' box at offset %d", boxSize, boxType, offset)
+		}
+		if boxSize > uint64(size-offset) {
+			return nil, invalidHEIC("'%s' box at offset %d needs %d bytes but the file ends after %d (truncated file?)",
+				boxType, offset, boxSize, size-offset)
+		}
+
+		b := box{Type: boxType, Offset: int(offset), Header: header[:headerSize]}
+		if boxType == "ftyp" || boxType == "meta" {
+			payloadSize := boxSize - headerSize
+			if payloadSize > maxMetaBoxSize {
+				return nil, invalidHEIC("'%s' box at offset %d is too large (%d bytes)", boxType, offset, boxSize)
+			}
+			// Readers may report EOF for an empty read at the end of the file
+			b.Payload = make([]byte, payloadSize)
+			if _, err := r.ReadAt(b.Payload, offset+int64(headerSize)); err != nil && payloadSize > 0 {
+				return nil, errors.Wrap(err, errors.ErrFileRead, "failed to read file data")
+			}
+		}
+		boxes = append(boxes, b)
+		offset += int64(boxSize)
 	}
 
-	// Check for the 'ftyp' signature at the start of the file
-	if !bytes.Equal(buffer[4:8], heicSignature) {
-		return false, errors.New("not a valid HEIC/HEIF file (missing 'ftyp' signature)")
+	if len(boxes) == 0 {
+		return nil, invalidHEIC("file is empty")
 	}
+	return boxes, nil
+}
 
-	// Check for known HEIC/HEIF brands
-	for i := 0; i < len(heicBrands); i++ {
-		// Brands are typically at offset 8-11 or 12-15 in the file
-		if bytes.Equal(buffer[8:12], heicBrands[i]) || 
-		   (len(buffer) > 15 && bytes.Equal(buffer[12:16], heicBrands[i])) {
-			return true, nil
+// validateMeta parses a 'meta' box and checks its item structure against a
+// file of the given size
+func validateMeta(payload []byte, size int64) (*heifMeta, error) {
+	children, err := metaChildren(payload)
+	if err != nil {
+		return nil, invalidHEIC("%v", err)
+	}
+
+	hdlr := findBox(children, "hdlr")
+	if hdlr == nil {
+		return nil, invalidHEIC("missing 'hdlr' box in 'meta'")
+	}
+	hr := newBoxReader(hdlr.Payload)
+	hr.fullBoxHeader()
+	hr.uint32() // pre_defined
+	if handler := hr.fourCC(); hr.err != nil || handler != "pict" {
+		return nil, invalidHEIC("'meta' handler is '%s', expected 'pict'", handler)
+	}
+
+	for _, name := range []string{"pitm", "iinf", "iloc", "iprp"} {
+		if findBox(children, name) == nil {
+			return nil, invalidHEIC("missing '%s' box in 'meta'", name)
+		}
+	}
+	iprp, err := parseBoxes(findBox(children, "iprp").Payload)
+	if err != nil {
+		return nil, invalidHEIC("invalid 'iprp' box: %v", err)
+	}
+	for _, name := range []string{"ipco", "ipma"} {
+		if findBox(iprp, name) == nil {
+			return nil, invalidHEIC("missing '%s' box in 'iprp'", name)
 		}
 	}
 
-	return false, errors.New("not a supported HEIC/HEIF variant")
+	meta, err := parseMetaChildren(children, nil)
+	if err != nil {
+		return nil, invalidHEIC("%v", err)
+	}
+
+	// Property associations must point into 'ipco'
+	for _, id := range meta.ItemIDs {
+		for _, index := range meta.Associations[id] {
+			if index >= len(meta.Properties) {
+				return nil, invalidHEIC("item %d refers to property %d in 'ipma' but 'ipco' holds %d properties",
+					id, index+1, len(meta.Properties))
+			}
+		}
+	}
+
+	// Item data must lie within the file or the 'idat' box
+	for _, id := range meta.ItemIDs {
+		if err := checkItemLocation(meta, id, size); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := checkPrimaryItem(meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// checkItemLocation checks that the extents of an item lie within their source
+func checkItemLocation(meta *heifMeta, id uint32, size int64) error {
+	loc, ok := meta.Locations[id]
+	if !ok {
+		return nil
+	}
+
+	var source uint64
+	var sourceName string
+	switch loc.ConstructionMethod {
+	case 0:
+		source, sourceName = uint64(size), "file"
+	case 1:
+		source, sourceName = uint64(len(meta.ItemData)), "'idat' box"
+	default:
+		// Offsets into other items are resolved by the decoder
+		return nil
+	}
+
+	for _, extent := range loc.Extents {
+		start := loc.BaseOffset + extent.Offset
+		if start < loc.BaseOffset || start > source || extent.Length > source-start {
+			return invalidHEIC("data of item %d at offset %d (%d bytes) lies beyond the end of the %s (%d bytes, truncated file?)",
+				id, start, extent.Length, sourceName, source)
+		}
+	}
+	return nil
+}
+
+// checkPrimaryItem checks that the primary item is a supported image with
+// its data, its properties and, for derived images, its source images
+func checkPrimaryItem(meta *heifMeta) error {
+	id := meta.PrimaryID
+	itemType, ok := meta.ItemTypes[id]
+	if !ok {
+		return invalidHEIC("primary item %d is not listed in 'iinf'", id)
+	}
+	if !supportedItemTypes[itemType] {
+		return invalidHEIC("primary item %d has unsupported type '%s'", id, itemType)
+	}
+	if _, ok := meta.Locations[id]; !ok && itemType != "iden" {
+		return invalidHEIC("primary item %d has no location in 'iloc'", id)
+	}
+
+	props := meta.itemProperties(id)
+	if len(props) == 0 {
+		return invalidHEIC("primary item %d has no properties in 'ipma'", id)
+	}
+	if findBox(props, "ispe") == nil {
+		return invalidHEIC("primary item %d has no image size ('ispe') property", id)
+	}
+
+	if itemType == "grid" || itemType == "iovl" || itemType == "iden" {
+		var sources []uint32
+		for _, ref := range meta.References {
+			if ref.Type == "dimg" && ref.From == id {
+				sources = append(sources, ref.To...)
+			}
+		}
+		if len(sources) == 0 {
+			return invalidHEIC("derived primary item %d ('%s') has no 'dimg' references", id, itemType)
+		}
+		for _, source := range sources {
+			if _, ok := meta.ItemTypes[source]; !ok {
+				return invalidHEIC("primary item %d refers to item %d, which is not listed in 'iinf'", id, source)
+			}
+		}
+	}
+	return nil
 }
 
 // GetFileSize returns the size of the file in bytes
@@ -60,7 +332,8 @@ func GetFileSize(filePath string) (int64, error) {
 	return fileInfo.Size(), nil
 }
 
-// ReadFileHeader reads the first n bytes from a file
+// ReadFileHeader reads the first n bytes from a file, or the whole file if
+// it is shorter
 func ReadFileHeader(filePath string, n int) ([]byte, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -68,26 +341,18 @@ func ReadFileHeader(filePath string, n int) ([]byte, error) {
 	}
 	defer file.Close()
 
+	// A single Read may return fewer bytes than are available
 	header := make([]byte, n)
-	_, err = file.Read(header)
-	if err != nil {
+	read, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
 		return nil, err
 	}
 
-	return header, nil
+	return header[:read], nil
 }
 
-// IsBigEndian checks if the system is big endian
-func IsBigEndian() bool {
-	var i int32 = 0x01020304
-	u := (*[4]byte)(&i)
-	return u[0] == 0x01
-}
-
-// ReadUint32 reads a 32-bit unsigned integer from a byte slice
+// ReadUint32 reads a big-endian 32-bit unsigned integer from a byte slice.
+// ISOBMFF fields are big-endian regardless of the host byte order.
 func ReadUint32(data []byte, offset int) uint32 {
-	if IsBigEndian() {
-		return binary.BigEndian.Uint32(data[offset : offset+4])
-	}
-	return binary.LittleEndian.Uint32(data[offset : offset+4])
+	return binary.BigEndian.Uint32(data[offset : offset+4])
 }
@@ -0,0 +1,225 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/spenceriam/HEIC-2-Go/internal/errors"
+)
+
+// testBox returns an ISOBMFF box with the concatenated payload
+func testBox(boxType string, payload ...[]byte) []byte {
+	data := make([]byte, 8)
+	copy(data[4:], boxType)
+	for _, p := range payload {
+		data = append(data, p...)
+	}
+	binary.BigEndian.PutUint32(data, uint32(len(data)))
+	return data
+}
+
+// be16 and be32 encode big-endian integers
+func be16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+func be32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+
+// syntheticHEIF describes a minimal HEIF file holding one image item with
+// four bytes of data, for tests that break one part of its structure
+type syntheticHEIF struct {
+	// Brands in 'ftyp', the major brand first
+	brands []string
+	// Handler type in 'hdlr'
+	handler string
+	// Item ID in 'pitm'
+	primaryID uint16
+	// Type of the image item, whose ID is 1
+	itemType string
+	// Length of the item's extent in 'iloc'
+	extentLength uint32
+	// 1-based index of the item's property in 'ipma'
+	propertyIndex byte
+	// Type of the only property in 'ipco'
+	propertyType string
+	// Boxes left out of 'meta'
+	omit []string
+}
+
+// validHEIF returns the description of a valid file
+func validHEIF() syntheticHEIF {
+	return syntheticHEIF{
+		brands:        []string{"heic", "mif1"},
+		handler:       "pict",
+		primaryID:     1,
+		itemType:      "hvc1",
+		extentLength:  4,
+		propertyIndex: 1,
+		propertyType:  "ispe",
+	}
+}
+
+// build returns the file data
+func (h syntheticHEIF) build() []byte {
+	var brands []byte
+	for _, brand := range h.brands {
+		brands = append(brands, brand...)
+	}
+	ftyp := testBox("ftyp", []byte(h.brands[0]), be32(0), brands)
+
+	property := testBox(h.propertyType, be32(0), be32(64), be32(48))
+	children := map[string][]byte{
+		"hdlr": testBox("hdlr", be32(0), be32(0), []byte(h.handler), make([]byte, 13)),
+		"pitm": testBox("pitm", be32(0), be16(h.primaryID)),
+		"iinf": testBox("iinf", be32(0), be16(1),
+			testBox("infe", be32(2<<24), be16(1), be16(0), []byte(h.itemType), []byte{0})),
+		"iprp": testBox("iprp", testBox("ipco", property),
+			testBox("ipma", be32(0), be32(1), be16(1), []byte{1, 0x80 | h.propertyIndex})),
+	}
+
+	// The item data follows the 'meta' box, whose size does not depend on
+	// the offset written into 'iloc'
+	build := func(dataOffset uint32) []byte {
+		children["iloc"] = testBox("iloc", be32(0), []byte{0x44, 0x00}, be16(1),
+			be16(1), be16(0), be16(1), be32(dataOffset), be32(h.extentLength))
+		meta := [][]byte{be32(0)}
+		for _, name := range []string{"hdlr", "pitm", "iinf", "iloc", "iprp"} {
+			if !containsString(h.omit, name) {
+				meta = append(meta, children[name])
+			}
+		}
+		return append(append([]byte{}, ftyp...), testBox("meta", meta...)...)
+	}
+	head := build(0)
+	head = build(uint32(len(head) + 8))
+	return append(head, testBox("mdat", []byte{1, 2, 3, 4})...)
+}
+
+// containsString reports whether a list holds a string
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// validateData validates HEIF file data
+func validateData(data []byte) (*heifFile, error) {
+	return validateHEIF(bytes.NewReader(data), int64(len(data)))
+}
+
+func TestValidateHEIF(t *testing.T) {
+	file, err := validateData(validHEIF().build())
+	if err != nil {
+		t.Fatalf("valid file rejected: %v", err)
+	}
+	if file.Meta.PrimaryID != 1 || file.Meta.ItemTypes[1] != "hvc1" || !file.FileType.hasBrand("mif1") {
+		t.Errorf("parsed %+v, want primary hvc1 item 1 and the mif1 brand", file.Meta)
+	}
+
+	// Other supported image types are accepted as the primary image too
+	avif := validHEIF()
+	avif.brands, avif.itemType = []string{"avif", "mif1"}, "av01"
+	if _, err := validateData(avif.build()); err != nil {
+		t.Errorf("AVIF file rejected: %v", err)
+	}
+}
+
+func TestValidateHEIFRejections(t *testing.T) {
+	modified := func(modify func(h *syntheticHEIF)) []byte {
+		h := validHEIF()
+		modify(&h)
+		return h.build()
+	}
+	valid := validHEIF().build()
+
+	tests := []struct {
+		name    string
+		data    []byte
+		details string
+	}{
+		{"empty file", nil, "file is empty"},
+		{"no ftyp first", testBox("mdat", []byte{1, 2, 3, 4}), "missing 'ftyp' box at the start of the file"},
+		{"truncated header", append(append([]byte{}, valid...), 0, 0, 0), "truncated box header"},
+		{"truncated file", valid[:len(valid)-2], "(truncated file?)"},
+		{"empty ftyp to the end", []byte("\x00\x00\x00\x00ftyp"), "invalid 'ftyp' box size 8"},
+		{"invalid box size", append(testBox("ftyp", []byte("heic"), be32(0)), 0, 0, 0, 4, 'f', 'r', 'e', 'e'), "invalid size 4 for 'free' box"},
+		{"bad brand", modified(func(h *syntheticHEIF) { h.brands = []string{"isom", "mp41"} }),
+			"no HEIF brand in 'ftyp' (major brand 'isom', compatible brands 'isom', 'mp41')"},
+		{"no meta", testBox("ftyp", []byte("heic"), be32(0), []byte("mif1")), "missing 'meta' box"},
+		{"sequence only", append(testBox("ftyp", []byte("msf1"), be32(0), []byte("msf1heic")), testBox("moov")...),
+			"image sequence without a still image"},
+		{"wrong handler", modified(func(h *syntheticHEIF) { h.handler = "vide" }), "'meta' handler is 'vide', expected 'pict'"},
+		{"missing hdlr", modified(func(h *syntheticHEIF) { h.omit = []string{"hdlr"} }), "missing 'hdlr' box in 'meta'"},
+		{"missing pitm", modified(func(h *syntheticHEIF) { h.omit = []string{"pitm"} }), "missing 'pitm' box in 'meta'"},
+		{"missing iinf", modified(func(h *syntheticHEIF) { h.omit = []string{"iinf"} }), "missing 'iinf' box in 'meta'"},
+		{"missing iloc", modified(func(h *syntheticHEIF) { h.omit = []string{"iloc"} }), "missing 'iloc' box in 'meta'"},
+		{"missing iprp", modified(func(h *syntheticHEIF) { h.omit = []string{"iprp"} }), "missing 'iprp' box in 'meta'"},
+		{"property out of range", modified(func(h *syntheticHEIF) { h.propertyIndex = 5 }),
+			"item 1 refers to property 5 in 'ipma' but 'ipco' holds 1 properties"},
+		{"iloc beyond the file", modified(func(h *syntheticHEIF) { h.extentLength = 1 << 20 }),
+			"data of item 1 at offset"},
+		{"primary not in iinf", modified(func(h *syntheticHEIF) { h.primaryID = 7 }), "primary item 7 is not listed in 'iinf'"},
+		{"unsupported primary", modified(func(h *syntheticHEIF) { h.itemType = "mime" }), "primary item 1 has unsupported type 'mime'"},
+		{"no image size", modified(func(h *syntheticHEIF) { h.propertyType = "pixi" }),
+			"primary item 1 has no image size ('ispe') property"},
+	}
+	for _, tt := range tests {
+		_, err := validateData(tt.data)
+		if !errors.Is(err, errors.ErrInvalidImage) {
+			t.Errorf("%s: error %v, want invalid_image", tt.name, err)
+			continue
+		}
+		appErr, _ := errors.AsAppError(err)
+		if !strings.Contains(appErr.Details, tt.details) {
+			t.Errorf("%s: details %q, want %q", tt.name, appErr.Details, tt.details)
+		}
+	}
+}
+
+// fuzzSeeds returns the valid synthetic file and variants of it as seeds
+func fuzzSeeds() [][]byte {
+	valid := validHEIF().build()
+	grid := validHEIF()
+	grid.itemType = "grid"
+	return [][]byte{valid, valid[:len(valid)/2], grid.build(), nil}
+}
+
+func FuzzValidateHEIF(f *testing.F) {
+	for _, seed := range fuzzSeeds() {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		file, err := validateData(data)
+		if err != nil {
+			// Every rejection explains itself as an invalid image
+			if !errors.Is(err, errors.ErrInvalidImage) {
+				t.Fatalf("error %v, want invalid_image", err)
+			}
+			return
+		}
+		// An accepted file has a primary image with data and a size
+		if err := checkPrimaryItem(file.Meta); err != nil {
+			t.Fatalf("accepted file fails the primary item check: %v", err)
+		}
+	})
+}
+
+func FuzzParseHEIFMeta(f *testing.F) {
+	for _, seed := range fuzzSeeds() {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		meta, err := parseHEIFMeta(data)
+		if err != nil {
+			return
+		}
+		// Reading items of a parsed file fails gracefully for bad locations
+		for _, id := range meta.ItemIDs {
+			meta.itemData(id)
+			meta.itemProperties(id)
+			meta.auxiliaryImages(id)
+		}
+	})
+}
@@ -0,0 +1,356 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+	"github.com/spenceriam/HEIC-2-Go/internal/errors"
+	heif "github.com/strukturag/libheif/go/heif"
+)
+
+// FileInfo describes the structure and metadata of a HEIF file
+type FileInfo struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// Brands from the 'ftyp' box
+	MajorBrand       string   `json:"major_brand"`
+	CompatibleBrands []string `json:"compatible_brands"`
+	// Number of top-level images, the ones that can be converted
+	ImageCount int    `json:"image_count"`
+	PrimaryID  uint32 `json:"primary_id"`
+	// Top-level images, in file order
+	Images []ImageInfo `json:"images"`
+	// Decoded EXIF fields of the primary image keyed by tag name
+	Exif map[string]string `json:"exif,omitempty"`
+}
+
+// ImageInfo describes a top-level image of a HEIF file
+type ImageInfo struct {
+	ID      uint32 `json:"id"`
+	Primary bool   `json:"primary"`
+	// Item type, e.g. "hvc1" for a coded image or "grid" for a tiled one
+	Type string `json:"type"`
+	// Size after the rotation and mirroring declared in the file
+	Width    int  `json:"width"`
+	Height   int  `json:"height"`
+	BitDepth int  `json:"bit_depth"`
+	Alpha    bool `json:"alpha"`
+	Depth    bool `json:"depth"`
+	// Declared color space, nil if the file does not declare one
+	Color *ColorInfo `json:"color,omitempty"`
+	// Tile layout of grid images
+	Grid       *GridInfo       `json:"grid,omitempty"`
+	Thumbnails []ThumbnailInfo `json:"thumbnails,omitempty"`
+	// Depth maps, mattes, gain maps and other auxiliary images
+	Auxiliary []AuxiliaryInfo `json:"auxiliary,omitempty"`
+}
+
+// ColorInfo describes the color space declared by an image
+type ColorInfo struct {
+	// Summary such as "Display P3 primaries, sRGB transfer"
+	Description string `json:"description"`
+	// Code points from an 'nclx' property, 0 if there is none
+	ColorPrimaries          int  `json:"color_primaries,omitempty"`
+	TransferCharacteristics int  `json:"transfer_characteristics,omitempty"`
+	MatrixCoefficients      int  `json:"matrix_coefficients,omitempty"`
+	FullRange               bool `json:"full_range,omitempty"`
+	// Size and description of an embedded ICC profile
+	ICCProfileSize int    `json:"icc_profile_size,omitempty"`
+	ICCProfileName string `json:"icc_profile_name,omitempty"`
+	// Whether the image uses the PQ or HLG transfer function
+	HDR bool `json:"hdr"`
+}
+
+// GridInfo describes the tile layout of a grid image
+type GridInfo struct {
+	Rows       int `json:"rows"`
+	Columns    int `json:"columns"`
+	TileWidth  int `json:"tile_width"`
+	TileHeight int `json:"tile_height"`
+}
+
+// ThumbnailInfo describes a thumbnail of an image
+type ThumbnailInfo struct {
+	ID     uint32 `json:"id"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// AuxiliaryInfo describes an auxiliary image attached to an image
+type AuxiliaryInfo struct {
+	ID uint32 `json:"id"`
+	// Short name such as "depth" or "hdrgainmap"
+	Name string `json:"name"`
+	// Auxiliary type URN from the 'auxC' property
+	Type string `json:"type"`
+}
+
+// Inspect reads a HEIF file and describes its structure and metadata. Files
+// that fail validation are rejected with the reason, like IsValidHEIC does.
+func Inspect(filePath string) (*FileInfo, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, errors.HandleFileError(err, filePath)
+	}
+
+	info, err := inspectData(data)
+	if err != nil {
+		return nil, err
+	}
+	info.Path = filePath
+	return info, nil
+}
+
+// inspectData describes the HEIF file held in data
+func inspectData(data []byte) (*FileInfo, error) {
+	file, err := validateHEIF(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	// Resolve item data from the file in memory
+	meta := file.Meta
+	meta.file = data
+
+	heifCtx, err := openHEIF(data)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &FileInfo{
+		Size:             int64(len(data)),
+		MajorBrand:       file.FileType.MajorBrand,
+		CompatibleBrands: file.FileType.CompatibleBrands,
+		ImageCount:       heifCtx.GetNumberOfTopLevelImages(),
+		PrimaryID:        meta.PrimaryID,
+	}
+
+	for _, id := range heifCtx.GetListOfTopLevelImageIDs() {
+		handle, err := heifCtx.GetImageHandle(id)
+		if err != nil {
+			return nil, errors.Wrap(err, errors.ErrInvalidImage, "invalid or corrupted HEIC file")
+		}
+		info.Images = append(info.Images, inspectImage(meta, data, handle, uint32(id)))
+	}
+
+	if exifData, err := extractExif(data, meta.PrimaryID); err == nil && exifData != nil {
+		info.Exif = exifFields(exifData)
+	}
+	return info, nil
+}
+
+// inspectImage describes a top-level image
+func inspectImage(meta *heifMeta, data []byte, handle *heif.ImageHandle, id uint32) ImageInfo {
+	img := ImageInfo{
+		ID:       id,
+		Primary:  id == meta.PrimaryID,
+		Type:     meta.ItemTypes[id],
+		Width:    handle.GetWidth(),
+		Height:   handle.GetHeight(),
+		BitDepth: imageBitDepth(data, id),
+		Alpha:    handle.HasAlphaChannel(),
+		Depth:    handle.HasDepthImage(),
+		Grid:     gridLayout(meta, id),
+	}
+
+	if color, err := extractColorInfo(data, id); err == nil && color != nil {
+		img.Color = describeColor(color)
+	}
+
+	for _, thumbID := range handle.GetListOfThumbnailIDs() {
+		thumb, err := handle.GetThumbnail(thumbID)
+		if err != nil {
+			continue
+		}
+		img.Thumbnails = append(img.Thumbnails, ThumbnailInfo{
+			ID:     uint32(thumbID),
+			Width:  thumb.GetWidth(),
+			Height: thumb.GetHeight(),
+		})
+	}
+
+	if aux, err := findAuxiliaryImages(data, id); err == nil {
+		for _, a := range aux {
+			img.Auxiliary = append(img.Auxiliary, AuxiliaryInfo{ID: a.ID, Name: a.Name(), Type: a.URN})
+		}
+	}
+	return img
+}
+
+// gridLayout returns the tile layout of a grid image, or nil if the item is
+// not a grid
+func gridLayout(meta *heifMeta, id uint32) *GridInfo {
+	if meta.ItemTypes[id] != "grid" {
+		return nil
+	}
+	payload, err := meta.itemData(id)
+	if err != nil {
+		return nil
+	}
+
+	r := newBoxReader(payload)
+	r.uint8() // version
+	r.uint8() // flags
+	grid := &GridInfo{Rows: int(r.uint8()) + 1, Columns: int(r.uint8()) + 1}
+	if r.err != nil {
+		return nil
+	}
+
+	for _, ref := range meta.References {
+		if ref.Type == "dimg" && ref.From == id && len(ref.To) > 0 {
+			grid.TileWidth, grid.TileHeight, _ = itemSize(meta, ref.To[0])
+			break
+		}
+	}
+	return grid
+}
+
+// itemSize returns the coded size of an item from its 'ispe' property
+func itemSize(meta *heifMeta, id uint32) (width, height int, ok bool) {
+	ispe := findBox(meta.itemProperties(id), "ispe")
+	if ispe == nil {
+		return 0, 0, false
+	}
+	r := newBoxReader(ispe.Payload)
+	r.fullBoxHeader()
+	width, height = int(r.uint32()), int(r.uint32())
+	return width, height, r.err == nil
+}
+
+// Names of common 'nclx' code points, following ITU-T H.273
+var (
+	nclxPrimaryNames = map[uint16]string{
+		1: "BT.709", 5: "BT.601 625", 6: "BT.601 525", 9: "BT.2020", 11: "DCI-P3", 12: "Display P3",
+	}
+	nclxTransferNames = map[uint16]string{
+		1: "BT.709", 4: "gamma 2.2", 6: "BT.601", 8: "linear", 13: "sRGB",
+		14: "BT.2020 10-bit", 15: "BT.2020 12-bit", 16: "PQ", 18: "HLG",
+	}
+	nclxMatrixNames = map[uint16]string{
+		0: "RGB", 1: "BT.709", 5: "BT.601", 6: "BT.601", 9: "BT.2020",
+	}
+)
+
+// nclxName returns the name of a code point, or its number if it is unknown
+func nclxName(names map[uint16]string, value uint16) string {
+	if name, ok := names[value]; ok {
+		return name
+	}
+	return fmt.Sprintf("%d", value)
+}
+
+// describeColor summarizes a declared color space
+func describeColor(ci *colorInfo) *ColorInfo {
+	info := &ColorInfo{HDR: ci.isHDR()}
+	var parts []string
+
+	if nclx := ci.NCLX; nclx != nil {
+		info.ColorPrimaries = int(nclx.ColorPrimaries)
+		info.TransferCharacteristics = int(nclx.TransferCharacteristics)
+		info.MatrixCoefficients = int(nclx.MatrixCoefficients)
+		info.FullRange = nclx.FullRange
+
+		rng := "limited range"
+		if nclx.FullRange {
+			rng = "full range"
+		}
+		parts = append(parts, fmt.Sprintf("%s primaries, %s transfer, %s matrix, %s",
+			nclxName(nclxPrimaryNames, nclx.ColorPrimaries),
+			nclxName(nclxTransferNames, nclx.TransferCharacteristics),
+			nclxName(nclxMatrixNames, nclx.MatrixCoefficients), rng))
+	}
+
+	if ci.ICCProfile != nil {
+		info.ICCProfileSize = len(ci.ICCProfile)
+		info.ICCProfileName = iccDescription(ci.ICCProfile)
+		if info.ICCProfileName != "" {
+			parts = append(parts, fmt.Sprintf("ICC profile %q", info.ICCProfileName))
+		} else {
+			parts = append(parts, "ICC profile")
+		}
+	}
+
+	info.Description = strings.Join(parts, "; ")
+	return info
+}
+
+// iccDescription returns the profile description of an ICC profile from its
+// 'desc' tag, which is a textDescriptionType in version 2 profiles and a
+// multiLocalizedUnicodeType in version 4
+func iccDescription(profile []byte) string {
+	tags, err := iccTags(profile)
+	if err != nil {
+		return ""
+	}
+	tag := tags["desc"]
+	if len(tag) < 12 {
+		return ""
+	}
+
+	switch string(tag[:4]) {
+	case "desc":
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+		if n > len(tag)-12 {
+			return ""
+		}
+		return strings.TrimRight(string(tag[12:12+n]), "\x00")
+	case "mluc":
+		// Use the first record
+		if len(tag) < 28 || binary.BigEndian.Uint32(tag[8:]) == 0 {
+			return ""
+		}
+		length := int(binary.BigEndian.Uint32(tag[20:]))
+		offset := int(binary.BigEndian.Uint32(tag[24:]))
+		if offset > len(tag) || length > len(tag)-offset {
+			return ""
+		}
+		units := make([]uint16, length/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(tag[offset+2*i:])
+		}
+		return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+	}
+	return ""
+}
+
+// exifFields decodes the fields of an EXIF block into readable strings,
+// leaving out the maker note and other binary blobs
+func exifFields(exifData []byte) map[string]string {
+	x, err := exif.Decode(bytes.NewReader(exifData))
+	if err != nil {
+		return nil
+	}
+
+	fields := make(map[string]string)
+	x.Walk(exifWalker(func(name exif.FieldName, tag *tiff.Tag) {
+		switch {
+		case name == exif.MakerNote:
+		case tag.Format() == tiff.StringVal:
+			if s, err := tag.StringVal(); err == nil {
+				fields[string(name)] = strings.TrimSpace(strings.TrimRight(s, "\x00"))
+			}
+		case tag.Format() == tiff.UndefVal && len(tag.Val) > 16:
+		default:
+			fields[string(name)] = strings.Trim(tag.String(), `"`)
+		}
+	}))
+
+	if lat, long, err := x.LatLong(); err == nil {
+		fields["GPSPosition"] = fmt.Sprintf("%.6f, %.6f", lat, long)
+	}
+	return fields
+}
+
+// exifWalker adapts a function to the exif.Walker interface
+type exifWalker func(name exif.FieldName, tag *tiff.Tag)
+
+// Walk implements exif.Walker
+func (w exifWalker) Walk(name exif.FieldName, tag *tiff.Tag) error {
+	w(name, tag)
+	return nil
+}
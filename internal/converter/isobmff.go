@@ -0,0 +1,621 @@
+package converter
+
+import (
+	"encoding/binary"
+	stderrors "errors"
+	"fmt"
+)
+
+// box represents a single ISOBMFF box
+type box struct {
+	// Four-character box type, e.g. "ftyp" or "meta"
+	Type string
+	// Offset of the box header from the start of the parsed buffer
+	Offset int
+	// Header with the size and type, sharing memory with the parsed buffer
+	Header []byte
+	// Payload following the box header
+	Payload []byte
+}
+
+// parseBoxes splits a buffer into the sequence of boxes it contains
+func parseBoxes(data []byte) ([]box, error) {
+	var boxes []box
+	offset := 0
+	for offset < len(data) {
+		if len(data)-offset < 8 {
+			return boxes, fmt.Errorf("truncated box header at offset %d", offset)
+		}
+
+		size := uint64(binary.BigEndian.Uint32(data[offset:]))
+		boxType := string(data[offset+4 : offset+8])
+		headerSize := uint64(8)
+
+		switch size {
+		case 0:
+			// Box extends to the end of the buffer
+			size = uint64(len(data) - offset)
+		case 1:
+			// 64-bit largesize follows the type
+			if len(data)-offset < 16 {
+				return boxes, fmt.Errorf("truncated '%s' box header at offset %d", boxType, offset)
+			}
+			size = binary.BigEndian.Uint64(data[offset+8:])
+			headerSize = 16
+		}
+
+		if size < headerSize || size > uint64(len(data)-offset) {
+			return boxes, fmt.Errorf("invalid size %d for '%s' box at offset %d", size, boxType, offset)
+		}
+
+		boxes = append(boxes, box{
+			Type:    boxType,
+			Offset:  offset,
+			Header:  data[offset : offset+int(headerSize)],
+			Payload: data[offset+int(headerSize) : offset+int(size)],
+		})
+		offset += int(size)
+	}
+
+	return boxes, nil
+}
+
+// findBox returns the first box of the given type, or nil if there is none
+func findBox(boxes []box, boxType string) *box {
+	for i := range boxes {
+		if boxes[i].Type == boxType {
+			return &boxes[i]
+		}
+	}
+	return nil
+}
+
+// boxReader reads big-endian fields from a box payload, remembering the first
+// out-of-bounds read so callers can check for truncation once at the end
+type boxReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+// newBoxReader creates a reader over a box payload
+func newBoxReader(data []byte) *boxReader {
+	return &boxReader{data: data}
+}
+
+// next returns the next n bytes of the payload
+func (r *boxReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.data)-r.pos < n {
+		r.err = stderrors.New("unexpected end of box data")
+		return nil
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *boxReader) uint8() uint8 {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *boxReader) uint16() uint16 {
+	if b := r.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *boxReader) uint32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *boxReader) uint64() uint64 {
+	if b := r.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+// uintN reads an unsigned integer of 0, 4 or 8 bytes as used by 'iloc'
+func (r *boxReader) uintN(size int) uint64 {
+	switch size {
+	case 0:
+		return 0
+	case 4:
+		return uint64(r.uint32())
+	case 8:
+		return r.uint64()
+	default:
+		if r.err == nil {
+			r.err = fmt.Errorf("unsupported field size %d", size)
+		}
+		return 0
+	}
+}
+
+// fourCC reads a four-character code
+func (r *boxReader) fourCC() string {
+	return string(r.next(4))
+}
+
+// fullBoxHeader reads the version and flags of a FullBox
+func (r *boxReader) fullBoxHeader() (version uint8, flags uint32) {
+	v := r.uint32()
+	return uint8(v >> 24), v & 0x00ffffff
+}
+
+// cString reads a NUL-terminated string
+func (r *boxReader) cString() string {
+	if r.err != nil {
+		return ""
+	}
+	for i := r.pos; i < len(r.data); i++ {
+		if r.data[i] == 0 {
+			s := string(r.data[r.pos:i])
+			r.pos = i + 1
+			return s
+		}
+	}
+	// Tolerate a missing terminator at the end of the box
+	s := string(r.data[r.pos:])
+	r.pos = len(r.data)
+	return s
+}
+
+// rest returns the unread remainder of the payload
+func (r *boxReader) rest() []byte {
+	if r.err != nil {
+		return nil
+	}
+	b := r.data[r.pos:]
+	r.pos = len(r.data)
+	return b
+}
+
+// fileType holds the brands of a HEIF file from its 'ftyp' box
+type fileType struct {
+	MajorBrand       string
+	MinorVersion     uint32
+	CompatibleBrands []string
+}
+
+// parseFileType parses the payload of an 'ftyp' box
+func parseFileType(payload []byte) (fileType, error) {
+	var ft fileType
+	if len(payload) < 8 || len(payload)%4 != 0 {
+		return ft, fmt.Errorf("invalid 'ftyp' box size %d", len(payload)+8)
+	}
+
+	r := newBoxReader(payload)
+	ft.MajorBrand = r.fourCC()
+	ft.MinorVersion = r.uint32()
+	for r.pos < len(r.data) {
+		ft.CompatibleBrands = append(ft.CompatibleBrands, r.fourCC())
+	}
+	return ft, r.err
+}
+
+// hasBrand reports whether the major or a compatible brand is the given one
+func (ft fileType) hasBrand(brand string) bool {
+	if ft.MajorBrand == brand {
+		return true
+	}
+	for _, b := range ft.CompatibleBrands {
+		if b == brand {
+			return true
+		}
+	}
+	return false
+}
+
+// itemExtent is a single contiguous piece of an item's data
+type itemExtent struct {
+	Offset uint64
+	Length uint64
+}
+
+// itemLocation describes where an item's data is stored
+type itemLocation struct {
+	ConstructionMethod uint8
+	BaseOffset         uint64
+	Extents            []itemExtent
+}
+
+// itemReference is a typed reference from one item to others
+type itemReference struct {
+	Type string
+	From uint32
+	To   []uint32
+
+	// Header of the reference box in the parsed buffer
+	header []byte
+}
+
+// heifMeta holds the parts of the 'meta' box needed to locate items
+type heifMeta struct {
+	// ID of the primary item from 'pitm'
+	PrimaryID uint32
+	// Item types keyed by item ID from 'iinf'
+	ItemTypes map[uint32]string
+	// Item IDs in the order they are declared in 'iinf'
+	ItemIDs []uint32
+	// Item locations keyed by item ID from 'iloc'
+	Locations map[uint32]itemLocation
+	// Item references from 'iref'
+	References []itemReference
+	// Item properties from 'ipco', in declaration order
+	Properties []box
+	// Indices into Properties keyed by item ID from 'ipma'
+	Associations map[uint32][]int
+	// Payload of the 'idat' box, if present
+	ItemData []byte
+
+	// The complete file, used to resolve file-offset item locations
+	file []byte
+}
+
+// parseHEIFMeta parses the top-level 'meta' box of a HEIF file
+func parseHEIFMeta(data []byte) (*heifMeta, error) {
+	boxes, err := parseBoxes(data)
+	if err != nil && len(boxes) == 0 {
+		return nil, err
+	}
+
+	metaBox := findBox(boxes, "meta")
+	if metaBox == nil {
+		return nil, stderrors.New("missing 'meta' box")
+	}
+
+	children, err := metaChildren(metaBox.Payload)
+	if err != nil {
+		return nil, err
+	}
+	return parseMetaChildren(children, data)
+}
+
+// metaChildren splits the payload of a 'meta' box into its child boxes
+func metaChildren(payload []byte) ([]box, error) {
+	r := newBoxReader(payload)
+	r.fullBoxHeader()
+	if r.err != nil {
+		return nil, fmt.Errorf("invalid 'meta' box: %w", r.err)
+	}
+	children, err := parseBoxes(r.rest())
+	if err != nil {
+		return nil, fmt.Errorf("invalid 'meta' box: %w", err)
+	}
+	return children, nil
+}
+
+// parseMetaChildren parses the children of a 'meta' box. The file is used to
+// resolve file-offset item locations and may be nil if no item data is read.
+func parseMetaChildren(children []box, file []byte) (*heifMeta, error) {
+	meta := &heifMeta{
+		ItemTypes:    make(map[uint32]string),
+		Locations:    make(map[uint32]itemLocation),
+		Associations: make(map[uint32][]int),
+		file:         file,
+	}
+
+	var err error
+	for _, child := range children {
+		switch child.Type {
+		case "pitm":
+			err = meta.parsePrimaryItem(child.Payload)
+		case "iinf":
+			err = meta.parseItemInfo(child.Payload)
+		case "iloc":
+			err = meta.parseItemLocations(child.Payload)
+		case "iref":
+			err = meta.parseItemReferences(child.Payload)
+		case "iprp":
+			err = meta.parseItemProperties(child.Payload)
+		case "idat":
+			meta.ItemData = child.Payload
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid '%s' box: %w", child.Type, err)
+		}
+	}
+
+	return meta, nil
+}
+
+// parsePrimaryItem parses the 'pitm' box
+func (m *heifMeta) parsePrimaryItem(payload []byte) error {
+	r := newBoxReader(payload)
+	version, _ := r.fullBoxHeader()
+	if version == 0 {
+		m.PrimaryID = uint32(r.uint16())
+	} else {
+		m.PrimaryID = r.uint32()
+	}
+	return r.err
+}
+
+// parseItemInfo parses the 'iinf' box and its 'infe' entries
+func (m *heifMeta) parseItemInfo(payload []byte) error {
+	r := newBoxReader(payload)
+	version, _ := r.fullBoxHeader()
+	if version == 0 {
+		r.uint16()
+	} else {
+		r.uint32()
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	entries, err := parseBoxes(r.rest())
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.Type != "infe" {
+			continue
+		}
+
+		er := newBoxReader(entry.Payload)
+		version, _ := er.fullBoxHeader()
+		if version < 2 {
+			// Version 0 and 1 entries predate item types and never describe images
+			continue
+		}
+
+		var id uint32
+		if version == 2 {
+			id = uint32(er.uint16())
+		} else {
+			id = er.uint32()
+		}
+		er.uint16() // item_protection_index
+		itemType := er.fourCC()
+		if er.err != nil {
+			return fmt.Errorf("invalid 'infe' entry: %w", er.err)
+		}
+
+		if _, seen := m.ItemTypes[id]; !seen {
+			m.ItemIDs = append(m.ItemIDs, id)
+		}
+		m.ItemTypes[id] = itemType
+	}
+
+	return nil
+}
+
+// parseItemLocations parses the 'iloc' box
+func (m *heifMeta) parseItemLocations(payload []byte) error {
+	r := newBoxReader(payload)
+	version, _ := r.fullBoxHeader()
+	if version > 2 {
+		return fmt.Errorf("unsupported version %d", version)
+	}
+
+	sizes := r.uint16()
+	offsetSize := int(sizes >> 12)
+	lengthSize := int(sizes>>8) & 0xf
+	baseOffsetSize := int(sizes>>4) & 0xf
+	indexSize := 0
+	if version == 1 || version == 2 {
+		indexSize = int(sizes) & 0xf
+	}
+
+	var itemCount uint32
+	if version < 2 {
+		itemCount = uint32(r.uint16())
+	} else {
+		itemCount = r.uint32()
+	}
+
+	for i := uint32(0); i < itemCount && r.err == nil; i++ {
+		var id uint32
+		if version < 2 {
+			id = uint32(r.uint16())
+		} else {
+			id = r.uint32()
+		}
+
+		var loc itemLocation
+		if version == 1 || version == 2 {
+			loc.ConstructionMethod = uint8(r.uint16() & 0xf)
+		}
+		r.uint16() // data_reference_index
+		loc.BaseOffset = r.uintN(baseOffsetSize)
+
+		extentCount := int(r.uint16())
+		for e := 0; e < extentCount && r.err == nil; e++ {
+			if indexSize > 0 {
+				r.uintN(indexSize)
+			}
+			loc.Extents = append(loc.Extents, itemExtent{
+				Offset: r.uintN(offsetSize),
+				Length: r.uintN(lengthSize),
+			})
+		}
+
+		m.Locations[id] = loc
+	}
+
+	return r.err
+}
+
+// parseItemReferences parses the 'iref' box
+func (m *heifMeta) parseItemReferences(payload []byte) error {
+	r := newBoxReader(payload)
+	version, _ := r.fullBoxHeader()
+	if r.err != nil {
+		return r.err
+	}
+
+	refs, err := parseBoxes(r.rest())
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range refs {
+		rr := newBoxReader(ref.Payload)
+		readID := func() uint32 {
+			if version == 0 {
+				return uint32(rr.uint16())
+			}
+			return rr.uint32()
+		}
+
+		reference := itemReference{Type: ref.Type, From: readID(), header: ref.Header}
+		count := int(rr.uint16())
+		for i := 0; i < count && rr.err == nil; i++ {
+			reference.To = append(reference.To, readID())
+		}
+		if rr.err != nil {
+			return fmt.Errorf("invalid '%s' reference: %w", ref.Type, rr.err)
+		}
+
+		m.References = append(m.References, reference)
+	}
+
+	return nil
+}
+
+// parseItemProperties parses the 'iprp' box with its 'ipco' and 'ipma' children
+func (m *heifMeta) parseItemProperties(payload []byte) error {
+	children, err := parseBoxes(payload)
+	if err != nil {
+		return err
+	}
+
+	if ipco := findBox(children, "ipco"); ipco != nil {
+		m.Properties, err = parseBoxes(ipco.Payload)
+		if err != nil {
+			return fmt.Errorf("invalid 'ipco' box: %w", err)
+		}
+	}
+
+	for _, child := range children {
+		if child.Type != "ipma" {
+			continue
+		}
+		if err := m.parsePropertyAssociations(child.Payload); err != nil {
+			return fmt.Errorf("invalid 'ipma' box: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// parsePropertyAssociations parses an 'ipma' box
+func (m *heifMeta) parsePropertyAssociations(payload []byte) error {
+	r := newBoxReader(payload)
+	version, flags := r.fullBoxHeader()
+
+	entryCount := r.uint32()
+	for i := uint32(0); i < entryCount && r.err == nil; i++ {
+		var id uint32
+		if version < 1 {
+			id = uint32(r.uint16())
+		} else {
+			id = r.uint32()
+		}
+
+		count := int(r.uint8())
+		for a := 0; a < count && r.err == nil; a++ {
+			// The top bit of each association is the 'essential' flag
+			var index int
+			if flags&1 != 0 {
+				index = int(r.uint16() & 0x7fff)
+			} else {
+				index = int(r.uint8() & 0x7f)
+			}
+
+			// Index 0 means no property; the rest are 1-based
+			if index > 0 {
+				m.Associations[id] = append(m.Associations[id], index-1)
+			}
+		}
+	}
+
+	return r.err
+}
+
+// itemProperties returns the properties associated with an item
+func (m *heifMeta) itemProperties(id uint32) []box {
+	var props []box
+	for _, index := range m.Associations[id] {
+		if index < len(m.Properties) {
+			props = append(props, m.Properties[index])
+		}
+	}
+	return props
+}
+
+// itemsOfType returns the IDs of all items with the given type
+func (m *heifMeta) itemsOfType(itemType string) []uint32 {
+	var ids []uint32
+	for _, id := range m.ItemIDs {
+		if m.ItemTypes[id] == itemType {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// referencesTo reports whether item from has a reference of the given type to item to
+func (m *heifMeta) referencesTo(refType string, from, to uint32) bool {
+	for _, ref := range m.References {
+		if ref.Type != refType || ref.From != from {
+			continue
+		}
+		for _, id := range ref.To {
+			if id == to {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// itemData returns the concatenated extents of an item
+func (m *heifMeta) itemData(id uint32) ([]byte, error) {
+	loc, ok := m.Locations[id]
+	if !ok {
+		return nil, fmt.Errorf("no location for item %d", id)
+	}
+
+	var source []byte
+	switch loc.ConstructionMethod {
+	case 0:
+		source = m.file
+	case 1:
+		source = m.ItemData
+	default:
+		return nil, fmt.Errorf("unsupported construction method %d for item %d", loc.ConstructionMethod, id)
+	}
+
+	var data []byte
+	for _, extent := range loc.Extents {
+		start := loc.BaseOffset + extent.Offset
+		length := extent.Length
+		if length == 0 {
+			// A zero length means the extent runs to the end of the source
+			if start > uint64(len(source)) {
+				return nil, fmt.Errorf("extent of item %d is out of range", id)
+			}
+			length = uint64(len(source)) - start
+		}
+		if start > uint64(len(source)) || length > uint64(len(source))-start {
+			return nil, fmt.Errorf("extent of item %d is out of range", id)
+		}
+		data = append(data, source[start:start+length]...)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,853 @@
+package converter
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"math/bits"
+
+	"github.com/disintegration/imaging"
+)
+
+// JPEG marker codes
+const (
+	jpegSOI  = 0xd8
+	jpegEOI  = 0xd9
+	jpegSOF0 = 0xc0
+	jpegSOF2 = 0xc2
+	jpegDHT  = 0xc4
+	jpegDQT  = 0xdb
+	jpegSOS  = 0xda
+
+	jpegMaxDimension = 65535
+	jpegBlockSize    = 64
+	jpegMaxEOBRun    = 0x7fff
+)
+
+// jpegUnzig maps the zig-zag coefficient order to the natural order
+var jpegUnzig = [jpegBlockSize]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// jpegBaseQuant holds the luminance and chrominance quantization tables of
+// the JPEG specification (Annex K.1) in zig-zag order
+var jpegBaseQuant = [2][jpegBlockSize]byte{
+	{
+		16, 11, 12, 14, 12, 10, 16, 14,
+		13, 14, 18, 17, 16, 19, 24, 40,
+		26, 24, 22, 22, 24, 49, 35, 37,
+		29, 40, 58, 51, 61, 60, 57, 51,
+		56, 55, 64, 72, 92, 78, 64, 68,
+		87, 69, 55, 56, 80, 109, 81, 87,
+		95, 98, 103, 104, 103, 62, 77, 113,
+		121, 112, 100, 120, 92, 101, 103, 99,
+	},
+	{
+		17, 18, 18, 24, 21, 24, 47, 26,
+		26, 47, 99, 66, 56, 66, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	},
+}
+
+// jpegHuffmanSpec is a Huffman table as stored in a DHT segment
+type jpegHuffmanSpec struct {
+	counts [16]byte
+	values []byte
+}
+
+// Huffman table slots, indexed by class and destination
+const (
+	jpegTableLumaDC = iota
+	jpegTableChromaDC
+	jpegTableLumaAC
+	jpegTableChromaAC
+	jpegNumTables
+)
+
+// jpegStandardHuffman holds the typical Huffman tables of the JPEG
+// specification (Annex K.3), indexed by table slot
+var jpegStandardHuffman = [jpegNumTables]jpegHuffmanSpec{
+	{
+		counts: [16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		values: []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		counts: [16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+		values: []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		counts: [16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+		values: []byte{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12,
+			0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+			0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16,
+			0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
+			0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+			0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79,
+			0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98,
+			0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+			0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4,
+			0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea,
+			0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+	{
+		counts: [16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+		values: []byte{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21,
+			0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91,
+			0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34,
+			0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38,
+			0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58,
+			0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78,
+			0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96,
+			0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4,
+			0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2,
+			0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9,
+			0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+}
+
+// jpegDCTCos holds the scaled DCT basis, jpegDCTCos[x][u] = C(u)/2 cos((2x+1)uπ/16)
+var jpegDCTCos = func() (table [8][8]float64) {
+	for x := 0; x < 8; x++ {
+		for u := 0; u < 8; u++ {
+			scale := 0.5
+			if u == 0 {
+				scale = 0.5 / math.Sqrt2
+			}
+			table[x][u] = scale * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16)
+		}
+	}
+	return table
+}()
+
+// jpegEncoder encodes JPEG files.
+//
+// The encoder supports 4:4:4, 4:2:2 and 4:2:0 chroma subsampling, baseline
+// and progressive (spectral selection) coding, and Huffman tables computed
+// for each image. Progressive files always use computed tables because the
+// standard ones cannot code end-of-band runs.
+type jpegEncoder struct {
+	quality         int
+	subsampling     ChromaSubsampling
+	progressive     bool
+	optimizeHuffman bool
+}
+
+// Encode implements ImageEncoder
+func (e *jpegEncoder) Encode(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 1 || height < 1 || width > jpegMaxDimension || height > jpegMaxDimension {
+		return fmt.Errorf("JPEG images are limited to %dx%d pixels, got %dx%d",
+			jpegMaxDimension, jpegMaxDimension, width, height)
+	}
+
+	frame := newJPEGFrame(img, e.subsampling, e.quality)
+	optimize := e.optimizeHuffman || e.progressive
+
+	bw := bufio.NewWriter(w)
+	jw := &jpegWriter{w: bw}
+	jw.writeMarker(jpegSOI, nil)
+	jw.writeQuantTables(frame)
+	if e.progressive {
+		jw.writeFrameHeader(jpegSOF2, frame)
+		for _, scan := range frame.progressiveScans() {
+			jw.writeScan(frame, scan, true)
+		}
+	} else {
+		jw.writeFrameHeader(jpegSOF0, frame)
+		jw.writeScan(frame, frame.baselineScan(), optimize)
+	}
+	jw.writeMarker(jpegEOI, nil)
+
+	if jw.err != nil {
+		return jw.err
+	}
+	return bw.Flush()
+}
+
+// EmbedMetadata implements MetadataEmbedder
+func (e *jpegEncoder) EmbedMetadata(encoded, exifData, iccProfile []byte) ([]byte, error) {
+	return insertJPEGMetadata(encoded, exifData, iccProfile)
+}
+
+// jpegComponent is a color component of a JPEG frame
+type jpegComponent struct {
+	// Sampling factors
+	h, v int
+	// Quantization table index, 0 for luma and 1 for chroma
+	quant int
+	// Blocks in the MCU-padded grid
+	blocksX, blocksY int
+	// Blocks covering the component, used by non-interleaved scans
+	coveredX, coveredY int
+	// Quantized coefficients in zig-zag order, 64 per block
+	coefs []int16
+}
+
+// block returns the coefficients of the block at the given grid position
+func (c *jpegComponent) block(bx, by int) []int16 {
+	offset := (by*c.blocksX + bx) * jpegBlockSize
+	return c.coefs[offset : offset+jpegBlockSize]
+}
+
+// jpegFrame holds the quantized coefficients of an image
+type jpegFrame struct {
+	width, height int
+	mcusX, mcusY  int
+	hmax, vmax    int
+	quant         [2][jpegBlockSize]byte
+	components    []*jpegComponent
+}
+
+// jpegScan describes the components and spectral band coded by a scan
+type jpegScan struct {
+	components []int
+	ss, se     int
+}
+
+// newJPEGFrame converts an image to quantized DCT coefficients
+func newJPEGFrame(img image.Image, subsampling ChromaSubsampling, quality int) *jpegFrame {
+	bounds := img.Bounds()
+	f := &jpegFrame{width: bounds.Dx(), height: bounds.Dy(), hmax: 1, vmax: 1}
+	for i := range f.quant {
+		f.quant[i] = scaleQuantTable(jpegBaseQuant[i], quality)
+	}
+
+	gray := isGrayImage(img)
+	if !gray {
+		f.hmax, f.vmax = subsampling.factors()
+	}
+	f.mcusX = (f.width + 8*f.hmax - 1) / (8 * f.hmax)
+	f.mcusY = (f.height + 8*f.vmax - 1) / (8 * f.vmax)
+
+	planes := f.readPlanes(img, gray)
+	for i, plane := range planes {
+		comp := &jpegComponent{h: 1, v: 1}
+		if i == 0 {
+			comp.h, comp.v = f.hmax, f.vmax
+		} else {
+			comp.quant = 1
+		}
+		comp.blocksX, comp.blocksY = f.mcusX*comp.h, f.mcusY*comp.v
+		compWidth := (f.width*comp.h + f.hmax - 1) / f.hmax
+		compHeight := (f.height*comp.v + f.vmax - 1) / f.vmax
+		comp.coveredX, comp.coveredY = (compWidth+7)/8, (compHeight+7)/8
+
+		if comp.h != f.hmax || comp.v != f.vmax {
+			plane = downsamplePlane(plane, f.mcusX*8*f.hmax, f.mcusY*8*f.vmax, f.hmax/comp.h, f.vmax/comp.v)
+		}
+		comp.coefs = quantizePlane(plane, comp.blocksX, comp.blocksY, &f.quant[comp.quant])
+		f.components = append(f.components, comp)
+	}
+	return f
+}
+
+// isGrayImage reports whether an image has a single gray channel
+func isGrayImage(img image.Image) bool {
+	switch img.(type) {
+	case *image.Gray, *image.Gray16:
+		return true
+	}
+	return false
+}
+
+// readPlanes converts an image to Y, Cb and Cr planes (or a single Y plane for
+// gray images) covering the MCU grid, replicating the edge pixels into the padding
+func (f *jpegFrame) readPlanes(img image.Image, gray bool) [][]uint8 {
+	stride := f.mcusX * 8 * f.hmax
+	rows := f.mcusY * 8 * f.vmax
+	count := 3
+	if gray {
+		count = 1
+	}
+	planes := make([][]uint8, count)
+	for i := range planes {
+		planes[i] = make([]uint8, stride*rows)
+	}
+
+	bounds := img.Bounds()
+	switch src := img.(type) {
+	case *image.YCbCr:
+		for y := 0; y < f.height; y++ {
+			for x := 0; x < f.width; x++ {
+				sx, sy := bounds.Min.X+x, bounds.Min.Y+y
+				offset := y*stride + x
+				planes[0][offset] = src.Y[src.YOffset(sx, sy)]
+				ci := src.COffset(sx, sy)
+				planes[1][offset] = src.Cb[ci]
+				planes[2][offset] = src.Cr[ci]
+			}
+		}
+	case *image.Gray:
+		for y := 0; y < f.height; y++ {
+			copy(planes[0][y*stride:], src.Pix[y*src.Stride:y*src.Stride+f.width])
+		}
+	case *image.Gray16:
+		for y := 0; y < f.height; y++ {
+			for x := 0; x < f.width; x++ {
+				planes[0][y*stride+x] = src.Pix[y*src.Stride+x*2]
+			}
+		}
+	default:
+		// Alpha is dropped, the color channels are kept as they are
+		nrgba := imaging.Clone(img)
+		for y := 0; y < f.height; y++ {
+			for x := 0; x < f.width; x++ {
+				i := y*nrgba.Stride + x*4
+				yy, cb, cr := color.RGBToYCbCr(nrgba.Pix[i], nrgba.Pix[i+1], nrgba.Pix[i+2])
+				offset := y*stride + x
+				planes[0][offset], planes[1][offset], planes[2][offset] = yy, cb, cr
+			}
+		}
+	}
+
+	for _, plane := range planes {
+		for y := 0; y < rows; y++ {
+			row := plane[y*stride : (y+1)*stride]
+			if y >= f.height {
+				copy(row, plane[(f.height-1)*stride:f.height*stride])
+				continue
+			}
+			for x := f.width; x < stride; x++ {
+				row[x] = row[f.width-1]
+			}
+		}
+	}
+	return planes
+}
+
+// downsamplePlane averages blocks of sx by sy samples
+func downsamplePlane(plane []uint8, width, height, sx, sy int) []uint8 {
+	outWidth, outHeight := width/sx, height/sy
+	out := make([]uint8, outWidth*outHeight)
+	area := sx * sy
+	for y := 0; y < outHeight; y++ {
+		for x := 0; x < outWidth; x++ {
+			sum := 0
+			for dy := 0; dy < sy; dy++ {
+				row := (y*sy + dy) * width
+				for dx := 0; dx < sx; dx++ {
+					sum += int(plane[row+x*sx+dx])
+				}
+			}
+			out[y*outWidth+x] = uint8((sum + area/2) / area)
+		}
+	}
+	return out
+}
+
+// quantizePlane applies the forward DCT to each block of a plane and
+// quantizes the coefficients
+func quantizePlane(plane []uint8, blocksX, blocksY int, quant *[jpegBlockSize]byte) []int16 {
+	stride := blocksX * 8
+	coefs := make([]int16, blocksX*blocksY*jpegBlockSize)
+	var samples, rows [jpegBlockSize]float64
+
+	for by := 0; by < blocksY; by++ {
+		for bx := 0; bx < blocksX; bx++ {
+			for y := 0; y < 8; y++ {
+				row := (by*8+y)*stride + bx*8
+				for x := 0; x < 8; x++ {
+					samples[y*8+x] = float64(plane[row+x]) - 128
+				}
+			}
+
+			// Separable 2D DCT, rows first then columns
+			for y := 0; y < 8; y++ {
+				for u := 0; u < 8; u++ {
+					sum := 0.0
+					for x := 0; x < 8; x++ {
+						sum += samples[y*8+x] * jpegDCTCos[x][u]
+					}
+					rows[y*8+u] = sum
+				}
+			}
+
+			block := coefs[(by*blocksX+bx)*jpegBlockSize:]
+			for k := 0; k < jpegBlockSize; k++ {
+				natural := jpegUnzig[k]
+				u, v := natural%8, natural/8
+				sum := 0.0
+				for y := 0; y < 8; y++ {
+					sum += rows[y*8+u] * jpegDCTCos[y][v]
+				}
+				block[k] = int16(math.Round(sum / float64(quant[k])))
+			}
+		}
+	}
+	return coefs
+}
+
+// scaleQuantTable scales a base quantization table to a quality from 1 to
+// 100, following the IJG convention
+func scaleQuantTable(base [jpegBlockSize]byte, quality int) [jpegBlockSize]byte {
+	if quality < 1 {
+		quality = 1
+	} else if quality > 100 {
+		quality = 100
+	}
+	scale := 200 - quality*2
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+
+	var table [jpegBlockSize]byte
+	for i, q := range base {
+		value := (int(q)*scale + 50) / 100
+		if value < 1 {
+			value = 1
+		} else if value > 255 {
+			value = 255
+		}
+		table[i] = byte(value)
+	}
+	return table
+}
+
+// baselineScan returns the single interleaved scan of a baseline frame
+func (f *jpegFrame) baselineScan() jpegScan {
+	scan := jpegScan{ss: 0, se: 63}
+	for i := range f.components {
+		scan.components = append(scan.components, i)
+	}
+	return scan
+}
+
+// progressiveScans returns the scan script of a progressive frame: the DC
+// coefficients of all components first, then the low luma frequencies, the
+// chroma and finally the remaining luma frequencies
+func (f *jpegFrame) progressiveScans() []jpegScan {
+	dc := jpegScan{ss: 0, se: 0}
+	for i := range f.components {
+		dc.components = append(dc.components, i)
+	}
+
+	scans := []jpegScan{dc, {components: []int{0}, ss: 1, se: 5}}
+	for i := 1; i < len(f.components); i++ {
+		scans = append(scans, jpegScan{components: []int{i}, ss: 1, se: 63})
+	}
+	return append(scans, jpegScan{components: []int{0}, ss: 6, se: 63})
+}
+
+// jpegSymbolSink receives the Huffman symbols and extra bits of a scan
+type jpegSymbolSink interface {
+	symbol(table int, value byte)
+	bits(value uint32, count int)
+}
+
+// encodeScan codes the blocks of a scan into a symbol sink
+func (f *jpegFrame) encodeScan(scan jpegScan, sink jpegSymbolSink) {
+	predictors := make([]int, len(f.components))
+	eobRun := 0
+
+	flushEOBRun := func(table int) {
+		if eobRun == 0 {
+			return
+		}
+		n := bits.Len(uint(eobRun)) - 1
+		sink.symbol(table, byte(n<<4))
+		if n > 0 {
+			sink.bits(uint32(eobRun)&(1<<n-1), n)
+		}
+		eobRun = 0
+	}
+
+	encodeBlock := func(ci int, block []int16) {
+		dcTable, acTable := jpegTableLumaDC, jpegTableLumaAC
+		if ci > 0 {
+			dcTable, acTable = jpegTableChromaDC, jpegTableChromaAC
+		}
+
+		if scan.ss == 0 {
+			diff := int(block[0]) - predictors[ci]
+			predictors[ci] = int(block[0])
+			size, value := jpegMagnitude(diff)
+			sink.symbol(dcTable, byte(size))
+			if size > 0 {
+				sink.bits(value, size)
+			}
+		}
+
+		start := scan.ss
+		if start == 0 {
+			start = 1
+		}
+		if start > scan.se {
+			return
+		}
+
+		run := 0
+		for k := start; k <= scan.se; k++ {
+			if block[k] == 0 {
+				run++
+				continue
+			}
+			flushEOBRun(acTable)
+			for run > 15 {
+				sink.symbol(acTable, 0xf0)
+				run -= 16
+			}
+			size, value := jpegMagnitude(int(block[k]))
+			sink.symbol(acTable, byte(run<<4|size))
+			sink.bits(value, size)
+			run = 0
+		}
+		if run > 0 {
+			if scan.ss == 0 {
+				// Sequential scans end every block explicitly
+				sink.symbol(acTable, 0x00)
+				return
+			}
+			eobRun++
+			if eobRun == jpegMaxEOBRun {
+				flushEOBRun(acTable)
+			}
+		}
+	}
+
+	if len(scan.components) == 1 {
+		ci := scan.components[0]
+		comp := f.components[ci]
+		for by := 0; by < comp.coveredY; by++ {
+			for bx := 0; bx < comp.coveredX; bx++ {
+				encodeBlock(ci, comp.block(bx, by))
+			}
+		}
+	} else {
+		for my := 0; my < f.mcusY; my++ {
+			for mx := 0; mx < f.mcusX; mx++ {
+				for _, ci := range scan.components {
+					comp := f.components[ci]
+					for v := 0; v < comp.v; v++ {
+						for h := 0; h < comp.h; h++ {
+							encodeBlock(ci, comp.block(mx*comp.h+h, my*comp.v+v))
+						}
+					}
+				}
+			}
+		}
+	}
+
+	acTable := jpegTableLumaAC
+	if scan.components[0] > 0 {
+		acTable = jpegTableChromaAC
+	}
+	flushEOBRun(acTable)
+}
+
+// jpegMagnitude returns the size category of a coefficient and its extra bits
+func jpegMagnitude(value int) (int, uint32) {
+	if value == 0 {
+		return 0, 0
+	}
+	magnitude := value
+	if value < 0 {
+		magnitude = -value
+		value--
+	}
+	size := bits.Len(uint(magnitude))
+	return size, uint32(value) & (1<<size - 1)
+}
+
+// scanTables returns the Huffman table slots used by a scan
+func (f *jpegFrame) scanTables(scan jpegScan) []int {
+	var tables []int
+	used := [jpegNumTables]bool{}
+	for _, ci := range scan.components {
+		dc, ac := jpegTableLumaDC, jpegTableLumaAC
+		if ci > 0 {
+			dc, ac = jpegTableChromaDC, jpegTableChromaAC
+		}
+		if scan.ss == 0 && !used[dc] {
+			used[dc] = true
+			tables = append(tables, dc)
+		}
+		if scan.se > 0 && !used[ac] {
+			used[ac] = true
+			tables = append(tables, ac)
+		}
+	}
+	return tables
+}
+
+// jpegFrequencies counts the Huffman symbols of a scan
+type jpegFrequencies [jpegNumTables][257]int
+
+func (c *jpegFrequencies) symbol(table int, value byte) {
+	c[table][value]++
+}
+
+func (c *jpegFrequencies) bits(uint32, int) {}
+
+// buildJPEGHuffman computes a length-limited Huffman table from symbol
+// frequencies, following Annex K.2 of the JPEG specification
+func buildJPEGHuffman(freq [257]int) jpegHuffmanSpec {
+	// Symbol 256 reserves the all-ones code, which JPEG forbids
+	freq[256] = 1
+	var codeSize [257]int
+	var others [257]int
+	for i := range others {
+		others[i] = -1
+	}
+
+	for {
+		// Find the two least frequent trees, preferring higher symbols on ties
+		v1, v2 := -1, -1
+		for i := 0; i < 257; i++ {
+			if freq[i] > 0 && (v1 < 0 || freq[i] <= freq[v1]) {
+				v1 = i
+			}
+		}
+		for i := 0; i < 257; i++ {
+			if freq[i] > 0 && i != v1 && (v2 < 0 || freq[i] <= freq[v2]) {
+				v2 = i
+			}
+		}
+		if v2 < 0 {
+			break
+		}
+
+		freq[v1] += freq[v2]
+		freq[v2] = 0
+
+		codeSize[v1]++
+		for others[v1] >= 0 {
+			v1 = others[v1]
+			codeSize[v1]++
+		}
+		others[v1] = v2
+
+		codeSize[v2]++
+		for others[v2] >= 0 {
+			v2 = others[v2]
+			codeSize[v2]++
+		}
+	}
+
+	var counts [33]int
+	for _, size := range codeSize {
+		if size > 0 {
+			counts[size]++
+		}
+	}
+
+	// Limit code lengths to 16 bits
+	for i := 32; i > 16; i-- {
+		for counts[i] > 0 {
+			j := i - 2
+			for counts[j] == 0 {
+				j--
+			}
+			counts[i] -= 2
+			counts[i-1]++
+			counts[j+1] += 2
+			counts[j]--
+		}
+	}
+
+	// Drop the reserved code
+	i := 16
+	for counts[i] == 0 {
+		i--
+	}
+	counts[i]--
+
+	var spec jpegHuffmanSpec
+	for i := 1; i <= 16; i++ {
+		spec.counts[i-1] = byte(counts[i])
+	}
+	for size := 1; size <= 32; size++ {
+		for symbol := 0; symbol < 256; symbol++ {
+			if codeSize[symbol] == size {
+				spec.values = append(spec.values, byte(symbol))
+			}
+		}
+	}
+	return spec
+}
+
+// jpegHuffmanCode maps symbols to their codes
+type jpegHuffmanCode struct {
+	codes   [256]uint32
+	lengths [256]int
+}
+
+// newJPEGHuffmanCode assigns the canonical codes of a Huffman table
+func newJPEGHuffmanCode(spec jpegHuffmanSpec) *jpegHuffmanCode {
+	h := &jpegHuffmanCode{}
+	code, k := uint32(0), 0
+	for length := 1; length <= 16; length++ {
+		for i := 0; i < int(spec.counts[length-1]); i++ {
+			symbol := spec.values[k]
+			h.codes[symbol] = code
+			h.lengths[symbol] = length
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return h
+}
+
+// jpegWriter writes JPEG segments and entropy-coded data
+type jpegWriter struct {
+	w   *bufio.Writer
+	err error
+
+	tables [jpegNumTables]*jpegHuffmanCode
+	acc    uint64
+	nbits  int
+}
+
+// write writes raw bytes, remembering the first error
+func (jw *jpegWriter) write(p []byte) {
+	if jw.err == nil {
+		_, jw.err = jw.w.Write(p)
+	}
+}
+
+// writeMarker writes a marker followed by its length-prefixed payload, if any
+func (jw *jpegWriter) writeMarker(marker byte, payload []byte) {
+	jw.write([]byte{0xff, marker})
+	if payload == nil {
+		return
+	}
+	length := len(payload) + 2
+	jw.write([]byte{byte(length >> 8), byte(length)})
+	jw.write(payload)
+}
+
+// writeQuantTables writes the DQT segment
+func (jw *jpegWriter) writeQuantTables(f *jpegFrame) {
+	count := 1
+	if len(f.components) > 1 {
+		count = 2
+	}
+	var payload []byte
+	for i := 0; i < count; i++ {
+		payload = append(payload, byte(i))
+		payload = append(payload, f.quant[i][:]...)
+	}
+	jw.writeMarker(jpegDQT, payload)
+}
+
+// writeFrameHeader writes the SOF segment
+func (jw *jpegWriter) writeFrameHeader(marker byte, f *jpegFrame) {
+	payload := []byte{8, byte(f.height >> 8), byte(f.height), byte(f.width >> 8), byte(f.width), byte(len(f.components))}
+	for i, comp := range f.components {
+		payload = append(payload, byte(i+1), byte(comp.h<<4|comp.v), byte(comp.quant))
+	}
+	jw.writeMarker(marker, payload)
+}
+
+// writeHuffmanTables writes a DHT segment and installs the tables for coding
+func (jw *jpegWriter) writeHuffmanTables(slots []int, specs [jpegNumTables]jpegHuffmanSpec) {
+	var payload []byte
+	for _, slot := range slots {
+		spec := specs[slot]
+		// Slots are ordered DC luma, DC chroma, AC luma, AC chroma
+		class, id := slot/2, slot%2
+		payload = append(payload, byte(class<<4|id))
+		payload = append(payload, spec.counts[:]...)
+		payload = append(payload, spec.values...)
+		jw.tables[slot] = newJPEGHuffmanCode(spec)
+	}
+	jw.writeMarker(jpegDHT, payload)
+}
+
+// writeScan writes the Huffman tables, header and entropy-coded data of a scan
+func (jw *jpegWriter) writeScan(f *jpegFrame, scan jpegScan, optimize bool) {
+	slots := f.scanTables(scan)
+	specs := jpegStandardHuffman
+	if optimize {
+		var freq jpegFrequencies
+		f.encodeScan(scan, &freq)
+		for _, slot := range slots {
+			specs[slot] = buildJPEGHuffman(freq[slot])
+		}
+	}
+	jw.writeHuffmanTables(slots, specs)
+
+	payload := []byte{byte(len(scan.components))}
+	for _, ci := range scan.components {
+		table := byte(0)
+		if ci > 0 {
+			table = 1
+		}
+		payload = append(payload, byte(ci+1), table<<4|table)
+	}
+	payload = append(payload, byte(scan.ss), byte(scan.se), 0)
+	jw.writeMarker(jpegSOS, payload)
+
+	f.encodeScan(scan, jw)
+	jw.flushBits()
+}
+
+// symbol implements jpegSymbolSink
+func (jw *jpegWriter) symbol(table int, value byte) {
+	code := jw.tables[table]
+	jw.bits(code.codes[value], code.lengths[value])
+}
+
+// bits implements jpegSymbolSink, stuffing a zero byte after each 0xFF
+func (jw *jpegWriter) bits(value uint32, count int) {
+	jw.acc = jw.acc<<uint(count) | uint64(value)
+	jw.nbits += count
+	for jw.nbits >= 8 {
+		b := byte(jw.acc >> uint(jw.nbits-8))
+		jw.nbits -= 8
+		if b == 0xff {
+			jw.write([]byte{0xff, 0x00})
+		} else if jw.err == nil {
+			jw.err = jw.w.WriteByte(b)
+		}
+	}
+	jw.acc &= 1<<uint(jw.nbits) - 1
+}
+
+// flushBits pads the entropy-coded data to a byte boundary with one bits
+func (jw *jpegWriter) flushBits() {
+	if jw.nbits > 0 {
+		pad := 8 - jw.nbits
+		jw.bits(1<<uint(pad)-1, pad)
+	}
+}
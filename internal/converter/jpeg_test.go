@@ -0,0 +1,239 @@
+package converter
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"testing"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// gradientImage returns a smooth opaque image, as photos are, so that the
+// quality of lossy encoders can be measured
+func gradientImage(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 255 / width), uint8(y * 255 / height), uint8((x + y) % 256), 255})
+		}
+	}
+	return img
+}
+
+// psnr returns the peak signal-to-noise ratio of two images of the same
+// size in dB, over the RGB channels
+func psnr(a, b image.Image) float64 {
+	var sum float64
+	var n int
+	bounds := a.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r1, g1, b1, _ := a.At(x, y).RGBA()
+			r2, g2, b2, _ := b.At(x, y).RGBA()
+			for _, d := range []float64{
+				float64(r1>>8) - float64(r2>>8),
+				float64(g1>>8) - float64(g2>>8),
+				float64(b1>>8) - float64(b2>>8),
+			} {
+				sum += d * d
+				n++
+			}
+		}
+	}
+	if sum == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(255*255/(sum/float64(n)))
+}
+
+// encodeJPEG encodes an image and decodes it again with image/jpeg
+func encodeJPEG(t *testing.T, encoder *jpegEncoder, img image.Image) ([]byte, image.Image) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := encoder.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("decoding the encoded image: %v", err)
+	}
+	return buf.Bytes(), decoded
+}
+
+func TestJPEGRoundTrip(t *testing.T) {
+	sizes := [][2]int{{1, 1}, {7, 9}, {16, 16}, {33, 17}, {301, 199}}
+	subsamplings := []ChromaSubsampling{Subsampling444, Subsampling422, Subsampling420}
+
+	for _, size := range sizes {
+		src := gradientImage(size[0], size[1])
+		for _, subsampling := range subsamplings {
+			for _, progressive := range []bool{false, true} {
+				for _, optimize := range []bool{false, true} {
+					name := fmt.Sprintf("%dx%d %s progressive=%v optimize=%v", size[0], size[1], subsampling, progressive, optimize)
+					t.Run(name, func(t *testing.T) {
+						encoder := &jpegEncoder{quality: 90, subsampling: subsampling, progressive: progressive, optimizeHuffman: optimize}
+						_, decoded := encodeJPEG(t, encoder, src)
+						if got := decoded.Bounds().Size(); got != src.Bounds().Size() {
+							t.Fatalf("size %v, want %v", got, src.Bounds().Size())
+						}
+						// Chroma subsampling blurs the colors of tiny images
+						// more than the DCT does
+						want := 30.0
+						if size[0] < 16 {
+							want = 20
+						}
+						if p := psnr(src, decoded); p < want {
+							t.Errorf("PSNR %.1f dB, want at least %.0f", p, want)
+						}
+					})
+				}
+			}
+		}
+	}
+}
+
+func TestJPEGQuality(t *testing.T) {
+	src := gradientImage(256, 192)
+
+	var lastSize int
+	lastPSNR := 0.0
+	for _, quality := range []int{1, 25, 50, 75, 90, 100} {
+		data, decoded := encodeJPEG(t, &jpegEncoder{quality: quality, subsampling: Subsampling444, optimizeHuffman: true}, src)
+		p := psnr(src, decoded)
+		if len(data) <= lastSize || p <= lastPSNR {
+			t.Errorf("quality %d: %d bytes at %.1f dB, want more than %d bytes at %.1f dB",
+				quality, len(data), p, lastSize, lastPSNR)
+		}
+		lastSize, lastPSNR = len(data), p
+	}
+	if lastPSNR < 40 {
+		t.Errorf("quality 100: PSNR %.1f dB, want at least 40", lastPSNR)
+	}
+
+	// Out-of-range qualities are clamped
+	for _, quality := range []int{-5, 0, 101, 1000} {
+		encodeJPEG(t, &jpegEncoder{quality: quality, subsampling: Subsampling420}, src)
+	}
+	if scaleQuantTable(jpegBaseQuant[0], 100) != scaleQuantTable(jpegBaseQuant[0], 250) {
+		t.Error("quality above 100 not clamped")
+	}
+	for _, q := range scaleQuantTable(jpegBaseQuant[0], 100) {
+		if q != 1 {
+			t.Fatalf("quality 100 quantizes by %d, want 1", q)
+		}
+	}
+}
+
+func TestJPEGGrayscale(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 50, 31))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i % 251)
+	}
+
+	for _, progressive := range []bool{false, true} {
+		_, decoded := encodeJPEG(t, &jpegEncoder{quality: 90, subsampling: Subsampling420, progressive: progressive}, src)
+		if _, ok := decoded.(*image.Gray); !ok {
+			t.Fatalf("progressive=%v: decoded as %T, want a single-component *image.Gray", progressive, decoded)
+		}
+		if p := psnr(src, decoded); p < 30 {
+			t.Errorf("progressive=%v: PSNR %.1f dB, want at least 30", progressive, p)
+		}
+	}
+}
+
+func TestJPEGRejectsInvalidSizes(t *testing.T) {
+	for _, rect := range []image.Rectangle{
+		image.Rect(0, 0, 0, 0),
+		image.Rect(0, 0, jpegMaxDimension+1, 1),
+		image.Rect(0, 0, 1, jpegMaxDimension+1),
+	} {
+		if err := (&jpegEncoder{quality: 90}).Encode(&bytes.Buffer{}, image.NewGray(rect)); err == nil {
+			t.Errorf("%v: Encode succeeded, want an error", rect)
+		}
+	}
+}
+
+func TestJPEGMetadata(t *testing.T) {
+	encoder := &jpegEncoder{quality: 90, subsampling: Subsampling420}
+	encoded, _ := encodeJPEG(t, encoder, gradientImage(40, 30))
+
+	// A profile above the segment size is split into several APP2 segments
+	small := buildICCProfile(srgbSpace())
+	large := append(append([]byte{}, small...), make([]byte, 2*jpegMaxSegmentPayload)...)
+
+	for _, icc := range [][]byte{small, large} {
+		data, err := encoder.EmbedMetadata(encoded, testExif, icc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Embedding again replaces the segments instead of adding more
+		data, err = encoder.EmbedMetadata(data, testExif, icc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+			t.Fatalf("decoding the JPEG with metadata: %v", err)
+		}
+
+		segments, _, err := splitJPEGSegments(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if segments[0].marker != jpegMarkerAPP1 || !bytes.HasPrefix(segments[0].payload, exifHeader) {
+			t.Errorf("first segment %#x, want the EXIF APP1 segment directly after SOI", segments[0].marker)
+		}
+
+		var exifSegments int
+		var profile []byte
+		for _, seg := range segments {
+			switch {
+			case seg.marker == jpegMarkerAPP1 && bytes.HasPrefix(seg.payload, exifHeader):
+				exifSegments++
+			case seg.marker == jpegMarkerAPP2 && bytes.HasPrefix(seg.payload, iccHeader):
+				chunk := seg.payload[len(iccHeader):]
+				count := (len(icc) + jpegMaxSegmentPayload - len(iccHeader) - 3) / (jpegMaxSegmentPayload - len(iccHeader) - 2)
+				if int(chunk[1]) != count {
+					t.Errorf("ICC chunk count %d, want %d", chunk[1], count)
+				}
+				profile = append(profile, chunk[2:]...)
+			}
+		}
+		if exifSegments != 1 {
+			t.Errorf("%d EXIF segments, want 1", exifSegments)
+		}
+		if !bytes.Equal(profile, icc) {
+			t.Errorf("reassembled ICC profile of %d bytes differs from the %d byte profile", len(profile), len(icc))
+		}
+
+		x, err := exif.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("parsing the embedded EXIF: %v", err)
+		}
+		tag, err := x.Get(exif.Orientation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if orientation, err := tag.Int(0); err != nil || orientation != 6 {
+			t.Errorf("Orientation %d, %v, want 6", orientation, err)
+		}
+	}
+}
+
+func TestInsertJPEGMetadataRejectsInvalidInput(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"empty":          nil,
+		"not jpeg":       []byte("\x89PNG\r\n\x1a\n"),
+		"bad length":     {0xff, 0xd8, 0xff, 0xe0, 0x00, 0x01},
+		"truncated":      {0xff, 0xd8, 0xff, 0xe0, 0x00, 0x10, 0x4a},
+		"no scan marker": {0xff, 0xd8, 0x00, 0x00, 0x00, 0x00},
+	} {
+		if _, err := insertJPEGMetadata(data, testExif, nil); err == nil {
+			t.Errorf("%s: insertJPEGMetadata succeeded, want an error", name)
+		}
+	}
+}
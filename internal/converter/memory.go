@@ -0,0 +1,91 @@
+package converter
+
+import (
+	"github.com/spenceriam/HEIC-2-Go/internal/errors"
+	heif "github.com/strukturag/libheif/go/heif"
+)
+
+// decodedCopies is how many full-size copies of a decoded image a conversion
+// holds at its peak: the image decoded by libheif, its copy in Go memory and
+// the result of a color conversion, rotation or resize
+const decodedCopies = 3
+
+// EstimateMemory estimates the peak memory needed to convert a file, in
+// bytes: the file data plus, for the largest image converted, its decoded
+// size and the working buffers of the encoders writing it, taken from its
+// image handle. Only the file's metadata is read, so the estimate is cheap
+// compared to the conversion.
+func (c *HEICConverter) EstimateMemory(inputPath string) (int64, error) {
+	heifCtx, parsed, size, err := openHEIFFile(inputPath)
+	if err != nil {
+		return 0, err
+	}
+	ids, err := c.selectImages(heifCtx)
+	if err != nil {
+		return 0, err
+	}
+
+	// Images are converted one after another, so the largest one decides
+	var largest int64
+	for _, id := range ids {
+		handle, err := heifCtx.GetImageHandle(id)
+		if err != nil {
+			return 0, errors.Wrap(err, errors.ErrInvalidImage, "failed to get image handle")
+		}
+		need := decodedCopies*decodedSize(handle, itemBitDepth(parsed.Meta, uint32(id))) +
+			c.encoderMemory(handle)
+		if need > largest {
+			largest = need
+		}
+	}
+	return size + largest, nil
+}
+
+// encoderMemory returns the largest working memory, in bytes, that one of
+// the encoders writing an image holds. Outputs are encoded one after
+// another, so only the largest one counts.
+func (c *HEICConverter) encoderMemory(handle *heif.ImageHandle) int64 {
+	pixels := int64(handle.GetWidth()) * int64(handle.GetHeight())
+	hasAlpha := handle.HasAlphaChannel()
+	var largest int64
+	for _, output := range c.outputs("", hasAlpha) {
+		if size := encoderSize(output.format, output.options, hasAlpha, pixels); size > largest {
+			largest = size
+		}
+	}
+	return largest
+}
+
+// encoderSize returns the working memory of the encoder of a format for an
+// image of the given number of pixels, in bytes. Both the JPEG and the WebP
+// encoders work from an 8-bit RGBA copy of the image.
+func encoderSize(format OutputFormat, opts Options, hasAlpha bool, pixels int64) int64 {
+	const rgbaCopy = 4
+	switch format {
+	case FormatJPEG:
+		// Full-size Y, Cb and Cr planes, the subsampled chroma planes and
+		// the quantized coefficients of every block, 2 bytes each
+		h, v := opts.ChromaSubsampling.factors()
+		return pixels*(rgbaCopy+3+2) + pixels*6/int64(h*v)
+	case FormatWebP:
+		// Lossless images, and the alpha plane of lossy ones, keep the ARGB
+		// pixels, their residuals and a 24-byte token per pixel
+		if opts.WebPLossless || hasAlpha {
+			return pixels * (rgbaCopy + 4 + 4 + 24)
+		}
+		// Source and reconstructed Y, U and V planes at 4:2:0
+		return pixels * (rgbaCopy + 3)
+	default:
+		return 0
+	}
+}
+
+// decodedSize returns the size of an image decoded to RGBA, in bytes. Images
+// with more than 8 bits per sample are decoded to 16 bits per sample.
+func decodedSize(handle *heif.ImageHandle, bitDepth int) int64 {
+	bytesPerPixel := int64(4)
+	if bitDepth > 8 {
+		bytesPerPixel = 8
+	}
+	return int64(handle.GetWidth()) * int64(handle.GetHeight()) * bytesPerPixel
+}
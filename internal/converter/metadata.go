@@ -0,0 +1,541 @@
+package converter
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	stderrors "errors"
+	"fmt"
+	"hash/crc32"
+	"sort"
+)
+
+// JPEG markers used when splicing metadata segments
+const (
+	jpegMarkerPrefix = 0xFF
+	jpegMarkerSOI    = 0xD8
+	jpegMarkerSOS    = 0xDA
+	jpegMarkerAPP0   = 0xE0
+	jpegMarkerAPP1   = 0xE1
+	jpegMarkerAPP2   = 0xE2
+
+	// Largest payload that fits in a single JPEG segment
+	jpegMaxSegmentPayload = 0xFFFF - 2
+)
+
+var (
+	// exifHeader prefixes the TIFF structure in a JPEG APP1 segment
+	exifHeader = []byte("Exif\x00\x00")
+	// iccHeader prefixes each ICC profile chunk in a JPEG APP2 segment
+	iccHeader = []byte("ICC_PROFILE\x00")
+
+	// pngSignature starts every PNG stream
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+	tiffHeaderLE = []byte{'I', 'I', 0x2A, 0x00}
+	tiffHeaderBE = []byte{'M', 'M', 0x00, 0x2A}
+
+	// tiffImageTags describe how the pixels of a TIFF file are stored, so
+	// the values written by the encoder are kept over those in EXIF IFD0
+	tiffImageTags = map[uint16]bool{
+		254: true, 255: true, 256: true, 257: true, 258: true, 259: true,
+		262: true, 266: true, 273: true, 277: true, 278: true, 279: true,
+		284: true, 317: true, 320: true, 322: true, 323: true, 324: true,
+		325: true, 338: true, 339: true, 513: true, 514: true, 529: true,
+		530: true, 531: true, 532: true,
+	}
+)
+
+// TIFF tags and field types used to carry metadata in TIFF output
+const (
+	tiffTagICCProfile = 34675
+	tiffTagExifIFD    = 34665
+	tiffTagGPSIFD     = 34853
+	tiffTagInteropIFD = 40965
+
+	tiffTypeLong      = 4
+	tiffTypeUndefined = 7
+	tiffTypeIFD       = 13
+
+	// Depth of the EXIF IFD below IFD0 and the interoperability IFD below it
+	tiffMaxIFDDepth = 2
+)
+
+// imageMetadata holds the metadata carried over from the source image
+type imageMetadata struct {
+	// Raw TIFF-structured EXIF block with its Orientation tag reset to 1,
+	// nil if absent. It is only written when metadata is preserved.
+	Exif []byte
+	// Color space of the source image, nil if the file does not declare one
+	Color *colorInfo
+	// EXIF block as found in the file, with maker notes and orientation
+	SourceExif []byte
+	// Error that kept the EXIF block of the file out of Exif, if any
+	ExifErr error
+	// Whether the image has an alpha plane, which selects the alpha format
+	HasAlpha bool
+}
+
+// extractExif returns the raw TIFF-structured EXIF block of an image in a
+// HEIF file, or nil if the file carries no EXIF data
+func extractExif(data []byte, itemID uint32) ([]byte, error) {
+	meta, err := parseHEIFMeta(data)
+	if err != nil {
+		return nil, err
+	}
+
+	exifItems := meta.itemsOfType("Exif")
+	if len(exifItems) == 0 {
+		return nil, nil
+	}
+
+	// Prefer the EXIF item that describes the image
+	exifID := exifItems[0]
+	for _, id := range exifItems {
+		if meta.referencesTo("cdsc", id, itemID) {
+			exifID = id
+			break
+		}
+	}
+
+	raw, err := meta.itemData(exifID)
+	if err != nil {
+		return nil, err
+	}
+
+	return stripExifOffset(raw)
+}
+
+// stripExifOffset removes the exif_tiff_header_offset field that precedes the
+// EXIF payload in HEIF files, returning data that starts at the TIFF header
+func stripExifOffset(raw []byte) ([]byte, error) {
+	if len(raw) < 4 {
+		return nil, stderrors.New("EXIF block too small")
+	}
+
+	offset := uint64(binary.BigEndian.Uint32(raw)) + 4
+	if offset < uint64(len(raw)) && isTIFFHeader(raw[offset:]) {
+		return raw[offset:], nil
+	}
+
+	// Some writers get the offset wrong, so fall back to searching for the header
+	for i := 4; i+4 <= len(raw); i++ {
+		if isTIFFHeader(raw[i:]) {
+			return raw[i:], nil
+		}
+	}
+
+	return nil, stderrors.New("EXIF block has no TIFF header")
+}
+
+// isTIFFHeader reports whether data starts with a TIFF byte-order header
+func isTIFFHeader(data []byte) bool {
+	return bytes.HasPrefix(data, tiffHeaderLE) || bytes.HasPrefix(data, tiffHeaderBE)
+}
+
+// insertJPEGMetadata returns a copy of a JPEG stream with the given EXIF block
+// stored in an APP1 segment and the ICC profile in APP2 segments, replacing any
+// such segments already present. Either block may be nil.
+func insertJPEGMetadata(jpeg, exifData, iccProfile []byte) ([]byte, error) {
+	var inserts []jpegSegment
+
+	if exifData != nil {
+		payload := append(append([]byte{}, exifHeader...), exifData...)
+		if len(payload) > jpegMaxSegmentPayload {
+			return nil, fmt.Errorf("EXIF block of %d bytes does not fit in a JPEG segment", len(exifData))
+		}
+		inserts = append(inserts, jpegSegment{marker: jpegMarkerAPP1, payload: payload})
+	}
+
+	if iccProfile != nil {
+		chunks, err := iccProfileSegments(iccProfile)
+		if err != nil {
+			return nil, err
+		}
+		inserts = append(inserts, chunks...)
+	}
+
+	segments, rest, err := splitJPEGSegments(jpeg)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.Grow(len(jpeg) + len(exifData) + len(iccProfile) + 64)
+	out.Write([]byte{jpegMarkerPrefix, jpegMarkerSOI})
+
+	// EXIF must follow SOI directly, or a JFIF APP0 segment if there is one
+	inserted := false
+	for _, seg := range segments {
+		if isReplacedSegment(seg, exifData != nil, iccProfile != nil) {
+			continue
+		}
+		if !inserted && seg.marker != jpegMarkerAPP0 {
+			writeJPEGSegments(&out, inserts)
+			inserted = true
+		}
+		writeJPEGSegment(&out, seg.marker, seg.payload)
+	}
+	if !inserted {
+		writeJPEGSegments(&out, inserts)
+	}
+	out.Write(rest)
+
+	return out.Bytes(), nil
+}
+
+// isReplacedSegment reports whether an existing segment is superseded by the
+// metadata being inserted
+func isReplacedSegment(seg jpegSegment, exif, icc bool) bool {
+	switch seg.marker {
+	case jpegMarkerAPP1:
+		return exif && bytes.HasPrefix(seg.payload, exifHeader)
+	case jpegMarkerAPP2:
+		return icc && bytes.HasPrefix(seg.payload, iccHeader)
+	}
+	return false
+}
+
+// iccProfileSegments splits an ICC profile into numbered APP2 chunks
+func iccProfileSegments(profile []byte) ([]jpegSegment, error) {
+	// Each chunk carries the header plus a sequence number and chunk count
+	chunkSize := jpegMaxSegmentPayload - len(iccHeader) - 2
+	count := (len(profile) + chunkSize - 1) / chunkSize
+	if count > 255 {
+		return nil, fmt.Errorf("ICC profile of %d bytes is too large to embed", len(profile))
+	}
+
+	segments := make([]jpegSegment, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * chunkSize
+		if end > len(profile) {
+			end = len(profile)
+		}
+
+		payload := append([]byte{}, iccHeader...)
+		payload = append(payload, byte(i+1), byte(count))
+		payload = append(payload, profile[i*chunkSize:end]...)
+		segments = append(segments, jpegSegment{marker: jpegMarkerAPP2, payload: payload})
+	}
+
+	return segments, nil
+}
+
+// jpegSegment is a marker segment from the header of a JPEG stream
+type jpegSegment struct {
+	marker  byte
+	payload []byte
+}
+
+// splitJPEGSegments splits a JPEG stream into the marker segments preceding
+// the first scan and the remainder of the stream starting at SOS
+func splitJPEGSegments(jpeg []byte) ([]jpegSegment, []byte, error) {
+	if len(jpeg) < 4 || jpeg[0] != jpegMarkerPrefix || jpeg[1] != jpegMarkerSOI {
+		return nil, nil, stderrors.New("not a JPEG stream")
+	}
+
+	var segments []jpegSegment
+	pos := 2
+	for {
+		if pos+4 > len(jpeg) || jpeg[pos] != jpegMarkerPrefix {
+			return nil, nil, fmt.Errorf("malformed JPEG marker at offset %d", pos)
+		}
+
+		marker := jpeg[pos+1]
+		if marker == jpegMarkerSOS {
+			return segments, jpeg[pos:], nil
+		}
+
+		length := int(binary.BigEndian.Uint16(jpeg[pos+2:]))
+		if length < 2 || pos+2+length > len(jpeg) {
+			return nil, nil, fmt.Errorf("invalid JPEG segment length at offset %d", pos)
+		}
+
+		segments = append(segments, jpegSegment{
+			marker:  marker,
+			payload: jpeg[pos+4 : pos+2+length],
+		})
+		pos += 2 + length
+	}
+}
+
+// writeJPEGSegments writes a sequence of marker segments
+func writeJPEGSegments(out *bytes.Buffer, segments []jpegSegment) {
+	for _, seg := range segments {
+		writeJPEGSegment(out, seg.marker, seg.payload)
+	}
+}
+
+// writeJPEGSegment writes a marker segment with its length field
+func writeJPEGSegment(out *bytes.Buffer, marker byte, payload []byte) {
+	out.Write([]byte{jpegMarkerPrefix, marker})
+	binary.Write(out, binary.BigEndian, uint16(len(payload)+2))
+	out.Write(payload)
+}
+
+// insertPNGMetadata returns a copy of a PNG stream with the EXIF block stored
+// in an eXIf chunk and the ICC profile in an iCCP chunk, replacing any such
+// chunks already present. Either block may be nil.
+func insertPNGMetadata(png, exifData, iccProfile []byte) ([]byte, error) {
+	if !bytes.HasPrefix(png, pngSignature) {
+		return nil, stderrors.New("not a PNG stream")
+	}
+
+	var inserts bytes.Buffer
+	if iccProfile != nil {
+		// iCCP holds a profile name, a compression method and the zlib stream
+		var compressed bytes.Buffer
+		compressed.WriteString("ICC Profile\x00\x00")
+		zw := zlib.NewWriter(&compressed)
+		if _, err := zw.Write(iccProfile); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		writePNGChunk(&inserts, "iCCP", compressed.Bytes())
+	}
+	if exifData != nil {
+		writePNGChunk(&inserts, "eXIf", exifData)
+	}
+
+	var out bytes.Buffer
+	out.Grow(len(png) + inserts.Len())
+	out.Write(pngSignature)
+
+	pos := len(pngSignature)
+	for pos < len(png) {
+		if pos+12 > len(png) {
+			return nil, fmt.Errorf("truncated PNG chunk at offset %d", pos)
+		}
+		length := int(binary.BigEndian.Uint32(png[pos:]))
+		if length < 0 || pos+12+length > len(png) {
+			return nil, fmt.Errorf("invalid PNG chunk length at offset %d", pos)
+		}
+		chunkType := string(png[pos+4 : pos+8])
+		chunk := png[pos : pos+12+length]
+		pos += 12 + length
+
+		switch chunkType {
+		case "iCCP", "sRGB":
+			if iccProfile != nil {
+				continue
+			}
+		case "eXIf":
+			if exifData != nil {
+				continue
+			}
+		}
+		out.Write(chunk)
+
+		// Both chunks must precede the image data, so place them after the header
+		if chunkType == "IHDR" {
+			out.Write(inserts.Bytes())
+		}
+	}
+
+	return out.Bytes(), nil
+}
+
+// writePNGChunk writes a PNG chunk with its length and CRC
+func writePNGChunk(out *bytes.Buffer, chunkType string, data []byte) {
+	binary.Write(out, binary.BigEndian, uint32(len(data)))
+	out.WriteString(chunkType)
+	out.Write(data)
+
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(chunkType))
+	crc.Write(data)
+	binary.Write(out, binary.BigEndian, crc.Sum32())
+}
+
+// tiffEntry is an IFD entry with its value in the byte order of the output,
+// or with the entries of the IFD it points to
+type tiffEntry struct {
+	tag, typ uint16
+	count    uint32
+	value    []byte
+	sub      []tiffEntry
+}
+
+// insertTIFFMetadata returns a copy of a TIFF file with the ICC profile and
+// the tags of the EXIF block added to its first IFD. Either block may be nil.
+// The EXIF, GPS and interoperability IFDs are rewritten in the byte order of
+// the file; tags describing the pixel layout keep the values of the file.
+func insertTIFFMetadata(tiffData, exifData, iccProfile []byte) ([]byte, error) {
+	if len(tiffData) < 8 || !isTIFFHeader(tiffData) {
+		return nil, stderrors.New("not a TIFF stream")
+	}
+	order := tiffByteOrder(tiffData)
+	entries, err := readTIFFIFD(tiffData, order, order, order.Uint32(tiffData[4:]), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	present := make(map[uint16]bool)
+	for _, entry := range entries {
+		present[entry.tag] = true
+	}
+	if exifData != nil {
+		if len(exifData) < 8 || !isTIFFHeader(exifData) {
+			return nil, stderrors.New("EXIF block has no TIFF header")
+		}
+		exifOrder := tiffByteOrder(exifData)
+		exifEntries, err := readTIFFIFD(exifData, exifOrder, order, exifOrder.Uint32(exifData[4:]), 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range exifEntries {
+			if !present[entry.tag] && !tiffImageTags[entry.tag] {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	if iccProfile != nil {
+		kept := entries[:0]
+		for _, entry := range entries {
+			if entry.tag != tiffTagICCProfile {
+				kept = append(kept, entry)
+			}
+		}
+		entries = append(kept, tiffEntry{tag: tiffTagICCProfile, typ: tiffTypeUndefined, count: uint32(len(iccProfile)), value: iccProfile})
+	}
+
+	// The new IFD is appended, leaving the old one unreferenced
+	out := append(make([]byte, 0, len(tiffData)+len(exifData)+len(iccProfile)+1024), tiffData...)
+	out, offset := writeTIFFIFD(out, order, entries)
+	order.PutUint32(out[4:], offset)
+	return out, nil
+}
+
+// tiffByteOrder returns the byte order of a TIFF header
+func tiffByteOrder(data []byte) binary.ByteOrder {
+	if data[0] == 'M' {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// tiffTypeLayout returns the size of the numbers that make up a value of a
+// TIFF field type and how many make up one value, or 0 for unknown types
+func tiffTypeLayout(typ uint16) (int, int) {
+	switch typ {
+	case 1, 2, 6, 7:
+		return 1, 1
+	case 3, 8:
+		return 2, 1
+	case 4, 9, 11, 13:
+		return 4, 1
+	case 5, 10:
+		return 4, 2
+	case 12:
+		return 8, 1
+	}
+	return 0, 0
+}
+
+// readTIFFIFD reads the entries of the IFD at offset, converting their values
+// from the byte order of the data to that of the output and reading the EXIF,
+// GPS and interoperability IFDs they point to. Entries of unknown types are
+// dropped, since their values cannot be converted.
+func readTIFFIFD(data []byte, order, out binary.ByteOrder, offset uint32, depth int) ([]tiffEntry, error) {
+	if uint64(offset)+2 > uint64(len(data)) {
+		return nil, fmt.Errorf("TIFF IFD offset %d is out of range", offset)
+	}
+	count := int(order.Uint16(data[offset:]))
+	start := int(offset) + 2
+	if start+12*count > len(data) {
+		return nil, fmt.Errorf("TIFF IFD at offset %d is truncated", offset)
+	}
+
+	entries := make([]tiffEntry, 0, count)
+	for i := 0; i < count; i++ {
+		field := data[start+12*i : start+12*i+12]
+		entry := tiffEntry{tag: order.Uint16(field), typ: order.Uint16(field[2:]), count: order.Uint32(field[4:])}
+		unit, perValue := tiffTypeLayout(entry.typ)
+		if unit == 0 {
+			continue
+		}
+
+		size := uint64(entry.count) * uint64(unit*perValue)
+		raw := field[8 : 8+min(size, 4)]
+		if size > 4 {
+			valueOffset := uint64(order.Uint32(field[8:]))
+			if valueOffset+size > uint64(len(data)) {
+				return nil, fmt.Errorf("value of TIFF tag %d is out of range", entry.tag)
+			}
+			raw = data[valueOffset : valueOffset+size]
+		}
+
+		isPointer := entry.tag == tiffTagExifIFD || entry.tag == tiffTagGPSIFD || entry.tag == tiffTagInteropIFD
+		if isPointer && entry.count == 1 && (entry.typ == tiffTypeLong || entry.typ == tiffTypeIFD) {
+			if depth >= tiffMaxIFDDepth {
+				continue
+			}
+			sub, err := readTIFFIFD(data, order, out, order.Uint32(raw), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			entry.sub = sub
+		} else {
+			entry.value = convertTIFFValue(raw, unit, order, out)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// convertTIFFValue returns a copy of a value with its numbers converted from
+// one byte order to another
+func convertTIFFValue(raw []byte, unit int, from, to binary.ByteOrder) []byte {
+	value := append([]byte{}, raw...)
+	if from == to || unit == 1 {
+		return value
+	}
+	for i := 0; i+unit <= len(value); i += unit {
+		for a, b := i, i+unit-1; a < b; a, b = a+1, b-1 {
+			value[a], value[b] = value[b], value[a]
+		}
+	}
+	return value
+}
+
+// writeTIFFIFD appends an IFD with its values and sub-IFDs to out and
+// returns the result and the offset of the IFD
+func writeTIFFIFD(out []byte, order binary.ByteOrder, entries []tiffEntry) ([]byte, uint32) {
+	// The tags of an IFD must be in ascending order
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+
+	// IFDs and values start on a word boundary
+	if len(out)%2 == 1 {
+		out = append(out, 0)
+	}
+	start := len(out)
+	out = append(out, make([]byte, 2+12*len(entries)+4)...)
+	order.PutUint16(out[start:], uint16(len(entries)))
+
+	for i, entry := range entries {
+		value := entry.value
+		if entry.sub != nil {
+			var offset uint32
+			out, offset = writeTIFFIFD(out, order, entry.sub)
+			value = make([]byte, 4)
+			order.PutUint32(value, offset)
+		}
+
+		field := start + 2 + 12*i
+		order.PutUint16(out[field:], entry.tag)
+		order.PutUint16(out[field+2:], entry.typ)
+		order.PutUint32(out[field+4:], entry.count)
+		if len(value) <= 4 {
+			copy(out[field+8:field+12], value)
+			continue
+		}
+		if len(out)%2 == 1 {
+			out = append(out, 0)
+		}
+		order.PutUint32(out[field+8:], uint32(len(out)))
+		out = append(out, value...)
+	}
+	return out, uint32(start)
+}
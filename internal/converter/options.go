@@ -0,0 +1,92 @@
+package converter
+
+import (
+	"fmt"
+	"image/png"
+	"strings"
+
+	"github.com/spenceriam/HEIC-2-Go/internal/errors"
+)
+
+// ChromaSubsampling selects how the color channels of JPEG output are sampled
+type ChromaSubsampling string
+
+const (
+	// Subsampling444 keeps the color channels at full resolution
+	Subsampling444 ChromaSubsampling = "4:4:4"
+	// Subsampling422 halves the horizontal color resolution
+	Subsampling422 ChromaSubsampling = "4:2:2"
+	// Subsampling420 halves the horizontal and vertical color resolution
+	Subsampling420 ChromaSubsampling = "4:2:0"
+)
+
+// ParseChromaSubsampling parses a subsampling name such as "4:2:0" or "444"
+func ParseChromaSubsampling(name string) (ChromaSubsampling, error) {
+	switch strings.ReplaceAll(strings.TrimSpace(name), ":", "") {
+	case "444":
+		return Subsampling444, nil
+	case "422":
+		return Subsampling422, nil
+	case "420":
+		return Subsampling420, nil
+	}
+	return "", fmt.Errorf("unsupported chroma subsampling: %s", name)
+}
+
+// factors returns the luma sampling factors relative to the chroma channels
+func (s ChromaSubsampling) factors() (int, int) {
+	switch s {
+	case Subsampling444:
+		return 1, 1
+	case Subsampling422:
+		return 2, 1
+	default:
+		return 2, 2
+	}
+}
+
+// Options holds the encoder settings used by the converter
+type Options struct {
+	// Quality of lossy output formats (1-100)
+	Quality int
+	// Chroma subsampling of JPEG output
+	ChromaSubsampling ChromaSubsampling
+	// Whether to write progressive JPEG files
+	Progressive bool
+	// Whether to compute Huffman tables for each JPEG file instead of using
+	// the standard ones
+	OptimizeHuffman bool
+	// PNG compression level
+	PNGCompression png.CompressionLevel
+	// Whether to compress TIFF files with Deflate
+	TIFFDeflate bool
+	// Whether to encode AVIF files losslessly
+	AVIFLossless bool
+	// Whether to encode WebP files losslessly, ignoring Quality
+	WebPLossless bool
+}
+
+// DefaultOptions returns the default encoder settings
+func DefaultOptions() Options {
+	return Options{
+		Quality:           90,
+		ChromaSubsampling: Subsampling420,
+		Progressive:       false,
+		OptimizeHuffman:   true,
+		PNGCompression:    png.DefaultCompression,
+		TIFFDeflate:       true,
+		AVIFLossless:      false,
+		WebPLossless:      false,
+	}
+}
+
+// Validate checks that the options are within their allowed ranges
+func (o Options) Validate() error {
+	if o.Quality < 1 || o.Quality > 100 {
+		return errors.InvalidInput("quality", o.Quality)
+	}
+	if _, err := ParseChromaSubsampling(string(o.ChromaSubsampling)); err != nil {
+		return errors.InvalidInput("chroma subsampling", o.ChromaSubsampling)
+	}
+	return nil
+}
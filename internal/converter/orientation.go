@@ -0,0 +1,214 @@
+package converter
+
+import (
+	"encoding/binary"
+	"image"
+
+	"github.com/disintegration/imaging"
+)
+
+// EXIF orientation values, see the TIFF/EP specification
+const (
+	orientationNormal     = 1
+	orientationFlipH      = 2
+	orientationRotate180  = 3
+	orientationFlipV      = 4
+	orientationTranspose  = 5
+	orientationRotate90   = 6
+	orientationTransverse = 7
+	orientationRotate270  = 8
+
+	exifTagOrientation = 0x0112
+	tiffTypeShort      = 3
+)
+
+// normalizeOrientation returns the decoded image in upright orientation.
+//
+// The output always stores its pixels upright and, when EXIF is written, has
+// an Orientation tag of 1. HEIF expresses rotation and mirroring through the
+// 'irot' and 'imir' item properties, which libheif applies while decoding, so
+// the decoded pixels are already upright and the EXIF tag (which merely
+// mirrors those properties) must be reset to avoid a second rotation.
+//
+// HEIF treats the EXIF tag as informative only, and editors often store
+// upright pixels but leave the tag set. The tag is therefore only applied
+// when useExif is set, for non-conforming files that carry no 'irot' or
+// 'imir' property.
+func normalizeOrientation(img image.Image, data []byte, itemID uint32, exifData []byte, useExif bool) image.Image {
+	if !useExif || hasTransformProperties(data, itemID) {
+		// libheif has already applied the container transformations
+		return img
+	}
+
+	orientation, _ := exifOrientation(exifData)
+	return applyOrientation(img, orientation)
+}
+
+// hasTransformProperties reports whether an image of a HEIF file carries
+// 'irot' or 'imir' transformation properties
+func hasTransformProperties(data []byte, itemID uint32) bool {
+	meta, err := parseHEIFMeta(data)
+	if err != nil {
+		// Without a readable container assume libheif handled it
+		return true
+	}
+
+	for _, prop := range meta.itemProperties(itemID) {
+		if prop.Type == "irot" || prop.Type == "imir" {
+			return true
+		}
+	}
+	return false
+}
+
+// applyOrientation transforms an image so that an EXIF orientation of 1 describes it
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if src, ok := img.(*image.NRGBA64); ok && orientation > orientationNormal && orientation <= orientationRotate270 {
+		// imaging only produces 8-bit images
+		return orientNRGBA64(src, orientation)
+	}
+
+	switch orientation {
+	case orientationFlipH:
+		return imaging.FlipH(img)
+	case orientationRotate180:
+		return imaging.Rotate180(img)
+	case orientationFlipV:
+		return imaging.FlipV(img)
+	case orientationTranspose:
+		return imaging.Transpose(img)
+	case orientationRotate90:
+		// The tag describes a clockwise rotation, imaging rotates counter-clockwise
+		return imaging.Rotate270(img)
+	case orientationTransverse:
+		return imaging.Transverse(img)
+	case orientationRotate270:
+		return imaging.Rotate90(img)
+	default:
+		return img
+	}
+}
+
+// applyTransformProperties applies the 'irot' and 'imir' properties of a
+// HEIF item to an image in the order they are listed, as libheif does while
+// decoding the item
+func applyTransformProperties(img image.Image, props []box) image.Image {
+	for _, prop := range props {
+		if len(prop.Payload) < 1 {
+			continue
+		}
+		switch prop.Type {
+		case "irot":
+			// Counter-clockwise in steps of 90 degrees
+			switch prop.Payload[0] & 3 {
+			case 1:
+				img = imaging.Rotate90(img)
+			case 2:
+				img = imaging.Rotate180(img)
+			case 3:
+				img = imaging.Rotate270(img)
+			}
+		case "imir":
+			// Axis 0 flips top to bottom, 1 flips left to right
+			if prop.Payload[0]&1 == 0 {
+				img = imaging.FlipV(img)
+			} else {
+				img = imaging.FlipH(img)
+			}
+		}
+	}
+	return img
+}
+
+// orientNRGBA64 applies an EXIF orientation to a 16-bit image
+func orientNRGBA64(src *image.NRGBA64, orientation int) *image.NRGBA64 {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	swap := orientation >= orientationTranspose
+	dstW, dstH := w, h
+	if swap {
+		dstW, dstH = h, w
+	}
+
+	dst := image.NewNRGBA64(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		for x := 0; x < dstW; x++ {
+			// Find the source pixel that ends up at (x, y)
+			var sx, sy int
+			switch orientation {
+			case orientationFlipH:
+				sx, sy = w-1-x, y
+			case orientationRotate180:
+				sx, sy = w-1-x, h-1-y
+			case orientationFlipV:
+				sx, sy = x, h-1-y
+			case orientationTranspose:
+				sx, sy = y, x
+			case orientationRotate90:
+				sx, sy = y, h-1-x
+			case orientationTransverse:
+				sx, sy = w-1-y, h-1-x
+			case orientationRotate270:
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[y*dst.Stride+x*8:y*dst.Stride+x*8+8], src.Pix[sy*src.Stride+sx*8:])
+		}
+	}
+	return dst
+}
+
+// findExifOrientation locates the Orientation entry in IFD0 of a TIFF-structured
+// EXIF block and returns its byte order and the offset of its value
+func findExifOrientation(exifData []byte) (binary.ByteOrder, int, bool) {
+	if len(exifData) < 8 || !isTIFFHeader(exifData) {
+		return nil, 0, false
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if exifData[0] == 'M' {
+		order = binary.BigEndian
+	}
+
+	ifd := int(order.Uint32(exifData[4:]))
+	if ifd < 8 || ifd+2 > len(exifData) {
+		return nil, 0, false
+	}
+
+	count := int(order.Uint16(exifData[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(exifData) {
+			break
+		}
+		if order.Uint16(exifData[entry:]) != exifTagOrientation {
+			continue
+		}
+		if order.Uint16(exifData[entry+2:]) != tiffTypeShort || order.Uint32(exifData[entry+4:]) != 1 {
+			return nil, 0, false
+		}
+		return order, entry + 8, true
+	}
+
+	return nil, 0, false
+}
+
+// exifOrientation returns the Orientation tag of an EXIF block
+func exifOrientation(exifData []byte) (int, bool) {
+	order, offset, ok := findExifOrientation(exifData)
+	if !ok {
+		return orientationNormal, false
+	}
+	return int(order.Uint16(exifData[offset:])), true
+}
+
+// resetExifOrientation returns a copy of an EXIF block with its Orientation
+// tag set to 1, leaving blocks without the tag unchanged
+func resetExifOrientation(exifData []byte) []byte {
+	order, offset, ok := findExifOrientation(exifData)
+	if !ok {
+		return exifData
+	}
+
+	updated := append([]byte{}, exifData...)
+	order.PutUint16(updated[offset:], orientationNormal)
+	return updated
+}
@@ -0,0 +1,160 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// orientationTestImage returns a 3x2 image in which every pixel is distinct
+func orientationTestImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(40 * (y*3 + x + 1)), G: uint8(x), B: uint8(y), A: 0xff})
+		}
+	}
+	return img
+}
+
+// orientationExif returns an EXIF block whose IFD0 holds an ImageWidth
+// entry followed by an Orientation entry of the given type
+func orientationExif(order binary.ByteOrder, tiffType, orientation uint16) []byte {
+	data := make([]byte, 8+2+2*12+4)
+	if order == binary.LittleEndian {
+		copy(data, "II")
+	} else {
+		copy(data, "MM")
+	}
+	order.PutUint16(data[2:], 42)
+	order.PutUint32(data[4:], 8)
+	order.PutUint16(data[8:], 2)
+
+	width := data[10:]
+	order.PutUint16(width, 0x0100)
+	order.PutUint16(width[2:], tiffTypeShort)
+	order.PutUint32(width[4:], 1)
+	order.PutUint16(width[8:], 4032)
+
+	entry := data[22:]
+	order.PutUint16(entry, exifTagOrientation)
+	order.PutUint16(entry[2:], tiffType)
+	order.PutUint32(entry[4:], 1)
+	order.PutUint16(entry[8:], orientation)
+	return data
+}
+
+func TestApplyOrientation(t *testing.T) {
+	src := orientationTestImage()
+	src16 := image.NewNRGBA64(src.Rect)
+	for i, v := range src.Pix {
+		binary.BigEndian.PutUint16(src16.Pix[i*2:], uint16(v)*0x101)
+	}
+
+	// Where the top-left and top-right pixels of the 3x2 image end up
+	tests := []struct {
+		orientation       int
+		size              image.Point
+		topLeft, topRight image.Point
+	}{
+		{orientationNormal, image.Pt(3, 2), image.Pt(0, 0), image.Pt(2, 0)},
+		{orientationFlipH, image.Pt(3, 2), image.Pt(2, 0), image.Pt(0, 0)},
+		{orientationRotate180, image.Pt(3, 2), image.Pt(2, 1), image.Pt(0, 1)},
+		{orientationFlipV, image.Pt(3, 2), image.Pt(0, 1), image.Pt(2, 1)},
+		{orientationTranspose, image.Pt(2, 3), image.Pt(0, 0), image.Pt(0, 2)},
+		{orientationRotate90, image.Pt(2, 3), image.Pt(1, 0), image.Pt(1, 2)},
+		{orientationTransverse, image.Pt(2, 3), image.Pt(1, 2), image.Pt(1, 0)},
+		{orientationRotate270, image.Pt(2, 3), image.Pt(0, 2), image.Pt(0, 0)},
+		// Values outside the specification leave the image as it is
+		{0, image.Pt(3, 2), image.Pt(0, 0), image.Pt(2, 0)},
+		{9, image.Pt(3, 2), image.Pt(0, 0), image.Pt(2, 0)},
+	}
+	for _, tt := range tests {
+		got := applyOrientation(src, tt.orientation)
+		if size := got.Bounds().Size(); size != tt.size {
+			t.Errorf("orientation %d: size %v, want %v", tt.orientation, size, tt.size)
+			continue
+		}
+		b := got.Bounds()
+		if c := got.At(b.Min.X+tt.topLeft.X, b.Min.Y+tt.topLeft.Y); c != src.At(0, 0) {
+			t.Errorf("orientation %d: top-left pixel not at %v", tt.orientation, tt.topLeft)
+		}
+		if c := got.At(b.Min.X+tt.topRight.X, b.Min.Y+tt.topRight.Y); c != src.At(2, 0) {
+			t.Errorf("orientation %d: top-right pixel not at %v", tt.orientation, tt.topRight)
+		}
+
+		// 16-bit images take their own path, which must match pixel for pixel
+		got16 := applyOrientation(src16, tt.orientation)
+		if got16.Bounds() != image.Rect(0, 0, tt.size.X, tt.size.Y) {
+			t.Errorf("orientation %d: 16-bit bounds %v, want size %v", tt.orientation, got16.Bounds(), tt.size)
+			continue
+		}
+		for y := 0; y < tt.size.Y; y++ {
+			for x := 0; x < tt.size.X; x++ {
+				want := color.NRGBA64Model.Convert(got.At(b.Min.X+x, b.Min.Y+y))
+				if c := got16.At(x, y); c != want {
+					t.Errorf("orientation %d: 16-bit pixel (%d, %d) = %v, want %v", tt.orientation, x, y, c, want)
+				}
+			}
+		}
+	}
+}
+
+func TestNormalizeOrientation(t *testing.T) {
+	src := orientationTestImage()
+	exifData := orientationExif(binary.BigEndian, tiffTypeShort, orientationRotate90)
+	rotated := image.Pt(2, 3)
+
+	withIrot := validHEIF()
+	withIrot.propertyType = "irot"
+
+	tests := []struct {
+		name    string
+		data    []byte
+		useExif bool
+		want    image.Point
+	}{
+		{"tag ignored", validHEIF().build(), false, src.Rect.Size()},
+		{"tag applied", validHEIF().build(), true, rotated},
+		// libheif has already applied the container's own transformation
+		{"irot property", withIrot.build(), true, src.Rect.Size()},
+		{"unreadable container", []byte("not a HEIF file"), true, src.Rect.Size()},
+	}
+	for _, tt := range tests {
+		got := normalizeOrientation(src, tt.data, 1, exifData, tt.useExif)
+		if size := got.Bounds().Size(); size != tt.want {
+			t.Errorf("%s: size %v, want %v", tt.name, size, tt.want)
+		}
+	}
+}
+
+func TestResetExifOrientation(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		exifData := orientationExif(order, tiffTypeShort, orientationRotate270)
+		original := append([]byte{}, exifData...)
+
+		reset := resetExifOrientation(exifData)
+		if orientation, ok := exifOrientation(reset); !ok || orientation != orientationNormal {
+			t.Errorf("%v: orientation %d after reset, want 1", order, orientation)
+		}
+		if !bytes.Equal(exifData, original) {
+			t.Errorf("%v: reset modified its input", order)
+		}
+		// Only the two bytes of the value change
+		if !bytes.Equal(reset[:30], original[:30]) || !bytes.Equal(reset[32:], original[32:]) {
+			t.Errorf("%v: reset changed other bytes of the block", order)
+		}
+	}
+
+	// Blocks without a usable tag are returned unchanged
+	for name, exifData := range map[string][]byte{
+		"long value": orientationExif(binary.LittleEndian, tiffTypeLong, orientationRotate90),
+		"not tiff":   []byte("Exif\x00\x00"),
+	} {
+		if got := resetExifOrientation(exifData); !bytes.Equal(got, exifData) {
+			t.Errorf("%s: block changed to % x", name, got)
+		}
+	}
+}
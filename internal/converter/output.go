@@ -0,0 +1,128 @@
+package converter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/spenceriam/HEIC-2-Go/internal/errors"
+	heif "github.com/strukturag/libheif/go/heif"
+)
+
+// writeOutput writes data to a temporary file next to path, syncs it to disk
+// and renames it over path, so the output either exists completely or an
+// existing file at path is left untouched. The temporary file is removed if
+// writing fails or the context is cancelled.
+func writeOutput(ctx context.Context, path string, data []byte) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	for len(data) > 0 {
+		if err := checkCancelled(ctx); err != nil {
+			return err
+		}
+		n := len(data)
+		if n > chunkSize {
+			n = chunkSize
+		}
+		if _, err := tmp.Write(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+
+	// Temporary files are private, outputs are readable like any other image
+	if err := tmp.Chmod(0644); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// PlannedOutputs returns the files that converting inputPath to outputPath
+// writes: the output of every selected image, named by IndexedOutputPath
+// when there are several, its renditions and its exported auxiliary images.
+// Only the file's metadata is read, so callers can check the files for
+// conflicts before converting.
+func (c *HEICConverter) PlannedOutputs(inputPath, outputPath string) ([]string, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	heifCtx, parsed, _, err := openHEIFFile(inputPath)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := c.selectImages(heifCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	var outputs []string
+	for i, id := range ids {
+		handle, err := heifCtx.GetImageHandle(id)
+		if err != nil {
+			return nil, errors.Wrap(err, errors.ErrInvalidImage, "failed to get image handle")
+		}
+		aux := parsed.Meta.auxiliaryImages(uint32(id))
+		output := imageOutputPath(outputPath, i, len(ids))
+		outputs = append(outputs, c.plannedImageOutputs(output, handle.HasAlphaChannel(), aux)...)
+	}
+	return outputs, nil
+}
+
+// plannedImageOutputs returns the files written for one image: its output,
+// the outputs of its renditions, in the alpha format if it applies, and its
+// auxiliary images if they are exported
+func (c *HEICConverter) plannedImageOutputs(outputPath string, hasAlpha bool, aux []auxiliaryImage) []string {
+	var paths []string
+	for _, output := range c.outputs(outputPath, hasAlpha) {
+		paths = append(paths, output.path)
+	}
+	if c.auxFormat != "" {
+		for _, output := range c.auxiliaryOutputs(aux, outputPath) {
+			paths = append(paths, output.path)
+		}
+	}
+	return paths
+}
+
+// openHEIFFile validates a HEIF file and reads it with libheif without
+// decoding any image. It returns the parsed structure and the file size too.
+func openHEIFFile(path string) (*heif.Context, *heifFile, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, 0, errors.HandleFileError(err, path)
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return nil, nil, 0, errors.Wrap(err, errors.ErrFileRead, "failed to get file info")
+	}
+	parsed, err := validateHEIF(file, fileInfo.Size())
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	heifCtx, err := heif.NewContext()
+	if err != nil {
+		return nil, nil, 0, errors.Wrap(err, errors.ErrSystem, "failed to create HEIF context")
+	}
+	if err := heifCtx.ReadFromFile(path); err != nil {
+		return nil, nil, 0, errors.Wrap(err, errors.ErrDecodeFailed, "failed to read HEIC file")
+	}
+	return heifCtx, parsed, fileInfo.Size(), nil
+}
@@ -0,0 +1,115 @@
+package converter
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/spenceriam/HEIC-2-Go/internal/errors"
+)
+
+func TestImageOutputPath(t *testing.T) {
+	out := filepath.Join("out", "IMG_0001.jpg")
+	if got := imageOutputPath(out, 0, 1); got != out {
+		t.Errorf("single image: %s, want %s", got, out)
+	}
+	tests := []struct {
+		i, count int
+		want     string
+	}{
+		{0, 2, "IMG_0001-1.jpg"},
+		{1, 2, "IMG_0001-2.jpg"},
+		{0, 12, "IMG_0001-01.jpg"},
+		{11, 12, "IMG_0001-12.jpg"},
+	}
+	for _, tt := range tests {
+		if got := imageOutputPath(out, tt.i, tt.count); got != filepath.Join("out", tt.want) {
+			t.Errorf("image %d of %d: %s, want %s", tt.i, tt.count, got, tt.want)
+		}
+	}
+}
+
+func TestPlannedImageOutputs(t *testing.T) {
+	c := NewHEICConverter(true).WithRenditions([]Rendition{
+		{Suffix: "_web", Resize: ResizeOptions{MaxWidth: 1600}},
+		{Suffix: "_thumb", Format: FormatWebP, Quality: 75},
+	})
+	got := c.plannedImageOutputs(filepath.Join("out", "IMG_0001-2.jpg"), false, nil)
+	want := []string{"IMG_0001-2.jpg", "IMG_0001-2_web.jpg", "IMG_0001-2_thumb.webp"}
+	if len(got) != len(want) {
+		t.Fatalf("planned %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != filepath.Join("out", want[i]) {
+			t.Errorf("planned %v, want %v", got, want)
+			break
+		}
+	}
+
+	if fmt.Sprint(NewHEICConverter(true).plannedImageOutputs("a.png", false, nil)) != "[a.png]" {
+		t.Error("without renditions only the output is planned")
+	}
+}
+
+func TestPlannedAuxiliaryOutputs(t *testing.T) {
+	aux := []auxiliaryImage{
+		{ID: 2, URN: "urn:com:apple:photo:2020:aux:hdrgainmap"},
+		{ID: 3, URN: "urn:mpeg:hevc:2015:auxid:2"},
+		{ID: 4, URN: "urn:com:apple:photo:2018:aux:portraiteffectsmatte"},
+		{ID: 5, URN: "urn:mpeg:mpegB:cicp:systems:auxiliary:depth"},
+	}
+	if got := NewHEICConverter(true).plannedImageOutputs("IMG.jpg", false, aux); len(got) != 1 {
+		t.Errorf("planned %v without the export, want only the output", got)
+	}
+
+	c := NewHEICConverter(true).WithAuxiliaryImages(FormatPNG)
+	got := c.plannedImageOutputs("IMG.jpg", false, aux)
+	want := "[IMG.jpg IMG_depth.png IMG_portraiteffectsmatte.png IMG_depth2.png]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("planned %v, want %s", got, want)
+	}
+}
+
+func TestPlannedAlphaOutputs(t *testing.T) {
+	c := NewHEICConverter(true).WithAlphaFormat(FormatPNG).WithRenditions([]Rendition{
+		{Suffix: "_web"},
+		{Suffix: "_thumb", Format: FormatWebP},
+	})
+	tests := []struct {
+		hasAlpha bool
+		want     string
+	}{
+		{false, "[IMG.jpg IMG_web.jpg IMG_thumb.webp]"},
+		// Only outputs whose format cannot store transparency switch
+		{true, "[IMG.png IMG_web.png IMG_thumb.webp]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(c.plannedImageOutputs("IMG.jpg", tt.hasAlpha, nil)); got != tt.want {
+			t.Errorf("alpha plane %v: planned %s, want %s", tt.hasAlpha, got, tt.want)
+		}
+		// The planned paths are those written, in the format written
+		for _, output := range c.outputs("IMG.jpg", tt.hasAlpha) {
+			if filepath.Ext(output.path) != output.format.Extension() {
+				t.Errorf("alpha plane %v: %s written as %s", tt.hasAlpha, output.path, output.format)
+			}
+		}
+	}
+
+	// Without an alpha format the configured format is kept and flattened
+	plain := NewHEICConverter(true)
+	if got := fmt.Sprint(plain.plannedImageOutputs("IMG.jpg", true, nil)); got != "[IMG.jpg]" {
+		t.Errorf("planned %s without an alpha format, want [IMG.jpg]", got)
+	}
+}
+
+func TestValidateRenditionsWithAlphaFormat(t *testing.T) {
+	renditions := []Rendition{{Suffix: "_a"}, {Suffix: "_a", Format: FormatPNG}}
+	if err := NewHEICConverter(true).WithRenditions(renditions).validate(); err != nil {
+		t.Errorf("validate = %v, want no error for different extensions", err)
+	}
+	// Images with an alpha plane would write both renditions as PNG
+	err := NewHEICConverter(true).WithRenditions(renditions).WithAlphaFormat(FormatPNG).validate()
+	if !errors.Is(err, errors.ErrInvalidInput) {
+		t.Errorf("validate = %v, want invalid_input", err)
+	}
+}
@@ -0,0 +1,142 @@
+package converter
+
+import (
+	"context"
+	"io"
+
+	"github.com/spenceriam/HEIC-2-Go/internal/errors"
+)
+
+// Stage identifies a step of a conversion
+type Stage int
+
+const (
+	// StageRead reads the input file
+	StageRead Stage = iota
+	// StageDecode decodes the HEIC image
+	StageDecode
+	// StageColor converts the colors or prepares the color profile
+	StageColor
+	// StageEncode encodes the output image
+	StageEncode
+	// StageMetadata embeds EXIF and ICC metadata in the output
+	StageMetadata
+	// StageWrite writes the output file
+	StageWrite
+	// StageDone means the conversion is complete
+	StageDone
+)
+
+// stagePercent is the overall progress at the start of each stage, roughly
+// following the time the stages take for a typical photo
+var stagePercent = [...]int{
+	StageRead:     0,
+	StageDecode:   10,
+	StageColor:    60,
+	StageEncode:   65,
+	StageMetadata: 90,
+	StageWrite:    95,
+	StageDone:     100,
+}
+
+// String returns a short description of the stage
+func (s Stage) String() string {
+	switch s {
+	case StageRead:
+		return "Reading"
+	case StageDecode:
+		return "Decoding"
+	case StageColor:
+		return "Converting colors"
+	case StageEncode:
+		return "Encoding"
+	case StageMetadata:
+		return "Writing metadata"
+	case StageWrite:
+		return "Saving"
+	case StageDone:
+		return "Done"
+	default:
+		return "Unknown"
+	}
+}
+
+// Progress reports how far a conversion has come
+type Progress struct {
+	Stage Stage
+	// Bytes done and total bytes of the stage, set while reading the input
+	Bytes      int64
+	TotalBytes int64
+	// Overall progress of the conversion (0-100)
+	Percent int
+	// Output file, set for StageWrite and StageDone. It can differ from the
+	// requested path, e.g. for files with several images or transparency.
+	Output string
+}
+
+// ProgressFunc receives progress updates. It is called on the goroutine that
+// runs Convert, so a converter shared between goroutines calls it concurrently.
+type ProgressFunc func(Progress)
+
+// chunkSize is the amount of data read or written between two progress
+// updates and cancellation checks
+const chunkSize = 256 * 1024
+
+// report sends a progress update for the start of a stage
+func (c *HEICConverter) report(stage Stage) {
+	if c.progress != nil {
+		c.progress(Progress{Stage: stage, Percent: stagePercent[stage]})
+	}
+}
+
+// reportOutput sends a progress update for a stage that writes an output file
+func (c *HEICConverter) reportOutput(stage Stage, outputPath string) {
+	if c.progress != nil {
+		c.progress(Progress{Stage: stage, Percent: stagePercent[stage], Output: outputPath})
+	}
+}
+
+// reportBytes sends a progress update for part of a stage that processes bytes
+func (c *HEICConverter) reportBytes(stage Stage, done, total int64) {
+	if c.progress == nil {
+		return
+	}
+	percent := stagePercent[stage]
+	if total > 0 {
+		span := int64(stagePercent[stage+1] - stagePercent[stage])
+		percent += int(span * done / total)
+	}
+	c.progress(Progress{Stage: stage, Bytes: done, TotalBytes: total, Percent: percent})
+}
+
+// checkCancelled returns an ErrCancelled error if the context is done
+func checkCancelled(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return errors.Wrap(err, errors.ErrCancelled, "conversion cancelled")
+	}
+	return nil
+}
+
+// readWithProgress reads size bytes from r in chunks and reports the bytes
+// read after each chunk
+func (c *HEICConverter) readWithProgress(ctx context.Context, r io.Reader, size int64) ([]byte, error) {
+	data := make([]byte, size)
+	var done int64
+	c.reportBytes(StageRead, 0, size)
+	for done < size {
+		if err := checkCancelled(ctx); err != nil {
+			return nil, err
+		}
+		end := done + chunkSize
+		if end > size {
+			end = size
+		}
+		n, err := io.ReadFull(r, data[done:end])
+		done += int64(n)
+		if err != nil {
+			return nil, err
+		}
+		c.reportBytes(StageRead, done, size)
+	}
+	return data, nil
+}
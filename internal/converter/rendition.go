@@ -0,0 +1,172 @@
+package converter
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spenceriam/HEIC-2-Go/internal/errors"
+)
+
+// Rendition is an additional output written from the same decoded image as
+// the main output, e.g. a web version or a thumbnail
+type Rendition struct {
+	// Suffix added to the output file name, e.g. "_web" for "IMG_0001_web.jpg"
+	Suffix string
+	// Output format, empty for the converter's format
+	Format OutputFormat
+	// Quality of lossy formats (1-100), 0 for the converter's quality
+	Quality int
+	// Size of the rendition. An empty mode or filter uses the defaults.
+	Resize ResizeOptions
+}
+
+// ParseRendition parses a rendition given as a suffix optionally followed by
+// a colon and comma-separated settings, e.g. "_thumb:max=320,quality=75".
+// The settings are max (a size like 1600 or 1600x1200), width, height,
+// scale, fit, filter, format and quality.
+func ParseRendition(spec string) (Rendition, error) {
+	suffix, settings, _ := strings.Cut(strings.TrimSpace(spec), ":")
+	r := Rendition{Suffix: suffix}
+
+	for _, setting := range strings.Split(settings, ",") {
+		if strings.TrimSpace(setting) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(setting, "=")
+		if !ok {
+			return r, fmt.Errorf("invalid rendition setting: %s", setting)
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+
+		var err error
+		switch key {
+		case "max":
+			width, height, found := strings.Cut(strings.ToLower(value), "x")
+			if !found {
+				height = width
+			}
+			if r.Resize.MaxWidth, err = strconv.Atoi(width); err == nil {
+				r.Resize.MaxHeight, err = strconv.Atoi(height)
+			}
+		case "width":
+			r.Resize.MaxWidth, err = strconv.Atoi(value)
+		case "height":
+			r.Resize.MaxHeight, err = strconv.Atoi(value)
+		case "scale":
+			r.Resize.Scale, err = strconv.Atoi(strings.TrimSuffix(value, "%"))
+		case "fit":
+			r.Resize.Mode, err = ParseResizeMode(value)
+		case "filter":
+			r.Resize.Filter, err = ParseResampleFilter(value)
+		case "format":
+			r.Format, err = ParseOutputFormat(value)
+		case "quality":
+			r.Quality, err = strconv.Atoi(value)
+		default:
+			return r, fmt.Errorf("unknown rendition setting: %s", key)
+		}
+		if err != nil {
+			return r, fmt.Errorf("invalid rendition setting %s: %s", key, value)
+		}
+	}
+
+	return r, r.withDefaults(DefaultResizeOptions()).Validate()
+}
+
+// withDefaults fills in the resize mode and filter if they are not set
+func (r Rendition) withDefaults(resize ResizeOptions) Rendition {
+	if r.Resize.Mode == "" {
+		r.Resize.Mode = resize.Mode
+	}
+	if r.Resize.Filter == "" {
+		r.Resize.Filter = resize.Filter
+	}
+	return r
+}
+
+// Validate checks that the rendition settings are within their allowed ranges
+func (r Rendition) Validate() error {
+	if r.Suffix == "" || strings.ContainsAny(r.Suffix, `/\`) || r.Suffix == "." || r.Suffix == ".." {
+		return errors.InvalidInput("rendition suffix", r.Suffix)
+	}
+	if _, ok := formats[r.Format]; r.Format != "" && !ok {
+		return errors.InvalidInput("rendition format", r.Format)
+	}
+	if r.Quality < 0 || r.Quality > 100 {
+		return errors.InvalidInput("rendition quality", r.Quality)
+	}
+	return r.Resize.Validate()
+}
+
+// RenditionOutputPath returns the output path of a rendition of the image
+// written to outputPath, e.g. "IMG_0001_web.jpg"
+func RenditionOutputPath(outputPath, suffix string, format OutputFormat) string {
+	ext := filepath.Ext(outputPath)
+	return strings.TrimSuffix(outputPath, ext) + suffix + format.Extension()
+}
+
+// imageOutput is one output file written from a decoded image
+type imageOutput struct {
+	path    string
+	format  OutputFormat
+	options Options
+	resize  ResizeOptions
+}
+
+// outputs returns the main output and the renditions of an image. Outputs
+// of images with an alpha plane use the alpha format, if one is set, when
+// their format cannot store transparency.
+func (c *HEICConverter) outputs(outputPath string, hasAlpha bool) []imageOutput {
+	format := c.outputFormat(c.format, hasAlpha)
+	if format != c.format {
+		outputPath = strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + format.Extension()
+	}
+	outputs := []imageOutput{{path: outputPath, format: format, options: c.options, resize: c.resize}}
+	for _, r := range c.renditions {
+		r = r.withDefaults(c.resize)
+		output := imageOutput{format: r.Format, options: c.options, resize: r.Resize}
+		if output.format == "" {
+			output.format = c.format
+		}
+		output.format = c.outputFormat(output.format, hasAlpha)
+		if r.Quality != 0 {
+			output.options.Quality = r.Quality
+		}
+		output.path = RenditionOutputPath(outputPath, r.Suffix, output.format)
+		outputs = append(outputs, output)
+	}
+	return outputs
+}
+
+// outputFormat returns the format written for an output in the given
+// format: the alpha format for images with an alpha plane if the format
+// cannot store transparency and an alpha format is set
+func (c *HEICConverter) outputFormat(format OutputFormat, hasAlpha bool) OutputFormat {
+	if hasAlpha && c.alphaFormat != "" && !format.SupportsAlpha() {
+		return c.alphaFormat
+	}
+	return format
+}
+
+// validateRenditions checks the renditions and that no two of them write
+// the same file, with or without the alpha format
+func (c *HEICConverter) validateRenditions() error {
+	for _, r := range c.renditions {
+		if err := r.withDefaults(c.resize).Validate(); err != nil {
+			return err
+		}
+	}
+	for _, hasAlpha := range []bool{false, true} {
+		seen := map[string]bool{}
+		for i, output := range c.outputs("", hasAlpha)[1:] {
+			key := strings.ToLower(output.path)
+			if seen[key] {
+				return errors.InvalidInput("rendition suffix", c.renditions[i].Suffix).WithDetails("two renditions write the same file")
+			}
+			seen[key] = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package converter
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/spenceriam/HEIC-2-Go/internal/errors"
+	xdraw "golang.org/x/image/draw"
+)
+
+// ResizeMode selects how an image is fitted into the maximum dimensions
+type ResizeMode string
+
+const (
+	// ResizeFit scales the image down to fit within the maximum dimensions,
+	// keeping its aspect ratio
+	ResizeFit ResizeMode = "fit"
+	// ResizeFill scales the image down to cover the maximum dimensions and
+	// crops what extends beyond them, around the center
+	ResizeFill ResizeMode = "fill"
+	// ResizeCrop cuts the center of the image to the maximum dimensions
+	// without scaling it
+	ResizeCrop ResizeMode = "crop"
+)
+
+// ParseResizeMode parses a resize mode name such as "fit" or "fill"
+func ParseResizeMode(name string) (ResizeMode, error) {
+	switch mode := ResizeMode(strings.ToLower(strings.TrimSpace(name))); mode {
+	case ResizeFit, ResizeFill, ResizeCrop:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unsupported resize mode: %s", name)
+}
+
+// ResampleFilter selects the filter used to scale images
+type ResampleFilter string
+
+const (
+	// FilterLanczos is a sharp, high-quality filter, best for photos
+	FilterLanczos ResampleFilter = "lanczos"
+	// FilterCatmullRom is slightly softer and faster than Lanczos
+	FilterCatmullRom ResampleFilter = "catmull-rom"
+	// FilterLinear is a fast bilinear filter
+	FilterLinear ResampleFilter = "linear"
+	// FilterBox averages the source pixels, fast for large reductions
+	FilterBox ResampleFilter = "box"
+	// FilterNearest picks the nearest pixel, keeping hard edges
+	FilterNearest ResampleFilter = "nearest"
+)
+
+// resampleFilters maps the filters to their imaging implementation
+var resampleFilters = map[ResampleFilter]imaging.ResampleFilter{
+	FilterLanczos:    imaging.Lanczos,
+	FilterCatmullRom: imaging.CatmullRom,
+	FilterLinear:     imaging.Linear,
+	FilterBox:        imaging.Box,
+	FilterNearest:    imaging.NearestNeighbor,
+}
+
+// ResampleFilters returns the supported resampling filters
+func ResampleFilters() []ResampleFilter {
+	return []ResampleFilter{FilterLanczos, FilterCatmullRom, FilterLinear, FilterBox, FilterNearest}
+}
+
+// ParseResampleFilter parses a filter name such as "lanczos" or "catmull-rom"
+func ParseResampleFilter(name string) (ResampleFilter, error) {
+	name = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), "_", "-")
+	if name == "catmullrom" {
+		name = string(FilterCatmullRom)
+	}
+	if _, ok := resampleFilters[ResampleFilter(name)]; ok {
+		return ResampleFilter(name), nil
+	}
+	return "", fmt.Errorf("unsupported resampling filter: %s", name)
+}
+
+// ResizeOptions holds the resize settings used by the converter. Images are
+// first scaled by Scale, then fitted into MaxWidth and MaxHeight according to
+// Mode. Images are never enlarged.
+type ResizeOptions struct {
+	// Maximum output width and height in pixels, 0 for no limit
+	MaxWidth  int
+	MaxHeight int
+	// Scale in percent (1-100), 0 to keep the original size
+	Scale int
+	// How the image is fitted into the maximum dimensions
+	Mode ResizeMode
+	// Filter used for scaling
+	Filter ResampleFilter
+}
+
+// DefaultResizeOptions returns resize settings that keep the original size
+func DefaultResizeOptions() ResizeOptions {
+	return ResizeOptions{
+		Mode:   ResizeFit,
+		Filter: FilterLanczos,
+	}
+}
+
+// Validate checks that the resize options are within their allowed ranges
+func (r ResizeOptions) Validate() error {
+	if r.MaxWidth < 0 {
+		return errors.InvalidInput("maximum width", r.MaxWidth)
+	}
+	if r.MaxHeight < 0 {
+		return errors.InvalidInput("maximum height", r.MaxHeight)
+	}
+	if r.Scale < 0 || r.Scale > 100 {
+		return errors.InvalidInput("scale", r.Scale)
+	}
+	if _, err := ParseResizeMode(string(r.Mode)); err != nil {
+		return errors.InvalidInput("resize mode", r.Mode)
+	}
+	if r.Mode != ResizeFit && (r.MaxWidth == 0 || r.MaxHeight == 0) {
+		return errors.InvalidInput("resize mode", r.Mode).
+			WithDetails("fill and crop need both a maximum width and height")
+	}
+	if _, ok := resampleFilters[r.Filter]; !ok {
+		return errors.InvalidInput("resampling filter", r.Filter)
+	}
+	return nil
+}
+
+// crops reports whether the options cut off parts of the image
+func (r ResizeOptions) crops() bool {
+	return r.Mode != ResizeFit && r.MaxWidth > 0 && r.MaxHeight > 0
+}
+
+// geometry returns the size an image of the given size is scaled to, and the
+// size of the centered region that is then kept
+func (r ResizeOptions) geometry(width, height int) (scaledW, scaledH, cropW, cropH int) {
+	w, h := float64(width), float64(height)
+	if r.Scale > 0 && r.Scale < 100 {
+		w, h = w*float64(r.Scale)/100, h*float64(r.Scale)/100
+	}
+
+	maxW, maxH := float64(r.MaxWidth), float64(r.MaxHeight)
+	switch r.Mode {
+	case ResizeFit:
+		factor := 1.0
+		if r.MaxWidth > 0 && w > maxW {
+			factor = maxW / w
+		}
+		if r.MaxHeight > 0 && h*factor > maxH {
+			factor = maxH / h
+		}
+		w, h = w*factor, h*factor
+	case ResizeFill:
+		if factor := math.Max(maxW/w, maxH/h); factor < 1 {
+			w, h = w*factor, h*factor
+		}
+	}
+
+	scaledW, scaledH = roundSize(w), roundSize(h)
+	cropW, cropH = scaledW, scaledH
+	if r.crops() {
+		cropW, cropH = min(cropW, r.MaxWidth), min(cropH, r.MaxHeight)
+	}
+	return scaledW, scaledH, cropW, cropH
+}
+
+// resizeImage scales and crops an image according to the resize options.
+// 16-bit images keep their precision.
+func resizeImage(img image.Image, r ResizeOptions) image.Image {
+	bounds := img.Bounds()
+	scaledW, scaledH, cropW, cropH := r.geometry(bounds.Dx(), bounds.Dy())
+
+	if scaledW != bounds.Dx() || scaledH != bounds.Dy() {
+		img = scaleImage(img, scaledW, scaledH, r.Filter)
+	}
+	if cropW != scaledW || cropH != scaledH {
+		img = cropCenter(img, cropW, cropH)
+	}
+	return img
+}
+
+// scaleImage resamples an image to the given size
+func scaleImage(img image.Image, width, height int, filter ResampleFilter) image.Image {
+	resample := resampleFilters[filter]
+	if !isHighBitDepth(img) {
+		return imaging.Resize(img, width, height, resample)
+	}
+
+	// imaging only produces 8-bit images, so use its kernel with x/image
+	var scaler xdraw.Scaler = xdraw.NearestNeighbor
+	if resample.Kernel != nil {
+		scaler = &xdraw.Kernel{Support: resample.Support, At: resample.Kernel}
+	}
+	dst := image.NewNRGBA64(image.Rect(0, 0, width, height))
+	scaler.Scale(dst, dst.Bounds(), img, img.Bounds(), xdraw.Src, nil)
+	return dst
+}
+
+// cropCenter cuts the centered region of the given size out of an image
+func cropCenter(img image.Image, width, height int) image.Image {
+	bounds := img.Bounds()
+	origin := image.Pt(bounds.Min.X+(bounds.Dx()-width)/2, bounds.Min.Y+(bounds.Dy()-height)/2)
+	rect := image.Rectangle{Min: origin, Max: origin.Add(image.Pt(width, height))}
+
+	src, ok := img.(*image.NRGBA64)
+	if !ok {
+		return imaging.Crop(img, rect)
+	}
+	dst := image.NewNRGBA64(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		start := src.PixOffset(rect.Min.X, rect.Min.Y+y)
+		copy(dst.Pix[y*dst.Stride:(y+1)*dst.Stride], src.Pix[start:])
+	}
+	return dst
+}
+
+// roundSize rounds a scaled dimension to whole pixels, keeping at least one
+func roundSize(v float64) int {
+	if n := int(math.Round(v)); n > 1 {
+		return n
+	}
+	return 1
+}
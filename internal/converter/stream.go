@@ -0,0 +1,133 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spenceriam/HEIC-2-Go/internal/errors"
+)
+
+// ConvertStream converts a HEIC file read from r and writes the converted
+// image to w, e.g. for uploads held in memory or data piped through stdin
+// and stdout. It converts the configured image or the primary image. Only
+// one output is written, so multiple images, renditions, auxiliary images
+// and a separate alpha format are not supported.
+func (c *HEICConverter) ConvertStream(ctx context.Context, r io.Reader, w io.Writer) error {
+	encoded, err := c.encodeStream(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	c.report(StageWrite)
+	if _, err := w.Write(encoded.data); err != nil {
+		return errors.Wrap(err, errors.ErrFileWrite, "failed to write output")
+	}
+	c.report(StageDone)
+
+	return encoded.warning()
+}
+
+// ConvertStreamToFile converts a HEIC file read from r like ConvertStream
+// and saves the converted image to outputPath. The file is replaced only
+// once the image is complete, so a failed or cancelled conversion leaves an
+// existing file at outputPath untouched.
+func (c *HEICConverter) ConvertStreamToFile(ctx context.Context, r io.Reader, outputPath string) error {
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return errors.Wrap(err, errors.ErrDirCreate, "failed to create output directory")
+	}
+	encoded, err := c.encodeStream(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	c.reportOutput(StageWrite, outputPath)
+	if err := writeOutput(ctx, outputPath, encoded.data); err != nil {
+		return errors.Wrap(err, errors.ErrFileWrite, "failed to save output file")
+	}
+	c.reportOutput(StageDone, outputPath)
+
+	return encoded.warning()
+}
+
+// encodeStream reads a HEIC file from r and encodes the configured or the
+// primary image for the single output of a stream
+func (c *HEICConverter) encodeStream(ctx context.Context, r io.Reader) (*encodedImage, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	if err := c.validateStream(); err != nil {
+		return nil, err
+	}
+
+	data, err := c.readAllWithProgress(ctx, r)
+	if err != nil {
+		if errors.Is(err, errors.ErrCancelled) {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, errors.ErrFileRead, "failed to read HEIC data")
+	}
+
+	c.report(StageDecode)
+	heifCtx, err := openHEIF(data)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := c.selectImages(heifCtx)
+	if err != nil {
+		return nil, err
+	}
+	img, metadata, err := c.decodeImage(heifCtx, data, ids[0])
+	if err != nil {
+		return nil, errors.Wrap(err, errors.ErrDecodeFailed, "failed to decode HEIC file")
+	}
+	if err := checkCancelled(ctx); err != nil {
+		return nil, err
+	}
+
+	return c.encodeImage(ctx, img, metadata, data, ids[0], c.outputs("", metadata.HasAlpha)[0])
+}
+
+// validateStream rejects settings that need more than one output file
+func (c *HEICConverter) validateStream() error {
+	var setting string
+	switch {
+	case c.allImages:
+		setting = "all images"
+	case len(c.renditions) > 0:
+		setting = "renditions"
+	case c.auxFormat != "":
+		setting = "auxiliary images"
+	case c.alphaFormat != "":
+		setting = "alpha format"
+	default:
+		return nil
+	}
+	return errors.New(errors.ErrNotSupported, "not supported when converting a stream").WithDetails(setting)
+}
+
+// readAllWithProgress reads r until EOF in chunks and reports the bytes read
+// after each chunk. The total size is unknown, so the progress stays at the
+// start of the read stage.
+func (c *HEICConverter) readAllWithProgress(ctx context.Context, r io.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	chunk := make([]byte, chunkSize)
+	c.reportBytes(StageRead, 0, 0)
+	for {
+		if err := checkCancelled(ctx); err != nil {
+			return nil, err
+		}
+		n, err := io.ReadFull(r, chunk)
+		buf.Write(chunk[:n])
+		c.reportBytes(StageRead, int64(buf.Len()), 0)
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			return buf.Bytes(), nil
+		default:
+			return nil, err
+		}
+	}
+}
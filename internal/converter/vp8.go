@@ -0,0 +1,1172 @@
+package converter
+
+import (
+	stderrors "errors"
+	"fmt"
+	"image"
+)
+
+// Lossy WebP (VP8) bitstream constants, see RFC 6386
+const (
+	vp8MaxDimension      = 1<<14 - 1
+	vp8MaxPartitions     = 8
+	vp8MaxPartitionSize  = 1<<24 - 1
+	vp8MaxFirstPartition = 1<<19 - 1
+	vp8MaxLevel          = 2048
+
+	// Intra prediction modes of 16x16 luma and 8x8 chroma blocks
+	vp8PredDC = 0
+	vp8PredV  = 1
+	vp8PredH  = 2
+	vp8PredTM = 3
+
+	// Token probability planes
+	vp8PlaneY1 = 0
+	vp8PlaneY2 = 1
+	vp8PlaneUV = 2
+
+	// Pixels that decoders assume above and left of the frame
+	vp8EdgeAbove = 127
+	vp8EdgeLeft  = 129
+)
+
+var (
+	// vp8Zigzag is the order in which coefficients are coded
+	vp8Zigzag = [16]int{0, 1, 4, 8, 5, 2, 3, 6, 9, 12, 13, 10, 7, 11, 14, 15}
+	// vp8Bands maps coefficient positions to probability bands
+	vp8Bands = [17]int{0, 1, 2, 3, 6, 4, 5, 6, 6, 6, 6, 6, 6, 6, 6, 7, 0}
+	// vp8CatProbs are the probabilities of the extra bits of token
+	// categories 3 to 6
+	vp8CatProbs = [4][]uint8{
+		{173, 148, 140},
+		{176, 155, 140, 135},
+		{180, 157, 141, 134, 130},
+		{254, 254, 243, 230, 196, 177, 153, 140, 133, 130, 129},
+	}
+)
+
+// vp8BoolEncoder writes the boolean entropy-coded data of a VP8 partition
+type vp8BoolEncoder struct {
+	buf      []byte
+	rng      uint32
+	bottom   uint32
+	bitCount int
+}
+
+// newVP8BoolEncoder creates an empty partition
+func newVP8BoolEncoder() *vp8BoolEncoder {
+	return &vp8BoolEncoder{rng: 255, bitCount: 24}
+}
+
+// writeBool writes a bit that is false with probability prob/256
+func (e *vp8BoolEncoder) writeBool(prob uint8, bit bool) {
+	split := 1 + (e.rng-1)*uint32(prob)>>8
+	if bit {
+		e.bottom += split
+		e.rng -= split
+	} else {
+		e.rng = split
+	}
+	for e.rng < 128 {
+		e.rng <<= 1
+		if e.bottom&(1<<31) != 0 {
+			e.carry()
+		}
+		e.bottom <<= 1
+		e.bitCount--
+		if e.bitCount == 0 {
+			e.buf = append(e.buf, byte(e.bottom>>24))
+			e.bottom &= 1<<24 - 1
+			e.bitCount = 8
+		}
+	}
+}
+
+// carry propagates a carry into the bytes already written
+func (e *vp8BoolEncoder) carry() {
+	for i := len(e.buf) - 1; i >= 0; i-- {
+		e.buf[i]++
+		if e.buf[i] != 0 {
+			return
+		}
+	}
+}
+
+// writeLiteral writes the low n bits of value, most significant first
+func (e *vp8BoolEncoder) writeLiteral(value uint32, n int) {
+	for i := n - 1; i >= 0; i-- {
+		e.writeBool(128, value>>uint(i)&1 != 0)
+	}
+}
+
+// bytes flushes the pending bits and returns the partition
+func (e *vp8BoolEncoder) bytes() []byte {
+	for i := 0; i < 32; i++ {
+		e.writeBool(128, false)
+	}
+	return e.buf
+}
+
+// writeCoefficients writes the quantized coefficients of a 4x4 block from
+// position first on, in zigzag order, and returns 1 if any was coded
+func (e *vp8BoolEncoder) writeCoefficients(levels *[16]int32, plane, context, first int) uint8 {
+	probs := &vp8DefaultTokenProb[plane]
+	last := -1
+	for i := 15; i >= first; i-- {
+		if levels[vp8Zigzag[i]] != 0 {
+			last = i
+			break
+		}
+	}
+
+	p := &probs[vp8Bands[first]][context]
+	if last < 0 {
+		e.writeBool(p[0], false)
+		return 0
+	}
+	e.writeBool(p[0], true)
+	for i := first; i <= last; i++ {
+		level := levels[vp8Zigzag[i]]
+		if level == 0 {
+			// A zero is never followed by the end of the block
+			e.writeBool(p[1], false)
+			p = &probs[vp8Bands[i+1]][0]
+			continue
+		}
+		e.writeBool(p[1], true)
+		magnitude := level
+		if magnitude < 0 {
+			magnitude = -magnitude
+		}
+		e.writeTokenValue(p, magnitude)
+		if magnitude == 1 {
+			p = &probs[vp8Bands[i+1]][1]
+		} else {
+			p = &probs[vp8Bands[i+1]][2]
+		}
+		e.writeBool(128, level < 0)
+		if i < 15 {
+			e.writeBool(p[0], i != last)
+		}
+	}
+	return 1
+}
+
+// writeTokenValue writes the token of a non-zero coefficient magnitude
+func (e *vp8BoolEncoder) writeTokenValue(p *[11]uint8, v int32) {
+	switch {
+	case v == 1:
+		e.writeBool(p[2], false)
+	case v <= 4:
+		e.writeBool(p[2], true)
+		e.writeBool(p[3], false)
+		if v == 2 {
+			e.writeBool(p[4], false)
+		} else {
+			e.writeBool(p[4], true)
+			e.writeBool(p[5], v == 4)
+		}
+	case v <= 10:
+		e.writeBool(p[2], true)
+		e.writeBool(p[3], true)
+		e.writeBool(p[6], false)
+		if v <= 6 {
+			e.writeBool(p[7], false)
+			e.writeBool(159, v == 6)
+		} else {
+			e.writeBool(p[7], true)
+			e.writeBool(165, (v-7)>>1 != 0)
+			e.writeBool(145, (v-7)&1 != 0)
+		}
+	default:
+		e.writeBool(p[2], true)
+		e.writeBool(p[3], true)
+		e.writeBool(p[6], true)
+		cat := 3
+		switch {
+		case v <= 18:
+			cat = 0
+		case v <= 34:
+			cat = 1
+		case v <= 66:
+			cat = 2
+		}
+		e.writeBool(p[8], cat >= 2)
+		e.writeBool(p[9+cat>>1], cat&1 != 0)
+		extra := uint32(v - 3 - 8<<uint(cat))
+		probs := vp8CatProbs[cat]
+		for i, prob := range probs {
+			e.writeBool(prob, extra>>uint(len(probs)-1-i)&1 != 0)
+		}
+	}
+}
+
+// vp8Encoder holds the state of a key frame being encoded
+type vp8Encoder struct {
+	mbw, mbh int
+	// Source planes, padded to whole macroblocks
+	y, u, v []uint8
+	// Reconstructed planes as decoders see them before loop filtering,
+	// which intra prediction works from
+	ry, ru, rv       []uint8
+	yStride, cStride int
+
+	// DC and AC quantizer steps
+	y1, y2, uv [2]int32
+
+	header *vp8BoolEncoder
+	// Whether the blocks above each macroblock and to the left of the
+	// current one have coefficients: 4 luma, 2 U and 2 V columns or rows,
+	// then Y2
+	topNZ  [][9]uint8
+	leftNZ [9]uint8
+}
+
+// encodeVP8 encodes an image as a lossy VP8 key frame. Quality ranges from 1
+// to 100; only 16x16 intra prediction is used.
+func encodeVP8(img *image.NRGBA, quality int) ([]byte, error) {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	if width < 1 || height < 1 || width > vp8MaxDimension || height > vp8MaxDimension {
+		return nil, fmt.Errorf("lossy WebP images are limited to %dx%d pixels, got %dx%d",
+			vp8MaxDimension, vp8MaxDimension, width, height)
+	}
+
+	e := newVP8Encoder(img)
+	q := vp8QuantIndex(quality)
+	e.y1 = [2]int32{vp8DCQuant[q], vp8ACQuant[q]}
+	e.y2 = [2]int32{vp8DCQuant[q] * 2, vp8ACQuant[q] * 155 / 100}
+	if e.y2[1] < 8 {
+		e.y2[1] = 8
+	}
+	uvDC := q
+	if uvDC > 117 {
+		uvDC = 117
+	}
+	e.uv = [2]int32{vp8DCQuant[uvDC], vp8ACQuant[q]}
+
+	// Rows of macroblocks alternate between up to 8 token partitions
+	log2Partitions := 0
+	for 1<<(log2Partitions+1) <= vp8MaxPartitions && 1<<(log2Partitions+1) <= e.mbh {
+		log2Partitions++
+	}
+	numPartitions := 1 << log2Partitions
+	partitions := make([]*vp8BoolEncoder, numPartitions)
+	for i := range partitions {
+		partitions[i] = newVP8BoolEncoder()
+	}
+
+	h := e.header
+	h.writeLiteral(0, 1) // color space
+	h.writeLiteral(0, 1) // clamping type
+	h.writeLiteral(0, 1) // no segmentation
+	h.writeLiteral(0, 1) // normal loop filter
+	h.writeLiteral(uint32(vp8FilterLevel(q)), 6)
+	h.writeLiteral(0, 3) // sharpness
+	h.writeLiteral(0, 1) // no loop filter deltas
+	h.writeLiteral(uint32(log2Partitions), 2)
+	h.writeLiteral(uint32(q), 7)
+	for i := 0; i < 5; i++ {
+		h.writeLiteral(0, 1) // no quantizer deltas
+	}
+	h.writeLiteral(0, 1) // refresh entropy probabilities
+	for i := range vp8TokenProbUpdateProb {
+		for j := range vp8TokenProbUpdateProb[i] {
+			for k := range vp8TokenProbUpdateProb[i][j] {
+				for _, prob := range vp8TokenProbUpdateProb[i][j][k] {
+					h.writeBool(prob, false)
+				}
+			}
+		}
+	}
+	h.writeLiteral(0, 1) // every macroblock codes its coefficients
+
+	for mby := 0; mby < e.mbh; mby++ {
+		e.leftNZ = [9]uint8{}
+		tokens := partitions[mby&(numPartitions-1)]
+		for mbx := 0; mbx < e.mbw; mbx++ {
+			e.encodeMacroblock(tokens, mbx, mby)
+		}
+	}
+
+	first := h.bytes()
+	if len(first) > vp8MaxFirstPartition {
+		return nil, stderrors.New("image has too many macroblocks for lossy WebP")
+	}
+	tag := uint32(len(first))<<5 | 1<<4 // key frame, version 0, shown
+	frame := []byte{byte(tag), byte(tag >> 8), byte(tag >> 16), 0x9d, 0x01, 0x2a,
+		byte(width), byte(width >> 8), byte(height), byte(height >> 8)}
+	frame = append(frame, first...)
+	sizes := make([]byte, 0, 3*(numPartitions-1))
+	var tokens []byte
+	for i, p := range partitions {
+		data := p.bytes()
+		if len(data) > vp8MaxPartitionSize {
+			return nil, stderrors.New("lossy WebP partition exceeds 16 MiB, lower the quality")
+		}
+		if i < numPartitions-1 {
+			sizes = append(sizes, byte(len(data)), byte(len(data)>>8), byte(len(data)>>16))
+		}
+		tokens = append(tokens, data...)
+	}
+	frame = append(frame, sizes...)
+	frame = append(frame, tokens...)
+	return frame, nil
+}
+
+// vp8QuantIndex maps a quality of 1 to 100 onto the quantizer index 127 to 0
+func vp8QuantIndex(quality int) int {
+	if quality < 1 {
+		quality = 1
+	}
+	if quality > 100 {
+		quality = 100
+	}
+	return (100 - quality) * 127 / 99
+}
+
+// vp8FilterLevel returns the loop filter strength for a quantizer index
+func vp8FilterLevel(q int) int {
+	level := q / 2
+	if level > 63 {
+		level = 63
+	}
+	return level
+}
+
+// newVP8Encoder converts an image to YUV 4:2:0 planes padded to whole
+// macroblocks by repeating the edge pixels
+func newVP8Encoder(img *image.NRGBA) *vp8Encoder {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	e := &vp8Encoder{
+		mbw:    (width + 15) / 16,
+		mbh:    (height + 15) / 16,
+		header: newVP8BoolEncoder(),
+	}
+	e.yStride, e.cStride = 16*e.mbw, 8*e.mbw
+	e.y = make([]uint8, e.yStride*16*e.mbh)
+	e.u = make([]uint8, e.cStride*8*e.mbh)
+	e.v = make([]uint8, e.cStride*8*e.mbh)
+	e.ry = make([]uint8, len(e.y))
+	e.ru = make([]uint8, len(e.u))
+	e.rv = make([]uint8, len(e.v))
+	e.topNZ = make([][9]uint8, e.mbw)
+
+	pixel := func(x, y int) (int, int, int) {
+		if x >= width {
+			x = width - 1
+		}
+		if y >= height {
+			y = height - 1
+		}
+		p := img.Pix[img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y):]
+		return int(p[0]), int(p[1]), int(p[2])
+	}
+	for y := 0; y < 16*e.mbh; y++ {
+		for x := 0; x < e.yStride; x++ {
+			r, g, b := pixel(x, y)
+			e.y[y*e.yStride+x] = vp8RGBToY(r, g, b)
+		}
+	}
+	for y := 0; y < 8*e.mbh; y++ {
+		for x := 0; x < e.cStride; x++ {
+			var r, g, b int
+			for _, d := range [4][2]int{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+				pr, pg, pb := pixel(2*x+d[0], 2*y+d[1])
+				r, g, b = r+pr, g+pg, b+pb
+			}
+			e.u[y*e.cStride+x], e.v[y*e.cStride+x] = vp8RGBToUV(r, g, b)
+		}
+	}
+	return e
+}
+
+// vp8RGBToY converts a pixel to limited-range BT.601 luma
+func vp8RGBToY(r, g, b int) uint8 {
+	return uint8((16839*r + 33059*g + 6420*b + 1<<15 + 16<<16) >> 16)
+}
+
+// vp8RGBToUV converts the sum of four pixels to limited-range BT.601 chroma
+func vp8RGBToUV(r, g, b int) (uint8, uint8) {
+	return vp8ClipUV(-9719*r - 19081*g + 28800*b), vp8ClipUV(28800*r - 24116*g - 4684*b)
+}
+
+// vp8ClipUV scales a chroma sum down and clamps it to 0..255
+func vp8ClipUV(v int) uint8 {
+	v = (v + 1<<17 + 128<<18) >> 18
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
+
+// encodeMacroblock predicts, transforms and codes one macroblock, writing
+// its modes to the first partition and its coefficients to tokens
+func (e *vp8Encoder) encodeMacroblock(tokens *vp8BoolEncoder, mbx, mby int) {
+	var y2Levels [16]int32
+	var yLevels [16][16]int32
+	yMode := e.encodeLuma(mbx, mby, &y2Levels, &yLevels)
+	var uLevels, vLevels [4][16]int32
+	cMode := e.encodeChroma(mbx, mby, &uLevels, &vLevels)
+
+	h := e.header
+	h.writeBool(145, true) // 16x16 prediction
+	switch yMode {
+	case vp8PredDC, vp8PredV:
+		h.writeBool(156, false)
+		h.writeBool(163, yMode == vp8PredV)
+	default:
+		h.writeBool(156, true)
+		h.writeBool(128, yMode == vp8PredTM)
+	}
+	h.writeBool(142, cMode != vp8PredDC)
+	if cMode != vp8PredDC {
+		h.writeBool(114, cMode != vp8PredV)
+		if cMode != vp8PredV {
+			h.writeBool(183, cMode == vp8PredTM)
+		}
+	}
+
+	top, left := &e.topNZ[mbx], &e.leftNZ
+	nz := tokens.writeCoefficients(&y2Levels, vp8PlaneY2, int(left[8]+top[8]), 0)
+	left[8], top[8] = nz, nz
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			nz := tokens.writeCoefficients(&yLevels[y*4+x], vp8PlaneY1, int(left[y]+top[x]), 1)
+			left[y], top[x] = nz, nz
+		}
+	}
+	for c, levels := range [2]*[4][16]int32{&uLevels, &vLevels} {
+		base := 4 + 2*c
+		for y := 0; y < 2; y++ {
+			for x := 0; x < 2; x++ {
+				nz := tokens.writeCoefficients(&levels[y*2+x], vp8PlaneUV, int(left[base+y]+top[base+x]), 0)
+				left[base+y], top[base+x] = nz, nz
+			}
+		}
+	}
+}
+
+// encodeLuma picks the luma prediction of a macroblock, quantizes its
+// residuals and reconstructs it, returning the mode
+func (e *vp8Encoder) encodeLuma(mbx, mby int, y2Levels *[16]int32, levels *[16][16]int32) int {
+	x0, y0 := 16*mbx, 16*mby
+	var pred [256]uint8
+	mode := e.predict(pred[:], e.y, e.ry, e.yStride, x0, y0, 16)
+
+	// Transform each 4x4 block and gather the DC coefficients for the WHT
+	var coeffs [16][16]int32
+	var dc [16]int32
+	for b := 0; b < 16; b++ {
+		bx, by := b%4*4, b/4*4
+		var residual [16]int32
+		for j := 0; j < 4; j++ {
+			for i := 0; i < 4; i++ {
+				src := e.y[(y0+by+j)*e.yStride+x0+bx+i]
+				residual[j*4+i] = int32(src) - int32(pred[(by+j)*16+bx+i])
+			}
+		}
+		vp8FDCT(&residual, &coeffs[b])
+		dc[b] = coeffs[b][0]
+	}
+	var wht, dequantized, dcOut [16]int32
+	vp8FWHT(&dc, &wht)
+	for i := range wht {
+		y2Levels[i] = vp8Quantize(wht[i], e.y2[btoi(i > 0)])
+		dequantized[i] = y2Levels[i] * e.y2[btoi(i > 0)]
+	}
+	vp8IWHT(&dequantized, &dcOut)
+
+	for b := 0; b < 16; b++ {
+		bx, by := b%4*4, b/4*4
+		var block [16]int32
+		block[0] = dcOut[b]
+		for k := 1; k < 16; k++ {
+			levels[b][k] = vp8Quantize(coeffs[b][k], e.y1[1])
+			block[k] = levels[b][k] * e.y1[1]
+		}
+		offset := (y0+by)*e.yStride + x0 + bx
+		for j := 0; j < 4; j++ {
+			copy(e.ry[offset+j*e.yStride:offset+j*e.yStride+4], pred[(by+j)*16+bx:])
+		}
+		vp8IDCT(&block, e.ry[offset:], e.yStride)
+	}
+	return mode
+}
+
+// encodeChroma picks the chroma prediction of a macroblock, quantizes the
+// residuals of both planes and reconstructs them, returning the mode
+func (e *vp8Encoder) encodeChroma(mbx, mby int, uLevels, vLevels *[4][16]int32) int {
+	x0, y0 := 8*mbx, 8*mby
+
+	// Both planes share a mode, chosen by their combined error
+	bestMode, bestErr := vp8PredDC, -1
+	var uPred, vPred [64]uint8
+	for mode := vp8PredDC; mode <= vp8PredTM; mode++ {
+		var up, vp [64]uint8
+		e.predictMode(up[:], e.ru, e.cStride, x0, y0, 8, mode)
+		e.predictMode(vp[:], e.rv, e.cStride, x0, y0, 8, mode)
+		err := vp8SSE(e.u, e.cStride, x0, y0, up[:], 8) + vp8SSE(e.v, e.cStride, x0, y0, vp[:], 8)
+		if bestErr < 0 || err < bestErr {
+			bestMode, bestErr, uPred, vPred = mode, err, up, vp
+		}
+	}
+
+	planes := []struct {
+		src, recon []uint8
+		pred       *[64]uint8
+		levels     *[4][16]int32
+	}{
+		{e.u, e.ru, &uPred, uLevels},
+		{e.v, e.rv, &vPred, vLevels},
+	}
+	for _, p := range planes {
+		for b := 0; b < 4; b++ {
+			bx, by := b%2*4, b/2*4
+			var residual, coeffs, block [16]int32
+			for j := 0; j < 4; j++ {
+				for i := 0; i < 4; i++ {
+					src := p.src[(y0+by+j)*e.cStride+x0+bx+i]
+					residual[j*4+i] = int32(src) - int32(p.pred[(by+j)*8+bx+i])
+				}
+			}
+			vp8FDCT(&residual, &coeffs)
+			for k := range coeffs {
+				p.levels[b][k] = vp8Quantize(coeffs[k], e.uv[btoi(k > 0)])
+				block[k] = p.levels[b][k] * e.uv[btoi(k > 0)]
+			}
+			offset := (y0+by)*e.cStride + x0 + bx
+			for j := 0; j < 4; j++ {
+				copy(p.recon[offset+j*e.cStride:offset+j*e.cStride+4], p.pred[(by+j)*8+bx:])
+			}
+			vp8IDCT(&block, p.recon[offset:], e.cStride)
+		}
+	}
+	return bestMode
+}
+
+// predict fills dst with the prediction of the mode that best matches the
+// source block and returns that mode
+func (e *vp8Encoder) predict(dst, src, recon []uint8, stride, x0, y0, size int) int {
+	bestMode, bestErr := vp8PredDC, -1
+	candidate := make([]uint8, size*size)
+	for mode := vp8PredDC; mode <= vp8PredTM; mode++ {
+		e.predictMode(candidate, recon, stride, x0, y0, size, mode)
+		if err := vp8SSE(src, stride, x0, y0, candidate, size); bestErr < 0 || err < bestErr {
+			bestMode, bestErr = mode, err
+			copy(dst, candidate)
+		}
+	}
+	return bestMode
+}
+
+// predictMode fills dst with an intra prediction from the reconstructed
+// pixels around a block, substituting the values that decoders use at the
+// frame edges
+func (e *vp8Encoder) predictMode(dst, recon []uint8, stride, x0, y0, size, mode int) {
+	// above[0] is the pixel above and to the left of the block
+	above := make([]uint8, size+1)
+	left := make([]uint8, size)
+	switch {
+	case y0 == 0:
+		for i := range above {
+			above[i] = vp8EdgeAbove
+		}
+	case x0 == 0:
+		above[0] = vp8EdgeLeft
+		copy(above[1:], recon[(y0-1)*stride:])
+	default:
+		copy(above, recon[(y0-1)*stride+x0-1:])
+	}
+	for j := range left {
+		if x0 == 0 {
+			left[j] = vp8EdgeLeft
+		} else {
+			left[j] = recon[(y0+j)*stride+x0-1]
+		}
+	}
+
+	switch mode {
+	case vp8PredDC:
+		sum, n := 0, 0
+		if y0 > 0 {
+			for _, p := range above[1:] {
+				sum += int(p)
+			}
+			n += size
+		}
+		if x0 > 0 {
+			for _, p := range left {
+				sum += int(p)
+			}
+			n += size
+		}
+		dc := uint8(128)
+		if n > 0 {
+			dc = uint8((sum + n/2) / n)
+		}
+		for i := range dst[:size*size] {
+			dst[i] = dc
+		}
+	case vp8PredV:
+		for j := 0; j < size; j++ {
+			copy(dst[j*size:(j+1)*size], above[1:])
+		}
+	case vp8PredH:
+		for j := 0; j < size; j++ {
+			for i := 0; i < size; i++ {
+				dst[j*size+i] = left[j]
+			}
+		}
+	default:
+		for j := 0; j < size; j++ {
+			for i := 0; i < size; i++ {
+				dst[j*size+i] = vp8Clip(int32(left[j]) + int32(above[1+i]) - int32(above[0]))
+			}
+		}
+	}
+}
+
+// vp8SSE returns the squared error between a source block and a prediction
+func vp8SSE(src []uint8, stride, x0, y0 int, pred []uint8, size int) int {
+	sum := 0
+	for j := 0; j < size; j++ {
+		for i := 0; i < size; i++ {
+			d := int(src[(y0+j)*stride+x0+i]) - int(pred[j*size+i])
+			sum += d * d
+		}
+	}
+	return sum
+}
+
+// vp8Quantize divides a coefficient by a quantizer step, rounding to nearest
+func vp8Quantize(c, step int32) int32 {
+	negative := c < 0
+	if negative {
+		c = -c
+	}
+	level := (c + step/2) / step
+	if level > vp8MaxLevel {
+		level = vp8MaxLevel
+	}
+	if negative {
+		return -level
+	}
+	return level
+}
+
+// vp8Clip clamps a pixel value to 0..255
+func vp8Clip(v int32) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
+
+// btoi converts a bool to 0 or 1
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// vp8FDCT computes the forward DCT of a 4x4 block of residuals, as libvpx does
+func vp8FDCT(in, out *[16]int32) {
+	var tmp [16]int32
+	for i := 0; i < 4; i++ {
+		row := in[i*4 : i*4+4]
+		a := (row[0] + row[3]) * 8
+		b := (row[1] + row[2]) * 8
+		c := (row[1] - row[2]) * 8
+		d := (row[0] - row[3]) * 8
+		tmp[i*4+0] = a + b
+		tmp[i*4+2] = a - b
+		tmp[i*4+1] = (c*2217 + d*5352 + 14500) >> 12
+		tmp[i*4+3] = (d*2217 - c*5352 + 7500) >> 12
+	}
+	for i := 0; i < 4; i++ {
+		a := tmp[i] + tmp[12+i]
+		b := tmp[4+i] + tmp[8+i]
+		c := tmp[4+i] - tmp[8+i]
+		d := tmp[i] - tmp[12+i]
+		out[i] = (a + b + 7) >> 4
+		out[8+i] = (a - b + 7) >> 4
+		out[4+i] = (c*2217+d*5352+12000)>>16 + int32(btoi(d != 0))
+		out[12+i] = (d*2217 - c*5352 + 51000) >> 16
+	}
+}
+
+// vp8IDCT adds the inverse DCT of a 4x4 block of coefficients to the pixels
+// at dst, exactly as decoders do
+func vp8IDCT(in *[16]int32, dst []uint8, stride int) {
+	const (
+		c1 = 85627 // 65536 * cos(pi/8) * sqrt(2)
+		c2 = 35468 // 65536 * sin(pi/8) * sqrt(2)
+	)
+	var m [4][4]int32
+	for i := 0; i < 4; i++ {
+		a := in[i] + in[8+i]
+		b := in[i] - in[8+i]
+		c := (in[4+i]*c2)>>16 - (in[12+i]*c1)>>16
+		d := (in[4+i]*c1)>>16 + (in[12+i]*c2)>>16
+		m[i] = [4]int32{a + d, b + c, b - c, a - d}
+	}
+	for j := 0; j < 4; j++ {
+		dc := m[0][j] + 4
+		a := dc + m[2][j]
+		b := dc - m[2][j]
+		c := (m[1][j]*c2)>>16 - (m[3][j]*c1)>>16
+		d := (m[1][j]*c1)>>16 + (m[3][j]*c2)>>16
+		row := dst[j*stride : j*stride+4]
+		row[0] = vp8Clip(int32(row[0]) + (a+d)>>3)
+		row[1] = vp8Clip(int32(row[1]) + (b+c)>>3)
+		row[2] = vp8Clip(int32(row[2]) + (b-c)>>3)
+		row[3] = vp8Clip(int32(row[3]) + (a-d)>>3)
+	}
+}
+
+// vp8FWHT computes the forward Walsh-Hadamard transform of the 16 luma DC
+// coefficients, as libvpx does
+func vp8FWHT(in, out *[16]int32) {
+	var tmp [16]int32
+	for i := 0; i < 4; i++ {
+		row := in[i*4 : i*4+4]
+		a := (row[0] + row[2]) * 4
+		d := (row[1] + row[3]) * 4
+		c := (row[1] - row[3]) * 4
+		b := (row[0] - row[2]) * 4
+		tmp[i*4+0] = a + d + int32(btoi(a != 0))
+		tmp[i*4+1] = b + c
+		tmp[i*4+2] = b - c
+		tmp[i*4+3] = a - d
+	}
+	for i := 0; i < 4; i++ {
+		a := tmp[i] + tmp[8+i]
+		d := tmp[4+i] + tmp[12+i]
+		c := tmp[4+i] - tmp[12+i]
+		b := tmp[i] - tmp[8+i]
+		for k, v := range [4]int32{a + d, b + c, b - c, a - d} {
+			if v < 0 {
+				v++
+			}
+			out[4*k+i] = (v + 3) >> 3
+		}
+	}
+}
+
+// vp8IWHT computes the inverse Walsh-Hadamard transform exactly as decoders
+// do, giving the DC coefficient of each luma block
+func vp8IWHT(in, out *[16]int32) {
+	var m [16]int32
+	for i := 0; i < 4; i++ {
+		a0 := in[i] + in[12+i]
+		a1 := in[4+i] + in[8+i]
+		a2 := in[4+i] - in[8+i]
+		a3 := in[i] - in[12+i]
+		m[i] = a0 + a1
+		m[8+i] = a0 - a1
+		m[4+i] = a3 + a2
+		m[12+i] = a3 - a2
+	}
+	for i := 0; i < 4; i++ {
+		dc := m[i*4] + 3
+		a0 := dc + m[i*4+3]
+		a1 := m[i*4+1] + m[i*4+2]
+		a2 := m[i*4+1] - m[i*4+2]
+		a3 := dc - m[i*4+3]
+		out[i*4+0] = (a0 + a1) >> 3
+		out[i*4+1] = (a3 + a2) >> 3
+		out[i*4+2] = (a0 - a1) >> 3
+		out[i*4+3] = (a3 - a2) >> 3
+	}
+}
+
+// Dequantization tables, see RFC 6386 section 14.1
+var (
+	vp8DCQuant = [128]int32{
+		4, 5, 6, 7, 8, 9, 10, 10,
+		11, 12, 13, 14, 15, 16, 17, 17,
+		18, 19, 20, 20, 21, 21, 22, 22,
+		23, 23, 24, 25, 25, 26, 27, 28,
+		29, 30, 31, 32, 33, 34, 35, 36,
+		37, 37, 38, 39, 40, 41, 42, 43,
+		44, 45, 46, 46, 47, 48, 49, 50,
+		51, 52, 53, 54, 55, 56, 57, 58,
+		59, 60, 61, 62, 63, 64, 65, 66,
+		67, 68, 69, 70, 71, 72, 73, 74,
+		75, 76, 76, 77, 78, 79, 80, 81,
+		82, 83, 84, 85, 86, 87, 88, 89,
+		91, 93, 95, 96, 98, 100, 101, 102,
+		104, 106, 108, 110, 112, 114, 116, 118,
+		122, 124, 126, 128, 130, 132, 134, 136,
+		138, 140, 143, 145, 148, 151, 154, 157,
+	}
+	vp8ACQuant = [128]int32{
+		4, 5, 6, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16, 17, 18, 19,
+		20, 21, 22, 23, 24, 25, 26, 27,
+		28, 29, 30, 31, 32, 33, 34, 35,
+		36, 37, 38, 39, 40, 41, 42, 43,
+		44, 45, 46, 47, 48, 49, 50, 51,
+		52, 53, 54, 55, 56, 57, 58, 60,
+		62, 64, 66, 68, 70, 72, 74, 76,
+		78, 80, 82, 84, 86, 88, 90, 92,
+		94, 96, 98, 100, 102, 104, 106, 108,
+		110, 112, 114, 116, 119, 122, 125, 128,
+		131, 134, 137, 140, 143, 146, 149, 152,
+		155, 158, 161, 164, 167, 170, 173, 177,
+		181, 185, 189, 193, 197, 201, 205, 209,
+		213, 217, 221, 225, 229, 234, 239, 245,
+		249, 254, 259, 264, 269, 274, 279, 284,
+	}
+)
+
+// Token probabilities and the probabilities of updating them, see RFC 6386
+// sections 13.4 and 13.5. The encoder keeps the defaults.
+var vp8TokenProbUpdateProb = [4][8][3][11]uint8{
+	{
+		{
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{176, 246, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{223, 241, 252, 255, 255, 255, 255, 255, 255, 255, 255},
+			{249, 253, 253, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 244, 252, 255, 255, 255, 255, 255, 255, 255, 255},
+			{234, 254, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{253, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 246, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{239, 253, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{254, 255, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 248, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{251, 255, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 253, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{251, 254, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{254, 255, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 254, 253, 255, 254, 255, 255, 255, 255, 255, 255},
+			{250, 255, 254, 255, 254, 255, 255, 255, 255, 255, 255},
+			{254, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+	},
+	{
+		{
+			{217, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{225, 252, 241, 253, 255, 255, 254, 255, 255, 255, 255},
+			{234, 250, 241, 250, 253, 255, 253, 254, 255, 255, 255},
+		},
+		{
+			{255, 254, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{223, 254, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{238, 253, 254, 254, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 248, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{249, 254, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 253, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{247, 254, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 253, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{252, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 254, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{253, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 254, 253, 255, 255, 255, 255, 255, 255, 255, 255},
+			{250, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{254, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+	},
+	{
+		{
+			{186, 251, 250, 255, 255, 255, 255, 255, 255, 255, 255},
+			{234, 251, 244, 254, 255, 255, 255, 255, 255, 255, 255},
+			{251, 251, 243, 253, 254, 255, 254, 255, 255, 255, 255},
+		},
+		{
+			{255, 253, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{236, 253, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{251, 253, 253, 254, 254, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 254, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{254, 254, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 254, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{254, 254, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{254, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{254, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+	},
+	{
+		{
+			{248, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{250, 254, 252, 254, 255, 255, 255, 255, 255, 255, 255},
+			{248, 254, 249, 253, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 253, 253, 255, 255, 255, 255, 255, 255, 255, 255},
+			{246, 253, 253, 255, 255, 255, 255, 255, 255, 255, 255},
+			{252, 254, 251, 254, 254, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 254, 252, 255, 255, 255, 255, 255, 255, 255, 255},
+			{248, 254, 253, 255, 255, 255, 255, 255, 255, 255, 255},
+			{253, 255, 254, 254, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 251, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{245, 251, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{253, 253, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 251, 253, 255, 255, 255, 255, 255, 255, 255, 255},
+			{252, 253, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 254, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 252, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{249, 255, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 254, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 255, 253, 255, 255, 255, 255, 255, 255, 255, 255},
+			{250, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+		{
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{254, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+			{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		},
+	},
+}
+
+var vp8DefaultTokenProb = [4][8][3][11]uint8{
+	{
+		{
+			{128, 128, 128, 128, 128, 128, 128, 128, 128, 128, 128},
+			{128, 128, 128, 128, 128, 128, 128, 128, 128, 128, 128},
+			{128, 128, 128, 128, 128, 128, 128, 128, 128, 128, 128},
+		},
+		{
+			{253, 136, 254, 255, 228, 219, 128, 128, 128, 128, 128},
+			{189, 129, 242, 255, 227, 213, 255, 219, 128, 128, 128},
+			{106, 126, 227, 252, 214, 209, 255, 255, 128, 128, 128},
+		},
+		{
+			{1, 98, 248, 255, 236, 226, 255, 255, 128, 128, 128},
+			{181, 133, 238, 254, 221, 234, 255, 154, 128, 128, 128},
+			{78, 134, 202, 247, 198, 180, 255, 219, 128, 128, 128},
+		},
+		{
+			{1, 185, 249, 255, 243, 255, 128, 128, 128, 128, 128},
+			{184, 150, 247, 255, 236, 224, 128, 128, 128, 128, 128},
+			{77, 110, 216, 255, 236, 230, 128, 128, 128, 128, 128},
+		},
+		{
+			{1, 101, 251, 255, 241, 255, 128, 128, 128, 128, 128},
+			{170, 139, 241, 252, 236, 209, 255, 255, 128, 128, 128},
+			{37, 116, 196, 243, 228, 255, 255, 255, 128, 128, 128},
+		},
+		{
+			{1, 204, 254, 255, 245, 255, 128, 128, 128, 128, 128},
+			{207, 160, 250, 255, 238, 128, 128, 128, 128, 128, 128},
+			{102, 103, 231, 255, 211, 171, 128, 128, 128, 128, 128},
+		},
+		{
+			{1, 152, 252, 255, 240, 255, 128, 128, 128, 128, 128},
+			{177, 135, 243, 255, 234, 225, 128, 128, 128, 128, 128},
+			{80, 129, 211, 255, 194, 224, 128, 128, 128, 128, 128},
+		},
+		{
+			{1, 1, 255, 128, 128, 128, 128, 128, 128, 128, 128},
+			{246, 1, 255, 128, 128, 128, 128, 128, 128, 128, 128},
+			{255, 128, 128, 128, 128, 128, 128, 128, 128, 128, 128},
+		},
+	},
+	{
+		{
+			{198, 35, 237, 223, 193, 187, 162, 160, 145, 155, 62},
+			{131, 45, 198, 221, 172, 176, 220, 157, 252, 221, 1},
+			{68, 47, 146, 208, 149, 167, 221, 162, 255, 223, 128},
+		},
+		{
+			{1, 149, 241, 255, 221, 224, 255, 255, 128, 128, 128},
+			{184, 141, 234, 253, 222, 220, 255, 199, 128, 128, 128},
+			{81, 99, 181, 242, 176, 190, 249, 202, 255, 255, 128},
+		},
+		{
+			{1, 129, 232, 253, 214, 197, 242, 196, 255, 255, 128},
+			{99, 121, 210, 250, 201, 198, 255, 202, 128, 128, 128},
+			{23, 91, 163, 242, 170, 187, 247, 210, 255, 255, 128},
+		},
+		{
+			{1, 200, 246, 255, 234, 255, 128, 128, 128, 128, 128},
+			{109, 178, 241, 255, 231, 245, 255, 255, 128, 128, 128},
+			{44, 130, 201, 253, 205, 192, 255, 255, 128, 128, 128},
+		},
+		{
+			{1, 132, 239, 251, 219, 209, 255, 165, 128, 128, 128},
+			{94, 136, 225, 251, 218, 190, 255, 255, 128, 128, 128},
+			{22, 100, 174, 245, 186, 161, 255, 199, 128, 128, 128},
+		},
+		{
+			{1, 182, 249, 255, 232, 235, 128, 128, 128, 128, 128},
+			{124, 143, 241, 255, 227, 234, 128, 128, 128, 128, 128},
+			{35, 77, 181, 251, 193, 211, 255, 205, 128, 128, 128},
+		},
+		{
+			{1, 157, 247, 255, 236, 231, 255, 255, 128, 128, 128},
+			{121, 141, 235, 255, 225, 227, 255, 255, 128, 128, 128},
+			{45, 99, 188, 251, 195, 217, 255, 224, 128, 128, 128},
+		},
+		{
+			{1, 1, 251, 255, 213, 255, 128, 128, 128, 128, 128},
+			{203, 1, 248, 255, 255, 128, 128, 128, 128, 128, 128},
+			{137, 1, 177, 255, 224, 255, 128, 128, 128, 128, 128},
+		},
+	},
+	{
+		{
+			{253, 9, 248, 251, 207, 208, 255, 192, 128, 128, 128},
+			{175, 13, 224, 243, 193, 185, 249, 198, 255, 255, 128},
+			{73, 17, 171, 221, 161, 179, 236, 167, 255, 234, 128},
+		},
+		{
+			{1, 95, 247, 253, 212, 183, 255, 255, 128, 128, 128},
+			{239, 90, 244, 250, 211, 209, 255, 255, 128, 128, 128},
+			{155, 77, 195, 248, 188, 195, 255, 255, 128, 128, 128},
+		},
+		{
+			{1, 24, 239, 251, 218, 219, 255, 205, 128, 128, 128},
+			{201, 51, 219, 255, 196, 186, 128, 128, 128, 128, 128},
+			{69, 46, 190, 239, 201, 218, 255, 228, 128, 128, 128},
+		},
+		{
+			{1, 191, 251, 255, 255, 128, 128, 128, 128, 128, 128},
+			{223, 165, 249, 255, 213, 255, 128, 128, 128, 128, 128},
+			{141, 124, 248, 255, 255, 128, 128, 128, 128, 128, 128},
+		},
+		{
+			{1, 16, 248, 255, 255, 128, 128, 128, 128, 128, 128},
+			{190, 36, 230, 255, 236, 255, 128, 128, 128, 128, 128},
+			{149, 1, 255, 128, 128, 128, 128, 128, 128, 128, 128},
+		},
+		{
+			{1, 226, 255, 128, 128, 128, 128, 128, 128, 128, 128},
+			{247, 192, 255, 128, 128, 128, 128, 128, 128, 128, 128},
+			{240, 128, 255, 128, 128, 128, 128, 128, 128, 128, 128},
+		},
+		{
+			{1, 134, 252, 255, 255, 128, 128, 128, 128, 128, 128},
+			{213, 62, 250, 255, 255, 128, 128, 128, 128, 128, 128},
+			{55, 93, 255, 128, 128, 128, 128, 128, 128, 128, 128},
+		},
+		{
+			{128, 128, 128, 128, 128, 128, 128, 128, 128, 128, 128},
+			{128, 128, 128, 128, 128, 128, 128, 128, 128, 128, 128},
+			{128, 128, 128, 128, 128, 128, 128, 128, 128, 128, 128},
+		},
+	},
+	{
+		{
+			{202, 24, 213, 235, 186, 191, 220, 160, 240, 175, 255},
+			{126, 38, 182, 232, 169, 184, 228, 174, 255, 187, 128},
+			{61, 46, 138, 219, 151, 178, 240, 170, 255, 216, 128},
+		},
+		{
+			{1, 112, 230, 250, 199, 191, 247, 159, 255, 255, 128},
+			{166, 109, 228, 252, 211, 215, 255, 174, 128, 128, 128},
+			{39, 77, 162, 232, 172, 180, 245, 178, 255, 255, 128},
+		},
+		{
+			{1, 52, 220, 246, 198, 199, 249, 220, 255, 255, 128},
+			{124, 74, 191, 243, 183, 193, 250, 221, 255, 255, 128},
+			{24, 71, 130, 219, 154, 170, 243, 182, 255, 255, 128},
+		},
+		{
+			{1, 182, 225, 249, 219, 240, 255, 224, 128, 128, 128},
+			{149, 150, 226, 252, 216, 205, 255, 171, 128, 128, 128},
+			{28, 108, 170, 242, 183, 194, 254, 223, 255, 255, 128},
+		},
+		{
+			{1, 81, 230, 252, 204, 203, 255, 192, 128, 128, 128},
+			{123, 102, 209, 247, 188, 196, 255, 233, 128, 128, 128},
+			{20, 95, 153, 243, 164, 173, 255, 203, 128, 128, 128},
+		},
+		{
+			{1, 222, 248, 255, 216, 213, 128, 128, 128, 128, 128},
+			{168, 175, 246, 252, 235, 205, 255, 255, 128, 128, 128},
+			{47, 116, 215, 255, 211, 212, 255, 255, 128, 128, 128},
+		},
+		{
+			{1, 121, 236, 253, 212, 214, 255, 255, 128, 128, 128},
+			{141, 84, 213, 252, 201, 202, 255, 219, 128, 128, 128},
+			{42, 80, 160, 240, 162, 185, 255, 205, 128, 128, 128},
+		},
+		{
+			{1, 1, 255, 128, 128, 128, 128, 128, 128, 128, 128},
+			{244, 1, 255, 128, 128, 128, 128, 128, 128, 128, 128},
+			{238, 1, 255, 128, 128, 128, 128, 128, 128, 128, 128},
+		},
+	},
+}
@@ -0,0 +1,837 @@
+package converter
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	stderrors "errors"
+	"fmt"
+	"image"
+	"io"
+	"math/bits"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+// WebP container constants
+const (
+	webpFlagICC       = 0x20
+	webpFlagAlpha     = 0x10
+	webpFlagExif      = 0x08
+	webpAlphaLossless = 0x01
+)
+
+// Lossless WebP (VP8L) bitstream constants
+const (
+	vp8lSignature         = 0x2f
+	vp8lMaxDimension      = 1 << 14
+	vp8lTransformPredict  = 0
+	vp8lTransformSubGreen = 2
+	vp8lPredictorBits     = 4
+	vp8lMaxBackRef        = 4096
+	vp8lMinBackRef        = 3
+	vp8lNumLengthCodes    = 24
+	vp8lNumDistanceCodes  = 40
+	vp8lMaxCodeLength     = 15
+	vp8lMaxCodeLenLength  = 7
+
+	// Distance codes for the pixel above and the pixel to the left
+	vp8lDistanceAbove = 1
+	vp8lDistanceLeft  = 2
+)
+
+// vp8lCodeLengthOrder is the order in which code length code lengths are stored
+var vp8lCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// vp8lPredictorModes are the predictor modes tried for each tile
+var vp8lPredictorModes = []int{1, 2, 7, 11, 12, 13}
+
+// webpEncoder encodes WebP files.
+//
+// Lossy files hold a VP8 key frame at the configured quality, with any
+// transparency stored losslessly in an ALPH chunk. Lossless files use the
+// VP8L bitstream: pixels are coded with the subtract-green and predictor
+// transforms followed by Huffman-coded literals and run-length back
+// references.
+type webpEncoder struct {
+	quality  int
+	lossless bool
+}
+
+// Encode implements ImageEncoder
+func (e *webpEncoder) Encode(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 1 || height < 1 || width > vp8lMaxDimension || height > vp8lMaxDimension {
+		return fmt.Errorf("WebP images are limited to %dx%d pixels, got %dx%d",
+			vp8lMaxDimension, vp8lMaxDimension, width, height)
+	}
+
+	nrgba := imaging.Clone(img)
+	hasAlpha := false
+	for i := 3; i < len(nrgba.Pix); i += 4 {
+		if nrgba.Pix[i] != 0xff {
+			hasAlpha = true
+			break
+		}
+	}
+
+	if !e.lossless {
+		frame, err := encodeVP8(nrgba, e.quality)
+		if err != nil {
+			return err
+		}
+		if !hasAlpha {
+			return writeWebPContainer(w, [][]byte{frame}, []string{"VP8 "})
+		}
+		vp8x := webpVP8X(webpFlagAlpha, width, height)
+		return writeWebPContainer(w, [][]byte{vp8x, encodeWebPAlpha(nrgba), frame}, []string{"VP8X", "ALPH", "VP8 "})
+	}
+
+	// Gather non-premultiplied ARGB pixels
+	argb := make([]uint32, width*height)
+	for i := range argb {
+		p := nrgba.Pix[i*4 : i*4+4]
+		argb[i] = uint32(p[3])<<24 | uint32(p[0])<<16 | uint32(p[1])<<8 | uint32(p[2])
+	}
+	return writeWebPContainer(w, [][]byte{encodeVP8L(argb, width, height, hasAlpha)}, []string{"VP8L"})
+}
+
+// encodeWebPAlpha returns an ALPH chunk holding the alpha channel as a
+// headerless VP8L image, with the alpha values in its green channel
+func encodeWebPAlpha(img *image.NRGBA) []byte {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	argb := make([]uint32, width*height)
+	for i := range argb {
+		argb[i] = 0xff000000 | uint32(img.Pix[i*4+3])<<8
+	}
+	// The VP8L header is 5 bytes; the ALPH header selects lossless
+	// compression without filtering
+	return append([]byte{webpAlphaLossless}, encodeVP8L(argb, width, height, false)[5:]...)
+}
+
+// EmbedMetadata implements MetadataEmbedder
+func (e *webpEncoder) EmbedMetadata(encoded, exifData, iccProfile []byte) ([]byte, error) {
+	return insertWebPMetadata(encoded, exifData, iccProfile)
+}
+
+// writeWebPContainer writes chunks into a RIFF WebP container
+func writeWebPContainer(w io.Writer, chunks [][]byte, chunkTypes []string) error {
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	for i, chunk := range chunks {
+		body.WriteString(chunkTypes[i])
+		binary.Write(&body, binary.LittleEndian, uint32(len(chunk)))
+		body.Write(chunk)
+		if len(chunk)%2 == 1 {
+			body.WriteByte(0)
+		}
+	}
+
+	header := []byte("RIFF")
+	header = binary.LittleEndian.AppendUint32(header, uint32(body.Len()))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(body.Bytes())
+	return err
+}
+
+// insertWebPMetadata converts a WebP file into the extended format carrying
+// the given EXIF block and ICC profile
+func insertWebPMetadata(webp, exifData, iccProfile []byte) ([]byte, error) {
+	if len(webp) < 12 || string(webp[:4]) != "RIFF" || string(webp[8:12]) != "WEBP" {
+		return nil, stderrors.New("not a WebP stream")
+	}
+
+	// Keep the image chunks and the canvas of an extended file
+	var images [][]byte
+	var imageTypes []string
+	var width, height int
+	hasAlpha := false
+	for pos := 12; pos+8 <= len(webp); {
+		size := int(binary.LittleEndian.Uint32(webp[pos+4:]))
+		if pos+8+size > len(webp) {
+			return nil, fmt.Errorf("invalid WebP chunk size at offset %d", pos)
+		}
+		chunkType, payload := string(webp[pos:pos+4]), webp[pos+8:pos+8+size]
+		switch chunkType {
+		case "VP8X":
+			if size < 10 {
+				return nil, stderrors.New("invalid WebP VP8X chunk")
+			}
+			hasAlpha = payload[0]&webpFlagAlpha != 0
+			width = (int(payload[4]) | int(payload[5])<<8 | int(payload[6])<<16) + 1
+			height = (int(payload[7]) | int(payload[8])<<8 | int(payload[9])<<16) + 1
+		case "ALPH", "VP8 ", "VP8L":
+			images = append(images, payload)
+			imageTypes = append(imageTypes, chunkType)
+		}
+		pos += 8 + size + size%2
+	}
+	if len(images) == 0 {
+		return nil, stderrors.New("WebP stream has no image chunk")
+	}
+
+	// A simple file takes its canvas size and alpha hint from the bitstream
+	if width == 0 {
+		bitstream := images[len(images)-1]
+		switch imageTypes[len(imageTypes)-1] {
+		case "VP8L":
+			if len(bitstream) < 5 || bitstream[0] != vp8lSignature {
+				return nil, stderrors.New("invalid WebP lossless bitstream")
+			}
+			header := binary.LittleEndian.Uint32(bitstream[1:])
+			width = int(header&0x3fff) + 1
+			height = int(header>>14&0x3fff) + 1
+			hasAlpha = header>>28&1 != 0
+		default:
+			if len(bitstream) < 10 || !bytes.Equal(bitstream[3:6], []byte{0x9d, 0x01, 0x2a}) {
+				return nil, stderrors.New("invalid WebP lossy bitstream")
+			}
+			width = int(binary.LittleEndian.Uint16(bitstream[6:]) & 0x3fff)
+			height = int(binary.LittleEndian.Uint16(bitstream[8:]) & 0x3fff)
+		}
+	}
+
+	var flags byte
+	if iccProfile != nil {
+		flags |= webpFlagICC
+	}
+	if hasAlpha {
+		flags |= webpFlagAlpha
+	}
+	if exifData != nil {
+		flags |= webpFlagExif
+	}
+
+	chunks := [][]byte{webpVP8X(flags, width, height)}
+	chunkTypes := []string{"VP8X"}
+	if iccProfile != nil {
+		chunks = append(chunks, iccProfile)
+		chunkTypes = append(chunkTypes, "ICCP")
+	}
+	chunks = append(chunks, images...)
+	chunkTypes = append(chunkTypes, imageTypes...)
+	if exifData != nil {
+		chunks = append(chunks, exifData)
+		chunkTypes = append(chunkTypes, "EXIF")
+	}
+
+	var out bytes.Buffer
+	if err := writeWebPContainer(&out, chunks, chunkTypes); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// webpVP8X returns the payload of a VP8X chunk with the given feature flags
+// and canvas size
+func webpVP8X(flags byte, width, height int) []byte {
+	vp8x := []byte{flags, 0, 0, 0}
+	vp8x = append(vp8x, byte(width-1), byte((width-1)>>8), byte((width-1)>>16))
+	return append(vp8x, byte(height-1), byte((height-1)>>8), byte((height-1)>>16))
+}
+
+// vp8lBitWriter writes the least-significant-bit-first VP8L bitstream
+type vp8lBitWriter struct {
+	buf   []byte
+	bits  uint64
+	nbits uint
+}
+
+// writeBits writes the low n bits of value, n <= 32
+func (bw *vp8lBitWriter) writeBits(value uint32, n uint) {
+	bw.bits |= uint64(value&(1<<n-1)) << bw.nbits
+	bw.nbits += n
+	for bw.nbits >= 8 {
+		bw.buf = append(bw.buf, byte(bw.bits))
+		bw.bits >>= 8
+		bw.nbits -= 8
+	}
+}
+
+// bytes flushes any partial byte and returns the bitstream
+func (bw *vp8lBitWriter) bytes() []byte {
+	if bw.nbits > 0 {
+		bw.buf = append(bw.buf, byte(bw.bits))
+		bw.bits, bw.nbits = 0, 0
+	}
+	return bw.buf
+}
+
+// encodeVP8L encodes ARGB pixels as a VP8L bitstream
+func encodeVP8L(argb []uint32, width, height int, hasAlpha bool) []byte {
+	bw := &vp8lBitWriter{}
+	bw.writeBits(vp8lSignature, 8)
+	bw.writeBits(uint32(width-1), 14)
+	bw.writeBits(uint32(height-1), 14)
+	if hasAlpha {
+		bw.writeBits(1, 1)
+	} else {
+		bw.writeBits(0, 1)
+	}
+	bw.writeBits(0, 3) // version
+
+	// Subtract-green transform
+	bw.writeBits(1, 1)
+	bw.writeBits(vp8lTransformSubGreen, 2)
+	for i, p := range argb {
+		green := (p >> 8) & 0xff
+		red := ((p >> 16) - green) & 0xff
+		blue := (p - green) & 0xff
+		argb[i] = p&0xff00ff00 | red<<16 | blue
+	}
+
+	// Predictor transform
+	bw.writeBits(1, 1)
+	bw.writeBits(vp8lTransformPredict, 2)
+	bw.writeBits(vp8lPredictorBits-2, 3)
+	modes, tilesX := vp8lChoosePredictors(argb, width, height)
+	writeVP8LEntropyImage(bw, modes, tilesX, false)
+	residuals := vp8lPredictResiduals(argb, width, height, modes, tilesX)
+
+	// No more transforms
+	bw.writeBits(0, 1)
+
+	writeVP8LEntropyImage(bw, residuals, width, true)
+	return bw.bytes()
+}
+
+// vp8lPredict returns the prediction for a pixel using one of the 14 modes
+func vp8lPredict(mode int, left, top, topLeft, topRight uint32) uint32 {
+	switch mode {
+	case 0:
+		return 0xff000000
+	case 1:
+		return left
+	case 2:
+		return top
+	case 3:
+		return topRight
+	case 4:
+		return topLeft
+	case 5:
+		return vp8lAverage2(vp8lAverage2(left, topRight), top)
+	case 6:
+		return vp8lAverage2(left, topLeft)
+	case 7:
+		return vp8lAverage2(left, top)
+	case 8:
+		return vp8lAverage2(topLeft, top)
+	case 9:
+		return vp8lAverage2(top, topRight)
+	case 10:
+		return vp8lAverage2(vp8lAverage2(left, topLeft), vp8lAverage2(top, topRight))
+	case 11:
+		return vp8lSelect(left, top, topLeft)
+	case 12:
+		return vp8lClampAddSubtractFull(left, top, topLeft)
+	default:
+		return vp8lClampAddSubtractHalf(vp8lAverage2(left, top), topLeft)
+	}
+}
+
+// vp8lAverage2 averages each channel of two pixels
+func vp8lAverage2(a, b uint32) uint32 {
+	return (((a ^ b) & 0xfefefefe) >> 1) + (a & b)
+}
+
+// vp8lChannel returns the channel of a pixel at the given bit shift
+func vp8lChannel(p uint32, shift uint) int {
+	return int(p>>shift) & 0xff
+}
+
+// vp8lAbs returns the absolute value of an int
+func vp8lAbs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// vp8lClamp clamps a channel value to 0..255
+func vp8lClamp(v int) uint32 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint32(v)
+}
+
+// vp8lSelect picks whichever of left and top is closer to the gradient estimate
+func vp8lSelect(left, top, topLeft uint32) uint32 {
+	distLeft, distTop := 0, 0
+	for shift := uint(0); shift < 32; shift += 8 {
+		estimate := vp8lChannel(left, shift) + vp8lChannel(top, shift) - vp8lChannel(topLeft, shift)
+		distLeft += vp8lAbs(estimate - vp8lChannel(left, shift))
+		distTop += vp8lAbs(estimate - vp8lChannel(top, shift))
+	}
+	if distLeft < distTop {
+		return left
+	}
+	return top
+}
+
+// vp8lClampAddSubtractFull computes a + b - c per channel
+func vp8lClampAddSubtractFull(a, b, c uint32) uint32 {
+	var out uint32
+	for shift := uint(0); shift < 32; shift += 8 {
+		v := vp8lChannel(a, shift) + vp8lChannel(b, shift) - vp8lChannel(c, shift)
+		out |= vp8lClamp(v) << shift
+	}
+	return out
+}
+
+// vp8lClampAddSubtractHalf computes a + (a - b) / 2 per channel
+func vp8lClampAddSubtractHalf(a, b uint32) uint32 {
+	var out uint32
+	for shift := uint(0); shift < 32; shift += 8 {
+		ca := vp8lChannel(a, shift)
+		v := ca + (ca-vp8lChannel(b, shift))/2
+		out |= vp8lClamp(v) << shift
+	}
+	return out
+}
+
+// vp8lPixelPrediction returns the prediction for the pixel at index i, taking
+// the special cases of the first row and column into account
+func vp8lPixelPrediction(argb []uint32, width, x, y, mode int) uint32 {
+	i := y*width + x
+	switch {
+	case x == 0 && y == 0:
+		return 0xff000000
+	case y == 0:
+		return argb[i-1]
+	case x == 0:
+		return argb[i-width]
+	}
+	// For the rightmost column the top-right pixel wraps to the current row
+	return vp8lPredict(mode, argb[i-1], argb[i-width], argb[i-width-1], argb[i-width+1])
+}
+
+// vp8lResidual subtracts a prediction from a pixel per channel
+func vp8lResidual(p, pred uint32) uint32 {
+	alphaGreen := (p | 0x00ff00ff) - (pred & 0xff00ff00)
+	redBlue := (p | 0xff00ff00) - (pred & 0x00ff00ff)
+	return alphaGreen&0xff00ff00 | redBlue&0x00ff00ff
+}
+
+// vp8lResidualCost estimates how expensive a residual is to code
+func vp8lResidualCost(residual uint32) int {
+	cost := 0
+	for shift := uint(0); shift < 32; shift += 8 {
+		cost += vp8lAbs(int(int8(residual >> shift)))
+	}
+	return cost
+}
+
+// vp8lChoosePredictors picks the cheapest predictor mode for each tile and
+// returns them as the predictor sub-image
+func vp8lChoosePredictors(argb []uint32, width, height int) ([]uint32, int) {
+	tileSize := 1 << vp8lPredictorBits
+	tilesX := (width + tileSize - 1) / tileSize
+	tilesY := (height + tileSize - 1) / tileSize
+	modes := make([]uint32, tilesX*tilesY)
+
+	for ty := 0; ty < tilesY; ty++ {
+		for tx := 0; tx < tilesX; tx++ {
+			bestMode, bestCost := vp8lPredictorModes[0], -1
+			for _, mode := range vp8lPredictorModes {
+				cost := 0
+				for y := ty * tileSize; y < (ty+1)*tileSize && y < height; y++ {
+					for x := tx * tileSize; x < (tx+1)*tileSize && x < width; x++ {
+						pred := vp8lPixelPrediction(argb, width, x, y, mode)
+						cost += vp8lResidualCost(vp8lResidual(argb[y*width+x], pred))
+					}
+				}
+				if bestCost < 0 || cost < bestCost {
+					bestMode, bestCost = mode, cost
+				}
+			}
+			modes[ty*tilesX+tx] = 0xff000000 | uint32(bestMode)<<8
+		}
+	}
+
+	return modes, tilesX
+}
+
+// vp8lPredictResiduals applies the predictor transform
+func vp8lPredictResiduals(argb []uint32, width, height int, modes []uint32, tilesX int) []uint32 {
+	residuals := make([]uint32, len(argb))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			mode := int(modes[(y>>vp8lPredictorBits)*tilesX+(x>>vp8lPredictorBits)]>>8) & 0xf
+			pred := vp8lPixelPrediction(argb, width, x, y, mode)
+			residuals[y*width+x] = vp8lResidual(argb[y*width+x], pred)
+		}
+	}
+	return residuals
+}
+
+// vp8lToken is either a literal pixel or a back reference
+type vp8lToken struct {
+	pixel    uint32
+	length   int
+	distance int
+}
+
+// vp8lTokenize replaces runs that repeat the pixel to the left or the row
+// above with back references
+func vp8lTokenize(pixels []uint32, width int) []vp8lToken {
+	tokens := make([]vp8lToken, 0, len(pixels))
+	for i := 0; i < len(pixels); {
+		leftRun, aboveRun := 0, 0
+		if i >= 1 {
+			for leftRun < vp8lMaxBackRef && i+leftRun < len(pixels) && pixels[i+leftRun] == pixels[i-1] {
+				leftRun++
+			}
+		}
+		if i >= width {
+			for aboveRun < vp8lMaxBackRef && i+aboveRun < len(pixels) && pixels[i+aboveRun] == pixels[i+aboveRun-width] {
+				aboveRun++
+			}
+		}
+
+		switch {
+		case leftRun >= vp8lMinBackRef && leftRun >= aboveRun:
+			tokens = append(tokens, vp8lToken{length: leftRun, distance: vp8lDistanceLeft})
+			i += leftRun
+		case aboveRun >= vp8lMinBackRef:
+			tokens = append(tokens, vp8lToken{length: aboveRun, distance: vp8lDistanceAbove})
+			i += aboveRun
+		default:
+			tokens = append(tokens, vp8lToken{pixel: pixels[i]})
+			i++
+		}
+	}
+	return tokens
+}
+
+// vp8lPrefixEncode splits a length or distance value into its prefix code
+// and extra bits
+func vp8lPrefixEncode(value int) (int, uint, uint32) {
+	value--
+	if value < 4 {
+		return value, 0, 0
+	}
+	highBit := bits.Len(uint(value)) - 1
+	secondBit := (value >> (highBit - 1)) & 1
+	extraBits := uint(highBit - 1)
+	return 2*highBit + secondBit, extraBits, uint32(value) & (1<<extraBits - 1)
+}
+
+// writeVP8LEntropyImage writes an entropy-coded image with a single group of
+// prefix codes
+func writeVP8LEntropyImage(bw *vp8lBitWriter, pixels []uint32, width int, isMain bool) {
+	bw.writeBits(0, 1) // no color cache
+	if isMain {
+		bw.writeBits(0, 1) // no meta prefix codes
+	}
+
+	tokens := vp8lTokenize(pixels, width)
+
+	// Histograms for green+length, red, blue, alpha and distance
+	histograms := [5][]int{
+		make([]int, 256+vp8lNumLengthCodes),
+		make([]int, 256),
+		make([]int, 256),
+		make([]int, 256),
+		make([]int, vp8lNumDistanceCodes),
+	}
+	for _, t := range tokens {
+		if t.length == 0 {
+			histograms[0][(t.pixel>>8)&0xff]++
+			histograms[1][(t.pixel>>16)&0xff]++
+			histograms[2][t.pixel&0xff]++
+			histograms[3][t.pixel>>24]++
+			continue
+		}
+		lengthCode, _, _ := vp8lPrefixEncode(t.length)
+		distanceCode, _, _ := vp8lPrefixEncode(t.distance)
+		histograms[0][256+lengthCode]++
+		histograms[4][distanceCode]++
+	}
+
+	var codes [5]vp8lHuffmanCode
+	for i, histogram := range histograms {
+		codes[i] = writeVP8LHuffmanCode(bw, histogram)
+	}
+
+	for _, t := range tokens {
+		if t.length == 0 {
+			codes[0].write(bw, int(t.pixel>>8)&0xff)
+			codes[1].write(bw, int(t.pixel>>16)&0xff)
+			codes[2].write(bw, int(t.pixel)&0xff)
+			codes[3].write(bw, int(t.pixel>>24))
+			continue
+		}
+		lengthCode, lengthBits, lengthExtra := vp8lPrefixEncode(t.length)
+		codes[0].write(bw, 256+lengthCode)
+		bw.writeBits(lengthExtra, lengthBits)
+		distanceCode, distanceBits, distanceExtra := vp8lPrefixEncode(t.distance)
+		codes[4].write(bw, distanceCode)
+		bw.writeBits(distanceExtra, distanceBits)
+	}
+}
+
+// vp8lHuffmanCode holds bit-reversed canonical codes ready for writing
+type vp8lHuffmanCode struct {
+	lengths []int
+	codes   []uint32
+}
+
+// write writes the code for a symbol
+func (c vp8lHuffmanCode) write(bw *vp8lBitWriter, symbol int) {
+	bw.writeBits(c.codes[symbol], uint(c.lengths[symbol]))
+}
+
+// newVP8LHuffmanCode builds canonical codes from code lengths
+func newVP8LHuffmanCode(lengths []int) vp8lHuffmanCode {
+	var count [vp8lMaxCodeLength + 1]int
+	for _, l := range lengths {
+		count[l]++
+	}
+	count[0] = 0
+
+	var next [vp8lMaxCodeLength + 2]uint32
+	code := uint32(0)
+	for l := 1; l <= vp8lMaxCodeLength; l++ {
+		code = (code + uint32(count[l-1])) << 1
+		next[l] = code
+	}
+
+	codes := make([]uint32, len(lengths))
+	for symbol, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		// The bitstream is read one bit at a time from the least significant end
+		codes[symbol] = bits.Reverse32(next[l]) >> (32 - uint(l))
+		next[l]++
+	}
+
+	return vp8lHuffmanCode{lengths: lengths, codes: codes}
+}
+
+// writeVP8LHuffmanCode chooses and writes a prefix code for a histogram
+func writeVP8LHuffmanCode(bw *vp8lBitWriter, histogram []int) vp8lHuffmanCode {
+	var used []int
+	for symbol, n := range histogram {
+		if n > 0 {
+			used = append(used, symbol)
+		}
+	}
+	if len(used) == 0 {
+		used = []int{0}
+	}
+
+	lengths := make([]int, len(histogram))
+
+	// Small alphabets of 8-bit symbols use the compact simple code
+	if len(used) <= 2 && used[len(used)-1] < 256 {
+		bw.writeBits(1, 1)
+		bw.writeBits(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			bw.writeBits(0, 1)
+			bw.writeBits(uint32(used[0]), 1)
+		} else {
+			bw.writeBits(1, 1)
+			bw.writeBits(uint32(used[0]), 8)
+		}
+		if len(used) == 2 {
+			bw.writeBits(uint32(used[1]), 8)
+			lengths[used[0]], lengths[used[1]] = 1, 1
+		}
+		return newVP8LHuffmanCode(lengths)
+	}
+
+	// A normal code needs at least two symbols to be complete
+	freq := append([]int{}, histogram...)
+	if len(used) == 1 {
+		dummy := 0
+		if used[0] == 0 {
+			dummy = 1
+		}
+		freq[dummy] = 1
+	}
+	lengths = vp8lCodeLengths(freq, vp8lMaxCodeLength)
+
+	bw.writeBits(0, 1)
+	writeVP8LCodeLengths(bw, lengths)
+	return newVP8LHuffmanCode(lengths)
+}
+
+// writeVP8LCodeLengths writes code lengths using the code length code
+func writeVP8LCodeLengths(bw *vp8lBitWriter, lengths []int) {
+	type lengthToken struct {
+		symbol    int
+		extra     uint32
+		extraBits uint
+	}
+
+	// Run-length encode the code lengths
+	var tokens []lengthToken
+	prevNonZero := 8
+	for i := 0; i < len(lengths); {
+		value := lengths[i]
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == value {
+			run++
+		}
+		i += run
+
+		if value == 0 {
+			for run >= 11 {
+				n := run
+				if n > 138 {
+					n = 138
+				}
+				tokens = append(tokens, lengthToken{18, uint32(n - 11), 7})
+				run -= n
+			}
+			if run >= 3 {
+				tokens = append(tokens, lengthToken{17, uint32(run - 3), 3})
+				run = 0
+			}
+			for ; run > 0; run-- {
+				tokens = append(tokens, lengthToken{symbol: 0})
+			}
+			continue
+		}
+
+		if value != prevNonZero {
+			tokens = append(tokens, lengthToken{symbol: value})
+			run--
+			prevNonZero = value
+		}
+		for run >= 3 {
+			n := run
+			if n > 6 {
+				n = 6
+			}
+			tokens = append(tokens, lengthToken{16, uint32(n - 3), 2})
+			run -= n
+		}
+		for ; run > 0; run-- {
+			tokens = append(tokens, lengthToken{symbol: value})
+		}
+	}
+
+	histogram := make([]int, len(vp8lCodeLengthOrder))
+	for _, t := range tokens {
+		histogram[t.symbol]++
+	}
+	used := 0
+	for _, n := range histogram {
+		if n > 0 {
+			used++
+		}
+	}
+	if used < 2 {
+		// Keep the code length code complete
+		for symbol := range histogram {
+			if histogram[symbol] == 0 {
+				histogram[symbol] = 1
+				break
+			}
+		}
+	}
+	codeLengthLengths := vp8lCodeLengths(histogram, vp8lMaxCodeLenLength)
+
+	count := len(vp8lCodeLengthOrder)
+	for count > 4 && codeLengthLengths[vp8lCodeLengthOrder[count-1]] == 0 {
+		count--
+	}
+	bw.writeBits(uint32(count-4), 4)
+	for i := 0; i < count; i++ {
+		bw.writeBits(uint32(codeLengthLengths[vp8lCodeLengthOrder[i]]), 3)
+	}
+
+	bw.writeBits(0, 1) // code lengths cover the whole alphabet
+
+	codeLengthCode := newVP8LHuffmanCode(codeLengthLengths)
+	for _, t := range tokens {
+		codeLengthCode.write(bw, t.symbol)
+		bw.writeBits(t.extra, t.extraBits)
+	}
+}
+
+// vp8lHuffmanNode is a node of the tree built by vp8lCodeLengths
+type vp8lHuffmanNode struct {
+	weight int
+	symbol int
+	left   *vp8lHuffmanNode
+	right  *vp8lHuffmanNode
+}
+
+// vp8lNodeHeap is a min-heap of tree nodes ordered by weight
+type vp8lNodeHeap []*vp8lHuffmanNode
+
+func (h vp8lNodeHeap) Len() int { return len(h) }
+func (h vp8lNodeHeap) Less(i, j int) bool {
+	if h[i].weight != h[j].weight {
+		return h[i].weight < h[j].weight
+	}
+	return h[i].symbol < h[j].symbol
+}
+func (h vp8lNodeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *vp8lNodeHeap) Push(x interface{}) { *h = append(*h, x.(*vp8lHuffmanNode)) }
+func (h *vp8lNodeHeap) Pop() interface{} {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
+
+// vp8lCodeLengths computes Huffman code lengths limited to maxLength bits.
+// At least two symbols must have a non-zero frequency.
+func vp8lCodeLengths(freq []int, maxLength int) []int {
+	weights := append([]int{}, freq...)
+	for {
+		lengths := make([]int, len(weights))
+		h := &vp8lNodeHeap{}
+		for symbol, w := range weights {
+			if w > 0 {
+				*h = append(*h, &vp8lHuffmanNode{weight: w, symbol: symbol})
+			}
+		}
+		sort.Sort(h)
+		heap.Init(h)
+
+		for h.Len() > 1 {
+			a := heap.Pop(h).(*vp8lHuffmanNode)
+			b := heap.Pop(h).(*vp8lHuffmanNode)
+			heap.Push(h, &vp8lHuffmanNode{weight: a.weight + b.weight, symbol: a.symbol, left: a, right: b})
+		}
+
+		maxDepth := 0
+		var walk func(n *vp8lHuffmanNode, depth int)
+		walk = func(n *vp8lHuffmanNode, depth int) {
+			if n.left == nil {
+				lengths[n.symbol] = depth
+				if depth > maxDepth {
+					maxDepth = depth
+				}
+				return
+			}
+			walk(n.left, depth+1)
+			walk(n.right, depth+1)
+		}
+		walk((*h)[0], 0)
+
+		if maxDepth <= maxLength {
+			return lengths
+		}
+
+		// Flatten the distribution and try again
+		for i, w := range weights {
+			if w > 0 {
+				weights[i] = (w + 1) / 2
+			}
+		}
+	}
+}
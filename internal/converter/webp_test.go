@@ -0,0 +1,298 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func TestWebPLosslessRoundTrip(t *testing.T) {
+	sizes := [][2]int{{1, 1}, {1, 7}, {7, 1}, {17, 33}, {100, 63}, {257, 129}}
+	for _, size := range sizes {
+		for _, alpha := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%dx%d alpha=%v", size[0], size[1], alpha), func(t *testing.T) {
+				src := testImage(size[0], size[1], alpha)
+				var buf bytes.Buffer
+				if err := (&webpEncoder{lossless: true}).Encode(&buf, src); err != nil {
+					t.Fatal(err)
+				}
+
+				decoded, err := webp.Decode(bytes.NewReader(buf.Bytes()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				assertSamePixels(t, src, decoded)
+			})
+		}
+	}
+}
+
+// smoothTestImage returns an image of gradients and soft edges, as found in
+// photos
+func smoothTestImage(width, height int, alpha bool) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			fx, fy := float64(x), float64(y)
+			c := color.NRGBA{
+				uint8(128 + 100*math.Sin(fx/9)*math.Cos(fy/13)),
+				uint8(x * 255 / width),
+				uint8(128 + 90*math.Sin((fx+fy)/17)),
+				255,
+			}
+			if alpha {
+				c.A = uint8(y * 255 / height)
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// lumaPSNR compares the luma plane of a decoded lossy WebP image with the
+// luma of the source
+func lumaPSNR(t *testing.T, src *image.NRGBA, decoded image.Image) float64 {
+	t.Helper()
+	var ycbcr *image.YCbCr
+	switch img := decoded.(type) {
+	case *image.YCbCr:
+		ycbcr = img
+	case *image.NYCbCrA:
+		ycbcr = &img.YCbCr
+	default:
+		t.Fatalf("decoded %T, want a YCbCr image", decoded)
+	}
+	if ycbcr.Rect.Size() != src.Rect.Size() {
+		t.Fatalf("size %v, want %v", ycbcr.Rect.Size(), src.Rect.Size())
+	}
+
+	var sse float64
+	for y := 0; y < src.Rect.Dy(); y++ {
+		for x := 0; x < src.Rect.Dx(); x++ {
+			p := src.Pix[src.PixOffset(x, y):]
+			want := vp8RGBToY(int(p[0]), int(p[1]), int(p[2]))
+			d := float64(ycbcr.Y[ycbcr.YOffset(x, y)]) - float64(want)
+			sse += d * d
+		}
+	}
+	if sse == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(255*255*float64(src.Rect.Dx()*src.Rect.Dy())/sse)
+}
+
+func TestWebPLossyRoundTrip(t *testing.T) {
+	sizes := [][2]int{{1, 1}, {1, 7}, {7, 1}, {17, 33}, {100, 63}, {257, 129}}
+	for _, size := range sizes {
+		for _, alpha := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%dx%d alpha=%v", size[0], size[1], alpha), func(t *testing.T) {
+				src := smoothTestImage(size[0], size[1], alpha)
+				var buf bytes.Buffer
+				if err := (&webpEncoder{quality: 90}).Encode(&buf, src); err != nil {
+					t.Fatal(err)
+				}
+
+				decoded, err := webp.Decode(bytes.NewReader(buf.Bytes()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if psnr := lumaPSNR(t, src, decoded); psnr < 38 {
+					t.Errorf("luma PSNR %.1f dB, want at least 38", psnr)
+				}
+
+				// Transparency is kept losslessly
+				withAlpha, ok := decoded.(*image.NYCbCrA)
+				if ok != alpha {
+					t.Fatalf("decoded %T with alpha=%v", decoded, alpha)
+				}
+				if alpha {
+					for y := 0; y < size[1]; y++ {
+						for x := 0; x < size[0]; x++ {
+							if got, want := withAlpha.A[withAlpha.AOffset(x, y)], src.NRGBAAt(x, y).A; got != want {
+								t.Fatalf("alpha at (%d,%d) = %d, want %d", x, y, got, want)
+							}
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestWebPQuality(t *testing.T) {
+	src := smoothTestImage(160, 120, false)
+	prevSize, prevPSNR := 0, math.Inf(1)
+	for _, quality := range []int{100, 90, 75, 50, 10} {
+		var buf bytes.Buffer
+		if err := (&webpEncoder{quality: quality}).Encode(&buf, src); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := webp.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		psnr := lumaPSNR(t, src, decoded)
+		if prevSize != 0 && (buf.Len() >= prevSize || psnr >= prevPSNR) {
+			t.Errorf("quality %d: %d bytes at %.1f dB after %d bytes at %.1f dB, want smaller and lower",
+				quality, buf.Len(), psnr, prevSize, prevPSNR)
+		}
+		prevSize, prevPSNR = buf.Len(), psnr
+	}
+}
+
+func TestWebPRejectsOversizedImages(t *testing.T) {
+	tests := []struct {
+		lossless      bool
+		width, height int
+	}{
+		{true, vp8lMaxDimension + 1, 1},
+		{true, 1, vp8lMaxDimension + 1},
+		{false, vp8MaxDimension + 1, 1},
+		{false, 1, vp8MaxDimension + 1},
+	}
+	for _, tt := range tests {
+		img := testImage(tt.width, tt.height, false)
+		if err := (&webpEncoder{quality: 90, lossless: tt.lossless}).Encode(&bytes.Buffer{}, img); err == nil {
+			t.Errorf("%dx%d lossless=%v: Encode succeeded, want an error", tt.width, tt.height, tt.lossless)
+		}
+	}
+}
+
+// webpChunk is a chunk of a RIFF WebP container
+type webpChunk struct {
+	fourCC  string
+	payload []byte
+}
+
+// parseWebPChunks splits a WebP file into its chunks and checks the RIFF
+// size and the padding of odd-sized chunks
+func parseWebPChunks(t *testing.T, data []byte) []webpChunk {
+	t.Helper()
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		t.Fatalf("missing RIFF WebP header")
+	}
+	if size := binary.LittleEndian.Uint32(data[4:]); int(size) != len(data)-8 {
+		t.Fatalf("RIFF size %d, want %d", size, len(data)-8)
+	}
+
+	var chunks []webpChunk
+	for pos := 12; pos < len(data); {
+		if pos+8 > len(data) {
+			t.Fatalf("truncated chunk header at offset %d", pos)
+		}
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		end := pos + 8 + size + size%2
+		if end > len(data) {
+			t.Fatalf("chunk '%s' at offset %d overruns the file", data[pos:pos+4], pos)
+		}
+		chunks = append(chunks, webpChunk{fourCC: string(data[pos : pos+4]), payload: data[pos+8 : pos+8+size]})
+		pos = end
+	}
+	return chunks
+}
+
+func TestWebPMetadataContainer(t *testing.T) {
+	icc := buildICCProfile(srgbSpace())
+	// An odd-sized EXIF block needs a padding byte
+	exifData := append(append([]byte{}, testExif...), 0)
+
+	tests := []struct {
+		name      string
+		lossless  bool
+		alpha     bool
+		exif, icc []byte
+		flags     byte
+		fourCCs   []string
+	}{
+		{"exif and icc", true, false, exifData, icc, 0x28, []string{"VP8X", "ICCP", "VP8L", "EXIF"}},
+		{"exif only", true, false, exifData, nil, 0x08, []string{"VP8X", "VP8L", "EXIF"}},
+		{"icc only", true, false, nil, icc, 0x20, []string{"VP8X", "ICCP", "VP8L"}},
+		{"alpha", true, true, exifData, icc, 0x38, []string{"VP8X", "ICCP", "VP8L", "EXIF"}},
+		{"lossy", false, false, exifData, icc, 0x28, []string{"VP8X", "ICCP", "VP8 ", "EXIF"}},
+		{"lossy alpha", false, true, exifData, nil, 0x18, []string{"VP8X", "ALPH", "VP8 ", "EXIF"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := testImage(21, 13, tt.alpha)
+			encoder := &webpEncoder{quality: 90, lossless: tt.lossless}
+			var buf bytes.Buffer
+			if err := encoder.Encode(&buf, src); err != nil {
+				t.Fatal(err)
+			}
+			data, err := encoder.EmbedMetadata(buf.Bytes(), tt.exif, tt.icc)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			chunks := parseWebPChunks(t, data)
+			var fourCCs []string
+			for _, chunk := range chunks {
+				fourCCs = append(fourCCs, chunk.fourCC)
+			}
+			if fmt.Sprint(fourCCs) != fmt.Sprint(tt.fourCCs) {
+				t.Fatalf("chunks %v, want %v", fourCCs, tt.fourCCs)
+			}
+
+			vp8x := chunks[0].payload
+			if len(vp8x) != 10 {
+				t.Fatalf("VP8X chunk of %d bytes, want 10", len(vp8x))
+			}
+			if vp8x[0] != tt.flags {
+				t.Errorf("VP8X flags %#x, want %#x", vp8x[0], tt.flags)
+			}
+			width := (int(vp8x[4]) | int(vp8x[5])<<8 | int(vp8x[6])<<16) + 1
+			height := (int(vp8x[7]) | int(vp8x[8])<<8 | int(vp8x[9])<<16) + 1
+			if width != 21 || height != 13 {
+				t.Errorf("VP8X canvas %dx%d, want 21x13", width, height)
+			}
+
+			for _, chunk := range chunks {
+				switch chunk.fourCC {
+				case "ICCP":
+					if !bytes.Equal(chunk.payload, tt.icc) {
+						t.Error("ICCP chunk differs from the profile")
+					}
+				case "EXIF":
+					if !bytes.Equal(chunk.payload, tt.exif) {
+						t.Error("EXIF chunk differs from the EXIF block")
+					}
+				}
+			}
+
+			// x/image rejects the alpha flag on lossless images, which libwebp
+			// and browsers accept, so only opaque lossless files are decoded
+			if tt.lossless && tt.alpha {
+				return
+			}
+			decoded, err := webp.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.lossless {
+				assertSamePixels(t, src, decoded)
+			} else if decoded.Bounds().Size() != src.Bounds().Size() {
+				t.Errorf("size %v, want %v", decoded.Bounds().Size(), src.Bounds().Size())
+			}
+		})
+	}
+}
+
+func TestInsertWebPMetadataRejectsInvalidInput(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"empty":     nil,
+		"not riff":  []byte("GIF89a......"),
+		"no image":  []byte("RIFF\x04\x00\x00\x00WEBP"),
+		"truncated": []byte("RIFF\x10\x00\x00\x00WEBPVP8L\xff\x00\x00\x00\x2f"),
+		"short vp8": []byte("RIFF\x10\x00\x00\x00WEBPVP8 \x04\x00\x00\x00\x00\x00\x00\x00"),
+	} {
+		if _, err := insertWebPMetadata(data, testExif, nil); err == nil {
+			t.Errorf("%s: insertWebPMetadata succeeded, want an error", name)
+		}
+	}
+}
@@ -1,10 +1,9 @@
 package errors
 
 import (
+	stderrors "errors"
 	"fmt"
 	"os"
-	"path/filepath"
-	"strings"
 )
 
 // ErrorCode represents different types of errors that can occur in the application
@@ -39,6 +38,9 @@ const (
 	// System errors
 	ErrSystem
 	ErrNotSupported
+
+	// Cancellation
+	ErrCancelled
 )
 
 // AppError represents an application error with a code and message
@@ -66,6 +68,13 @@ func (e *AppError) Unwrap() error {
 	return e.Err
 }
 
+// Is reports whether the target is an AppError with the same code, so that
+// the standard errors.Is matches application errors anywhere in a chain
+func (e *AppError) Is(target error) bool {
+	t, ok := target.(*AppError)
+	return ok && t.Code == e.Code
+}
+
 // New creates a new application error
 func New(code ErrorCode, message string) *AppError {
 	return &AppError{
@@ -103,15 +112,13 @@ func Wrap(err error, code ErrorCode, message string) *AppError {
 	}
 }
 
-// Is checks if the error is of a specific type
+// Is checks if the error, or any error it wraps, is an AppError with the
+// given code
 func Is(err error, code ErrorCode) bool {
 	if err == nil {
 		return false
 	}
-	if appErr, ok := err.(*AppError); ok {
-		return appErr.Code == code
-	}
-	return false
+	return stderrors.Is(err, &AppError{Code: code})
 }
 
 // Common error constructors
@@ -0,0 +1,148 @@
+package errors
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorCodeExitCodes(t *testing.T) {
+	tests := []struct {
+		code     ErrorCode
+		name     string
+		exitCode int
+	}{
+		{ErrFileNotFound, "file_not_found", 10},
+		{ErrFileRead, "file_read", 11},
+		{ErrFileWrite, "file_write", 12},
+		{ErrFileExists, "file_exists", 13},
+		{ErrFileCreate, "file_create", 14},
+		{ErrDirCreate, "dir_create", 20},
+		{ErrDirRead, "dir_read", 21},
+		{ErrInvalidImage, "invalid_image", 30},
+		{ErrDecodeFailed, "decode_failed", 31},
+		{ErrEncodeFailed, "encode_failed", 32},
+		{ErrMetadataPreservation, "metadata_preservation", 33},
+		{ErrPermissionDenied, "permission_denied", 40},
+		{ErrAdminRequired, "admin_required", 41},
+		{ErrInvalidInput, "invalid_input", 50},
+		{ErrInvalidFormat, "invalid_format", 51},
+		{ErrSystem, "system", 60},
+		{ErrNotSupported, "not_supported", 61},
+		{ErrCancelled, "cancelled", 130},
+		{ErrorCode(0), "unknown", ExitFailure},
+	}
+	if len(tests)-1 != len(errorCodes) {
+		t.Fatalf("%d codes tested, %d defined", len(tests)-1, len(errorCodes))
+	}
+
+	for _, tt := range tests {
+		if got := tt.code.String(); got != tt.name {
+			t.Errorf("%d.String() = %q, want %q", tt.code, got, tt.name)
+		}
+		if got := tt.code.ExitCode(); got != tt.exitCode {
+			t.Errorf("%s.ExitCode() = %d, want %d", tt.name, got, tt.exitCode)
+		}
+
+		err := New(tt.code, "failed")
+		wrapped := fmt.Errorf("converting a.heic: %w", err)
+		if got := ExitCode(err); got != tt.exitCode {
+			t.Errorf("ExitCode(%s error) = %d, want %d", tt.name, got, tt.exitCode)
+		}
+		if got := ExitCode(wrapped); got != tt.exitCode {
+			t.Errorf("ExitCode(wrapped %s error) = %d, want %d", tt.name, got, tt.exitCode)
+		}
+	}
+
+	if got := ExitCode(nil); got != ExitOK {
+		t.Errorf("ExitCode(nil) = %d, want %d", got, ExitOK)
+	}
+	if got := ExitCode(fmt.Errorf("plain")); got != ExitFailure {
+		t.Errorf("ExitCode(plain error) = %d, want %d", got, ExitFailure)
+	}
+}
+
+func TestIsWalksTheChain(t *testing.T) {
+	cancelled := New(ErrCancelled, "conversion cancelled")
+	tests := []struct {
+		name string
+		err  error
+		code ErrorCode
+		want bool
+	}{
+		{"nil", nil, ErrCancelled, false},
+		{"plain error", fmt.Errorf("cancelled"), ErrCancelled, false},
+		{"app error", cancelled, ErrCancelled, true},
+		{"other code", cancelled, ErrDecodeFailed, false},
+		{"wrapped with %w", fmt.Errorf("a.heic: %w", cancelled), ErrCancelled, true},
+		{"wrapped twice", fmt.Errorf("batch: %w", fmt.Errorf("a.heic: %w", cancelled)), ErrCancelled, true},
+		{"wrapped with %v", fmt.Errorf("a.heic: %v", cancelled), ErrCancelled, false},
+		{"inner app error", New(ErrDecodeFailed, "decode").WithError(cancelled), ErrCancelled, true},
+		{"outer app error", New(ErrDecodeFailed, "decode").WithError(cancelled), ErrDecodeFailed, true},
+		{"joined", stderrors.Join(fmt.Errorf("first"), cancelled), ErrCancelled, true},
+	}
+	for _, tt := range tests {
+		if got := Is(tt.err, tt.code); got != tt.want {
+			t.Errorf("%s: Is(%v, %s) = %v, want %v", tt.name, tt.err, tt.code, got, tt.want)
+		}
+		if tt.err == nil {
+			continue
+		}
+		if got := stderrors.Is(tt.err, New(tt.code, "")); got != tt.want {
+			t.Errorf("%s: errors.Is = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAsAppError(t *testing.T) {
+	inner := New(ErrFileWrite, "failed to write output").WithDetails("out.jpg")
+	appErr, ok := AsAppError(fmt.Errorf("a.heic: %w", inner))
+	if !ok || appErr != inner {
+		t.Fatalf("AsAppError = %v, %v, want the wrapped error", appErr, ok)
+	}
+	if _, ok := AsAppError(fmt.Errorf("plain")); ok {
+		t.Error("AsAppError found an AppError in a plain error")
+	}
+
+	var target *AppError
+	if !stderrors.As(fmt.Errorf("a.heic: %w", inner), &target) || target.Details != "out.jpg" {
+		t.Errorf("errors.As = %v", target)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	if Wrap(nil, ErrSystem, "failed") != nil {
+		t.Error("Wrap(nil) is not nil")
+	}
+
+	cause := fmt.Errorf("disk full")
+	err := Wrap(cause, ErrFileWrite, "failed to write output")
+	if err.Code != ErrFileWrite || !stderrors.Is(err, cause) {
+		t.Errorf("Wrap = %v, want a file_write error wrapping the cause", err)
+	}
+
+	// Application errors keep their code
+	if got := Wrap(err, ErrSystem, "other"); got != err {
+		t.Errorf("Wrap(AppError) = %v, want it unchanged", got)
+	}
+}
+
+func TestToJSON(t *testing.T) {
+	var report jsonError
+	err := fmt.Errorf("a.heic: %w", Wrap(fmt.Errorf("disk full"), ErrFileWrite, "failed to write output"))
+	if e := json.Unmarshal(ToJSON(err), &report); e != nil {
+		t.Fatal(e)
+	}
+	want := jsonError{Code: int(ErrFileWrite), Name: "file_write", Message: "failed to write output", Cause: "disk full", ExitCode: 12}
+	if report != want {
+		t.Errorf("ToJSON = %+v, want %+v", report, want)
+	}
+
+	if e := json.Unmarshal(ToJSON(fmt.Errorf("plain")), &report); e != nil {
+		t.Fatal(e)
+	}
+	if report.Name != "unknown" || report.ExitCode != ExitFailure || report.Message != "plain" {
+		t.Errorf("ToJSON(plain) = %+v", report)
+	}
+}
@@ -0,0 +1,123 @@
+package errors
+
+import (
+	"encoding/json"
+	stderrors "errors"
+)
+
+// Process exit codes. Every ErrorCode maps to its own exit code so scripts can
+// tell failures apart; the tens digit groups related codes.
+const (
+	// ExitOK means the command succeeded
+	ExitOK = 0
+	// ExitFailure is used for errors that carry no ErrorCode and for runs
+	// that failed with several different errors
+	ExitFailure = 1
+	// ExitUsage means the command line could not be parsed
+	ExitUsage = 2
+)
+
+// errorCodeInfo is the stable name and exit code of an ErrorCode
+type errorCodeInfo struct {
+	name     string
+	exitCode int
+}
+
+// errorCodes lists the name and exit code of every ErrorCode
+var errorCodes = map[ErrorCode]errorCodeInfo{
+	ErrFileNotFound:         {"file_not_found", 10},
+	ErrFileRead:             {"file_read", 11},
+	ErrFileWrite:            {"file_write", 12},
+	ErrFileExists:           {"file_exists", 13},
+	ErrFileCreate:           {"file_create", 14},
+	ErrDirCreate:            {"dir_create", 20},
+	ErrDirRead:              {"dir_read", 21},
+	ErrInvalidImage:         {"invalid_image", 30},
+	ErrDecodeFailed:         {"decode_failed", 31},
+	ErrEncodeFailed:         {"encode_failed", 32},
+	ErrMetadataPreservation: {"metadata_preservation", 33},
+	ErrPermissionDenied:     {"permission_denied", 40},
+	ErrAdminRequired:        {"admin_required", 41},
+	ErrInvalidInput:         {"invalid_input", 50},
+	ErrInvalidFormat:        {"invalid_format", 51},
+	ErrSystem:               {"system", 60},
+	ErrNotSupported:         {"not_supported", 61},
+	ErrCancelled:            {"cancelled", 130},
+}
+
+// String returns the stable name of the error code
+func (c ErrorCode) String() string {
+	if info, ok := errorCodes[c]; ok {
+		return info.name
+	}
+	return "unknown"
+}
+
+// ExitCode returns the process exit code for the error code
+func (c ErrorCode) ExitCode() int {
+	if info, ok := errorCodes[c]; ok {
+		return info.exitCode
+	}
+	return ExitFailure
+}
+
+// AsAppError finds the first AppError in an error chain
+func AsAppError(err error) (*AppError, bool) {
+	var appErr *AppError
+	if stderrors.As(err, &appErr) {
+		return appErr, true
+	}
+	return nil, false
+}
+
+// ExitCode returns the process exit code for an error
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	if appErr, ok := AsAppError(err); ok {
+		return appErr.Code.ExitCode()
+	}
+	return ExitFailure
+}
+
+// jsonError is the machine-readable form of an error
+type jsonError struct {
+	Code     int    `json:"code"`
+	Name     string `json:"name"`
+	Message  string `json:"message"`
+	Details  string `json:"details,omitempty"`
+	Cause    string `json:"cause,omitempty"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// MarshalJSON implements json.Marshaler
+func (e *AppError) MarshalJSON() ([]byte, error) {
+	out := jsonError{
+		Code:     int(e.Code),
+		Name:     e.Code.String(),
+		Message:  e.Message,
+		Details:  e.Details,
+		ExitCode: e.Code.ExitCode(),
+	}
+	if e.Err != nil {
+		out.Cause = e.Err.Error()
+	}
+	return json.Marshal(out)
+}
+
+// ToJSON returns the machine-readable form of any error. Errors without an
+// ErrorCode are reported with code 0 and the name "unknown".
+func ToJSON(err error) []byte {
+	var data []byte
+	if appErr, ok := AsAppError(err); ok {
+		data, _ = json.Marshal(appErr)
+	} else {
+		data, _ = json.Marshal(jsonError{
+			Name:     ErrorCode(0).String(),
+			Message:  err.Error(),
+			ExitCode: ExitFailure,
+		})
+	}
+	return data
+}
@@ -1,154 +1,108 @@
 package ui
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
-	"time"
 
-	"github.com/spenceriam/HEIC-2-Go/internal/converter"
+	"github.com/fatih/color"
+	"github.com/spenceriam/HEIC-2-Go/internal/batch"
+	"github.com/spenceriam/HEIC-2-Go/internal/errors"
 )
 
-// BatchProcessor handles batch processing of directories
-func (f *FileInputScreen) BatchProcessDirectory(inputDir, outputDir string) error {
+// BatchProcessDirectory converts all HEIC files in a directory and shows the
+// progress. Cancelling the context stops the conversion.
+func (f *FileInputScreen) BatchProcessDirectory(ctx context.Context, inputDir, outputDir string) error {
+	conv := f.newConverter()
+
 	// Get all HEIC files in the directory
-	files, err := findHEICFiles(inputDir)
+	jobs, err := batch.PlanDirectory(inputDir, outputDir, f.settings.BatchLayout(), conv.GetOutputPath)
 	if err != nil {
 		return fmt.Errorf("error finding HEIC files: %w", err)
 	}
 
-	if len(files) == 0 {
+	if len(jobs) == 0 {
 		return fmt.Errorf("no HEIC files found in directory")
 	}
 
 	// Create output directory if it doesn't exist
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return fmt.Errorf("failed to create output directory: %w", err)
+	if outputDir != "" {
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
 	}
 
-	// Create a channel to track progress
-	progressChan := make(chan int, len(files))
-	fileChan := make(chan string, len(files))
-	doneChan := make(chan bool)
-	errorChan := make(chan error, 1)
-
-	// Start the progress display
+	// Run the conversions in the background and follow their events
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Conflict prompts and the progress bar take turns on the terminal
+	var terminal sync.Mutex
+	events := make(chan batch.Event)
+	engine := batch.NewEngine(conv).
+		WithWorkers(f.settings.BatchWorkers()).
+		WithMemoryBudget(f.settings.BatchMemoryBudget()).
+		WithEvents(events)
+	if policy, ok := f.settings.BatchConflictPolicy(); ok {
+		engine.WithConflictPolicy(policy)
+	} else {
+		engine.WithConflictResolver(f.conflictResolver(cancel, &terminal))
+	}
+	done := make(chan *batch.Summary, 1)
 	go func() {
-		f.showBatchProgress(inputDir, len(files), progressChan, fileChan, doneChan, errorChan)
+		done <- engine.Run(ctx, jobs)
 	}()
 
-	// Process files in parallel
-	var wg sync.WaitGroup
-	converter := converter.NewHEICConverter(true) // Preserve metadata
+	f.showBatchProgress(inputDir, len(jobs), events, &terminal)
+	summary := <-done
+	f.showBatchSummary(summary)
 
-	// Start worker goroutines
-	numWorkers := 4 // Number of concurrent conversions
-	for i := 0; i < numWorkers; i++ {
-		wg.Add(1)
-		go f.batchWorker(&wg, converter, inputDir, outputDir, progressChan, fileChan, errorChan)
+	if summary.Cancelled > 0 {
+		return errors.New(errors.ErrCancelled, "batch conversion cancelled").
+			WithDetails(fmt.Sprintf("%d of %d files were not converted", summary.Cancelled, summary.Total))
 	}
-
-	// Wait for all workers to finish
-	wg.Wait()
-	close(progressChan)
-	doneChan <- true
-
-	// Check for any errors
-	select {
-	case err := <-errorChan:
-		return err
-	default:
-		return nil
+	if summary.Failed > 0 {
+		return fmt.Errorf("%d of %d files could not be converted", summary.Failed, summary.Total)
 	}
+	return nil
 }
 
-// batchWorker processes files from the queue
-func (f *FileInputScreen) batchWorker(wg *sync.WaitGroup, conv *converter.HEICConverter, 
-	inputDir, outputDir string, progressChan chan<- int, 
-	fileChan chan<- string, errorChan chan<- error) {
-	defer wg.Done()
-
-	for file := range f.batchQueue {
-		// Process the file
-		outputFile := filepath.Join(outputDir, filepath.Base(file))
-		outputFile = strings.TrimSuffix(outputFile, filepath.Ext(outputFile)) + ".jpg"
+// showBatchProgress displays the batch processing progress until the event
+// stream ends, drawing only while it holds the terminal
+func (f *FileInputScreen) showBatchProgress(dir string, totalFiles int, events <-chan batch.Event, terminal *sync.Mutex) {
+	fmt.Printf("\nConverting %d files from %s\n\n", totalFiles, dir)
 
-		// Convert the file
-		if err := conv.Convert(file, outputFile); err != nil {
-			errorChan <- fmt.Errorf("error converting %s: %w", file, err)
-			continue
-		}
-
-		// Update progress
-		progressChan <- 1
-		fileChan <- filepath.Base(file)
-	}
-}
-
-// showBatchProgress displays the batch processing progress
-func (f *FileInputScreen) showBatchProgress(dir string, totalFiles int, 
-	progressChan <-chan int, fileChan <-chan string, 
-	doneChan <-chan bool, errorChan chan<- error) {
-	
 	// Create a progress bar
 	progressBar := NewProgressBar(totalFiles)
-	progressBar.startTime = time.Now()
-
-	// Track processed files
-	processed := 0
-	var currentFile string
 
-	for {
-		select {
-		case n := <-progressChan:
-			processed += n
-			progressBar.Update(processed)
-
-		case file := <-fileChan:
-			currentFile = file
-
-		case <-doneChan:
-			progressBar.Update(totalFiles)
-			fmt.Printf("\n✅ Successfully processed %d files\n", totalFiles)
-			return
-
-		case err := <-errorChan:
-			fmt.Printf("\n\n❌ Error: %v\n", err)
-			errorChan <- err
-			return
-
-		case <-time.After(100 * time.Millisecond):
-			// Update the display periodically
-			if currentFile != "" {
-				fmt.Printf("\rProcessing: %-50s", currentFile)
-			}
+	for event := range events {
+		if event.Type == batch.EventJobFinished {
+			terminal.Lock()
+			progressBar.Update(event.Completed)
+			terminal.Unlock()
 		}
 	}
 }
 
-// findHEICFiles finds all HEIC files in a directory
-func findHEICFiles(dir string) ([]string, error) {
-	var files []string
-
-	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// Skip directories
-		if info.IsDir() {
-			return nil
-		}
+// showBatchSummary displays the outcome of a batch run
+func (f *FileInputScreen) showBatchSummary(summary *batch.Summary) {
+	converted := summary.Succeeded + summary.Warnings
+	fmt.Printf("\n✅ Successfully processed %d of %d files in %s\n", converted, summary.Total, formatDuration(summary.Duration))
+	if summary.Skipped > 0 {
+		fmt.Printf("⏭️  Skipped %d files that already existed\n", summary.Skipped)
+	}
+	if summary.Cancelled > 0 {
+		color.Yellow("Cancelled %d files", summary.Cancelled)
+	}
 
-		// Check if file is HEIC/HEIF
-		ext := strings.ToLower(filepath.Ext(path))
-		if ext == ".heic" || ext == ".heif" {
-			files = append(files, path)
+	for _, result := range summary.Results {
+		switch result.Status {
+		case batch.StatusWarning:
+			color.Yellow("⚠️  %s: %v", filepath.Base(result.Job.Input), result.Err)
+		case batch.StatusFailed:
+			color.Red("❌ %s: %v", filepath.Base(result.Job.Input), result.Err)
 		}
-
-		return nil
-	})
-
-	return files, err
+	}
 }
@@ -1,10 +1,15 @@
 package ui
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+
+	"github.com/spenceriam/HEIC-2-Go/internal/batch"
 )
 
 // ConflictResolution represents the user's choice for handling file conflicts
@@ -17,10 +22,14 @@ const (
 	ConflictRename
 	// ConflictSkip indicates the user wants to skip this file
 	ConflictSkip
+	// ConflictApplyToAll indicates the user wants to choose a policy for
+	// this and all remaining conflicts
+	ConflictApplyToAll
 )
 
-// handleFileConflict handles file conflict resolution
-func (f *FileInputScreen) handleFileConflict(outputPath string) (string, ConflictResolution, error) {
+// handleFileConflict handles file conflict resolution. During batch
+// conversions applyToAll offers a choice for all remaining conflicts.
+func (f *FileInputScreen) handleFileConflict(outputPath string, applyToAll bool) (string, ConflictResolution, error) {
 	// If the output file doesn't exist, no conflict
 	if _, err := os.Stat(outputPath); os.IsNotExist(err) {
 		return outputPath, 0, nil
@@ -43,13 +52,21 @@ func (f *FileInputScreen) handleFileConflict(outputPath string) (string, Conflic
 		fmt.Println("2. Save with a different name")
 		fmt.Println("3. Skip this file")
 		fmt.Println("4. Cancel all")
-		fmt.Print("\nEnter your choice (1-4): ")
+		maxChoice := 4
+		if applyToAll {
+			fmt.Println("5. Apply a choice to all remaining files")
+			maxChoice = 5
+		}
+		fmt.Printf("\nEnter your choice (1-%d): ", maxChoice)
 
 		// Get user input
 		var choice int
 		_, err := fmt.Scanln(&choice)
-		if err != nil {
-			fmt.Println("Invalid input. Please enter a number between 1 and 4.")
+		if noInput(err) {
+			return "", 0, fmt.Errorf("no answer to the file conflict: %w", err)
+		}
+		if err != nil || choice < 1 || choice > maxChoice {
+			fmt.Printf("Invalid input. Please enter a number between 1 and %d.\n", maxChoice)
 			continue
 		}
 
@@ -66,8 +83,88 @@ func (f *FileInputScreen) handleFileConflict(outputPath string) (string, Conflic
 			return "", ConflictSkip, nil
 		case 4: // Cancel
 			return "", 0, fmt.Errorf("operation cancelled by user")
+		case 5: // Apply to all
+			return outputPath, ConflictApplyToAll, nil
+		}
+	}
+}
+
+// promptConflictPolicy asks the user how all remaining conflicts are handled
+func (f *FileInputScreen) promptConflictPolicy() (batch.ConflictPolicy, error) {
+	policies := []struct {
+		policy      batch.ConflictPolicy
+		description string
+	}{
+		{batch.ConflictOverwrite, "Overwrite all existing files"},
+		{batch.ConflictRename, "Save all with a numbered name (e.g. IMG_0001_2.jpg)"},
+		{batch.ConflictSkip, "Skip all existing files"},
+		{batch.ConflictKeepNewer, "Skip files that are newer than their HEIC file, overwrite the rest"},
+	}
+
+	for {
+		fmt.Println("\nHow should all remaining conflicts be handled?")
+		for i, p := range policies {
+			fmt.Printf("%d. %s\n", i+1, p.description)
+		}
+		fmt.Printf("\nEnter your choice (1-%d): ", len(policies))
+
+		var choice int
+		_, err := fmt.Scanln(&choice)
+		if noInput(err) {
+			return "", fmt.Errorf("no answer to the file conflict: %w", err)
+		}
+		if err != nil || choice < 1 || choice > len(policies) {
+			fmt.Printf("Invalid input. Please enter a number between 1 and %d.\n", len(policies))
+			continue
+		}
+		return policies[choice-1].policy, nil
+	}
+}
+
+// noInput reports whether a read failed because stdin has no more input, in
+// which case asking again cannot succeed
+func noInput(err error) bool {
+	return err == io.EOF || err == io.ErrUnexpectedEOF
+}
+
+// conflictResolver returns a batch conflict resolver that asks the user about
+// each existing output file until they choose a policy for all remaining ones.
+// Cancelling from the menu, or running out of input, cancels the batch. The
+// terminal is held while asking so the progress bar is not drawn over the menu.
+func (f *FileInputScreen) conflictResolver(cancel context.CancelFunc, terminal *sync.Mutex) batch.ConflictResolver {
+	var remaining batch.ConflictPolicy
+	return func(job batch.Job, existing string) (batch.Job, batch.ConflictPolicy, error) {
+		if remaining != "" {
+			return job, remaining, nil
+		}
+
+		terminal.Lock()
+		defer terminal.Unlock()
+		newPath, resolution, err := f.handleFileConflict(existing, true)
+		if err != nil {
+			cancel()
+			return job, "", err
+		}
+
+		switch resolution {
+		case ConflictRename:
+			// The new name replaces the job's output name, from which the
+			// names of the other files written for it are derived
+			ext := filepath.Ext(job.Output)
+			job.Output = filepath.Join(filepath.Dir(job.Output), strings.TrimSuffix(filepath.Base(newPath), filepath.Ext(newPath))+ext)
+			return job, batch.ConflictRename, nil
+		case ConflictSkip:
+			return job, batch.ConflictSkip, nil
+		case ConflictApplyToAll:
+			policy, err := f.promptConflictPolicy()
+			if err != nil {
+				cancel()
+				return job, "", err
+			}
+			remaining = policy
+			return job, policy, nil
 		default:
-			fmt.Println("Invalid choice. Please enter a number between 1 and 4.")
+			return job, batch.ConflictOverwrite, nil
 		}
 	}
 }
@@ -130,22 +227,20 @@ func (f *FileInputScreen) prepareOutputPath(inputPath, outputDir string) (string
 	// Generate the output path
 	ext := filepath.Ext(inputPath)
 	base := strings.TrimSuffix(filepath.Base(inputPath), ext)
-	outputPath := filepath.Join(outputDir, base+".jpg")
+	outputPath := filepath.Join(outputDir, base+f.settings.Format().Extension())
 
 	// Check for conflicts and handle them
 	if _, err := os.Stat(outputPath); err == nil {
 		// File exists, handle conflict
-		newPath, resolution, err := f.handleFileConflict(outputPath)
+		newPath, resolution, err := f.handleFileConflict(outputPath, false)
 		if err != nil {
 			return "", err
 		}
 
 		switch resolution {
 		case ConflictOverwrite:
-			// Remove the existing file
-			if err := os.Remove(outputPath); err != nil {
-				return "", fmt.Errorf("failed to remove existing file: %w", err)
-			}
+			// The converter replaces the existing file only once the new
+			// output is complete
 			return outputPath, nil
 		case ConflictRename:
 			return newPath, nil
@@ -1,26 +1,55 @@
 package ui
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/fatih/color"
+	"github.com/spenceriam/HEIC-2-Go/internal/app"
+	"github.com/spenceriam/HEIC-2-Go/internal/config"
+	"github.com/spenceriam/HEIC-2-Go/internal/converter"
+	"github.com/spenceriam/HEIC-2-Go/internal/errors"
 )
 
 // FileInputScreen handles the file input interface
 type FileInputScreen struct {
-	screen *Screen
+	screen   *Screen
+	settings *config.Settings
 }
 
 // NewFileInputScreen creates a new file input screen
 func NewFileInputScreen(screen *Screen) *FileInputScreen {
+	// Load the saved settings, falling back to the defaults
+	settings, err := config.Load()
+	if err != nil {
+		color.Yellow("Warning: %v\n", err)
+	}
+
 	return &FileInputScreen{
-		screen: screen,
+		screen:   screen,
+		settings: settings,
 	}
 }
 
+// newConverter creates a converter configured from the current settings
+func (f *FileInputScreen) newConverter() *converter.HEICConverter {
+	conv := converter.NewHEICConverter(f.settings.PreserveMetadata).
+		WithOutputFormat(f.settings.Format()).
+		WithOptions(f.settings.EncoderOptions()).
+		WithResize(f.settings.ResizeOptions())
+	if format, err := converter.ParseOutputFormat(f.settings.AlphaFormat); err == nil {
+		conv.WithAlphaFormat(format)
+	}
+	if background, err := converter.ParseBackground(f.settings.Background); err == nil {
+		conv.WithBackground(background)
+	}
+	return conv
+}
+
 // Show displays the file input screen
 func (f *FileInputScreen) Show() (string, error) {
 	// Create admin manager
@@ -120,6 +149,10 @@ File path: `
 
 		// Validate that it's actually a HEIC/HEIF file
 		isValid, err := converter.IsValidHEIC(input)
+		if appErr, ok := err.(*errors.AppError); ok && appErr.Code == errors.ErrInvalidImage {
+			f.screen.ShowError(fmt.Sprintf("The selected file is not a valid HEIC/HEIF file: %s", appErr.Details))
+			continue
+		}
 		if err != nil {
 			f.screen.ShowError(fmt.Sprintf("Error validating HEIC file: %v", err))
 			continue
@@ -135,48 +168,46 @@ File path: `
 	}
 }
 
-// ShowProcessingScreen displays the file processing screen with progress updates
-func (f *FileInputScreen) ShowProcessingScreen(filePath string) (string, error) {
-	// Generate output path (same directory, change extension to .jpg)
-	outputPath := strings.TrimSuffix(filePath, filepath.Ext(filePath)) + ".jpg"
-
-	// Create channels for progress updates
-	progressChan := make(chan int)
-	doneChan := make(chan bool)
-	errorChan := make(chan error)
+// ShowProcessingScreen converts a file while displaying its progress.
+// Cancelling the context stops the conversion.
+func (f *FileInputScreen) ShowProcessingScreen(ctx context.Context, filePath string) (string, error) {
+	// Generate output path (same directory, extension of the output format)
+	conv := f.newConverter()
+	outputPath := conv.GetOutputPath(filePath)
+
+	// Report the progress of the conversion stages to the progress screen and
+	// remember the file written, which has another extension if transparency
+	// was kept in an alpha-capable format
+	progressChan := make(chan converter.Progress, 16)
+	written := outputPath
+	conv.WithProgress(func(p converter.Progress) {
+		if p.Stage == converter.StageDone && p.Output != "" {
+			written = p.Output
+		}
+		progressChan <- p
+	})
 
-	// Start the conversion in a goroutine
+	// Run the conversion in the background
+	errChan := make(chan error, 1)
 	go func() {
-		// Create a converter instance
-		converter := converter.NewHEICConverter(true) // Preserve metadata
-
-		// Create a progress reporter
-		go func() {
-			// Simulate progress updates
-			for i := 0; i <= 100; i += 5 {
-				progressChan <- i
-				time.Sleep(100 * time.Millisecond)
-			}
-			close(progressChan)
-
-			// Perform the actual conversion
-			if err := converter.Convert(filePath, outputPath); err != nil {
-				errorChan <- err
-				return
-			}
-
-			// Signal completion
-			doneChan <- true
-		}()
+		err := conv.ConvertContext(ctx, filePath, outputPath)
+		close(progressChan)
+		errChan <- err
 	}()
 
-	// Show the progress screen and wait for completion
-	if err := f.ShowProgressScreen(filePath, progressChan, doneChan, errorChan); err != nil {
-		return "", err
+	// Show the progress screen until the conversion is complete
+	f.ShowProgressScreen(filePath, progressChan)
+
+	if err := <-errChan; err != nil {
+		// A conversion that only lost its metadata still produced an output
+		if !errors.Is(err, errors.ErrMetadataPreservation) {
+			return "", err
+		}
+		color.Yellow("\n⚠️  %v", err)
 	}
 
 	// Return the output path where the file was saved
-	return outputPath, nil
+	return written, nil
 }
 
 // ShowSuccessScreen displays the success screen after conversion
@@ -2,10 +2,13 @@ package ui
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"strconv"
 	"strings"
+
+	"github.com/spenceriam/HEIC-2-Go/internal/app"
 )
 
 // MenuOption represents a single menu option
@@ -92,16 +95,14 @@ func (s *Screen) handleSingleFile() error {
 		return fmt.Errorf("file selection failed: %w", err)
 	}
 
-	// Show processing screen
-	outputPath, err := fileInput.ShowProcessingScreen(filePath)
+	// Convert the file while showing its progress, Ctrl+C cancels it
+	ctx, stop := app.WithInterrupt(context.Background())
+	defer stop()
+	outputPath, err := fileInput.ShowProcessingScreen(ctx, filePath)
 	if err != nil {
-		return fmt.Errorf("error showing processing screen: %w", err)
+		return fmt.Errorf("conversion failed: %w", err)
 	}
 
-	// TODO: Implement actual file conversion
-	// For now, simulate a successful conversion
-	// time.Sleep(2 * time.Second) // Simulate processing time
-
 	// Show success screen
 	if err := fileInput.ShowSuccessScreen(filePath, outputPath); err != nil {
 		return fmt.Errorf("error showing success screen: %w", err)
@@ -112,16 +113,38 @@ func (s *Screen) handleSingleFile() error {
 
 // handleDirectory handles the directory conversion option
 func (s *Screen) handleDirectory() error {
-	s.ShowMessage("Directory conversion selected")
-	// TODO: Implement directory conversion
+	fileInput := NewFileInputScreen(s)
+
+	inputDir, err := s.GetInput("\nEnter the directory containing HEIC files: ")
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(inputDir); err != nil || !info.IsDir() {
+		return fmt.Errorf("not a valid directory: %s", inputDir)
+	}
+
+	outputDir, err := s.GetInput(fmt.Sprintf("Enter the output directory (press Enter for %s): ", fileInput.settings.OutputDir))
+	if err != nil {
+		return err
+	}
+	if outputDir == "" {
+		outputDir = fileInput.settings.OutputDir
+	}
+
+	// Ctrl+C cancels the conversion and reports what finished
+	ctx, stop := app.WithInterrupt(context.Background())
+	defer stop()
+	if err := fileInput.BatchProcessDirectory(ctx, inputDir, outputDir); err != nil {
+		return err
+	}
+
+	s.ShowMessage("Directory conversion complete")
 	return nil
 }
 
 // handleSettings handles the settings menu
 func (s *Screen) handleSettings() error {
-	s.ShowMessage("Settings menu selected")
-	// TODO: Implement settings
-	return nil
+	return NewFileInputScreen(s).ShowSettingsMenu()
 }
 
 // handleExit handles the exit option
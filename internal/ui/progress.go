@@ -2,10 +2,11 @@ package ui
 
 import (
 	"fmt"
+	"path/filepath"
 	"strings"
 	"time"
 
-	"github.com/fatih/color"
+	"github.com/spenceriam/HEIC-2-Go/internal/converter"
 )
 
 // ProgressBar represents a progress bar in the terminal
@@ -13,6 +14,7 @@ type ProgressBar struct {
 	total     int
 	current   int
 	width     int
+	label     string
 	startTime time.Time
 }
 
@@ -32,6 +34,11 @@ func (p *ProgressBar) Update(current int) {
 	p.Render()
 }
 
+// SetLabel sets the text shown after the progress bar, such as the current stage
+func (p *ProgressBar) SetLabel(label string) {
+	p.label = label
+}
+
 // Increment increments the progress by 1
 func (p *ProgressBar) Increment() {
 	if p.current < p.total {
@@ -71,6 +78,11 @@ func (p *ProgressBar) Render() {
 		formatDuration(remaining),
 	)
 
+	// Append the label, padded to clear a longer previous label
+	if p.label != "" {
+		progressBar += fmt.Sprintf("  %-32s", p.label)
+	}
+
 	// Print the progress bar
 	fmt.Print(progressBar)
 
@@ -95,8 +107,9 @@ func formatDuration(d time.Duration) string {
 	return fmt.Sprintf("%02d:%02d", m, s)
 }
 
-// ShowProgressScreen displays a progress screen during file conversion
-func (f *FileInputScreen) ShowProgressScreen(filePath string, progressChan <-chan int, doneChan <-chan bool, errorChan <-chan error) error {
+// ShowProgressScreen displays the progress of a file conversion until the
+// progress channel is closed
+func (f *FileInputScreen) ShowProgressScreen(filePath string, progressChan <-chan converter.Progress) {
 	f.screen.Clear()
 	f.screen.DisplayWelcome()
 
@@ -111,30 +124,29 @@ func (f *FileInputScreen) ShowProgressScreen(filePath string, progressChan <-cha
 	// Create a progress bar
 	progressBar := NewProgressBar(100)
 
-	// Start a goroutine to update the progress
-	go func() {
-		for {
-			select {
-			case progress, ok := <-progressChan:
-				if !ok {
-					return
-				}
-				progressBar.Update(progress)
-
-			case <-doneChan:
-				progressBar.Update(100)
-				return
-
-			case err := <-errorChan:
-				if err != nil {
-					f.screen.ShowError(fmt.Sprintf("Error during conversion: %v", err))
-					return
-				}
-			}
+	for progress := range progressChan {
+		label := progress.Stage.String()
+		if progress.TotalBytes > 0 {
+			label = fmt.Sprintf("%s %s / %s", label, formatBytes(progress.Bytes), formatBytes(progress.TotalBytes))
 		}
-	}()
+		progressBar.SetLabel(label)
+		progressBar.Update(progress.Percent)
+	}
 
-	// Wait for the conversion to complete
-	<-doneChan
-	return nil
+	// Finish the progress line if the conversion stopped early
+	if progressBar.current < progressBar.total {
+		fmt.Println()
+	}
+}
+
+// formatBytes formats a byte count in a human-readable format
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1024*1024:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1024*1024))
+	case n >= 1024:
+		return fmt.Sprintf("%.1f KB", float64(n)/1024)
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
 }
@@ -4,41 +4,15 @@ import (
 	"bufio"
 	"fmt"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 
 	"github.com/fatih/color"
+	"github.com/spenceriam/HEIC-2-Go/internal/batch"
+	"github.com/spenceriam/HEIC-2-Go/internal/config"
+	"github.com/spenceriam/HEIC-2-Go/internal/converter"
 )
 
-// Settings holds the application settings
-type Settings struct {
-	// Image quality (1-100)
-	Quality int `json:"quality"`
-	// Default output directory
-	OutputDir string `json:"output_dir"`
-	// Color theme (light/dark)
-	Theme string `json:"theme"`
-	// Whether to preserve EXIF metadata
-	PreserveMetadata bool `json:"preserve_metadata"`
-}
-
-// DefaultSettings returns the default application settings
-func DefaultSettings() *Settings {
-	// Get user's home directory
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		homeDir = "."
-	}
-
-	return &Settings{
-		Quality:          90,
-		OutputDir:        filepath.Join(homeDir, "Pictures", "HEIC-2-JPG"),
-		Theme:            "dark",
-		PreserveMetadata: true,
-	}
-}
-
 // ShowSettingsMenu displays the settings menu
 func (f *FileInputScreen) ShowSettingsMenu() error {
 	for {
@@ -56,9 +30,13 @@ func (f *FileInputScreen) ShowSettingsMenu() error {
 		fmt.Printf("2. Output Directory: %s\n", f.settings.OutputDir)
 		fmt.Printf("3. Theme: %s\n", strings.Title(f.settings.Theme))
 		fmt.Printf("4. Preserve Metadata: %v\n", f.settings.PreserveMetadata)
-		fmt.Println("5. Reset to Defaults")
-		fmt.Println("6. Back to Main Menu")
-		fmt.Print("\nSelect an option (1-6): ")
+		fmt.Printf("5. Output Format: %s\n", strings.ToUpper(string(f.settings.Format())))
+		fmt.Printf("6. Flatten Batch Output: %v\n", f.settings.FlattenOutput)
+		fmt.Printf("7. Existing Files: %s\n", f.settings.ConflictPolicy)
+		fmt.Printf("8. Resize: %s\n", resizeSummary(f.settings))
+		fmt.Println("9. Reset to Defaults")
+		fmt.Println("10. Back to Main Menu")
+		fmt.Print("\nSelect an option (1-10): ")
 
 		// Get user input
 		reader := bufio.NewReader(os.Stdin)
@@ -75,14 +53,34 @@ func (f *FileInputScreen) ShowSettingsMenu() error {
 		case "4":
 			f.toggleMetadataPreservation()
 		case "5":
-			f.resetToDefaults()
+			f.updateOutputFormat()
 		case "6":
+			f.toggleFlattenOutput()
+		case "7":
+			f.updateConflictPolicy()
+		case "8":
+			f.updateResizeSettings()
+		case "9":
+			f.resetToDefaults()
+		case "10":
 			return nil
 		default:
 			fmt.Println("\nInvalid option. Please try again.")
 			fmt.Print("Press Enter to continue...")
 			reader.ReadString('\n')
+			continue
 		}
+
+		f.saveSettings()
+	}
+}
+
+// saveSettings persists the settings, warning the user if that fails
+func (f *FileInputScreen) saveSettings() {
+	if err := config.Save(f.settings); err != nil {
+		color.Yellow("\nWarning: settings could not be saved: %v\n", err)
+		fmt.Print("Press Enter to continue...")
+		bufio.NewReader(os.Stdin).ReadString('\n')
 	}
 }
 
@@ -156,6 +154,43 @@ func (f *FileInputScreen) updateOutputDirectory() {
 	reader.ReadString('\n')
 }
 
+// updateOutputFormat allows the user to choose the output image format
+func (f *FileInputScreen) updateOutputFormat() {
+	f.screen.Clear()
+	f.screen.DisplayWelcome()
+
+	fmt.Println("\n╔══════════════════════════════════════════════════════════════════════╗")
+	fmt.Println("║                     Output Format                             ║")
+	fmt.Println("╚══════════════════════════════════════════════════════════════════════╝")
+	fmt.Println()
+
+	formats := converter.SupportedFormats()
+	fmt.Printf("Current format: %s\n\n", strings.ToUpper(string(f.settings.Format())))
+	for i, format := range formats {
+		fmt.Printf("%d. %s (%s)\n", i+1, strings.ToUpper(string(format)), format.Extension())
+	}
+	fmt.Printf("\nSelect a format (1-%d, or press Enter to cancel): ", len(formats))
+
+	reader := bufio.NewReader(os.Stdin)
+	input, _ := reader.ReadString('\n')
+	input = strings.TrimSpace(input)
+
+	if input == "" {
+		return
+	}
+
+	choice, err := strconv.Atoi(input)
+	if err != nil || choice < 1 || choice > len(formats) {
+		fmt.Println("\nInvalid selection. The output format was not changed.")
+	} else {
+		f.settings.OutputFormat = string(formats[choice-1])
+		fmt.Println("\nOutput format updated successfully!")
+	}
+
+	fmt.Print("Press Enter to continue...")
+	reader.ReadString('\n')
+}
+
 // toggleTheme toggles between light and dark themes
 func (f *FileInputScreen) toggleTheme() {
 	if f.settings.Theme == "dark" {
@@ -183,10 +218,167 @@ func (f *FileInputScreen) toggleMetadataPreservation() {
 	bufio.NewReader(os.Stdin).ReadString('\n')
 }
 
+// toggleFlattenOutput toggles between mirroring the input directory
+// structure and writing all batch output into one directory
+func (f *FileInputScreen) toggleFlattenOutput() {
+	f.settings.FlattenOutput = !f.settings.FlattenOutput
+	if f.settings.FlattenOutput {
+		fmt.Println("\nBatch output will be written into a single directory.")
+		fmt.Println("Files with the same name are prefixed with their subdirectory.")
+	} else {
+		fmt.Println("\nBatch output will mirror the input directory structure.")
+	}
+	fmt.Print("Press Enter to continue...")
+	bufio.NewReader(os.Stdin).ReadString('\n')
+}
+
+// updateConflictPolicy allows the user to choose how existing output files
+// are handled in batch conversions
+func (f *FileInputScreen) updateConflictPolicy() {
+	f.screen.Clear()
+	f.screen.DisplayWelcome()
+
+	fmt.Println("\n╔══════════════════════════════════════════════════════════════════════╗")
+	fmt.Println("║                     Existing Files                            ║")
+	fmt.Println("╚══════════════════════════════════════════════════════════════════════╝")
+	fmt.Println()
+
+	policies := []struct {
+		name        string
+		description string
+	}{
+		{config.ConflictAsk, "ask about every existing file"},
+		{string(batch.ConflictOverwrite), "replace existing files"},
+		{string(batch.ConflictSkip), "keep existing files and skip their conversion"},
+		{string(batch.ConflictRename), "save with a numbered name, e.g. IMG_0001_2.jpg"},
+		{string(batch.ConflictKeepNewer), "skip files that are newer than their HEIC file"},
+		{string(batch.ConflictFail), "report existing files as errors"},
+	}
+
+	fmt.Printf("Current policy: %s\n\n", f.settings.ConflictPolicy)
+	for i, p := range policies {
+		fmt.Printf("%d. %s - %s\n", i+1, p.name, p.description)
+	}
+	fmt.Printf("\nSelect a policy (1-%d, or press Enter to cancel): ", len(policies))
+
+	reader := bufio.NewReader(os.Stdin)
+	input, _ := reader.ReadString('\n')
+	input = strings.TrimSpace(input)
+
+	if input == "" {
+		return
+	}
+
+	choice, err := strconv.Atoi(input)
+	if err != nil || choice < 1 || choice > len(policies) {
+		fmt.Println("\nInvalid selection. The policy was not changed.")
+	} else {
+		f.settings.ConflictPolicy = policies[choice-1].name
+		fmt.Println("\nPolicy for existing files updated successfully!")
+	}
+
+	fmt.Print("Press Enter to continue...")
+	reader.ReadString('\n')
+}
+
+// resizeSummary describes the resize settings in one line
+func resizeSummary(s *config.Settings) string {
+	var parts []string
+	if s.ScalePercent > 0 && s.ScalePercent < 100 {
+		parts = append(parts, fmt.Sprintf("%d%%", s.ScalePercent))
+	}
+	if s.MaxWidth > 0 || s.MaxHeight > 0 {
+		limit := func(v int) string {
+			if v == 0 {
+				return "any"
+			}
+			return strconv.Itoa(v)
+		}
+		parts = append(parts, fmt.Sprintf("max %sx%s (%s)", limit(s.MaxWidth), limit(s.MaxHeight), s.ResizeMode))
+	}
+	if len(parts) == 0 {
+		return "Off"
+	}
+	return strings.Join(parts, ", ") + ", " + s.ResizeFilter
+}
+
+// updateResizeSettings allows the user to set the output size limits, the
+// fit mode and the resampling filter
+func (f *FileInputScreen) updateResizeSettings() {
+	f.screen.Clear()
+	f.screen.DisplayWelcome()
+
+	fmt.Println("\n╔══════════════════════════════════════════════════════════════════════╗")
+	fmt.Println("║                          Resize                               ║")
+	fmt.Println("╚══════════════════════════════════════════════════════════════════════╝")
+	fmt.Println()
+	fmt.Printf("Current: %s\n", resizeSummary(f.settings))
+	fmt.Println("Press Enter to keep a value, enter 0 to remove a limit.")
+
+	reader := bufio.NewReader(os.Stdin)
+	readNumber := func(prompt string, current, max int) int {
+		for {
+			fmt.Printf("\n%s [%d]: ", prompt, current)
+			input, _ := reader.ReadString('\n')
+			input = strings.TrimSpace(input)
+			if input == "" {
+				return current
+			}
+			value, err := strconv.Atoi(input)
+			if err == nil && value >= 0 && (max == 0 || value <= max) {
+				return value
+			}
+			if max > 0 {
+				fmt.Printf("Invalid input. Please enter a number between 0 and %d.\n", max)
+			} else {
+				fmt.Println("Invalid input. Please enter 0 or a positive number.")
+			}
+		}
+	}
+	readChoice := func(prompt, current string, choices []string) string {
+		for {
+			fmt.Printf("\n%s (%s) [%s]: ", prompt, strings.Join(choices, ", "), current)
+			input, _ := reader.ReadString('\n')
+			input = strings.ToLower(strings.TrimSpace(input))
+			if input == "" {
+				return current
+			}
+			for _, choice := range choices {
+				if input == choice {
+					return choice
+				}
+			}
+			fmt.Println("Invalid selection.")
+		}
+	}
+
+	settings := *f.settings
+	settings.ScalePercent = readNumber("Scale in percent (1-100)", settings.ScalePercent, 100)
+	settings.MaxWidth = readNumber("Maximum width in pixels", settings.MaxWidth, 0)
+	settings.MaxHeight = readNumber("Maximum height in pixels", settings.MaxHeight, 0)
+
+	// Filling and cropping need a box with both dimensions
+	settings.ResizeMode = string(converter.ResizeFit)
+	if settings.MaxWidth > 0 && settings.MaxHeight > 0 {
+		modes := []string{string(converter.ResizeFit), string(converter.ResizeFill), string(converter.ResizeCrop)}
+		settings.ResizeMode = readChoice("Fit mode", f.settings.ResizeMode, modes)
+	}
+
+	var filters []string
+	for _, filter := range converter.ResampleFilters() {
+		filters = append(filters, string(filter))
+	}
+	settings.ResizeFilter = readChoice("Resampling filter", settings.ResizeFilter, filters)
+
+	*f.settings = settings
+	fmt.Printf("\nResize settings updated: %s\n", resizeSummary(f.settings))
+	fmt.Print("Press Enter to continue...")
+	reader.ReadString('\n')
+}
+
 // resetToDefaults resets all settings to their default values
 func (f *FileInputScreen) resetToDefaults() {
-	defaultSettings := DefaultSettings()
-	f.settings = defaultSettings
+	f.settings.Reset()
 	fmt.Println("\nAll settings have been reset to their default values.")
 	fmt.Print("Press Enter to continue...")
 	bufio.NewReader(os.Stdin).ReadString('\n')
@@ -0,0 +1,112 @@
+package heic2go
+
+import (
+	"errors"
+	"fmt"
+
+	apperrors "github.com/spenceriam/HEIC-2-Go/internal/errors"
+)
+
+// Kinds of errors returned by this package. Every returned error is an
+// *Error whose Kind is one of these, so callers can test for them with
+// errors.Is.
+var (
+	// ErrNotFound means the input file does not exist
+	ErrNotFound = errors.New("file not found")
+	// ErrPermission means a file or directory could not be accessed
+	ErrPermission = errors.New("permission denied")
+	// ErrIO means reading the input or writing the output failed
+	ErrIO = errors.New("input/output error")
+	// ErrInvalidImage means the input is not a valid HEIC/HEIF file
+	ErrInvalidImage = errors.New("not a valid HEIC/HEIF image")
+	// ErrDecode means the image could not be decoded
+	ErrDecode = errors.New("decoding failed")
+	// ErrEncode means the output image could not be encoded
+	ErrEncode = errors.New("encoding failed")
+	// ErrMetadata means the output was written without some of its metadata
+	ErrMetadata = errors.New("metadata not preserved")
+	// ErrInvalidOption means an option has an invalid value
+	ErrInvalidOption = errors.New("invalid option")
+	// ErrUnsupported means a format or feature is not supported
+	ErrUnsupported = errors.New("not supported")
+	// ErrCancelled means the context was cancelled before the work finished
+	ErrCancelled = errors.New("cancelled")
+)
+
+// Error is the error type returned by this package
+type Error struct {
+	// Kind is one of the Err variables of this package
+	Kind error
+	// Message describes what failed
+	Message string
+	// Details such as a file path or the reason a file was rejected
+	Details string
+	// Err is the underlying error, if any
+	Err error
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	msg := "heic2go: " + e.Message
+	if e.Details != "" {
+		msg = fmt.Sprintf("%s: %s", msg, e.Details)
+	}
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s: %v", msg, e.Err)
+	}
+	return msg
+}
+
+// Is reports whether the error is of the given kind
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// errorKinds maps the internal error codes to the error kinds
+var errorKinds = map[apperrors.ErrorCode]error{
+	apperrors.ErrFileNotFound:         ErrNotFound,
+	apperrors.ErrFileRead:             ErrIO,
+	apperrors.ErrFileWrite:            ErrIO,
+	apperrors.ErrFileExists:           ErrIO,
+	apperrors.ErrFileCreate:           ErrIO,
+	apperrors.ErrDirCreate:            ErrIO,
+	apperrors.ErrDirRead:              ErrIO,
+	apperrors.ErrInvalidImage:         ErrInvalidImage,
+	apperrors.ErrDecodeFailed:         ErrDecode,
+	apperrors.ErrEncodeFailed:         ErrEncode,
+	apperrors.ErrMetadataPreservation: ErrMetadata,
+	apperrors.ErrPermissionDenied:     ErrPermission,
+	apperrors.ErrAdminRequired:        ErrPermission,
+	apperrors.ErrInvalidInput:         ErrInvalidOption,
+	apperrors.ErrInvalidFormat:        ErrInvalidOption,
+	apperrors.ErrSystem:               ErrIO,
+	apperrors.ErrNotSupported:         ErrUnsupported,
+	apperrors.ErrCancelled:            ErrCancelled,
+}
+
+// wrapError converts an error of the internal packages into an *Error
+func wrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	appErr, ok := apperrors.AsAppError(err)
+	if !ok {
+		return &Error{Kind: ErrIO, Message: "unexpected error", Err: err}
+	}
+
+	kind, ok := errorKinds[appErr.Code]
+	if !ok {
+		kind = ErrIO
+	}
+	return &Error{Kind: kind, Message: appErr.Message, Details: appErr.Details, Err: appErr.Err}
+}
+
+// optionError creates the error returned for an invalid option value
+func optionError(name string, value interface{}) error {
+	return &Error{Kind: ErrInvalidOption, Message: "invalid option", Details: fmt.Sprintf("%s: %v", name, value)}
+}
@@ -0,0 +1,110 @@
+// Package heic2go converts HEIC/HEIF images to JPEG, PNG, WebP, TIFF and
+// AVIF. It is the public API of HEIC-2-Go for use in other Go programs.
+//
+// A Converter converts files with a fixed set of options:
+//
+//	conv, err := heic2go.New(heic2go.WithFormat(heic2go.PNG), heic2go.WithMaxSize(1920, 1080))
+//	if err != nil {
+//		return err
+//	}
+//	err = conv.ConvertFile(ctx, "IMG_0001.heic", "IMG_0001.png")
+//
+// Convert does the same with a reader and a writer, e.g. for uploads held in
+// memory. Decode and Encode work on readers and writers for callers that
+// process the image themselves. Errors are of type *Error and can be tested
+// with errors.Is against ErrInvalidImage, ErrDecode and the other kinds.
+package heic2go
+
+import (
+	"context"
+	"image"
+	"io"
+
+	"github.com/spenceriam/HEIC-2-Go/internal/converter"
+)
+
+// Converter converts HEIC images with a fixed set of options. It is safe
+// for concurrent use.
+type Converter struct {
+	settings settings
+}
+
+// New creates a Converter. Without options it writes JPEG files at quality
+// 90 with their EXIF metadata and color profile.
+func New(opts ...Option) (*Converter, error) {
+	s := defaultSettings()
+	for _, opt := range opts {
+		if err := opt(&s); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.resize.Validate(); err != nil {
+		return nil, wrapError(err)
+	}
+	return &Converter{settings: s}, nil
+}
+
+// Format returns the output format of the converter
+func (c *Converter) Format() Format {
+	return Format(c.settings.format)
+}
+
+// converter creates the internal converter for one conversion
+func (c *Converter) converter() *converter.HEICConverter {
+	s := c.settings
+	conv := converter.NewHEICConverter(s.metadata).
+		WithOutputFormat(s.format).
+		WithOptions(s.options).
+		WithResize(s.resize).
+		WithBackground(s.background).
+		WithGainMap(s.gainMap)
+	if s.srgb {
+		conv.WithColorMode(converter.ColorModeConvertSRGB)
+	}
+	return conv
+}
+
+// ConvertFile converts the primary image of a HEIC file and writes it to
+// outputPath, creating its directory if needed. Cancelling the context stops
+// the conversion without leaving a partial output file. An error of kind
+// ErrMetadata means the output was written without some of its metadata.
+func (c *Converter) ConvertFile(ctx context.Context, inputPath, outputPath string) error {
+	return wrapError(c.converter().ConvertContext(ctx, inputPath, outputPath))
+}
+
+// Convert converts a HEIC file read from r and writes the converted image
+// to w, without temporary files. Only the primary image is converted.
+func (c *Converter) Convert(ctx context.Context, r io.Reader, w io.Writer) error {
+	return wrapError(c.converter().ConvertStream(ctx, r, w))
+}
+
+// OutputPath returns the default output path for an input file: the same
+// path with the extension of the output format
+func (c *Converter) OutputPath(inputPath string) string {
+	return c.converter().GetOutputPath(inputPath)
+}
+
+// Encode writes an image in the converter's format, resized according to its
+// options. Metadata is not written since an image.Image carries none.
+func (c *Converter) Encode(w io.Writer, img image.Image) error {
+	return wrapError(c.converter().Encode(w, img))
+}
+
+// Decode reads a HEIC file and decodes its primary image. The image is
+// upright and in sRGB, with HDR images tone mapped, so that it displays
+// correctly without a color profile. 10 and 12-bit images are returned with
+// 16 bits per sample.
+func Decode(r io.Reader) (image.Image, error) {
+	img, err := converter.Decode(r)
+	return img, wrapError(err)
+}
+
+// Encode writes an image in the format and with the settings given by the
+// options, e.g. heic2go.Encode(w, img, heic2go.WithFormat(heic2go.WebP))
+func Encode(w io.Writer, img image.Image, opts ...Option) error {
+	c, err := New(opts...)
+	if err != nil {
+		return err
+	}
+	return c.Encode(w, img)
+}
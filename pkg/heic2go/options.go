@@ -0,0 +1,200 @@
+package heic2go
+
+import (
+	"image/color"
+
+	"github.com/spenceriam/HEIC-2-Go/internal/converter"
+)
+
+// Format is an output image format
+type Format string
+
+// Supported output formats
+const (
+	JPEG Format = "jpeg"
+	PNG  Format = "png"
+	WebP Format = "webp"
+	TIFF Format = "tiff"
+	AVIF Format = "avif"
+)
+
+// Extension returns the file extension of the format, including the dot
+func (f Format) Extension() string {
+	return converter.OutputFormat(f).Extension()
+}
+
+// ParseFormat parses a format name such as "jpg", "png" or "webp"
+func ParseFormat(name string) (Format, error) {
+	format, err := converter.ParseOutputFormat(name)
+	if err != nil {
+		return "", optionError("format", name)
+	}
+	return Format(format), nil
+}
+
+// ResizeMode selects how images are fitted into the maximum size
+type ResizeMode string
+
+// Resize modes
+const (
+	// Fit scales images down to fit within the maximum size
+	Fit ResizeMode = "fit"
+	// Fill scales images down to cover the maximum size and crops the rest
+	Fill ResizeMode = "fill"
+	// Crop cuts the center of images to the maximum size without scaling
+	Crop ResizeMode = "crop"
+)
+
+// settings collects the options of a Converter
+type settings struct {
+	format     converter.OutputFormat
+	options    converter.Options
+	resize     converter.ResizeOptions
+	metadata   bool
+	srgb       bool
+	gainMap    bool
+	background color.Color
+}
+
+// defaultSettings returns the settings of a Converter without options
+func defaultSettings() settings {
+	return settings{
+		format:     converter.FormatJPEG,
+		options:    converter.DefaultOptions(),
+		resize:     converter.DefaultResizeOptions(),
+		metadata:   true,
+		gainMap:    true,
+		background: converter.DefaultBackground,
+	}
+}
+
+// Option configures a Converter
+type Option func(*settings) error
+
+// WithFormat sets the output format (default JPEG)
+func WithFormat(format Format) Option {
+	return func(s *settings) error {
+		parsed, err := converter.ParseOutputFormat(string(format))
+		if err != nil {
+			return optionError("format", format)
+		}
+		s.format = parsed
+		return nil
+	}
+}
+
+// WithQuality sets the quality of lossy formats from 1 to 100 (default 90)
+func WithQuality(quality int) Option {
+	return func(s *settings) error {
+		if quality < 1 || quality > 100 {
+			return optionError("quality", quality)
+		}
+		s.options.Quality = quality
+		return nil
+	}
+}
+
+// WithChromaSubsampling sets the chroma subsampling of JPEG output: "4:4:4",
+// "4:2:2" or "4:2:0" (default)
+func WithChromaSubsampling(subsampling string) Option {
+	return func(s *settings) error {
+		parsed, err := converter.ParseChromaSubsampling(subsampling)
+		if err != nil {
+			return optionError("chroma subsampling", subsampling)
+		}
+		s.options.ChromaSubsampling = parsed
+		return nil
+	}
+}
+
+// WithProgressive writes progressive JPEG files
+func WithProgressive(progressive bool) Option {
+	return func(s *settings) error {
+		s.options.Progressive = progressive
+		return nil
+	}
+}
+
+// WithWebPLossless writes lossless WebP files, which ignore the quality
+// (default false)
+func WithWebPLossless(lossless bool) Option {
+	return func(s *settings) error {
+		s.options.WebPLossless = lossless
+		return nil
+	}
+}
+
+// WithMetadata controls whether EXIF metadata is copied into the output
+// (default true)
+func WithMetadata(preserve bool) Option {
+	return func(s *settings) error {
+		s.metadata = preserve
+		return nil
+	}
+}
+
+// WithSRGB converts wide-gamut images to sRGB instead of embedding their
+// color profile in the output
+func WithSRGB(convert bool) Option {
+	return func(s *settings) error {
+		s.srgb = convert
+		return nil
+	}
+}
+
+// WithGainMap controls whether JPEG output of iPhone HDR photos keeps the
+// HDR gain map (default true)
+func WithGainMap(keep bool) Option {
+	return func(s *settings) error {
+		s.gainMap = keep
+		return nil
+	}
+}
+
+// WithMaxSize limits the output size in pixels. A zero dimension is not
+// limited. Images are never enlarged.
+func WithMaxSize(width, height int) Option {
+	return func(s *settings) error {
+		if width < 0 || height < 0 {
+			return optionError("maximum size", [2]int{width, height})
+		}
+		s.resize.MaxWidth, s.resize.MaxHeight = width, height
+		return nil
+	}
+}
+
+// WithScale scales images to a percentage of their size (1-100)
+func WithScale(percent int) Option {
+	return func(s *settings) error {
+		if percent < 1 || percent > 100 {
+			return optionError("scale", percent)
+		}
+		s.resize.Scale = percent
+		return nil
+	}
+}
+
+// WithResizeMode sets how images are fitted into the maximum size (default
+// Fit). Fill and Crop need both a maximum width and height.
+func WithResizeMode(mode ResizeMode) Option {
+	return func(s *settings) error {
+		parsed, err := converter.ParseResizeMode(string(mode))
+		if err != nil {
+			return optionError("resize mode", mode)
+		}
+		s.resize.Mode = parsed
+		return nil
+	}
+}
+
+// WithBackground sets the color that transparent areas are flattened onto
+// for formats without transparency (default white)
+func WithBackground(background color.Color) Option {
+	return func(s *settings) error {
+		if background == nil {
+			return optionError("background", background)
+		}
+		s.background = background
+		return nil
+	}
+}